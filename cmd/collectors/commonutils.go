@@ -125,6 +125,42 @@ func GetDataInterval(param *node.Node, defaultInterval time.Duration) (time.Dura
 	return defaultInterval, nil
 }
 
+// CounterReset holds the counter_reset parameters of a perf object. The same parameters are used by ZapiPerf and RestPerf.
+//
+//	counter_reset:
+//	  policy: zero_based  # skip (default), zero_based or interpolate
+//	  uptime: uptime      # optional, counter with the seconds since an instance restarted
+type CounterReset struct {
+	Policy matrix.ResetPolicy
+	Uptime string
+}
+
+func ParseCounterReset(param *node.Node) (CounterReset, error) {
+	var (
+		cr  CounterReset
+		err error
+	)
+	n := param.GetChildS("counter_reset")
+	if n == nil {
+		cr.Policy = matrix.ResetSkip
+		return cr, nil
+	}
+	if cr.Policy, err = matrix.ParseResetPolicy(n.GetChildContentS("policy")); err != nil {
+		return cr, err
+	}
+	cr.Uptime = n.GetChildContentS("uptime")
+	return cr, nil
+}
+
+// NewResets returns the Resets of a poll. Restarts are detected from the raw uptime counter, when configured.
+func (c CounterReset) NewResets(curMat *matrix.Matrix, prevMat *matrix.Matrix) *matrix.Resets {
+	resets := matrix.NewResets(c.Policy)
+	if c.Uptime != "" {
+		resets.DetectRestarts(c.Uptime, curMat, prevMat)
+	}
+	return resets
+}
+
 func UpdateProtectedFields(instance *matrix.Instance) {
 
 	// check for group_type
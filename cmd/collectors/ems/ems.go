@@ -3,6 +3,7 @@ package ems
 import (
 	"fmt"
 	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/collectors/ems/sink"
 	rest2 "github.com/netapp/harvest/v2/cmd/collectors/rest"
 	"github.com/netapp/harvest/v2/cmd/poller/collector"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
@@ -39,9 +40,17 @@ type Ems struct {
 	maxURLSize     int
 	DefaultLabels  []string
 	severityFilter string
-	eventNames     []string                 // consist of all ems events supported
+	eventNames     []string               // consist of all ems events supported
+	listener       *listener              // receives the events pushed by the cluster, nil when polling only
+	sinks          []*sink.Forwarder      // forward the events as structured logs
+	maintenance    map[string][]*window   // suppression windows of the maintenance section of the template
+	dedup          map[string]*dedupEntry // events that repeated events are collapsed into, by dedup key
+	dedupInstances map[string]*dedupEntry // the same events, by instance key
+	rates          map[string]*rateEntry  // events of the current rate limit window, by ems or dedup key
+	now            func() time.Time
 	bookendEmsMap  map[string]*set.Set      // This is reverse bookend ems map, [Resolving ems]:[Set of Issuing ems]. Using Set here to ensure that it has slice of unique issuing ems
 	resolveAfter   map[string]time.Duration // This is resolve after map, [Issuing ems]:[Duration]. After this duration, ems got auto resolved.
+	statePath      string                   // file where the active bookend ems are kept across restarts
 }
 
 type Metric struct {
@@ -64,6 +73,7 @@ type emsProp struct {
 	Plugins        []plugin.Plugin // built-in or custom plugins
 	Matches        []*Matches
 	Labels         map[string]string
+	Throttle       *throttle // dedup, rate limit and suppression windows, nil when there are none
 }
 
 func init() {
@@ -97,6 +107,11 @@ func (e *Ems) Init(a *collector.AbstractCollector) error {
 
 	e.bookendEmsMap = make(map[string]*set.Set)
 	e.resolveAfter = make(map[string]time.Duration)
+	e.maintenance = make(map[string][]*window)
+	e.dedup = make(map[string]*dedupEntry)
+	e.dedupInstances = make(map[string]*dedupEntry)
+	e.rates = make(map[string]*rateEntry)
+	e.now = time.Now
 
 	if err = e.InitClient(); err != nil {
 		return err
@@ -114,7 +129,14 @@ func (e *Ems) Init(a *collector.AbstractCollector) error {
 		return err
 	}
 
-	return e.InitMatrix()
+	if err = e.InitMatrix(); err != nil {
+		return err
+	}
+
+	if e.listener != nil {
+		return e.listener.start()
+	}
+	return nil
 }
 
 func (e *Ems) InitMatrix() error {
@@ -130,9 +152,28 @@ func (e *Ems) InitMatrix() error {
 			mat.SetGlobalLabel(l.GetNameS(), l.GetContentS())
 		}
 	}
+	// bookend ems restored from the state file
+	for name, mx := range e.Matrix {
+		if name != e.Object {
+			mx.SetGlobalLabels(mat.GetGlobalLabels())
+		}
+	}
 	return nil
 }
 
+// Stop stops the servers of the listener, and writes the queued events to the sinks
+func (e *Ems) Stop() {
+	if e.listener != nil {
+		e.listener.stop()
+	}
+	for _, f := range e.sinks {
+		if err := f.Close(); err != nil {
+			e.Logger.Error().Err(err).Str("sink", f.Name()).Msg("Failed to close sink")
+		}
+	}
+	e.AbstractCollector.Stop()
+}
+
 func (e *Ems) LoadPlugin(kind string, _ *plugin.AbstractPlugin) plugin.Plugin {
 	e.Logger.Warn().Str("kind", kind).Msg("no ems plugin found")
 	return nil
@@ -142,6 +183,7 @@ func (e *Ems) InitCache() error {
 
 	var (
 		events *node.Node
+		err    error
 	)
 
 	if x := e.Params.GetChildContentS("object"); x != "" {
@@ -162,6 +204,21 @@ func (e *Ems) InitCache() error {
 	}
 	e.Logger.Debug().Str("severityFilter", e.severityFilter).Msgf("")
 
+	if e.listener, err = newListener(e.Params.GetChildS("listener"), e.Logger, e.acceptEvent); err != nil {
+		return err
+	}
+
+	if sinks := e.Params.GetChildS("sinks"); sinks != nil {
+		for _, params := range sinks.GetChildren() {
+			f, err := sink.NewForwarder(params, e.Logger)
+			if err != nil {
+				return err
+			}
+			e.sinks = append(e.sinks, f)
+		}
+		_, _ = e.Metadata.NewMetricUint64("sink_dropped")
+	}
+
 	if export := e.Params.GetChildS("export_options"); export != nil {
 		e.Matrix[e.Object].SetExportOptions(export)
 	}
@@ -190,6 +247,10 @@ func (e *Ems) InitCache() error {
 		e.ReturnTimeOut = returnTimeout
 	}
 
+	if maintenance := e.Params.GetChildS("maintenance"); maintenance != nil {
+		e.ParseMaintenance(maintenance)
+	}
+
 	// init plugins
 	if e.Plugins == nil {
 		e.Plugins = make(map[string][]plugin.Plugin)
@@ -236,10 +297,14 @@ func (e *Ems) InitCache() error {
 				e.ParseResolveEms(line1, prop)
 			}
 		}
+		e.ParseThrottle(line, &prop)
 		e.emsProp[prop.Name] = append(e.emsProp[prop.Name], &prop)
 	}
 	//add severity filter
 	e.Filter = append(e.Filter, e.severityFilter)
+
+	e.statePath = e.StatePath()
+	e.loadState()
 	return nil
 }
 
@@ -348,12 +413,64 @@ func (e *Ems) PollData() (map[string]*matrix.Matrix, error) {
 
 	startTime = time.Now()
 
+	// events pushed to the listener, which are polled as well when some may have been missed
+	var pushed []gjson.Result
+	poll := true
+	if e.listener != nil {
+		// restart the servers that failed
+		if err = e.listener.start(); err != nil {
+			e.Logger.Error().Err(err).Msg("Failed to restart listener")
+		}
+		pushed, poll = e.listener.drain()
+	}
+
 	// add time filter
 	clusterTime, err := collectors.GetClusterTime(e.Client, e.ReturnTimeOut, e.Logger)
 	if err != nil {
+		e.requeue(pushed, poll)
 		return nil, err
 	}
 	toTime := clusterTime.Unix()
+
+	if poll {
+		if records, err = e.pollEvents(clusterTime); err != nil {
+			e.requeue(pushed, poll)
+			return nil, err
+		}
+	}
+	records = appendPushed(records, pushed)
+
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	_, count, instanceCount = e.HandleResults(records, e.emsProp)
+
+	parseD = time.Since(startTime)
+
+	_ = e.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = e.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = e.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = e.Metadata.LazySetValueUint64("instances", "data", instanceCount)
+	if len(e.sinks) > 0 {
+		var dropped uint64
+		for _, f := range e.sinks {
+			dropped += f.Dropped()
+		}
+		_ = e.Metadata.LazySetValueUint64("sink_dropped", "data", dropped)
+	}
+
+	e.AddCollectCount(count)
+
+	// update lastFilterTime to current cluster time
+	e.lastFilterTime = toTime
+	e.saveState()
+	return e.Matrix, nil
+}
+
+// pollEvents returns the events of the cluster since the last poll
+func (e *Ems) pollEvents(clusterTime time.Time) ([]gjson.Result, error) {
+	var records []gjson.Result
+
 	timeFilter := e.getTimeStampFilter(clusterTime)
 	filter := e.Filter
 	filter = append(filter, timeFilter)
@@ -387,24 +504,60 @@ func (e *Ems) PollData() (map[string]*matrix.Matrix, error) {
 		}
 		records = append(records, r...)
 	}
+	return records, nil
+}
 
-	apiD = time.Since(startTime)
-
-	startTime = time.Now()
-	_, count, instanceCount = e.HandleResults(records, e.emsProp)
-
-	parseD = time.Since(startTime)
+// appendPushed appends the pushed events that were not polled. Events are identified by their node and index, pushed
+// events without an index are always appended.
+func appendPushed(records []gjson.Result, pushed []gjson.Result) []gjson.Result {
+	polled := make(map[string]bool, len(records))
+	for _, r := range records {
+		if id, ok := eventID(r); ok {
+			polled[id] = true
+		}
+	}
+	for _, p := range pushed {
+		if id, ok := eventID(p); ok && polled[id] {
+			continue
+		}
+		records = append(records, p)
+	}
+	return records
+}
 
-	_ = e.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
-	_ = e.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
-	_ = e.Metadata.LazySetValueUint64("metrics", "data", count)
-	_ = e.Metadata.LazySetValueUint64("instances", "data", instanceCount)
+func eventID(event gjson.Result) (string, bool) {
+	index := event.Get("index").Int()
+	if index == 0 {
+		return "", false
+	}
+	return event.Get("node.name").String() + "/" + strconv.FormatInt(index, 10), true
+}
 
-	e.AddCollectCount(count)
+// requeue puts back the pushed events of a poll that failed
+func (e *Ems) requeue(pushed []gjson.Result, gap bool) {
+	if e.listener != nil {
+		e.listener.requeue(pushed, gap)
+	}
+}
 
-	// update lastFilterTime to current cluster time
-	e.lastFilterTime = toTime
-	return e.Matrix, nil
+// acceptEvent filters the events pushed to the listener like the queries of the polls: by name and severity
+func (e *Ems) acceptEvent(event gjson.Result) bool {
+	if _, ok := e.emsProp[event.Get("message.name").String()]; !ok {
+		return false
+	}
+	severity := event.Get("message.severity").String()
+	accepted := false
+	for _, s := range strings.Split(strings.TrimPrefix(e.severityFilter, severityFilterPrefix), "|") {
+		if negated, ok := strings.CutPrefix(s, "!"); ok {
+			if strings.EqualFold(negated, severity) {
+				return false
+			}
+			accepted = true
+		} else if s == "*" || strings.EqualFold(s, severity) {
+			accepted = true
+		}
+	}
+	return accepted
 }
 
 func (e *Ems) getHref(names []string, filter []string) string {
@@ -453,6 +606,7 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 	)
 
 	var m = e.Matrix
+	throttled := make(map[string]int)
 
 	for _, instanceData := range result {
 		var (
@@ -512,6 +666,7 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 							instance.SetExportable(true)
 						}
 						emsResolved = true
+						e.resetDedup(issuingEms + bookendKey)
 					}
 				}
 			}
@@ -520,7 +675,13 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 				e.Logger.Warn().Str("resolving ems", msgName).Str("issue ems", strings.Join(issuingEmsList.Slice(), ",")).
 					Msg("Unable to find matching issue ems in cache")
 			}
+			e.forward(instanceData, e.eventLabels(p, instanceData))
 		} else {
+			if reason := e.throttleEvent(msgName, prop[msgName], instanceData); reason != "" {
+				throttled[reason]++
+				continue
+			}
+
 			if _, ok := m[msgName]; !ok {
 				//create matrix if not exists for the ems event
 				mx = matrix.New(msgName, e.Prop.Object, msgName)
@@ -636,6 +797,7 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 								Msg("Unable to find metric")
 						}
 					}
+					e.setDedupCount(p, mx, instance, instanceKey)
 					instanceLabelCount += instanceLabelCountPs
 					isMatch = isMatch || isMatchPs
 				}
@@ -645,9 +807,21 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 				continue
 			}
 			count += instanceLabelCount
+			if instance := mx.GetInstance(instanceKey); instance != nil {
+				e.forward(instanceData, instance.GetLabels())
+			}
 		}
 	}
 
+	e.updateDedupCounts()
+	if len(throttled) > 0 {
+		e.Logger.Debug().
+			Int("suppressed", throttled["suppressed"]).
+			Int("rate_limited", throttled["rate_limited"]).
+			Int("duplicate", throttled["duplicate"]).
+			Msg("Throttled ems")
+	}
+
 	for _, v := range e.Matrix {
 		for _, i := range v.GetInstances() {
 			if i.IsExportable() {
@@ -659,6 +833,57 @@ func (e *Ems) HandleResults(result []gjson.Result, prop map[string][]*emsProp) (
 	return m, count, instanceCount
 }
 
+// eventLabels returns the labels of an event that has no instance, like a resolving ems
+func (e *Ems) eventLabels(p *emsProp, instanceData gjson.Result) map[string]string {
+	labels := make(map[string]string)
+	for label, display := range p.InstanceLabels {
+		if value := parseProperties(instanceData, label); value.Exists() {
+			labels[display] = value.String()
+		}
+	}
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// forward sends an event, with all its parameters and the labels of its instance, to the sinks
+func (e *Ems) forward(instanceData gjson.Result, labels map[string]string) {
+	if len(e.sinks) == 0 {
+		return
+	}
+
+	event := sink.Event{
+		Name:     instanceData.Get("message.name").String(),
+		Severity: instanceData.Get("message.severity").String(),
+		Node:     instanceData.Get("node.name").String(),
+		Index:    instanceData.Get("index").Int(),
+		Message:  instanceData.Get("log_message").String(),
+		Labels:   make(map[string]string),
+	}
+	if t, err := time.Parse(time.RFC3339, instanceData.Get("time").String()); err == nil {
+		event.Time = t
+	} else {
+		event.Time = time.Now()
+	}
+	for k, v := range e.Matrix[e.Object].GetGlobalLabels() {
+		event.Labels[k] = v
+	}
+	for k, v := range labels {
+		event.Labels[k] = v
+	}
+	if parameters := instanceData.Get("parameters").Array(); len(parameters) > 0 {
+		event.Parameters = make(map[string]string, len(parameters))
+		for _, p := range parameters {
+			event.Parameters[p.Get("name").String()] = p.Get("value").String()
+		}
+	}
+
+	for _, f := range e.sinks {
+		f.Send(event)
+	}
+}
+
 func (e *Ems) getInstanceKeys(p *emsProp, instanceData gjson.Result) string {
 	var instanceKey string
 	// extract instance key(s)
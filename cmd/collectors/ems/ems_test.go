@@ -1,15 +1,21 @@
 package ems
 
 import (
+	"encoding/json"
 	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/collectors/ems/sink"
 	"github.com/netapp/harvest/v2/cmd/poller/collector"
 	"github.com/netapp/harvest/v2/cmd/poller/options"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/tree"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
 	"github.com/rs/zerolog/log"
+	"github.com/tidwall/gjson"
+	"net"
 	"os"
+	"path/filepath"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -25,7 +31,7 @@ var autoresolveEmsNames = []string{"LUN.offline", "monitor.fan.critical"}
 
 func Test_Ems(t *testing.T) {
 	// Initialize the Ems collector
-	e := NewEms()
+	e := NewEms(t.TempDir())
 	// Bookend issuing-resolving ems test
 	BookendEmsTest(t, e)
 }
@@ -42,7 +48,7 @@ func BookendEmsTest(t *testing.T, e *Ems) {
 	e.testAutoResolvingEms(t, "testdata/autoresolveEms.json")
 }
 
-func NewEms() *Ems {
+func NewEms(stateDir string) *Ems {
 	// homePath is harvest directory level
 	homePath := "../../../"
 	emsConfigPath := homePath + "conf/ems/default.yaml"
@@ -53,7 +59,9 @@ func NewEms() *Ems {
 	opts.HomePath = homePath
 	opts.IsTest = true
 
-	ac := collector.New("Ems", "Ems", opts, emsParams(emsConfigPath), nil)
+	params := emsParams(emsConfigPath)
+	params.NewChildS("state_dir", stateDir)
+	ac := collector.New("Ems", "Ems", opts, params, nil)
 	e := &Ems{}
 	if err := e.Init(ac); err != nil {
 		log.Fatal().Err(err).Send()
@@ -64,6 +72,38 @@ func NewEms() *Ems {
 	return e
 }
 
+func Test_EmsState(t *testing.T) {
+	stateDir := t.TempDir()
+	e := NewEms(stateDir)
+	e.testBookendIssuingEms(t, "testdata/issuingEms.json")
+	e.lastFilterTime = 1700000000
+	e.saveState()
+
+	// a restarted collector resolves the ems raised before the restart
+	restarted := NewEms(stateDir)
+	if restarted.lastFilterTime != e.lastFilterTime {
+		t.Errorf("lastFilterTime got=%d, want=%d", restarted.lastFilterTime, e.lastFilterTime)
+	}
+	for _, name := range issuingEmsNames {
+		mx, ok := restarted.Matrix[name]
+		if !ok {
+			t.Fatalf("Bookend ems %s not restored", name)
+		}
+		if got, want := len(mx.GetInstances()), len(e.Matrix[name].GetInstances()); got != want {
+			t.Errorf("%s instances got=%d, want=%d", name, got, want)
+		}
+		if got, want := mx.GetGlobalLabels()["cluster"], restarted.Matrix[restarted.Object].GetGlobalLabels()["cluster"]; got != want {
+			t.Errorf("%s cluster label got=%s, want=%s", name, got, want)
+		}
+		for key, instance := range mx.GetInstances() {
+			if instance.IsExportable() {
+				t.Errorf("%s instance %s should not be exported before it is resolved", name, key)
+			}
+		}
+	}
+	restarted.testBookendResolvingEms(t, "testdata/resolvingEms.json")
+}
+
 func emsParams(emsConfigPath string) *node.Node {
 	bytes, err := os.ReadFile(emsConfigPath)
 	if err != nil {
@@ -229,3 +269,127 @@ func (e *Ems) testAutoResolvingEms(t *testing.T, path string) {
 		t.Fatalf("These Bookend Ems haven't been auto resolved: %s", notAutoResolvedEmsNames)
 	}
 }
+
+func Test_EmsSinks(t *testing.T) {
+	e := NewEms(t.TempDir())
+	path := filepath.Join(t.TempDir(), "ems.ndjson")
+	params := node.NewS("file")
+	params.NewChildS("path", path)
+	f, err := sink.NewForwarder(params, e.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sinks = []*sink.Forwarder{f}
+
+	e.testBookendIssuingEms(t, "testdata/issuingEms.json")
+	e.testBookendResolvingEms(t, "testdata/resolvingEms.json")
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var event sink.Event
+		if err = json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		names[event.Name]++
+		if event.Name == "hm.alert.raised" {
+			if event.Parameters["alert_id"] != "RaidLeftBehindAggrAlert" || event.Labels["alert_id"] != "RaidLeftBehindAggrAlert" {
+				t.Errorf("event should have the parameters and labels of the ems, got=%s", line)
+			}
+		}
+	}
+	for _, name := range []string{"hm.alert.raised", "wafl.vvol.offline", "hm.alert.cleared", "wafl.vvol.online"} {
+		if names[name] == 0 {
+			t.Errorf("event %s not forwarded, got=%v", name, names)
+		}
+	}
+}
+
+func Test_EmsPushedAndPolled(t *testing.T) {
+	e := NewEms(t.TempDir())
+	path := filepath.Join(t.TempDir(), "ems.ndjson")
+	params := node.NewS("file")
+	params.NewChildS("path", path)
+	f, err := sink.NewForwarder(params, e.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sinks = []*sink.Forwarder{f}
+
+	// the events are pushed, then polled to fill a gap
+	polled := collectors.JSONToGson("testdata/issuingEms.json", true)
+	pushed := collectors.JSONToGson("testdata/issuingEms.json", true)
+	records := appendPushed(polled, pushed)
+	if len(records) != len(polled) {
+		t.Fatalf("records got=%d, want=%d", len(records), len(polled))
+	}
+
+	e.updateMatrix(time.Now())
+	if _, count, _ := e.HandleResults(records, e.emsProp); count != expectedInstanceLabelCount {
+		t.Errorf("instance labels got=%d, want=%d", count, expectedInstanceLabelCount)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(strings.Split(strings.TrimSpace(string(b)), "\n")); got != len(polled) {
+		t.Errorf("forwarded events got=%d, want=%d", got, len(polled))
+	}
+
+	// pushed events that were not polled, or without an index, are kept
+	newer := gjson.Parse(`{"index": 1, "node": {"name": "umeng-aff300-01"}, "message": {"name": "LUN.offline"}}`)
+	syslog := gjson.Parse(`{"node": {"name": "umeng-aff300-01"}, "message": {"name": "LUN.offline"}}`)
+	if got := appendPushed(polled, []gjson.Result{newer, syslog}); len(got) != len(polled)+2 {
+		t.Errorf("records got=%d, want=%d", len(got), len(polled)+2)
+	}
+}
+
+func Test_EmsStop(t *testing.T) {
+	e := NewEms(t.TempDir())
+	params := node.NewS("listener")
+	params.NewChildS("webhook", "").NewChildS("addr", "127.0.0.1:0")
+	var err error
+	if e.listener, err = newListener(params, e.Logger, e.acceptEvent); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.listener.start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := e.listener.webhook.bound
+
+	// the queued events are written when the collector stops
+	path := filepath.Join(t.TempDir(), "ems.ndjson")
+	sinkParams := node.NewS("file")
+	sinkParams.NewChildS("path", path)
+	sinkParams.NewChildS("flush_interval", "1h")
+	f, err := sink.NewForwarder(sinkParams, e.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sinks = []*sink.Forwarder{f}
+	e.forward(gjson.Parse(`{"message": {"name": "LUN.offline"}}`), nil)
+
+	e.Stop()
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		_ = conn.Close()
+		t.Error("the webhook should not accept connections after the collector stopped")
+	}
+	if b, err := os.ReadFile(path); err != nil || !strings.Contains(string(b), "LUN.offline") {
+		t.Errorf("queued event should be written on stop, got=%s err=%v", b, err)
+	}
+
+	// events forwarded after the stop are dropped
+	e.forward(gjson.Parse(`{"message": {"name": "LUN.online"}}`), nil)
+	if f.Dropped() != 1 {
+		t.Errorf("dropped got=%d, want=1", f.Dropped())
+	}
+}
@@ -0,0 +1,359 @@
+package ems
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMaxPushedEvents = 10_000
+const maxWebhookBodySize = 10 * 1024 * 1024
+
+// listener receives the ems events that ONTAP pushes to its rest-api (webhook) and syslog destinations, and queues
+// them until the next poll of the collector.
+//
+// The queue has a gap when events may have been missed: before the first poll, after a server of the listener
+// failed, or when the queue was full. The collector then polls the events of the cluster since the last poll.
+type listener struct {
+	Logger    *logging.Logger
+	webhook   *server
+	syslog    *server
+	accept    func(gjson.Result) bool // filters the events before they are queued
+	maxEvents int
+
+	mu     sync.Mutex
+	events []gjson.Result
+	gap    bool
+}
+
+// server is a webhook or syslog server of the listener
+type server struct {
+	kind     string
+	addr     string
+	bound    string // address of the running server, e.g. with the port chosen by the system for port 0
+	path     string // of the webhook
+	tls      *tls.Config
+	serve    func(net.Listener) error
+	mu       sync.Mutex
+	running  bool
+	shutdown func()
+}
+
+// newListener creates the listener of the listener section of the collector parameters, or returns nil when there
+// is none
+//
+//	listener:
+//	  max_events: 10000
+//	  webhook:
+//	    addr: :8443
+//	    path: /ems
+//	    tls:
+//	      cert_file: cert/harvest.crt
+//	      key_file: cert/harvest.key
+//	      ca_cert_file: cert/ontap-ca.crt  # optional, requires client certificates signed by this CA
+//	  syslog:
+//	    addr: :6514
+//	    tls:
+//	      cert_file: cert/harvest.crt
+//	      key_file: cert/harvest.key
+func newListener(params *node.Node, logger *logging.Logger, accept func(gjson.Result) bool) (*listener, error) {
+	if params == nil {
+		return nil, nil
+	}
+
+	l := &listener{
+		Logger:    logger,
+		accept:    accept,
+		maxEvents: defaultMaxPushedEvents,
+		gap:       true,
+	}
+
+	if s := params.GetChildContentS("max_events"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "listener max_events: "+s)
+		}
+		l.maxEvents = n
+	}
+
+	var err error
+	if w := params.GetChildS("webhook"); w != nil {
+		if l.webhook, err = newServer("webhook", w); err != nil {
+			return nil, err
+		}
+		l.webhook.path = w.GetChildContentS("path")
+		if l.webhook.path == "" {
+			l.webhook.path = "/"
+		}
+		l.webhook.serve = l.serveWebhook
+	}
+	if s := params.GetChildS("syslog"); s != nil {
+		if l.syslog, err = newServer("syslog", s); err != nil {
+			return nil, err
+		}
+		l.syslog.serve = l.serveSyslog
+	}
+	if l.webhook == nil && l.syslog == nil {
+		return nil, errs.New(errs.ErrMissingParam, "listener must have a webhook or syslog section")
+	}
+	return l, nil
+}
+
+func newServer(kind string, params *node.Node) (*server, error) {
+	s := &server{kind: kind, addr: params.GetChildContentS("addr")}
+	if s.addr == "" {
+		return nil, errs.New(errs.ErrMissingParam, "listener "+kind+" addr")
+	}
+
+	t := params.GetChildS("tls")
+	if t == nil {
+		return s, nil
+	}
+	certFile := t.GetChildContentS("cert_file")
+	keyFile := t.GetChildContentS("key_file")
+	if certFile == "" || keyFile == "" {
+		return nil, errs.New(errs.ErrMissingParam, "listener "+kind+" tls cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errs.New(errs.ErrConfig, "listener "+kind+" tls: "+err.Error())
+	}
+	s.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+
+	if caFile := t.GetChildContentS("ca_cert_file"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, errs.New(errs.ErrConfig, "listener "+kind+" tls: "+err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errs.New(errs.ErrConfig, "listener "+kind+" tls: no certificate in "+caFile)
+		}
+		s.tls.ClientCAs = pool
+		s.tls.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return s, nil
+}
+
+// start starts the servers of the listener that are not running, and returns the first error
+func (l *listener) start() error {
+	var firstErr error
+	for _, s := range []*server{l.webhook, l.syslog} {
+		if s == nil {
+			continue
+		}
+		if err := l.startServer(s); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (l *listener) startServer(s *server) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errs.New(errs.ErrConnection, "listener "+s.kind+": "+err.Error())
+	}
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	s.running = true
+	s.bound = ln.Addr().String()
+	s.shutdown = func() { _ = ln.Close() }
+	l.Logger.Info().Str("kind", s.kind).Str("addr", s.bound).Bool("tls", s.tls != nil).Msg("listener started")
+
+	go func() {
+		err := s.serve(ln)
+		s.mu.Lock()
+		stopped := !s.running
+		s.running = false
+		s.mu.Unlock()
+		if stopped {
+			return
+		}
+		// events may be missed until the server is restarted by the next poll
+		l.Logger.Error().Err(err).Str("kind", s.kind).Msg("listener failed")
+		l.setGap()
+	}()
+	return nil
+}
+
+// stop stops the servers of the listener
+func (l *listener) stop() {
+	for _, s := range []*server{l.webhook, l.syslog} {
+		if s == nil {
+			continue
+		}
+		s.mu.Lock()
+		if s.running {
+			s.running = false
+			s.shutdown()
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (l *listener) push(events ...gjson.Result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, event := range events {
+		if l.accept != nil && !l.accept(event) {
+			continue
+		}
+		if len(l.events) >= l.maxEvents {
+			// the oldest events are dropped, and polled instead
+			l.events = l.events[1:]
+			if !l.gap {
+				l.Logger.Warn().Int("max_events", l.maxEvents).Msg("listener queue is full, dropping events")
+			}
+			l.gap = true
+		}
+		l.events = append(l.events, event)
+	}
+}
+
+func (l *listener) setGap() {
+	l.mu.Lock()
+	l.gap = true
+	l.mu.Unlock()
+}
+
+// drain returns the queued events, and whether events may have been missed since the last drain
+func (l *listener) drain() ([]gjson.Result, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events, gap := l.events, l.gap
+	l.events = nil
+	l.gap = false
+	return events, gap
+}
+
+// requeue puts back drained events that could not be handled, before the events pushed since
+func (l *listener) requeue(events []gjson.Result, gap bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(events, l.events...)
+	if len(l.events) > l.maxEvents {
+		l.events = l.events[len(l.events)-l.maxEvents:]
+		gap = true
+	}
+	l.gap = l.gap || gap
+}
+
+func (l *listener) serveWebhook(ln net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(l.webhook.path, l.handleWebhook)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 60 * time.Second,
+	}
+	err := srv.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// handleWebhook accepts a JSON event in the format of the records of api/support/ems/events, an array of events, or
+// an object with the events in records
+func (l *listener) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBodySize {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	events, err := parseWebhook(body)
+	if err != nil {
+		l.Logger.Warn().Err(err).Str("remote", r.RemoteAddr).Msg("invalid webhook event")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.push(events...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseWebhook(body []byte) ([]gjson.Result, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("invalid JSON")
+	}
+	result := gjson.ParseBytes(body)
+	if records := result.Get("records"); records.IsArray() {
+		result = records
+	}
+	if result.IsArray() {
+		return result.Array(), nil
+	}
+	if result.IsObject() {
+		return []gjson.Result{result}, nil
+	}
+	return nil, errors.New("event must be a JSON object or array")
+}
+
+func (l *listener) serveSyslog(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go l.handleSyslog(conn)
+	}
+}
+
+func (l *listener) handleSyslog(conn net.Conn) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	err := readSyslog(conn, func(msg []byte) {
+		event, err := parseSyslog(msg)
+		if err != nil {
+			l.Logger.Warn().Err(err).Str("remote", remote).Str("message", truncate(string(msg), 200)).
+				Msg("invalid syslog message")
+			return
+		}
+		l.push(event)
+	})
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		l.Logger.Warn().Err(err).Str("remote", remote).Msg("syslog connection closed")
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "") + "..."
+}
@@ -0,0 +1,165 @@
+package ems
+
+import (
+	"bytes"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		name, msg                      string
+		event, severity, node, message string
+		params                         map[string]string
+	}{
+		{
+			name:     "msgid and structured data",
+			msg:      `<11>1 2023-05-09T09:42:13Z cluster-01 mgwd - LUN.offline [ems@789 object_uuid="abc" lun_path="/vol/v1/\"l1\""] LUN went offline`,
+			event:    "LUN.offline",
+			severity: "error",
+			node:     "cluster-01",
+			message:  "LUN went offline",
+			params:   map[string]string{"object_uuid": "abc", "lun_path": `/vol/v1/"l1"`},
+		},
+		{
+			name:     "ontap message prefix",
+			msg:      `<13>1 2023-05-09T09:42:13Z - - - - - [node-01:wafl.vvol.offline:notice]: Volume 'v1' has gone offline.`,
+			event:    "wafl.vvol.offline",
+			severity: "notice",
+			node:     "node-01",
+			message:  "Volume 'v1' has gone offline.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := parseSyslog([]byte(tt.msg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := event.Get("message.name").String(); got != tt.event {
+				t.Errorf("name got=%s, want=%s", got, tt.event)
+			}
+			if got := event.Get("message.severity").String(); got != tt.severity {
+				t.Errorf("severity got=%s, want=%s", got, tt.severity)
+			}
+			if got := event.Get("node.name").String(); got != tt.node {
+				t.Errorf("node got=%s, want=%s", got, tt.node)
+			}
+			if got := event.Get("log_message").String(); got != tt.message {
+				t.Errorf("log_message got=%s, want=%s", got, tt.message)
+			}
+			for name, value := range tt.params {
+				if got := parseProperties(event, "parameters."+name).String(); got != value {
+					t.Errorf("parameter %s got=%s, want=%s", name, got, value)
+				}
+			}
+		})
+	}
+
+	for _, msg := range []string{"LUN.offline", "<13>2 - - - - - -", "<13>1 - - - - - - no name", `<13>1 - - - - x [a b="c] d`} {
+		if _, err := parseSyslog([]byte(msg)); err == nil {
+			t.Errorf("parseSyslog(%q) should fail", msg)
+		}
+	}
+}
+
+func TestReadSyslog(t *testing.T) {
+	stream := "<13>1 - - - - a -\n19 <13>1 - - - - b - x\n<13>1 - - - - c -"
+	var got []string
+	if err := readSyslog(strings.NewReader(stream), func(msg []byte) { got = append(got, string(msg)) }); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"<13>1 - - - - a -", "<13>1 - - - - b - x", "<13>1 - - - - c -"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestListener(t *testing.T) {
+	params := node.NewS("listener")
+	params.NewChildS("max_events", "2")
+	params.NewChildS("webhook", "").NewChildS("addr", "127.0.0.1:0")
+	params.NewChildS("syslog", "").NewChildS("addr", "127.0.0.1:0")
+
+	accept := func(event gjson.Result) bool { return event.Get("message.name").String() != "ignored" }
+	l, err := newListener(params, logging.Get(), accept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer l.stop()
+
+	if _, gap := l.drain(); !gap {
+		t.Error("the first poll should fill the gap before the listener started")
+	}
+
+	body := `{"records": [{"message": {"name": "LUN.offline"}}, {"message": {"name": "ignored"}}]}`
+	resp, err := http.Post("http://"+l.webhook.bound+"/", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("webhook status got=%d, want=%d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	conn, err := net.Dial("tcp", l.syslog.bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("<11>1 - node-01 - - LUN.online -\n"))
+	_ = conn.Close()
+
+	var events []gjson.Result
+	var gap bool
+	for i := 0; i < 50 && len(events) < 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		l.mu.Lock()
+		events, gap = l.events, l.gap
+		l.mu.Unlock()
+	}
+	if len(events) != 2 || gap {
+		t.Fatalf("events got=%d gap=%v, want=2 and no gap", len(events), gap)
+	}
+	if events[0].Get("message.name").String() != "LUN.offline" || events[1].Get("message.name").String() != "LUN.online" {
+		t.Errorf("events got=%v", events)
+	}
+
+	// when the queue is full, the oldest events are dropped and polled instead
+	l.push(gjson.Parse(`{"message": {"name": "LUN.destroy"}}`))
+	events, gap = l.drain()
+	if len(events) != 2 || !gap || events[1].Get("message.name").String() != "LUN.destroy" {
+		t.Errorf("full queue got=%d gap=%v, want=2 and a gap", len(events), gap)
+	}
+}
+
+func TestAcceptEvent(t *testing.T) {
+	e := NewEms(t.TempDir())
+	tests := []struct {
+		event string
+		want  bool
+	}{
+		{`{"message": {"name": "LUN.offline", "severity": "alert"}}`, true},
+		{`{"message": {"name": "LUN.offline", "severity": "debug"}}`, false},
+		{`{"message": {"name": "not.in.template", "severity": "alert"}}`, false},
+	}
+	for _, tt := range tests {
+		if got := e.acceptEvent(gjson.Parse(tt.event)); got != tt.want {
+			t.Errorf("acceptEvent(%s) got=%v, want=%v", tt.event, got, tt.want)
+		}
+	}
+
+	e.severityFilter = severityFilterPrefix + "!informational"
+	if !e.acceptEvent(gjson.Parse(`{"message": {"name": "LUN.offline", "severity": "debug"}}`)) {
+		t.Error("debug events should be accepted when only informational events are excluded")
+	}
+}
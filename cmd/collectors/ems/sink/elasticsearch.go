@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"strconv"
+	"strings"
+)
+
+const defaultElasticsearchIndex = "harvest-ems"
+
+// Elasticsearch indexes the events with the bulk API of Elasticsearch
+//
+//	elasticsearch:
+//	  url: http://localhost:9200
+//	  index: harvest-ems-{date}   # {date} is the date of the event, as 2006.01.02
+//
+// The id of a document is the index of its event on its node, when the event has one, so that a batch that is retried
+// does not index the same event twice.
+type Elasticsearch struct {
+	*httpClient
+	index string
+}
+
+type esAction struct {
+	Index esIndex `json:"index"`
+}
+
+type esIndex struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id,omitempty"`
+}
+
+type esDocument struct {
+	Timestamp string `json:"@timestamp"`
+	*Event
+}
+
+type esResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func newElasticsearch(params *node.Node) (*Elasticsearch, error) {
+	c, err := newHTTPClient("elasticsearch", params)
+	if err != nil {
+		return nil, err
+	}
+	e := &Elasticsearch{httpClient: c, index: params.GetChildContentS("index")}
+	if e.index == "" {
+		e.index = defaultElasticsearchIndex
+	}
+	return e, nil
+}
+
+func (e *Elasticsearch) Name() string {
+	return "elasticsearch"
+}
+
+func (e *Elasticsearch) Write(events []Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for i := range events {
+		event := &events[i]
+		action := esAction{Index: esIndex{Index: strings.ReplaceAll(e.index, "{date}", event.Time.UTC().Format("2006.01.02"))}}
+		if event.Index != 0 {
+			action.Index.ID = event.id()
+		}
+		if err := enc.Encode(action); err != nil {
+			return Permanent(err)
+		}
+		if err := enc.Encode(esDocument{Timestamp: event.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"), Event: event}); err != nil {
+			return Permanent(err)
+		}
+	}
+
+	b, err := e.post(strings.TrimSuffix(e.url, "/")+"/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// the bulk API returns 200 when some documents fail. The batch is retried when a failure is temporary.
+	var response esResponse
+	if err = json.Unmarshal(b, &response); err != nil {
+		return Permanent(fmt.Errorf("invalid bulk response: %w", err))
+	}
+	if !response.Errors {
+		return nil
+	}
+	failed, retry := 0, false
+	var first json.RawMessage
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Status >= 200 && result.Status <= 299 {
+				continue
+			}
+			failed++
+			if first == nil {
+				first = result.Error
+			}
+			if result.Status == 429 || result.Status >= 500 {
+				retry = true
+			}
+		}
+	}
+	err = fmt.Errorf("%d of %d documents failed: %s", failed, len(events), truncate(string(first), 500))
+	if !retry {
+		return Permanent(err)
+	}
+	return err
+}
+
+func (e *Elasticsearch) Close() error {
+	return nil
+}
+
+// id identifies an event by its cluster, node and index
+func (e *Event) id() string {
+	return e.Labels["cluster_uuid"] + "-" + e.Node + "-" + strconv.FormatInt(e.Index, 10)
+}
@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	defaultMaxFileSize = 100 // MB
+	defaultMaxFiles    = 5
+)
+
+// File appends the events to a file, as one JSON object per line (NDJSON). The file is rotated when it reaches
+// max_size: ems.ndjson is renamed ems.ndjson.1, ems.ndjson.1 is renamed ems.ndjson.2, and so on, up to max_files.
+//
+//	file:
+//	  path: /var/log/harvest/ems.ndjson
+//	  max_size: 100   # MB
+//	  max_files: 5    # rotated files that are kept
+type File struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFile(params *node.Node) (*File, error) {
+	f := &File{
+		path:     params.GetChildContentS("path"),
+		maxSize:  defaultMaxFileSize * 1024 * 1024,
+		maxFiles: defaultMaxFiles,
+	}
+	if f.path == "" {
+		return nil, errs.New(errs.ErrMissingParam, "file path")
+	}
+	if s := params.GetChildContentS("max_size"); s != "" {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n <= 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "file max_size: "+s)
+		}
+		f.maxSize = int64(n * 1024 * 1024)
+	}
+	if s := params.GetChildContentS("max_files"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "file max_files: "+s)
+		}
+		f.maxFiles = n
+	}
+	return f, nil
+}
+
+func (f *File) Name() string {
+	return "file"
+}
+
+func (f *File) Write(events []Event) error {
+	lines, err := ndjson(events)
+	if err != nil {
+		return Permanent(err)
+	}
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(lines)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(lines)
+	f.size += int64(n)
+	if err != nil {
+		// the next write reopens the file
+		_ = f.Close()
+	}
+	return err
+}
+
+func (f *File) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) rotate() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if f.maxFiles == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return f.open()
+	}
+	for i := f.maxFiles - 1; i >= 0; i-- {
+		from := f.path
+		if i > 0 {
+			from = fmt.Sprintf("%s.%d", f.path, i)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return f.open()
+}
+
+// Stdout writes the events to the standard output, as one JSON object per line
+type Stdout struct {
+	w io.Writer
+}
+
+func newStdout() *Stdout {
+	return &Stdout{w: os.Stdout}
+}
+
+func (s *Stdout) Name() string {
+	return "stdout"
+}
+
+func (s *Stdout) Write(events []Event) error {
+	lines, err := ndjson(events)
+	if err != nil {
+		return Permanent(err)
+	}
+	_, err = s.w.Write(lines)
+	return err
+}
+
+func (s *Stdout) Close() error {
+	return nil
+}
+
+func ndjson(events []Event) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for i := range events {
+		if err := enc.Encode(&events[i]); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
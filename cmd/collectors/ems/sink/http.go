@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/tls"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"io"
+	"net/http"
+	"time"
+)
+
+const defaultHTTPTimeout = 30 * time.Second
+
+// httpClient posts the batches of the loki and elasticsearch sinks
+//
+//	url: https://host:port/path
+//	username: user          # optional, basic auth
+//	password: pass
+//	headers:                # optional, e.g. the tenant of loki
+//	  X-Scope-OrgID: harvest
+//	timeout: 30s
+//	tls_skip_verify: false
+type httpClient struct {
+	url      string
+	username string
+	password string
+	headers  map[string]string
+	client   *http.Client
+}
+
+func newHTTPClient(kind string, params *node.Node) (*httpClient, error) {
+	c := &httpClient{
+		url:      params.GetChildContentS("url"),
+		username: params.GetChildContentS("username"),
+		password: params.GetChildContentS("password"),
+		headers:  make(map[string]string),
+	}
+	if c.url == "" {
+		return nil, errs.New(errs.ErrMissingParam, kind+" url")
+	}
+	if h := params.GetChildS("headers"); h != nil {
+		for _, header := range h.GetChildren() {
+			c.headers[header.GetNameS()] = header.GetContentS()
+		}
+	}
+
+	timeout := defaultHTTPTimeout
+	if s := params.GetChildContentS("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, errs.New(errs.ErrInvalidParam, kind+" timeout: "+s)
+		}
+		timeout = d
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if params.GetChildContentS("tls_skip_verify") == "true" {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	c.client = &http.Client{Transport: transport, Timeout: timeout}
+	return c, nil
+}
+
+// post sends body to url, and returns the body of the response when its status is 2xx
+func (c *httpClient) post(url, contentType string, body []byte) ([]byte, error) {
+	request, err := requests.New(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	request.Header.Set("Content-Type", contentType)
+	for name, value := range c.headers {
+		request.Header.Set(name, value)
+	}
+	if c.username != "" {
+		request.SetBasicAuth(c.username, c.password)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, httpError(response.StatusCode, b)
+	}
+	return b, nil
+}
@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var defaultLokiLabels = []string{"cluster", "node", "severity", "name"}
+
+// Loki pushes the events to the push API of Loki, as JSON lines of streams of the labels of the events
+//
+//	loki:
+//	  url: http://localhost:3100/loki/api/v1/push
+//	  labels:     # labels of the streams, the name, severity and node of the event, or labels of the instance
+//	    - cluster
+//	    - severity
+type Loki struct {
+	*httpClient
+	labels []string
+}
+
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLoki(params *node.Node) (*Loki, error) {
+	c, err := newHTTPClient("loki", params)
+	if err != nil {
+		return nil, err
+	}
+	l := &Loki{httpClient: c, labels: defaultLokiLabels}
+	if labels := params.GetChildS("labels"); labels != nil {
+		l.labels = labels.GetAllChildContentS()
+	}
+	return l, nil
+}
+
+func (l *Loki) Name() string {
+	return "loki"
+}
+
+func (l *Loki) Write(events []Event) error {
+	// loki rejects the entries of a stream that are older than the last one
+	events = slices.Clone(events)
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+
+	streams := make(map[string]*lokiStream)
+	var keys []string
+	for _, event := range events {
+		labels := make(map[string]string)
+		var key strings.Builder
+		for _, name := range l.labels {
+			if value := event.label(name); value != "" {
+				labels[name] = value
+				key.WriteString(name + "=" + value + ",")
+			}
+		}
+		stream := streams[key.String()]
+		if stream == nil {
+			stream = &lokiStream{Stream: labels}
+			streams[key.String()] = stream
+			keys = append(keys, key.String())
+		}
+		line, err := json.Marshal(event)
+		if err != nil {
+			return Permanent(err)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(event.Time.UnixNano(), 10), string(line)})
+	}
+
+	push := lokiPush{}
+	for _, key := range keys {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return Permanent(err)
+	}
+	_, err = l.post(l.url, "application/json", body)
+	return err
+}
+
+func (l *Loki) Close() error {
+	return nil
+}
+
+// label returns the name, severity or node of the event, or a label of its instance
+func (e *Event) label(name string) string {
+	switch name {
+	case "name":
+		return e.Name
+	case "severity":
+		return e.Severity
+	case "node":
+		if e.Node != "" {
+			return e.Node
+		}
+	}
+	return e.Labels[name]
+}
@@ -0,0 +1,301 @@
+// Package sink forwards ems events as structured logs to log stores and files.
+//
+// Each sink is configured in the sinks section of the Ems collector
+//
+//	sinks:
+//	  loki:
+//	    url: http://localhost:3100/loki/api/v1/push
+//	  file:
+//	    path: /var/log/harvest/ems.ndjson
+//
+// and receives the events through a Forwarder, which batches them and retries the batches that failed.
+package sink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 500
+	defaultQueueSize     = 10_000
+	defaultFlushInterval = 5 * time.Second
+	defaultMaxRetries    = 5
+	defaultRetryInterval = time.Second
+	maxRetryInterval     = time.Minute
+)
+
+// Event is an ems event with all its parameters, and the labels of its instance
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Name       string            `json:"name"`
+	Severity   string            `json:"severity,omitempty"`
+	Node       string            `json:"node,omitempty"`
+	Index      int64             `json:"index,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// Sink writes batches of events to a destination
+type Sink interface {
+	Name() string
+	Write(events []Event) error
+	Close() error
+}
+
+// permanentError is an error of a batch that fails again when it is retried, e.g. a rejected request
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error of a sink as not worth retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// New creates the sink of a node of the sinks section. The name of the node is the kind of the sink.
+func New(params *node.Node) (Sink, error) {
+	switch kind := params.GetNameS(); kind {
+	case "loki":
+		return newLoki(params)
+	case "elasticsearch":
+		return newElasticsearch(params)
+	case "file":
+		return newFile(params)
+	case "stdout":
+		return newStdout(), nil
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "unknown sink: "+kind)
+	}
+}
+
+// Forwarder sends the events to a sink in batches, from a goroutine, so that a slow or unavailable destination does
+// not delay the polls of the collector. A batch that fails is retried with an exponential backoff, then dropped.
+type Forwarder struct {
+	Logger        *logging.Logger
+	sink          Sink
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryInterval time.Duration
+	events        chan Event
+	done          chan struct{}
+	stop          chan struct{} // closed by Close, interrupts the wait before a retry
+	closed        bool          // events sent after Close are dropped, failed batches are not retried
+	dropped       uint64        // events dropped because the queue was full or the batch failed
+	queueFull     int           // events dropped because the queue was full, since the last write
+	mu            sync.Mutex
+	sleep         func(time.Duration)
+}
+
+// NewForwarder creates the sink of params and starts forwarding to it. The parameters of the forwarder are
+//
+//	batch_size: 500      # events per write
+//	flush_interval: 5s   # max delay of an event
+//	queue_size: 10000    # events waiting to be written, more are dropped
+//	max_retries: 5
+//	retry_interval: 1s   # doubles after each retry
+func NewForwarder(params *node.Node, logger *logging.Logger) (*Forwarder, error) {
+	s, err := New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Forwarder{
+		Logger:        logger,
+		sink:          s,
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+		maxRetries:    defaultMaxRetries,
+		retryInterval: defaultRetryInterval,
+		done:          make(chan struct{}),
+		stop:          make(chan struct{}),
+	}
+	f.sleep = f.pause
+
+	queueSize := defaultQueueSize
+	for name, value := range map[string]*int{"batch_size": &f.batchSize, "queue_size": &queueSize, "max_retries": &f.maxRetries} {
+		if s := params.GetChildContentS(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n == 0 && name != "max_retries" {
+				return nil, errs.New(errs.ErrInvalidParam, name+": "+s)
+			}
+			*value = n
+		}
+	}
+	for name, value := range map[string]*time.Duration{"flush_interval": &f.flushInterval, "retry_interval": &f.retryInterval} {
+		if s := params.GetChildContentS(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return nil, errs.New(errs.ErrInvalidParam, name+": "+s)
+			}
+			*value = d
+		}
+	}
+
+	f.events = make(chan Event, queueSize)
+	go f.run()
+	return f, nil
+}
+
+// Name returns the name of the sink
+func (f *Forwarder) Name() string {
+	return f.sink.Name()
+}
+
+// Send queues an event, or drops it when the queue is full or the forwarder is closed
+func (f *Forwarder) Send(event Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		f.dropped++
+		return
+	}
+	select {
+	case f.events <- event:
+	default:
+		// logged by the next write, since the queue may be full for many events
+		f.dropped++
+		f.queueFull++
+	}
+}
+
+// Dropped returns the number of events that were not written
+func (f *Forwarder) Dropped() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dropped
+}
+
+// Close writes the queued events and closes the sink. Batches that fail are dropped instead of retried, so that an
+// unavailable destination does not delay the poller when it stops.
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.stop)
+	close(f.events)
+	f.mu.Unlock()
+	<-f.done
+	return f.sink.Close()
+}
+
+func (f *Forwarder) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, f.batchSize)
+	for {
+		select {
+		case event, ok := <-f.events:
+			if !ok {
+				f.write(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= f.batchSize {
+				f.write(batch)
+				batch = make([]Event, 0, f.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				f.write(batch)
+				batch = make([]Event, 0, f.batchSize)
+			}
+		}
+	}
+}
+
+func (f *Forwarder) write(batch []Event) {
+	f.mu.Lock()
+	queueFull := f.queueFull
+	f.queueFull = 0
+	f.mu.Unlock()
+	if queueFull > 0 {
+		f.Logger.Error().Str("sink", f.sink.Name()).Int("events", queueFull).Msg("Dropped events, queue is full")
+	}
+
+	if len(batch) == 0 {
+		return
+	}
+	wait := f.retryInterval
+	for attempt := 0; ; attempt++ {
+		err := f.sink.Write(batch)
+		if err == nil {
+			return
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= f.maxRetries || f.isClosed() {
+			f.drop(len(batch), err)
+			return
+		}
+		f.Logger.Warn().Err(err).
+			Str("sink", f.sink.Name()).
+			Int("attempt", attempt+1).
+			Str("retryIn", wait.String()).
+			Msg("Failed to write events")
+		f.sleep(wait)
+		wait = min(2*wait, maxRetryInterval)
+	}
+}
+
+// pause waits before the retry of a batch, until the forwarder is closed
+func (f *Forwarder) pause(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-f.stop:
+	}
+}
+
+func (f *Forwarder) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *Forwarder) drop(n int, err error) {
+	f.mu.Lock()
+	f.dropped += uint64(n)
+	f.mu.Unlock()
+	f.Logger.Error().Err(err).Str("sink", f.sink.Name()).Int("events", n).Msg("Dropped events")
+}
+
+// httpError is the error of an unexpected status of an HTTP sink. Client errors are permanent, except for 408 and 429.
+func httpError(status int, body []byte) error {
+	err := fmt.Errorf("status %d: %s", status, truncate(string(body), 500))
+	if status >= 400 && status < 500 && status != 408 && status != 429 {
+		return Permanent(err)
+	}
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,265 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testEvents() []Event {
+	start := time.Date(2023, 5, 9, 9, 42, 13, 0, time.UTC)
+	return []Event{
+		{Time: start.Add(time.Second), Name: "LUN.online", Severity: "notice", Node: "node-01", Index: 2,
+			Labels: map[string]string{"cluster": "c1", "cluster_uuid": "u1"}, Parameters: map[string]string{"lun_path": "/vol/v1/l1"}},
+		{Time: start, Name: "LUN.offline", Severity: "error", Node: "node-01", Index: 1,
+			Labels: map[string]string{"cluster": "c1", "cluster_uuid": "u1"}, Parameters: map[string]string{"lun_path": "/vol/v1/l1"}},
+	}
+}
+
+func params(kind string, children map[string]string) *node.Node {
+	n := node.NewS(kind)
+	for k, v := range children {
+		n.NewChildS(k, v)
+	}
+	return n
+}
+
+func TestLoki(t *testing.T) {
+	var push lokiPush
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") != "harvest" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	p := params("loki", map[string]string{"url": server.URL + "/loki/api/v1/push"})
+	p.NewChildS("headers", "").NewChildS("X-Scope-OrgID", "harvest")
+	s, err := New(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(push.Streams) != 2 {
+		t.Fatalf("streams got=%d, want=2", len(push.Streams))
+	}
+	first := push.Streams[0]
+	want := map[string]string{"cluster": "c1", "node": "node-01", "severity": "error", "name": "LUN.offline"}
+	for k, v := range want {
+		if first.Stream[k] != v {
+			t.Errorf("stream label %s got=%s, want=%s", k, first.Stream[k], v)
+		}
+	}
+	var line Event
+	if err = json.Unmarshal([]byte(first.Values[0][1]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if first.Values[0][0] != "1683625333000000000" || line.Parameters["lun_path"] != "/vol/v1/l1" {
+		t.Errorf("entry got=%v", first.Values[0])
+	}
+}
+
+func TestElasticsearch(t *testing.T) {
+	var lines []string
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/_bulk" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+		if calls == 1 {
+			_, _ = w.Write([]byte(`{"errors": true, "items": [{"index": {"status": 201}}, {"index": {"status": 429, "error": {"type": "es_rejected_execution_exception"}}}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"errors": false, "items": []}`))
+	}))
+	defer server.Close()
+
+	s, err := New(params("elasticsearch", map[string]string{"url": server.URL + "/", "index": "ems-{date}"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(testEvents()); err == nil || errors.As(err, new(*permanentError)) {
+		t.Errorf("a rejected document should be retried, got err=%v", err)
+	}
+	if err = s.Write(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 4 {
+		t.Fatalf("lines got=%d, want=4", len(lines))
+	}
+	if lines[0] != `{"index":{"_index":"ems-2023.05.09","_id":"u1-node-01-2"}}` {
+		t.Errorf("action got=%s", lines[0])
+	}
+	var doc map[string]any
+	if err = json.Unmarshal([]byte(lines[1]), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["@timestamp"] != "2023-05-09T09:42:14.000Z" || doc["name"] != "LUN.online" {
+		t.Errorf("document got=%v", doc)
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ems", "ems.ndjson")
+	s, err := New(params("file", map[string]string{"path": path, "max_size": "0.0002", "max_files": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each write is larger than half the max size, so each write after the first rotates
+	for i := 0; i < 4; i++ {
+		if err = s.Write(testEvents()[:1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event Event
+		if err = json.Unmarshal(bytes.TrimSpace(b), &event); err != nil || event.Name != "LUN.online" {
+			t.Errorf("%s got=%s err=%v", name, b, err)
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("only 2 rotated files should be kept, err=%v", err)
+	}
+}
+
+func TestStdout(t *testing.T) {
+	var b bytes.Buffer
+	s := &Stdout{w: &b}
+	if err := s.Write(testEvents()); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(&b)
+	n := 0
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("lines got=%d, want=2", n)
+	}
+}
+
+// fakeSink fails the first writes
+type fakeSink struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	writes   [][]Event
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+func (s *fakeSink) Close() error { return nil }
+func (s *fakeSink) Write(events []Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	s.writes = append(s.writes, events)
+	return nil
+}
+
+func newTestForwarder(s Sink, batchSize int) *Forwarder {
+	f := &Forwarder{
+		Logger:        logging.Get(),
+		sink:          s,
+		batchSize:     batchSize,
+		flushInterval: time.Hour,
+		maxRetries:    2,
+		retryInterval: time.Second,
+		events:        make(chan Event, 10),
+		done:          make(chan struct{}),
+		stop:          make(chan struct{}),
+		sleep:         func(time.Duration) {},
+	}
+	go f.run()
+	return f
+}
+
+func TestForwarder(t *testing.T) {
+	s := &fakeSink{failures: 2, err: errors.New("unavailable")}
+	f := newTestForwarder(s, 2)
+	for i := 0; i < 3; i++ {
+		f.Send(Event{Name: "LUN.offline"})
+	}
+	// the first batch is retried before close
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.writes)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the first batch succeeds at the last retry, the second batch is written on close
+	if len(s.writes) != 2 || len(s.writes[0]) != 2 || len(s.writes[1]) != 1 || f.Dropped() != 0 {
+		t.Errorf("writes got=%v dropped=%d", s.writes, f.Dropped())
+	}
+
+	s = &fakeSink{failures: 1, err: Permanent(errors.New("bad request"))}
+	f = newTestForwarder(s, 2)
+	f.Send(Event{Name: "LUN.offline"})
+	_ = f.Close()
+	if len(s.writes) != 0 || f.Dropped() != 1 {
+		t.Errorf("a permanent error should drop the batch, writes=%v dropped=%d", s.writes, f.Dropped())
+	}
+}
+
+func TestForwarderCloseStopsRetries(t *testing.T) {
+	s := &fakeSink{failures: 100, err: errors.New("unavailable")}
+	f := newTestForwarder(s, 1)
+	f.retryInterval = time.Hour
+	f.sleep = f.pause
+	f.Send(Event{Name: "LUN.offline"})
+
+	start := time.Now()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("close should not wait for the retries, took %s", elapsed)
+	}
+	if f.Dropped() != 1 {
+		t.Errorf("dropped got=%d, want=1", f.Dropped())
+	}
+}
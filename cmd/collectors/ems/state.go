@@ -0,0 +1,127 @@
+package ems
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/persist"
+	"maps"
+	"os"
+	"sort"
+)
+
+const stateVersion = 1
+
+// emsState is the state of the collector that is kept across restarts of the poller: the active instances of the
+// issuing bookend ems, which would not be resolved otherwise, and the time of the last poll.
+type emsState struct {
+	Version        int                       `json:"version"`
+	ClusterUUID    string                    `json:"cluster_uuid"`
+	LastFilterTime int64                     `json:"last_filter_time"`
+	Events         map[string][]bookendEvent `json:"events"` // active instances of each issuing ems
+}
+
+type bookendEvent struct {
+	Key       string            `json:"key"`
+	Labels    map[string]string `json:"labels"`
+	Timestamp float64           `json:"timestamp"` // value of the timestamp metric, in microseconds
+}
+
+// saveState writes the active bookend instances and the time of the last poll to the state file
+func (e *Ems) saveState() {
+	s := emsState{
+		Version:        stateVersion,
+		ClusterUUID:    e.Client.Cluster().UUID,
+		LastFilterTime: e.lastFilterTime,
+		Events:         make(map[string][]bookendEvent),
+	}
+
+	for issuingEms := range e.resolveAfter {
+		mx := e.Matrix[issuingEms]
+		if mx == nil {
+			continue
+		}
+		eventMetric := mx.GetMetric("events")
+		timestampMetric := mx.GetMetric("timestamp")
+		if eventMetric == nil {
+			continue
+		}
+		for key, instance := range mx.GetInstances() {
+			if val, ok := eventMetric.GetValueFloat64(instance); !ok || val != 1 {
+				continue
+			}
+			event := bookendEvent{Key: key, Labels: maps.Clone(instance.GetLabels())}
+			if timestampMetric != nil {
+				event.Timestamp, _ = timestampMetric.GetValueFloat64(instance)
+			}
+			s.Events[issuingEms] = append(s.Events[issuingEms], event)
+		}
+		sort.Slice(s.Events[issuingEms], func(i, j int) bool {
+			return s.Events[issuingEms][i].Key < s.Events[issuingEms][j].Key
+		})
+	}
+
+	if err := persist.Save(e.statePath, s); err != nil {
+		e.Logger.Error().Err(err).Str("path", e.statePath).Msg("Unable to save state")
+	}
+}
+
+// loadState restores the active bookend instances and the time of the last poll from the state file. The state of
+// another cluster, and events that are not issuing bookend ems anymore, are ignored.
+func (e *Ems) loadState() {
+	var s emsState
+	if err := persist.Load(e.statePath, &s); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			e.Logger.Warn().Err(err).Str("path", e.statePath).Msg("Unable to load state")
+		}
+		return
+	}
+	if s.Version != stateVersion {
+		e.Logger.Warn().Int("version", s.Version).Str("path", e.statePath).Msg("Unknown state version, ignored")
+		return
+	}
+	if uuid := e.Client.Cluster().UUID; s.ClusterUUID != uuid {
+		e.Logger.Warn().Str("state", s.ClusterUUID).Str("cluster", uuid).Msg("State of another cluster, ignored")
+		return
+	}
+
+	e.lastFilterTime = s.LastFilterTime
+	restored := 0
+	for issuingEms, events := range s.Events {
+		props := e.emsProp[issuingEms]
+		if _, ok := e.resolveAfter[issuingEms]; !ok || len(props) == 0 {
+			continue
+		}
+		mx := matrix.New(issuingEms, e.Prop.Object, issuingEms)
+		for _, metric := range props[0].Metrics {
+			if metr, err := mx.NewMetricFloat64(metric.Name); err == nil {
+				metr.SetExportable(metric.Exportable)
+			}
+		}
+		eventMetric := mx.GetMetric("events")
+		timestampMetric := mx.GetMetric("timestamp")
+		if eventMetric == nil {
+			continue
+		}
+		for _, event := range events {
+			instance, err := mx.NewInstance(event.Key)
+			if err != nil {
+				continue
+			}
+			instance.SetLabels(event.Labels)
+			// active instances are exported when they are raised or resolved, not when they are restored
+			instance.SetExportable(false)
+			_ = eventMetric.SetValueFloat64(instance, 1)
+			if timestampMetric != nil {
+				_ = timestampMetric.SetValueFloat64(instance, event.Timestamp)
+			}
+			restored++
+		}
+		e.Matrix[issuingEms] = mx
+	}
+
+	e.Logger.Info().
+		Int("instances", restored).
+		Int64("lastFilterTime", e.lastFilterTime).
+		Str("path", e.statePath).
+		Msg("Restored bookend ems")
+}
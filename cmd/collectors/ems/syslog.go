@@ -0,0 +1,233 @@
+package ems
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxSyslogMessageSize = 64 * 1024
+
+// emsSeverities maps the syslog severity of the PRI part of a message to the severity of an ems event
+var emsSeverities = []string{"emergency", "alert", "alert", "error", "notice", "notice", "informational", "debug"}
+
+// syslogEvent is an ems event received by syslog, in the format of the records of api/support/ems/events
+type syslogEvent struct {
+	Index      int64            `json:"index,omitempty"`
+	Time       string           `json:"time,omitempty"`
+	Message    syslogMessage    `json:"message"`
+	Node       syslogNode       `json:"node"`
+	Source     string           `json:"source,omitempty"`
+	LogMessage string           `json:"log_message,omitempty"`
+	Parameters []syslogProperty `json:"parameters,omitempty"`
+}
+
+type syslogMessage struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+}
+
+type syslogNode struct {
+	Name string `json:"name,omitempty"`
+	UUID string `json:"uuid,omitempty"`
+}
+
+type syslogProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// readSyslog reads the messages of a syslog stream, framed by octet counting (RFC 6587 3.4.1) or by new lines
+// (RFC 6587 3.4.2), and calls handle for each message
+func readSyslog(r io.Reader, handle func([]byte)) error {
+	br := bufio.NewReaderSize(r, maxSyslogMessageSize)
+	for {
+		first, err := br.Peek(1)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var msg []byte
+		if first[0] >= '1' && first[0] <= '9' {
+			length, err := br.ReadString(' ')
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil || n > maxSyslogMessageSize {
+				return fmt.Errorf("invalid message length %q", length)
+			}
+			msg = make([]byte, n)
+			if _, err = io.ReadFull(br, msg); err != nil {
+				return err
+			}
+		} else {
+			line, err := br.ReadSlice('\n')
+			if err != nil && !errors.Is(err, io.EOF) {
+				if errors.Is(err, bufio.ErrBufferFull) {
+					return fmt.Errorf("message longer than %d bytes", br.Size())
+				}
+				return err
+			}
+			msg = bytes.Clone(line)
+			if errors.Is(err, io.EOF) && len(msg) == 0 {
+				return nil
+			}
+		}
+
+		if msg = bytes.TrimRight(msg, "\r\n\x00"); len(msg) > 0 {
+			handle(msg)
+		}
+	}
+}
+
+// parseSyslog converts an RFC 5424 message to an ems event
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [STRUCTURED-DATA] MSG
+//
+// The name of the event is the MSGID, or the prefix of the MSG, as in "[node:wafl.vvol.offline:notice]: ...".
+// The parameters of the event are the params of the structured data.
+func parseSyslog(msg []byte) (gjson.Result, error) {
+	s := string(msg)
+
+	if !strings.HasPrefix(s, "<") {
+		return gjson.Result{}, errors.New("missing PRI")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 {
+		return gjson.Result{}, errors.New("invalid PRI")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return gjson.Result{}, fmt.Errorf("invalid PRI %q", s[1:end])
+	}
+	s = s[end+1:]
+
+	// VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	header := make([]string, 6)
+	for i := range header {
+		var field string
+		field, s, _ = strings.Cut(s, " ")
+		if field == "" {
+			return gjson.Result{}, errors.New("incomplete header")
+		}
+		header[i] = field
+	}
+	if header[0] != "1" {
+		return gjson.Result{}, fmt.Errorf("unsupported version %q", header[0])
+	}
+
+	event := syslogEvent{
+		Time:    nilValue(header[1]),
+		Node:    syslogNode{Name: nilValue(header[2])},
+		Source:  nilValue(header[3]),
+		Message: syslogMessage{Name: nilValue(header[5]), Severity: emsSeverities[pri%8]},
+	}
+
+	if event.Parameters, s, err = parseStructuredData(s); err != nil {
+		return gjson.Result{}, err
+	}
+	s = strings.TrimPrefix(s, "\xEF\xBB\xBF") // BOM
+	event.LogMessage = s
+
+	// [node:name:severity]: text, or [name:severity]: text
+	if prefix, text, ok := strings.Cut(s, "]: "); ok && strings.HasPrefix(prefix, "[") {
+		fields := strings.Split(prefix[1:], ":")
+		if len(fields) >= 2 {
+			if event.Message.Name == "" {
+				event.Message.Name = fields[len(fields)-2]
+			}
+			event.Message.Severity = fields[len(fields)-1]
+			if len(fields) >= 3 && event.Node.Name == "" {
+				event.Node.Name = fields[0]
+			}
+		}
+		event.LogMessage = text
+	}
+
+	for _, p := range event.Parameters {
+		switch p.Name {
+		case "index":
+			event.Index, _ = strconv.ParseInt(p.Value, 10, 64)
+		case "node_uuid":
+			event.Node.UUID = p.Value
+		}
+	}
+
+	if event.Message.Name == "" {
+		return gjson.Result{}, errors.New("missing event name")
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return gjson.Result{}, err
+	}
+	return gjson.ParseBytes(b), nil
+}
+
+// parseStructuredData returns the params of the structured data at the start of s, and the rest of s
+func parseStructuredData(s string) ([]syslogProperty, string, error) {
+	if strings.HasPrefix(s, "-") {
+		return nil, strings.TrimPrefix(s[1:], " "), nil
+	}
+
+	var params []syslogProperty
+	for strings.HasPrefix(s, "[") {
+		// SD-ID
+		i := strings.IndexAny(s, " ]")
+		if i < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		s = s[i:]
+
+		for {
+			s = strings.TrimLeft(s, " ")
+			if strings.HasPrefix(s, "]") {
+				s = s[1:]
+				break
+			}
+			name, rest, ok := strings.Cut(s, "=\"")
+			if !ok || name == "" {
+				return nil, "", errors.New("invalid structured data param")
+			}
+			var value strings.Builder
+			closed := false
+			for j := 0; j < len(rest); j++ {
+				c := rest[j]
+				if c == '\\' && j+1 < len(rest) && strings.IndexByte(`"\]`, rest[j+1]) >= 0 {
+					value.WriteByte(rest[j+1])
+					j++
+					continue
+				}
+				if c == '"' {
+					s = rest[j+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", errors.New("unterminated structured data param")
+			}
+			params = append(params, syslogProperty{Name: name, Value: value.String()})
+		}
+	}
+	return params, strings.TrimPrefix(s, " "), nil
+}
+
+// nilValue returns the value of a header field, which is empty for the NILVALUE "-"
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
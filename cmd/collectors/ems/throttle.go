@@ -0,0 +1,422 @@
+package ems
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const countMetric = "count"
+
+// throttle limits the events of an ems: repeated events are collapsed into one with a count, events beyond a rate
+// are dropped, and events raised during a suppression window are dropped.
+//
+//	events:
+//	  - name: wafl.vvol.offline
+//	    dedup:
+//	      window: 10m
+//	      keys:                       # default: the instance keys of the ems, without the index
+//	        - parameters.volume_name
+//	    rate_limit:
+//	      events: 10
+//	      per: 1h
+//	      by: instance                # or event, the default
+//	    suppress:
+//	      - weekend                   # a window of the maintenance section of the template
+//	      - days: [saturday, sunday]
+//	        from: "22:00"
+//	        to: "06:00"
+//	        timezone: Europe/Paris
+//	      - from: 2023-06-01T00:00:00Z
+//	        to: 2023-06-02T00:00:00Z
+//
+// Resolving ems are never throttled, so that the issues they resolve do not stay active.
+type throttle struct {
+	dedupWindow     time.Duration
+	dedupKeys       []string
+	rateLimit       int
+	ratePer         time.Duration
+	ratePerInstance bool
+	suppress        []*window
+}
+
+// window is a suppression window, weekly or between two dates
+type window struct {
+	days     map[time.Weekday]bool // every day when empty
+	from     time.Duration         // since midnight
+	duration time.Duration
+	location *time.Location
+	start    time.Time
+	end      time.Time
+}
+
+// dedupEntry is the event that repeated events are collapsed into
+type dedupEntry struct {
+	name        string // of the ems
+	start       time.Time
+	window      time.Duration
+	count       int
+	instanceKey string
+	labels      map[string]string
+	updated     bool // count changed in this poll
+}
+
+type rateEntry struct {
+	start time.Time
+	per   time.Duration
+	count int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// ParseMaintenance parses the named suppression windows of the maintenance section of the template. Several windows
+// can have the same name.
+//
+//	maintenance:
+//	  - name: weekend
+//	    days: [saturday, sunday]
+//	    from: "22:00"
+//	    to: "06:00"
+func (e *Ems) ParseMaintenance(maintenance *node.Node) {
+	e.maintenance = make(map[string][]*window)
+	for _, w := range maintenance.GetChildren() {
+		name := w.GetChildContentS("name")
+		if name == "" {
+			e.Logger.Error().Msg("Missing maintenance window name")
+			continue
+		}
+		parsed, err := parseWindow(w)
+		if err != nil {
+			e.Logger.Error().Err(err).Str("maintenance", name).Msg("Invalid suppression window")
+			continue
+		}
+		e.maintenance[name] = append(e.maintenance[name], parsed)
+	}
+}
+
+// ParseThrottle parses the dedup, rate_limit and suppress sections of an ems
+func (e *Ems) ParseThrottle(line *node.Node, prop *emsProp) {
+	t := &throttle{}
+	logger := e.Logger.SubLogger("ems", prop.Name)
+
+	if dedup := line.GetChildS("dedup"); dedup != nil {
+		d, err := time.ParseDuration(dedup.GetChildContentS("window"))
+		if err != nil || d <= 0 {
+			logger.Error().Str("window", dedup.GetChildContentS("window")).Msg("Invalid dedup window, ignored")
+		} else {
+			t.dedupWindow = d
+			if keys := dedup.GetChildS("keys"); keys != nil {
+				t.dedupKeys = keys.GetAllChildContentS()
+			}
+		}
+	}
+
+	if rate := line.GetChildS("rate_limit"); rate != nil {
+		n, err1 := strconv.Atoi(rate.GetChildContentS("events"))
+		d, err2 := time.ParseDuration(rate.GetChildContentS("per"))
+		by := rate.GetChildContentS("by")
+		if err1 != nil || err2 != nil || n < 1 || d <= 0 || by != "" && by != "event" && by != "instance" {
+			logger.Error().
+				Str("events", rate.GetChildContentS("events")).
+				Str("per", rate.GetChildContentS("per")).
+				Str("by", by).
+				Msg("Invalid rate_limit, ignored")
+		} else {
+			t.rateLimit = n
+			t.ratePer = d
+			t.ratePerInstance = by == "instance"
+		}
+	}
+
+	if suppress := line.GetChildS("suppress"); suppress != nil {
+		for _, w := range suppress.GetChildren() {
+			// the name of a window of the maintenance section, or a window
+			if len(w.GetChildren()) == 0 {
+				windows, ok := e.maintenance[w.GetContentS()]
+				if !ok {
+					logger.Error().Str("maintenance", w.GetContentS()).Msg("Unknown maintenance window, ignored")
+				}
+				t.suppress = append(t.suppress, windows...)
+				continue
+			}
+			parsed, err := parseWindow(w)
+			if err != nil {
+				logger.Error().Err(err).Msg("Invalid suppression window, ignored")
+				continue
+			}
+			t.suppress = append(t.suppress, parsed)
+		}
+	}
+
+	if t.dedupWindow > 0 || t.rateLimit > 0 || len(t.suppress) > 0 {
+		prop.Throttle = t
+	}
+}
+
+// parseWindow parses a weekly window, with days, from and to as hh:mm, and timezone, or a window between two RFC 3339
+// dates
+func parseWindow(n *node.Node) (*window, error) {
+	from := n.GetChildContentS("from")
+	to := n.GetChildContentS("to")
+	if from == "" || to == "" {
+		return nil, fmt.Errorf("window must have from and to")
+	}
+
+	if start, err := time.Parse(time.RFC3339, from); err == nil {
+		end, err := time.Parse(time.RFC3339, to)
+		if err != nil || !end.After(start) {
+			return nil, fmt.Errorf("invalid window end %q", to)
+		}
+		return &window{start: start, end: end}, nil
+	}
+
+	w := &window{days: make(map[time.Weekday]bool), location: time.UTC}
+	var err error
+	if w.from, err = parseClock(from); err != nil {
+		return nil, err
+	}
+	until, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	// a window that ends before it starts ends the next day
+	w.duration = until - w.from
+	if w.duration <= 0 {
+		w.duration += 24 * time.Hour
+	}
+	if tz := n.GetChildContentS("timezone"); tz != "" {
+		if w.location, err = time.LoadLocation(tz); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", tz)
+		}
+	}
+	if days := n.GetChildS("days"); days != nil {
+		for _, day := range days.GetAllChildContentS() {
+			d, ok := parseWeekday(day)
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", day)
+			}
+			w.days[d] = true
+		}
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(s)
+	for name, d := range weekdays {
+		if s == name || len(s) == 3 && strings.HasPrefix(name, s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// contains returns true when t is in the window
+func (w *window) contains(t time.Time) bool {
+	if !w.start.IsZero() {
+		return !t.Before(w.start) && t.Before(w.end)
+	}
+	t = t.In(w.location)
+	// the window that started today or, when it ends the next day, yesterday
+	for _, daysAgo := range []int{0, 1} {
+		day := t.AddDate(0, 0, -daysAgo)
+		if len(w.days) > 0 && !w.days[day.Weekday()] {
+			continue
+		}
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, w.location).Add(w.from)
+		if !t.Before(start) && t.Before(start.Add(w.duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// throttleEvent returns the reason to drop an event, or an empty string to keep it
+func (e *Ems) throttleEvent(msgName string, props []*emsProp, instanceData gjson.Result) string {
+	var t *throttle
+	var p *emsProp
+	for _, prop := range props {
+		if prop.Throttle != nil {
+			t, p = prop.Throttle, prop
+			break
+		}
+	}
+	if t == nil {
+		return ""
+	}
+
+	// suppression windows apply to the time the event was raised. The rate and dedup windows are measured by the
+	// clock of the poller, which also ends them, so that events received late count in the current windows.
+	at := e.now()
+	raised := at
+	if eventTime, err := time.Parse(time.RFC3339, instanceData.Get("time").String()); err == nil {
+		raised = eventTime
+	}
+
+	for _, w := range t.suppress {
+		if w.contains(raised) {
+			return "suppressed"
+		}
+	}
+
+	if t.rateLimit > 0 {
+		key := msgName
+		if t.ratePerInstance {
+			key = e.dedupKey(p, nil, instanceData)
+		}
+		r := e.rates[key]
+		if r == nil || at.Sub(r.start) >= t.ratePer {
+			r = &rateEntry{start: at, per: t.ratePer}
+			e.rates[key] = r
+		}
+		r.count++
+		if r.count > t.rateLimit {
+			return "rate_limited"
+		}
+	}
+
+	if t.dedupWindow > 0 {
+		key := e.dedupKey(p, t.dedupKeys, instanceData)
+		if d := e.dedup[key]; d != nil && at.Sub(d.start) < t.dedupWindow {
+			d.count++
+			d.updated = true
+			return "duplicate"
+		}
+		if d := e.dedup[key]; d != nil {
+			e.forgetDedup(key, d)
+		}
+		d := &dedupEntry{
+			name:        msgName,
+			start:       at,
+			window:      t.dedupWindow,
+			count:       1,
+			instanceKey: e.getInstanceKeys(p, instanceData),
+		}
+		e.dedup[key] = d
+		e.dedupInstances[d.instanceKey] = d
+	}
+	return ""
+}
+
+// dedupKey identifies the events that are collapsed: by keys, or by the instance keys of the ems without the index
+func (e *Ems) dedupKey(p *emsProp, keys []string, instanceData gjson.Result) string {
+	key := p.Name
+	if len(keys) == 0 {
+		keys = p.InstanceKeys
+	}
+	for _, k := range keys {
+		if k == "index" {
+			continue
+		}
+		key += Hyphen + parseProperties(instanceData, k).String()
+	}
+	return key
+}
+
+// setDedupCount sets the count metric of an instance that events are collapsed into, and keeps its labels to export it
+// again when events are collapsed into it in a later poll
+func (e *Ems) setDedupCount(p *emsProp, mx *matrix.Matrix, instance *matrix.Instance, instanceKey string) {
+	if p.Throttle == nil || p.Throttle.dedupWindow == 0 {
+		return
+	}
+	if d := e.dedupInstances[instanceKey]; d != nil {
+		d.labels = maps.Clone(instance.GetLabels())
+		e.setCount(mx, instance, d.count)
+	}
+}
+
+// updateDedupCounts exports the instances whose count changed in this poll, and forgets the windows that ended
+func (e *Ems) updateDedupCounts() {
+	now := e.now()
+	for key, d := range e.dedup {
+		if d.updated && d.labels != nil {
+			e.exportDedupCount(d)
+		}
+		d.updated = false
+		if now.Sub(d.start) >= d.window {
+			e.forgetDedup(key, d)
+		}
+	}
+	for key, r := range e.rates {
+		if now.Sub(r.start) >= r.per {
+			delete(e.rates, key)
+		}
+	}
+}
+
+func (e *Ems) exportDedupCount(d *dedupEntry) {
+	mx := e.Matrix[d.name]
+	if mx == nil {
+		mx = matrix.New(d.name, e.Prop.Object, d.name)
+		mx.SetGlobalLabels(e.Matrix[e.Object].GetGlobalLabels())
+		e.Matrix[d.name] = mx
+	}
+	instance := mx.GetInstance(d.instanceKey)
+	if instance == nil {
+		// the instance of an event of a previous poll, which is not a bookend ems
+		var err error
+		if instance, err = mx.NewInstance(d.instanceKey); err != nil {
+			e.Logger.Error().Err(err).Str("Instance key", d.instanceKey).Msg("")
+			return
+		}
+		instance.SetLabels(maps.Clone(d.labels))
+		if metr, err := e.getOrCreateMetric(mx, "events", true); err == nil {
+			_ = metr.SetValueFloat64(instance, 1)
+		}
+	}
+	instance.SetExportable(true)
+	e.setCount(mx, instance, d.count)
+}
+
+func (e *Ems) setCount(mx *matrix.Matrix, instance *matrix.Instance, count int) {
+	metr, err := e.getOrCreateMetric(mx, countMetric, true)
+	if err != nil {
+		e.Logger.Error().Err(err).Str("name", countMetric).Msg("failed to get metric")
+		return
+	}
+	_ = metr.SetValueFloat64(instance, float64(count))
+}
+
+func (e *Ems) getOrCreateMetric(mx *matrix.Matrix, name string, exportable bool) (*matrix.Metric, error) {
+	if metr := mx.GetMetric(name); metr != nil {
+		return metr, nil
+	}
+	metr, err := mx.NewMetricFloat64(name)
+	if err != nil {
+		return nil, err
+	}
+	metr.SetExportable(exportable)
+	return metr, nil
+}
+
+// resetDedup forgets the events collapsed into resolved instances, so that the next issue is raised again
+func (e *Ems) resetDedup(suffix string) {
+	for key, d := range e.dedup {
+		if strings.HasSuffix(d.instanceKey, suffix) {
+			e.forgetDedup(key, d)
+		}
+	}
+}
+
+func (e *Ems) forgetDedup(key string, d *dedupEntry) {
+	delete(e.dedup, key)
+	if e.dedupInstances[d.instanceKey] == d {
+		delete(e.dedupInstances, d.instanceKey)
+	}
+}
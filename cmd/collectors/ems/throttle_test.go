@@ -0,0 +1,174 @@
+package ems
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/tidwall/gjson"
+	"testing"
+	"time"
+)
+
+var throttleStart = time.Date(2023, 6, 3, 10, 0, 0, 0, time.UTC) // a saturday
+
+func emsEvent(name string, index int, uuid string, at time.Time) gjson.Result {
+	return gjson.Parse(fmt.Sprintf(`{"index": %d, "time": %q, "message": {"name": %q, "severity": "alert"},
+		"node": {"name": "node-01"}, "parameters": [{"name": "object_uuid", "value": %q}, {"name": "lun_path", "value": "/vol/v1/l1"}]}`,
+		index, at.Format(time.RFC3339), name, uuid))
+}
+
+// poll handles events like a poll of the collector, and returns the count of each exported instance of the ems
+func poll(e *Ems, name string, events ...gjson.Result) map[string]float64 {
+	e.updateMatrix(e.now())
+	e.HandleResults(events, e.emsProp)
+	counts := make(map[string]float64)
+	mx := e.Matrix[name]
+	if mx == nil {
+		return counts
+	}
+	for key, instance := range mx.GetInstances() {
+		if !instance.IsExportable() {
+			continue
+		}
+		counts[key] = 1
+		if metric := mx.GetMetric(countMetric); metric != nil {
+			if v, ok := metric.GetValueFloat64(instance); ok {
+				counts[key] = v
+			}
+		}
+	}
+	return counts
+}
+
+func TestEmsDedup(t *testing.T) {
+	e := NewEms(t.TempDir())
+	e.now = func() time.Time { return throttleStart }
+	e.emsProp["LUN.destroy"][0].Throttle = &throttle{dedupWindow: 10 * time.Minute}
+	minute := func(n int) time.Time { return throttleStart.Add(time.Duration(n) * time.Minute) }
+
+	counts := poll(e, "LUN.destroy", emsEvent("LUN.destroy", 1, "u1", minute(0)), emsEvent("LUN.destroy", 2, "u1", minute(1)),
+		emsEvent("LUN.destroy", 3, "u1", minute(2)))
+	if len(counts) != 1 || counts["-1-LUN.destroy"] != 3 {
+		t.Errorf("first poll got=%v, want one instance with a count of 3", counts)
+	}
+
+	// a duplicate in the next poll exports the first event again
+	counts = poll(e, "LUN.destroy", emsEvent("LUN.destroy", 4, "u1", minute(3)))
+	if len(counts) != 1 || counts["-1-LUN.destroy"] != 4 {
+		t.Errorf("second poll got=%v, want the first instance with a count of 4", counts)
+	}
+	if e.Matrix["LUN.destroy"].GetInstance("-1-LUN.destroy").GetLabel("lun_path") != "/vol/v1/l1" {
+		t.Error("the instance exported again should have its labels")
+	}
+
+	// after the window, the next event is exported
+	e.now = func() time.Time { return minute(11) }
+	counts = poll(e, "LUN.destroy", emsEvent("LUN.destroy", 5, "u1", minute(11)))
+	if len(counts) != 1 || counts["-5-LUN.destroy"] != 1 {
+		t.Errorf("after the window got=%v, want a new instance", counts)
+	}
+}
+
+func TestEmsDedupBookend(t *testing.T) {
+	e := NewEms(t.TempDir())
+	e.now = func() time.Time { return throttleStart }
+	e.emsProp["LUN.offline"][0].Throttle = &throttle{dedupWindow: time.Hour}
+
+	counts := poll(e, "LUN.offline", emsEvent("LUN.offline", 1, "u1", throttleStart), emsEvent("LUN.offline", 2, "u1", throttleStart))
+	if len(counts) != 1 || counts["-1-LUN.offline-u1"] != 2 {
+		t.Errorf("got=%v, want one active instance with a count of 2", counts)
+	}
+
+	// once resolved, the issue is raised again
+	poll(e, "LUN.offline", emsEvent("LUN.online", 3, "u1", throttleStart))
+	counts = poll(e, "LUN.offline", emsEvent("LUN.offline", 4, "u1", throttleStart))
+	if counts["-4-LUN.offline-u1"] != 1 {
+		t.Errorf("got=%v, want a new active instance after the issue was resolved", counts)
+	}
+}
+
+func TestEmsRateLimit(t *testing.T) {
+	e := NewEms(t.TempDir())
+	e.now = func() time.Time { return throttleStart }
+	e.emsProp["LUN.destroy"][0].Throttle = &throttle{rateLimit: 2, ratePer: time.Hour}
+
+	counts := poll(e, "LUN.destroy", emsEvent("LUN.destroy", 1, "u1", throttleStart), emsEvent("LUN.destroy", 2, "u2", throttleStart),
+		emsEvent("LUN.destroy", 3, "u3", throttleStart))
+	if len(counts) != 2 {
+		t.Errorf("got=%v, want 2 instances", counts)
+	}
+
+	later := throttleStart.Add(time.Hour)
+	e.now = func() time.Time { return later }
+	if counts = poll(e, "LUN.destroy", emsEvent("LUN.destroy", 4, "u4", later)); len(counts) != 1 {
+		t.Errorf("next window got=%v, want 1 instance", counts)
+	}
+}
+
+func TestEmsSuppress(t *testing.T) {
+	template := `
+maintenance:
+  - name: weekend
+    days: [saturday, sun]
+    from: "22:00"
+    to: "06:00"
+    timezone: Europe/Paris
+events:
+  - name: LUN.destroy
+    suppress:
+      - weekend
+      - from: 2023-06-05T08:00:00Z
+        to: 2023-06-05T09:00:00Z
+`
+	root, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEms(t.TempDir())
+	e.ParseMaintenance(root.GetChildS("maintenance"))
+	prop := &emsProp{Name: "LUN.destroy"}
+	e.ParseThrottle(root.GetChildS("events").GetChildren()[0], prop)
+	if prop.Throttle == nil || len(prop.Throttle.suppress) != 2 {
+		t.Fatalf("throttle got=%v, want 2 suppression windows", prop.Throttle)
+	}
+	e.emsProp["LUN.destroy"][0].Throttle = prop.Throttle
+
+	tests := []struct {
+		at         string
+		suppressed bool
+	}{
+		{"2023-06-03T19:59:00Z", false}, // saturday 21:59 in Paris
+		{"2023-06-03T20:00:00Z", true},  // saturday 22:00
+		{"2023-06-04T03:59:00Z", true},  // sunday 05:59, window of saturday
+		{"2023-06-04T21:00:00Z", true},  // sunday 23:00
+		{"2023-06-05T04:30:00Z", false}, // monday 06:30
+		{"2023-06-05T08:30:00Z", true},  // maintenance on monday
+		{"2023-06-05T09:00:00Z", false},
+	}
+	for i, tt := range tests {
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		e.now = func() time.Time { return at }
+		counts := poll(e, "LUN.destroy", emsEvent("LUN.destroy", i, "u1", at))
+		if (len(counts) == 0) != tt.suppressed {
+			t.Errorf("%s got suppressed=%v, want=%v", tt.at, len(counts) == 0, tt.suppressed)
+		}
+	}
+}
+
+func TestEmsThrottleLateEvents(t *testing.T) {
+	e := NewEms(t.TempDir())
+	now := throttleStart
+	e.now = func() time.Time { return now }
+	e.emsProp["LUN.destroy"][0].Throttle = &throttle{dedupWindow: 10 * time.Minute}
+	e.emsProp["LUN.offline"][0].Throttle = &throttle{rateLimit: 1, ratePer: 10 * time.Minute}
+
+	// the events were raised an hour before they are received, their windows last until the next poll
+	late := throttleStart.Add(-time.Hour)
+	poll(e, "LUN.destroy", emsEvent("LUN.destroy", 1, "u1", late), emsEvent("LUN.offline", 2, "u1", late))
+	now = now.Add(time.Minute)
+	if counts := poll(e, "LUN.destroy", emsEvent("LUN.destroy", 3, "u1", late)); counts["-1-LUN.destroy"] != 2 {
+		t.Errorf("dedup got=%v, want the first instance with a count of 2", counts)
+	}
+	if counts := poll(e, "LUN.offline", emsEvent("LUN.offline", 4, "u2", late)); counts["-4-LUN.offline-u2"] != 0 {
+		t.Errorf("rate limit got=%v, want the event dropped", counts)
+	}
+}
@@ -0,0 +1,230 @@
+package collectors
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/schedule"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/set"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	FocusTask  = "focus"
+	FocusLabel = "resolution"
+)
+
+// Focus holds the state of the focus schedule of a perf object. The focus task polls a few selected instances at a
+// higher resolution than the data task. Instances are selected by name, regex, or by ranking the instances of the
+// last data poll. The focus task expires after the configured duration. The same parameters are used by ZapiPerf
+// and RestPerf.
+//
+//	schedule:
+//	  - data: 1m
+//	  - focus: 10s
+//	focus:
+//	  duration: 30m         # stop polling after this duration, default 1h
+//	  label: volume         # label matched by instances and regex, default is the instance key
+//	  instances:
+//	    - vol1
+//	  regex:
+//	    - ^db_.*
+//	  top: 5                # the five instances with the highest top_metric of the last data poll
+//	  top_metric: total_ops
+//
+// Focus keeps its own cache of raw counters, so deltas are calculated between two focus polls.
+type Focus struct {
+	Logger    *logging.Logger
+	Interval  time.Duration
+	Expires   time.Time
+	Cache     *matrix.Matrix
+	IsEmpty   bool // true when the cache has no raw counters yet
+	label     string
+	names     *set.Set
+	regex     []*regexp.Regexp
+	top       int
+	topMetric string
+	topKeys   []string
+	expired   bool
+}
+
+// ParseFocus returns nil when the template has no focus task
+func ParseFocus(param *node.Node, s *schedule.Schedule, logger *logging.Logger) (*Focus, error) {
+	task := s.GetTask(FocusTask)
+	n := param.GetChildS("focus")
+	if task == nil {
+		if n != nil {
+			logger.Warn().Msg("focus is ignored, the schedule has no focus task")
+		}
+		return nil, nil
+	}
+	if n == nil {
+		return nil, errs.New(errs.ErrMissingParam, "focus")
+	}
+
+	f := &Focus{
+		Interval: task.GetInterval(),
+		label:    n.GetChildContentS("label"),
+		names:    set.New(),
+		Logger:   logger,
+	}
+
+	duration := time.Hour
+	if d := n.GetChildContentS("duration"); d != "" {
+		var err error
+		if duration, err = time.ParseDuration(d); err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, "focus duration: "+err.Error())
+		}
+	}
+	f.Expires = time.Now().Add(duration)
+
+	if x := n.GetChildS("instances"); x != nil {
+		f.names.AddValues(x.GetAllChildContentS())
+	}
+	if x := n.GetChildS("regex"); x != nil {
+		for _, r := range x.GetAllChildContentS() {
+			re, err := regexp.Compile(r)
+			if err != nil {
+				return nil, errs.New(errs.ErrInvalidParam, fmt.Sprintf("focus regex %s: %v", r, err))
+			}
+			f.regex = append(f.regex, re)
+		}
+	}
+	if x := n.GetChildContentS("top"); x != "" {
+		top, err := strconv.Atoi(x)
+		if err != nil || top < 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "focus top: "+x)
+		}
+		f.top = top
+		if f.topMetric = n.GetChildContentS("top_metric"); f.topMetric == "" {
+			return nil, errs.New(errs.ErrMissingParam, "focus top_metric")
+		}
+	}
+	if f.names.IsEmpty() && len(f.regex) == 0 && f.top == 0 {
+		return nil, errs.New(errs.ErrInvalidParam, "focus requires instances, regex or top")
+	}
+
+	logger.Info().
+		Str("interval", f.Interval.String()).
+		Str("duration", duration.String()).
+		Int("names", f.names.Size()).
+		Int("regex", len(f.regex)).
+		Int("top", f.top).
+		Msg("Focus enabled")
+	return f, nil
+}
+
+// Rank selects the top instances by the top metric of a cooked data matrix. Instances without a value are ignored.
+func (f *Focus) Rank(data *matrix.Matrix) {
+	if f == nil || f.top == 0 || f.expired {
+		return
+	}
+	metric := data.LookupMetric(f.topMetric)
+	if metric == nil {
+		f.Logger.Warn().Str("metric", f.topMetric).Msg("focus top_metric not found")
+		return
+	}
+
+	type ranked struct {
+		key   string
+		value float64
+	}
+	all := make([]ranked, 0, len(data.GetInstances()))
+	for key, instance := range data.GetInstances() {
+		if v, ok := metric.GetValueFloat64(instance); ok {
+			all = append(all, ranked{key: key, value: v})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].value == all[j].value {
+			return all[i].key < all[j].key
+		}
+		return all[i].value > all[j].value
+	})
+
+	f.topKeys = f.topKeys[:0]
+	for i := 0; i < len(all) && i < f.top; i++ {
+		f.topKeys = append(f.topKeys, all[i].key)
+	}
+}
+
+// Refresh updates the focus cache from the data cache and returns false when there is nothing to poll, either
+// because the focus expired or because no instance matches. The cache is rebuilt when the selection changes.
+func (f *Focus) Refresh(data *matrix.Matrix, now time.Time) bool {
+	if f == nil || f.expired {
+		return false
+	}
+	if now.After(f.Expires) {
+		f.Logger.Info().Msg("Focus expired")
+		f.expired = true
+		f.Cache = nil
+		f.topKeys = nil
+		return false
+	}
+
+	selected := f.Select(data)
+	if len(selected) == 0 {
+		f.Cache = nil
+		return false
+	}
+	if f.Cache != nil {
+		cached := f.Cache.GetInstanceKeys()
+		slices.Sort(cached)
+		if slices.Equal(selected, cached) {
+			return true
+		}
+	}
+
+	cache := data.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+	for key := range data.GetInstances() {
+		if !slices.Contains(selected, key) {
+			cache.RemoveInstance(key)
+		}
+	}
+	cache.Reset()
+	cache.Identifier = data.Identifier + "_" + FocusTask
+	cache.DetachGlobalLabels()
+	cache.SetGlobalLabel(FocusLabel, f.Interval.String())
+	f.Cache = cache
+	f.IsEmpty = true
+
+	f.Logger.Debug().Strs("instances", selected).Msg("Focus selection changed")
+	return true
+}
+
+// Select returns the sorted keys of the data instances that match the focus filter
+func (f *Focus) Select(data *matrix.Matrix) []string {
+	selected := make([]string, 0)
+	for key, instance := range data.GetInstances() {
+		if f.matches(key, instance) {
+			selected = append(selected, key)
+		}
+	}
+	slices.Sort(selected)
+	return selected
+}
+
+func (f *Focus) matches(key string, instance *matrix.Instance) bool {
+	if slices.Contains(f.topKeys, key) {
+		return true
+	}
+	value := key
+	if f.label != "" {
+		value = instance.GetLabel(f.label)
+	}
+	if f.names.Has(value) {
+		return true
+	}
+	for _, re := range f.regex {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package collectors
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/schedule"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newFocus(t *testing.T, yml string) *Focus {
+	t.Helper()
+	root, err := tree.LoadYaml([]byte(yml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := schedule.New()
+	_ = s.NewTaskString("data", "1m", nil, true, "")
+	_ = s.NewTaskString(FocusTask, "10s", nil, true, "")
+	f, err := ParseFocus(root, s, logging.Get())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func focusMatrix() *matrix.Matrix {
+	mat := matrix.New("uuid", "volume", "volume")
+	mat.SetGlobalLabel("cluster", "c1")
+	ops, _ := mat.NewMetricFloat64("total_ops", "ops")
+	for i, name := range []string{"vol1", "vol2", "db_1", "db_2", "vol3"} {
+		instance, _ := mat.NewInstance("key_" + name)
+		instance.SetLabel("volume", name)
+		_ = ops.SetValueFloat64(instance, float64(i*10))
+	}
+	return mat
+}
+
+func TestFocus_Select(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		want []string
+	}{
+		{
+			name: "names",
+			yml: `
+focus:
+  label: volume
+  instances:
+    - vol1
+    - vol3`,
+			want: []string{"key_vol1", "key_vol3"},
+		},
+		{
+			name: "regex",
+			yml: `
+focus:
+  label: volume
+  regex:
+    - ^db_`,
+			want: []string{"key_db_1", "key_db_2"},
+		},
+		{
+			name: "instance key",
+			yml: `
+focus:
+  instances:
+    - key_vol2`,
+			want: []string{"key_vol2"},
+		},
+		{
+			name: "top",
+			yml: `
+focus:
+  top: 2
+  top_metric: ops`,
+			want: []string{"key_db_2", "key_vol3"},
+		},
+		{
+			name: "top and names",
+			yml: `
+focus:
+  label: volume
+  instances:
+    - vol1
+  top: 1
+  top_metric: total_ops`,
+			want: []string{"key_vol1", "key_vol3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFocus(t, tt.yml)
+			mat := focusMatrix()
+			f.Rank(mat)
+			if got := f.Select(mat); !slices.Equal(got, tt.want) {
+				t.Errorf("Select() got=%v, want=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFocus_Refresh(t *testing.T) {
+	f := newFocus(t, `
+focus:
+  duration: 1m
+  label: volume
+  instances:
+    - vol1
+    - vol2`)
+	mat := focusMatrix()
+	now := time.Now()
+
+	if !f.Refresh(mat, now) {
+		t.Fatalf("Refresh() got=false, want=true")
+	}
+	cache := f.Cache
+	if got := cache.GetInstanceKeys(); len(got) != 2 {
+		t.Errorf("cached instances got=%v, want 2", got)
+	}
+	if !f.IsEmpty {
+		t.Errorf("new cache should be empty")
+	}
+	if got := cache.GetGlobalLabels()[FocusLabel]; got != "10s" {
+		t.Errorf("label %s got=%s, want=10s", FocusLabel, got)
+	}
+	if _, ok := mat.GetGlobalLabels()[FocusLabel]; ok {
+		t.Errorf("data matrix should not have the %s label", FocusLabel)
+	}
+	if cache.Identifier == mat.Identifier {
+		t.Errorf("focus identifier should differ from data identifier %s", mat.Identifier)
+	}
+
+	// same selection keeps the cache
+	f.IsEmpty = false
+	if !f.Refresh(mat, now) || f.Cache != cache || f.IsEmpty {
+		t.Errorf("cache should be kept when the selection does not change")
+	}
+
+	// selection changes when an instance is removed
+	mat.RemoveInstance("key_vol2")
+	if !f.Refresh(mat, now) || f.Cache == cache || !f.IsEmpty {
+		t.Errorf("cache should be rebuilt when the selection changes")
+	}
+
+	if f.Refresh(mat, now.Add(2*time.Minute)) {
+		t.Errorf("Refresh() got=true after expiry, want=false")
+	}
+	if f.Refresh(mat, now) {
+		t.Errorf("Refresh() got=true, focus should stay expired")
+	}
+}
+
+func TestParseFocus(t *testing.T) {
+	tests := []struct {
+		name    string
+		yml     string
+		wantErr bool
+	}{
+		{name: "no filter", yml: "focus:\n  duration: 1m", wantErr: true},
+		{name: "bad duration", yml: "focus:\n  duration: 1x\n  instances:\n    - a", wantErr: true},
+		{name: "bad regex", yml: "focus:\n  regex:\n    - '['", wantErr: true},
+		{name: "top without metric", yml: "focus:\n  top: 3", wantErr: true},
+		{name: "missing focus", yml: "objects:\n  Volume: volume.yaml", wantErr: true},
+		{name: "valid", yml: "focus:\n  top: 3\n  top_metric: total_ops", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := tree.LoadYaml([]byte(tt.yml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := schedule.New()
+			_ = s.NewTaskString(FocusTask, "10s", nil, true, "")
+			_, err = ParseFocus(root, s, logging.Get())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFocus() error=%v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// without a focus task, focus is disabled
+	root, _ := tree.LoadYaml([]byte("focus:\n  top: 3\n  top_metric: total_ops"))
+	if f, err := ParseFocus(root, schedule.New(), logging.Get()); f != nil || err != nil {
+		t.Errorf("ParseFocus() got=%v err=%v, want nil", f, err)
+	}
+}
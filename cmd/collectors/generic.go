@@ -0,0 +1,90 @@
+package collectors
+
+import (
+	"crypto/tls"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LoadTemplate merges the template of the object, conf/$collector/$template, with the parameters of the collector.
+// It is shared by the collectors that are not ONTAP or StorageGRID collectors, such as Prometheus, GenericRest and
+// Snmp, whose templates have no versions.
+func LoadTemplate(c *collector.AbstractCollector) error {
+	objects := c.Params.GetChildS("objects")
+	if objects == nil || objects.GetChildContentS(c.Object) == "" {
+		// the object is defined in the template of the collector
+		return nil
+	}
+	template, err := collector.ImportTemplate(c.Options.ConfPaths, objects.GetChildContentS(c.Object), c.Name)
+	if err != nil {
+		return err
+	}
+	c.Params.Union(template)
+	return nil
+}
+
+// InitMatrix sets the object and the global labels of the matrix of the collector, from the object and labels
+// parameters of its template, and returns the matrix
+func InitMatrix(c *collector.AbstractCollector) *matrix.Matrix {
+	mat := c.Matrix[c.Object]
+	if x := c.Params.GetChildContentS("object"); x != "" {
+		mat.Object = x
+	} else {
+		mat.Object = strings.ToLower(c.Object)
+	}
+	if c.Params.HasChildS("labels") {
+		for _, l := range c.Params.GetChildS("labels").GetChildren() {
+			mat.SetGlobalLabel(l.GetNameS(), l.GetContentS())
+		}
+	}
+	return mat
+}
+
+// ClientTimeout returns the client_timeout parameter of the collector, or defaultTimeout
+func ClientTimeout(c *collector.AbstractCollector, defaultTimeout time.Duration) (time.Duration, error) {
+	s := c.Params.GetChildContentS("client_timeout")
+	if s == "" {
+		return defaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errs.New(errs.ErrInvalidParam, "client_timeout: "+s)
+	}
+	return timeout, nil
+}
+
+// NewHTTPClient returns the HTTP client of the collector, with its client_timeout and the use_insecure_tls of its
+// poller. The poller is nil when it is not in harvest.yml.
+func NewHTTPClient(c *collector.AbstractCollector, defaultTimeout time.Duration) (*http.Client, *conf.Poller, error) {
+	timeout, err := ClientTimeout(c, defaultTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	poller, err := conf.PollerNamed(c.Options.Poller)
+	if err != nil {
+		poller = nil
+	}
+	if poller != nil && poller.UseInsecureTLS != nil && *poller.UseInsecureTLS {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, poller, nil
+}
+
+// PollerURL returns the URL of the address of the poller, with the scheme parameter of the collector, or
+// defaultScheme
+func PollerURL(c *collector.AbstractCollector, poller *conf.Poller, defaultScheme string) (string, error) {
+	if poller.Addr == "" {
+		return "", errs.New(errs.ErrMissingParam, "addr")
+	}
+	scheme := c.Params.GetChildContentS("scheme")
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+	return scheme + "://" + poller.Addr, nil
+}
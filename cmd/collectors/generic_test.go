@@ -0,0 +1,37 @@
+package collectors
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"testing"
+	"time"
+)
+
+func TestInitMatrix(t *testing.T) {
+	params := node.NewS("Snmp")
+	params.NewChildS("object", "snmp_interface")
+	params.NewChildS("labels", "").NewChildS("site", "lab")
+	params.NewChildS("client_timeout", "5s")
+	params.NewChildS("scheme", "http")
+	c := &collector.AbstractCollector{
+		Object: "Interface",
+		Params: params,
+		Matrix: map[string]*matrix.Matrix{"Interface": matrix.New("Snmp", "Interface", "Interface")},
+	}
+
+	mat := InitMatrix(c)
+	if mat.Object != "snmp_interface" || mat.GetGlobalLabels()["site"] != "lab" {
+		t.Errorf("got object=%s labels=%v, want snmp_interface and site", mat.Object, mat.GetGlobalLabels())
+	}
+	if timeout, err := ClientTimeout(c, time.Minute); err != nil || timeout != 5*time.Second {
+		t.Errorf("client_timeout got=%s %v, want 5s", timeout, err)
+	}
+	if url, err := PollerURL(c, &conf.Poller{Addr: "10.0.0.1:9100"}, "https"); err != nil || url != "http://10.0.0.1:9100" {
+		t.Errorf("url got=%s %v, want http://10.0.0.1:9100", url, err)
+	}
+	if _, err := PollerURL(c, &conf.Poller{}, "https"); err == nil {
+		t.Error("a poller without addr should fail")
+	}
+}
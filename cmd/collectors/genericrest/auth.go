@@ -0,0 +1,174 @@
+package genericrest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	authBasic  = "basic"
+	authBearer = "bearer"
+	authOAuth2 = "oauth2"
+	authAPIKey = "api_key"
+
+	defaultKeyHeader = "X-API-Key"
+)
+
+// authorization authorizes the requests of the collector. The secrets default to the credentials of the poller, so
+// that they can come from the poller's password, credentials_file or credentials_script instead of the template.
+type authorization struct {
+	kind         string // basic, bearer, oauth2 or api_key, none when empty
+	username     string
+	password     string
+	token        string // bearer token, or key of the api_key header
+	header       string // header of the api key
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	accessToken  string // access token of the client credentials, until expires
+	expires      time.Time
+}
+
+// newAuthorization parses the auth section of the template. Without one, the poller's username and password are
+// sent with basic authentication when the poller defines them.
+//
+//	auth:
+//	  type:       oauth2
+//	  token_url:  https://login.example.com/oauth2/token
+//	  scopes:
+//	    - metrics.read
+func newAuthorization(n *node.Node, username string, password string) (*authorization, error) {
+	a := &authorization{username: username, password: password}
+	if n == nil {
+		if username != "" {
+			a.kind = authBasic
+		}
+		return a, nil
+	}
+
+	a.kind = n.GetChildContentS("type")
+	switch a.kind {
+	case authBasic:
+		if username == "" {
+			return nil, errs.New(errs.ErrMissingParam, "username of the poller for basic auth")
+		}
+	case authBearer:
+		if a.token = n.GetChildContentS("token"); a.token == "" {
+			a.token = password
+		}
+		if a.token == "" {
+			return nil, errs.New(errs.ErrMissingParam, "auth token")
+		}
+	case authAPIKey:
+		if a.header = n.GetChildContentS("header"); a.header == "" {
+			a.header = defaultKeyHeader
+		}
+		if a.token = n.GetChildContentS("key"); a.token == "" {
+			a.token = password
+		}
+		if a.token == "" {
+			return nil, errs.New(errs.ErrMissingParam, "auth key")
+		}
+	case authOAuth2:
+		if a.tokenURL = n.GetChildContentS("token_url"); a.tokenURL == "" {
+			return nil, errs.New(errs.ErrMissingParam, "auth token_url")
+		}
+		if a.clientID = n.GetChildContentS("client_id"); a.clientID == "" {
+			a.clientID = username
+		}
+		if a.clientSecret = n.GetChildContentS("client_secret"); a.clientSecret == "" {
+			a.clientSecret = password
+		}
+		if a.clientID == "" || a.clientSecret == "" {
+			return nil, errs.New(errs.ErrMissingParam, "auth client_id and client_secret")
+		}
+		if scopes := n.GetChildS("scopes"); scopes != nil {
+			a.scopes = scopes.GetAllChildContentS()
+		}
+	case "":
+		return nil, errs.New(errs.ErrMissingParam, "auth type")
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "auth type "+a.kind)
+	}
+	return a, nil
+}
+
+// authorize adds the credentials to the request, and requests an access token first when the cached one expired
+func (a *authorization) authorize(client *http.Client, request *http.Request) error {
+	switch a.kind {
+	case authBasic:
+		request.SetBasicAuth(a.username, a.password)
+	case authBearer:
+		request.Header.Set("Authorization", "Bearer "+a.token)
+	case authAPIKey:
+		request.Header.Set(a.header, a.token)
+	case authOAuth2:
+		if a.accessToken == "" || time.Now().After(a.expires) {
+			if err := a.requestToken(client); err != nil {
+				return err
+			}
+		}
+		request.Header.Set("Authorization", "Bearer "+a.accessToken)
+	}
+	return nil
+}
+
+// invalidate forgets the access token after the API rejected it, so that the next request asks for a new one.
+// It returns false when there is no token to renew.
+func (a *authorization) invalidate() bool {
+	if a.kind != authOAuth2 || a.accessToken == "" {
+		return false
+	}
+	a.accessToken = ""
+	return true
+}
+
+// requestToken requests an access token with the client credentials grant of OAuth2 (RFC 6749 section 4.4)
+func (a *authorization) requestToken(client *http.Client) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.scopes) > 0 {
+		form.Set("scope", strings.Join(a.scopes, " "))
+	}
+	request, err := requests.New(http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return errs.New(errs.ErrConnection, err.Error())
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return errs.New(errs.ErrAuthFailed, "token request: "+response.Status+": "+string(body))
+	}
+	token := gjson.ParseBytes(body)
+	if a.accessToken = token.Get("access_token").String(); a.accessToken == "" {
+		return errs.New(errs.ErrAuthFailed, "token request: missing access_token")
+	}
+
+	// renew the token a little before it expires. Without expires_in, the token is used until it is rejected
+	a.expires = time.Now().Add(24 * 365 * time.Hour)
+	if expiresIn := token.Get("expires_in").Int(); expiresIn > 0 {
+		lifetime := time.Duration(expiresIn) * time.Second
+		a.expires = time.Now().Add(lifetime - min(lifetime/10, time.Minute))
+	}
+	return nil
+}
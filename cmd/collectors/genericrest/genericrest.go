@@ -0,0 +1,464 @@
+// Package genericrest collects the metrics of any JSON HTTP API, e.g. of SAN switches or hypervisors, with the
+// counter syntax of the templates of the Rest collector. Unlike the Rest collector, it does not discover an ONTAP
+// cluster, the authentication, pagination and the path of the records are defined by the template.
+package genericrest
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/collectors/rest"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/netapp/harvest/v2/pkg/set"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/tidwall/gjson"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type GenericRest struct {
+	*collector.AbstractCollector
+	client    *http.Client
+	url       string // base URL of the queries
+	headers   map[string]string
+	auth      *authorization
+	paginate  *pagination
+	prop      *prop
+	endpoints []*prop
+}
+
+// prop is a query of the template, and the counters of its records
+type prop struct {
+	query          string
+	records        string // path of the records in the response, the response is the records when empty
+	instanceKeys   []string
+	instanceLabels map[string]string
+	metrics        map[string]*rest.Metric
+}
+
+func init() {
+	plugin.RegisterModule(&GenericRest{})
+}
+
+func (g *GenericRest) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.genericrest",
+		New: func() plugin.Module { return new(GenericRest) },
+	}
+}
+
+func (g *GenericRest) Init(a *collector.AbstractCollector) error {
+	var err error
+	g.AbstractCollector = a
+
+	if err = collectors.LoadTemplate(g.AbstractCollector); err != nil {
+		return err
+	}
+	if err = collector.Init(g); err != nil {
+		return err
+	}
+	if err = g.InitCache(); err != nil {
+		return err
+	}
+	if err = g.initClient(); err != nil {
+		return err
+	}
+	g.InitMatrix()
+
+	g.Logger.Info().Str("url", g.url).Str("query", g.prop.query).Int("endpoints", len(g.endpoints)).Msg("initialized")
+	return nil
+}
+
+func (g *GenericRest) InitMatrix() {
+	mat := collectors.InitMatrix(g.AbstractCollector)
+	if e := g.Params.GetChildS("export_options"); e != nil {
+		mat.SetExportOptions(e)
+	}
+}
+
+func (g *GenericRest) InitCache() error {
+	var err error
+
+	if g.prop, err = parseProp(g.Params); err != nil {
+		return err
+	}
+	if len(g.prop.metrics) == 0 && len(g.prop.instanceLabels) == 0 {
+		return errs.New(errs.ErrMissingParam, "counters")
+	}
+	if endpoints := g.Params.GetChildS("endpoints"); endpoints != nil {
+		for _, e := range endpoints.GetChildren() {
+			p, err := parseProp(e)
+			if err != nil {
+				return err
+			}
+			g.endpoints = append(g.endpoints, p)
+		}
+	}
+
+	g.Logger.Debug().
+		Strs("instanceKeys", g.prop.instanceKeys).
+		Int("numMetrics", len(g.prop.metrics)).
+		Int("numLabels", len(g.prop.instanceLabels)).
+		Msg("Initialized metric cache")
+	return nil
+}
+
+// parseProp parses the query of the template, or of an endpoint, and its counters with the syntax of the Rest
+// collector: ^^ for the instance keys, ^ for the labels, the other counters are metrics
+func parseProp(n *node.Node) (*prop, error) {
+	p := &prop{
+		query:          n.GetChildContentS("query"),
+		records:        n.GetChildContentS("records"),
+		instanceLabels: make(map[string]string),
+		metrics:        make(map[string]*rest.Metric),
+	}
+	if p.query == "" {
+		return nil, errs.New(errs.ErrMissingParam, "query")
+	}
+
+	instanceKeys := make(map[string]string)
+	if counters := n.GetChildS("counters"); counters != nil {
+		for _, c := range counters.GetAllChildContentS() {
+			if c == "" {
+				continue
+			}
+			name, display, kind, metricType := util.ParseMetric(c)
+			switch kind {
+			case "key":
+				p.instanceLabels[name] = display
+				instanceKeys[display] = name
+			case "label":
+				p.instanceLabels[name] = display
+			case "float":
+				p.metrics[name] = &rest.Metric{Label: display, Name: name, MetricType: metricType, Exportable: true}
+			}
+		}
+	}
+	// sort keys by display name, like the Rest collector, to match the keys of the endpoints
+	for _, k := range util.GetSortedKeys(instanceKeys) {
+		p.instanceKeys = append(p.instanceKeys, instanceKeys[k])
+	}
+	return p, nil
+}
+
+func (g *GenericRest) initClient() error {
+	client, poller, err := collectors.NewHTTPClient(g.AbstractCollector, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	g.url = strings.TrimSuffix(g.Params.GetChildContentS("url"), "/")
+	// without url, the API is on the address of the poller
+	if poller != nil && g.url == "" {
+		if g.url, err = collectors.PollerURL(g.AbstractCollector, poller, "https"); err != nil {
+			return err
+		}
+	}
+	if err = g.setAuthorization(); err != nil {
+		return err
+	}
+	if g.paginate, err = newPagination(g.Params.GetChildS("paginate")); err != nil {
+		return err
+	}
+	g.headers = make(map[string]string)
+	if headers := g.Params.GetChildS("headers"); headers != nil {
+		for _, h := range headers.GetChildren() {
+			g.headers[h.GetNameS()] = h.GetContentS()
+		}
+	}
+
+	g.client = client
+	return nil
+}
+
+// setAuthorization reads the credentials of the poller, which calls its credentials_script when they expired
+func (g *GenericRest) setAuthorization() error {
+	var username, password string
+	if g.Auth != nil {
+		if pollerAuth, err := g.Auth.GetPollerAuth(); err == nil {
+			username = pollerAuth.Username
+			password = pollerAuth.Password
+		}
+	}
+	a, err := newAuthorization(g.Params.GetChildS("auth"), username, password)
+	if err != nil {
+		return err
+	}
+	g.auth = a
+	return nil
+}
+
+// renewCredentials is called when the API rejected the credentials. It calls the credentials_script of the poller
+// again, like rest.Client, or forgets the OAuth2 access token. It returns false when there is nothing to renew.
+func (g *GenericRest) renewCredentials() bool {
+	if g.Auth != nil {
+		if pollerAuth, err := g.Auth.GetPollerAuth(); err == nil && pollerAuth.HasCredentialScript {
+			g.Auth.Expire()
+			if err = g.setAuthorization(); err != nil {
+				g.Logger.Error().Err(err).Msg("Failed to renew credentials")
+				return false
+			}
+			g.Logger.Debug().Msg("credentials rejected, renewed them with the credentials script")
+			return true
+		}
+	}
+	if g.auth.invalidate() {
+		g.Logger.Debug().Msg("access token rejected, request a new one")
+		return true
+	}
+	return false
+}
+
+func (g *GenericRest) PollData() (map[string]*matrix.Matrix, error) {
+	var (
+		records      []gjson.Result
+		err          error
+		apiD, parseD time.Duration
+	)
+
+	g.Logger.Debug().Msg("starting data poll")
+	mat := g.Matrix[g.Object]
+	mat.Reset()
+
+	startTime := time.Now()
+	if records, err = g.fetch(g.prop); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errs.New(errs.ErrNoInstance, "no "+g.Object+" records at "+g.prop.query)
+	}
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	count := g.handleResults(records, g.prop, false)
+	parseD = time.Since(startTime)
+
+	for _, e := range g.endpoints {
+		startTime = time.Now()
+		if records, err = g.fetch(e); err != nil {
+			g.Logger.Error().Err(err).Str("query", e.query).Msg("failed to fetch endpoint")
+			continue
+		}
+		apiD += time.Since(startTime)
+		startTime = time.Now()
+		count += g.handleResults(records, e, true)
+		parseD += time.Since(startTime)
+	}
+
+	numInstances := len(mat.GetInstances())
+	_ = g.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = g.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = g.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = g.Metadata.LazySetValueInt64("instances", "data", int64(numInstances))
+	g.AddCollectCount(count)
+
+	return g.Matrix, nil
+}
+
+// handleResults sets the labels and metrics of the instances of the records, like the Rest collector.
+// The records of an endpoint only update the instances of the query, they don't create or remove instances.
+func (g *GenericRest) handleResults(records []gjson.Result, p *prop, isEndPoint bool) uint64 {
+	var count uint64
+
+	mat := g.Matrix[g.Object]
+	oldInstances := set.New()
+	currentInstances := set.New()
+	for key := range mat.GetInstances() {
+		oldInstances.Add(key)
+	}
+
+	for _, record := range records {
+		if !record.IsObject() {
+			g.Logger.Warn().Str("type", record.Type.String()).Msg("record is not an object, skipping")
+			continue
+		}
+
+		var instanceKey string
+		for _, k := range p.instanceKeys {
+			if value := record.Get(k); value.Exists() {
+				instanceKey += value.String()
+			}
+		}
+		if len(p.instanceKeys) > 0 && instanceKey == "" {
+			g.Logger.Trace().Msg("instance key is empty, skipping")
+			continue
+		}
+
+		instance := mat.GetInstance(instanceKey)
+		if isEndPoint && instance == nil {
+			g.Logger.Trace().Str("instanceKey", instanceKey).Msg("instance not found")
+			continue
+		}
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				g.Logger.Error().Err(err).Str("instanceKey", instanceKey).Msg("Failed to create new missing instance")
+				continue
+			}
+		}
+		if currentInstances.Has(instanceKey) {
+			g.Logger.Warn().Str("instanceKey", instanceKey).Msg("This instance is already processed. instanceKey is not unique")
+		}
+		currentInstances.Add(instanceKey)
+		oldInstances.Remove(instanceKey)
+
+		if !isEndPoint {
+			instance.ClearLabels()
+		}
+		for label, display := range p.instanceLabels {
+			value := record.Get(label)
+			if !value.Exists() {
+				continue
+			}
+			if value.IsArray() {
+				var values []string
+				for _, v := range value.Array() {
+					values = append(values, v.String())
+				}
+				sort.Strings(values)
+				instance.SetLabel(display, strings.Join(values, ","))
+			} else {
+				instance.SetLabel(display, value.String())
+			}
+			count++
+		}
+
+		for _, m := range p.metrics {
+			metric := mat.GetMetric(m.Name)
+			if metric == nil {
+				var err error
+				if metric, err = mat.NewMetricFloat64(m.Name, m.Label); err != nil {
+					g.Logger.Error().Err(err).Str("name", m.Name).Msg("NewMetricFloat64")
+					continue
+				}
+			}
+			f := record.Get(m.Name)
+			if !f.Exists() {
+				continue
+			}
+			var value float64
+			switch m.MetricType {
+			case "duration":
+				value = rest.HandleDuration(f.String())
+			case "timestamp":
+				value = rest.HandleTimestamp(f.String())
+			case "":
+				value = f.Float()
+			default:
+				g.Logger.Warn().Str("type", m.MetricType).Str("metric", m.Name).Msg("unknown metric type")
+				continue
+			}
+			if err := metric.SetValueFloat64(instance, value); err != nil {
+				g.Logger.Error().Err(err).Str("metric", m.Name).Msg("Unable to set float key on metric")
+				continue
+			}
+			count++
+		}
+	}
+
+	if !isEndPoint {
+		for key := range oldInstances.Iter() {
+			mat.RemoveInstance(key)
+			g.Logger.Debug().Str("key", key).Msg("removed instance")
+		}
+	}
+	return count
+}
+
+// fetch returns the records of all the pages of a query
+func (g *GenericRest) fetch(p *prop) ([]gjson.Result, error) {
+	var records []gjson.Result
+
+	u := p.query
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		u = g.url + "/" + strings.TrimPrefix(u, "/")
+	}
+	u, err := g.paginate.first(u)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidParam, "query "+p.query)
+	}
+
+	seen := set.New()
+	for page := 0; u != ""; page++ {
+		if page == g.paginate.maxPages {
+			g.Logger.Warn().Str("query", p.query).Int("maxPages", page).Msg("too many pages, the next pages are ignored")
+			break
+		}
+		if seen.Has(u) {
+			g.Logger.Warn().Str("url", u).Msg("the next page was already read, stop")
+			break
+		}
+		seen.Add(u)
+
+		g.Logger.Debug().Str("url", u).Msg("")
+		body, header, err := g.get(u)
+		if err != nil {
+			return nil, err
+		}
+		response := gjson.ParseBytes(body)
+		items := response
+		if p.records != "" {
+			items = response.Get(p.records)
+		}
+		pageRecords := items.Array()
+		records = append(records, pageRecords...)
+
+		if u, err = g.paginate.next(u, header, response, len(pageRecords)); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// get returns the body and headers of the response of a GET request. When the credentials are rejected, the request
+// is retried once with renewed credentials, see renewCredentials.
+func (g *GenericRest) get(u string) ([]byte, http.Header, error) {
+	for retried := false; ; retried = true {
+		request, err := requests.New(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		request.Header.Set("Accept", "application/json")
+		for name, value := range g.headers {
+			request.Header.Set(name, value)
+		}
+		if err = g.auth.authorize(g.client, request); err != nil {
+			return nil, nil, err
+		}
+
+		response, err := g.client.Do(request)
+		if err != nil {
+			return nil, nil, errs.New(errs.ErrConnection, err.Error())
+		}
+		body, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case response.StatusCode == http.StatusUnauthorized && !retried && g.renewCredentials():
+			continue
+		case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+			return nil, nil, errs.New(errs.ErrAuthFailed, response.Status)
+		case response.StatusCode != http.StatusOK:
+			return nil, nil, errs.New(errs.ErrAPIResponse, response.Status+": "+string(body), errs.WithStatus(response.StatusCode))
+		}
+		if !gjson.ValidBytes(body) {
+			return nil, nil, errs.New(errs.ErrAPIResponse, "invalid JSON response of "+u)
+		}
+		return body, response.Header, nil
+	}
+}
+
+// Interface guards
+var (
+	_ collector.Collector = (*GenericRest)(nil)
+)
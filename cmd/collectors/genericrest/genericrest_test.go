@@ -0,0 +1,300 @@
+package genericrest
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func newGenericRest(t *testing.T, template string) *GenericRest {
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := collector.New("GenericRest", params.GetChildContentS("object"), options.New(), params, nil)
+	g := &GenericRest{}
+	if err = g.Init(ac); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestPoll(t *testing.T) {
+	var tokens int
+	pages := map[string]string{
+		"": `{"switches": [{"id": "sw1", "model": "G720", "vsans": [20, 10], "stats": {"rx_bytes": 100}}], "next": "c2"}`,
+		"c2": `{"switches": [{"id": "sw2", "model": "G720", "vsans": [10], "stats": {"rx_bytes": 200},
+               "uptime": "P1DT2H"}], "next": ""}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			id, secret, _ := r.BasicAuth()
+			if id != "harvest" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			tokens++
+			_, _ = fmt.Fprintf(w, `{"access_token": "t%d", "token_type": "Bearer", "expires_in": 3600}`, tokens)
+			return
+		}
+		// the first token is rejected, the collector asks for a new one
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/switches":
+			_, _ = w.Write([]byte(pages[r.URL.Query().Get("cursor")]))
+		case "/api/health":
+			_, _ = w.Write([]byte(`[{"id": "sw1", "status": "ok", "temperature": 41}, {"id": "sw3", "status": "down"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newGenericRest(t, `
+object: switch
+url: `+server.URL+`/api
+query: switches
+records: switches
+schedule:
+  - data: 1m
+auth:
+  type: oauth2
+  token_url: `+server.URL+`/token
+  client_id: harvest
+  client_secret: s3cret
+paginate:
+  type: cursor
+  cursor: next
+  cursor_param: cursor
+counters:
+  - ^^id => switch
+  - ^model
+  - ^vsans
+  - stats.rx_bytes => rx_bytes
+  - uptime(duration) => uptime
+endpoints:
+  - query: health
+    counters:
+      - ^^id => switch
+      - ^status
+      - temperature
+`)
+	mats, err := g.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := mats[g.Object]
+	if len(mat.GetInstances()) != 2 {
+		t.Fatalf("instances got=%d, want=2", len(mat.GetInstances()))
+	}
+	sw1 := mat.GetInstance("sw1")
+	if sw1.GetLabel("vsans") != "10,20" || sw1.GetLabel("status") != "ok" || sw1.GetLabel("switch") != "sw1" {
+		t.Errorf("labels got=%v", sw1.GetLabels())
+	}
+	if v, _ := mat.GetMetric("temperature").GetValueFloat64(sw1); v != 41 {
+		t.Errorf("temperature got=%f, want=41", v)
+	}
+	sw2 := mat.GetInstance("sw2")
+	if v, _ := mat.GetMetric("stats.rx_bytes").GetValueFloat64(sw2); v != 200 {
+		t.Errorf("rx_bytes got=%f, want=200", v)
+	}
+	if v, _ := mat.GetMetric("uptime").GetValueFloat64(sw2); v != 93600 {
+		t.Errorf("uptime got=%f, want=93600", v)
+	}
+	if display := mat.GetMetric("stats.rx_bytes").GetName(); display != "rx_bytes" {
+		t.Errorf("display got=%s, want=rx_bytes", display)
+	}
+
+	// the cached token is used by the next poll
+	if _, err = g.PollData(); err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 2 {
+		t.Errorf("tokens got=%d, want=2", tokens)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	const total = 5
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var start, end int
+		switch r.URL.Path {
+		case "/link":
+			start, _ = strconv.Atoi(query.Get("page"))
+			end = min(start+2, total)
+			if end < total {
+				w.Header().Add("Link", fmt.Sprintf(`</link?page=%d>; rel="next", </link?page=4>; rel="last"`, end))
+			}
+		case "/offset":
+			start, _ = strconv.Atoi(query.Get("skip"))
+			limit, _ := strconv.Atoi(query.Get("top"))
+			end = min(start+limit, total)
+		}
+		_, _ = w.Write([]byte("["))
+		for i := start; i < end; i++ {
+			if i > start {
+				_, _ = w.Write([]byte(","))
+			}
+			_, _ = fmt.Fprintf(w, `{"name": "p%d", "value": %d}`, i, i)
+		}
+		_, _ = w.Write([]byte("]"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		query    string
+		paginate string
+	}{
+		{name: "link", query: "link", paginate: "type: link"},
+		{name: "offset", query: "offset", paginate: "type: offset\n  limit: 2\n  limit_param: top\n  offset_param: skip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGenericRest(t, `
+object: port
+url: `+server.URL+`
+query: `+tt.query+`
+schedule:
+  - data: 1m
+paginate:
+  `+tt.paginate+`
+counters:
+  - ^^name
+  - value
+`)
+			mats, err := g.PollData()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(mats[g.Object].GetInstances()); got != total {
+				t.Errorf("instances got=%d, want=%d", got, total)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   string
+		header string
+		want   string
+	}{
+		{name: "basic", header: "Authorization", want: "Basic aGFydmVzdDpwYXNz"},
+		{name: "bearer", auth: "bearer", header: "Authorization", want: "Bearer pass"},
+		{name: "api key", auth: "api_key", header: "X-API-Key", want: "pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n *node.Node
+			if tt.auth != "" {
+				n = node.NewS("auth")
+				n.NewChildS("type", tt.auth)
+			}
+			a, err := newAuthorization(n, "harvest", "pass")
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if err = a.authorize(nil, request); err != nil {
+				t.Fatal(err)
+			}
+			if got := request.Header.Get(tt.header); got != tt.want {
+				t.Errorf("%s got=%s, want=%s", tt.header, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newAuthorization(node.NewS("auth"), "", ""); err == nil {
+		t.Error("auth without type should fail")
+	}
+}
+
+func TestResolveOtherHost(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "/api/ports?page=2", want: "https://switch1/api/ports?page=2"},
+		{ref: "https://SWITCH1/api/ports?page=2", want: "https://SWITCH1/api/ports?page=2"},
+		{ref: "https://attacker/api/ports?page=2"},
+		{ref: "http://switch1/api/ports?page=2"},
+		{ref: "//attacker/api/ports"},
+	}
+	for _, tt := range tests {
+		got, err := resolve("https://switch1/api/ports", tt.ref)
+		if tt.want == "" && err == nil {
+			t.Errorf("%s got=%s, want an error", tt.ref, got)
+		}
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s got=%s err=%v, want=%s", tt.ref, got, err, tt.want)
+		}
+	}
+}
+
+func TestCredentialsScript(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	script := filepath.Join(dir, "password.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat "+passwordFile+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	setPassword := func(password string) {
+		if err := os.WriteFile(passwordFile, []byte(password), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	password := "old"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, got, _ := r.BasicAuth(); got != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"name": "p1", "value": 1}]`))
+	}))
+	defer server.Close()
+
+	setPassword(password)
+	poller := &conf.Poller{Name: "test", Username: "harvest", CredentialsScript: conf.CredentialsScript{Path: script, Schedule: "24h"}}
+	params, err := tree.LoadYaml([]byte(`
+object: port
+url: ` + server.URL + `
+query: ports
+schedule:
+  - data: 1m
+counters:
+  - ^^name
+  - value
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &GenericRest{}
+	if err = g.Init(collector.New("GenericRest", "port", options.New(), params, auth.NewCredentials(poller, logging.Get()))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the password is rotated, the cached one is rejected
+	password = "new"
+	setPassword(password)
+	if _, err = g.PollData(); err != nil {
+		t.Fatalf("poll after the password changed: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package genericrest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	pageLink   = "link"
+	pageCursor = "cursor"
+	pageOffset = "offset"
+
+	defaultMaxPages = 1000
+	defaultLimit    = 100
+)
+
+// pagination returns the URLs of the pages of a query
+type pagination struct {
+	kind        string // link, cursor or offset, a single page when empty
+	cursor      string // path of the cursor in the response
+	cursorParam string // query parameter of the cursor, the cursor is the URL of the next page when empty
+	limit       int
+	limitParam  string
+	offsetParam string
+	maxPages    int
+}
+
+// newPagination parses the paginate section of the template, e.g.
+//
+//	paginate:
+//	  type:         cursor
+//	  cursor:       meta.next_cursor
+//	  cursor_param: cursor
+func newPagination(n *node.Node) (*pagination, error) {
+	p := &pagination{maxPages: defaultMaxPages}
+	if n == nil {
+		return p, nil
+	}
+	if s := n.GetChildContentS("max_pages"); s != "" {
+		var err error
+		if p.maxPages, err = strconv.Atoi(s); err != nil || p.maxPages <= 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "paginate max_pages: "+s)
+		}
+	}
+
+	p.kind = n.GetChildContentS("type")
+	switch p.kind {
+	case pageLink:
+	case pageCursor:
+		if p.cursor = n.GetChildContentS("cursor"); p.cursor == "" {
+			return nil, errs.New(errs.ErrMissingParam, "paginate cursor")
+		}
+		p.cursorParam = n.GetChildContentS("cursor_param")
+	case pageOffset:
+		p.limit = defaultLimit
+		if s := n.GetChildContentS("limit"); s != "" {
+			var err error
+			if p.limit, err = strconv.Atoi(s); err != nil || p.limit <= 0 {
+				return nil, errs.New(errs.ErrInvalidParam, "paginate limit: "+s)
+			}
+		}
+		if p.limitParam = n.GetChildContentS("limit_param"); p.limitParam == "" {
+			p.limitParam = "limit"
+		}
+		if p.offsetParam = n.GetChildContentS("offset_param"); p.offsetParam == "" {
+			p.offsetParam = "offset"
+		}
+	case "":
+		return nil, errs.New(errs.ErrMissingParam, "paginate type")
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "paginate type "+p.kind)
+	}
+	return p, nil
+}
+
+// first returns the URL of the first page of a query
+func (p *pagination) first(u string) (string, error) {
+	if p.kind != pageOffset {
+		return u, nil
+	}
+	return setParams(u, map[string]string{p.limitParam: strconv.Itoa(p.limit), p.offsetParam: "0"})
+}
+
+// next returns the URL of the page after the page at u, or "" after the last page. count is the number of records of
+// the page at u.
+func (p *pagination) next(u string, header http.Header, response gjson.Result, count int) (string, error) {
+	switch p.kind {
+	case pageLink:
+		if link := nextLink(header.Values("Link")); link != "" {
+			return resolve(u, link)
+		}
+	case pageCursor:
+		cursor := response.Get(p.cursor).String()
+		if cursor == "" {
+			return "", nil
+		}
+		if p.cursorParam == "" {
+			return resolve(u, cursor)
+		}
+		return setParams(u, map[string]string{p.cursorParam: cursor})
+	case pageOffset:
+		if count < p.limit {
+			return "", nil
+		}
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return "", err
+		}
+		offset, _ := strconv.Atoi(parsed.Query().Get(p.offsetParam))
+		return setParams(u, map[string]string{p.offsetParam: strconv.Itoa(offset + count)})
+	}
+	return "", nil
+}
+
+// nextLink returns the target of the next relation of the Link headers (RFC 8288), e.g.
+//
+//	Link: <https://switch1/api/ports?page=2>; rel="next", <https://switch1/api/ports?page=5>; rel="last"
+func nextLink(links []string) string {
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// resolve returns the URL of ref, a URL relative to u or an absolute URL. Since the requests carry the credentials of
+// the collector, the next page must have the scheme and host of u.
+func resolve(u string, ref string) (string, error) {
+	base, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", errs.New(errs.ErrAPIResponse, "invalid next page "+ref)
+	}
+	next := base.ResolveReference(r)
+	if next.Scheme != base.Scheme || !strings.EqualFold(next.Host, base.Host) {
+		return "", errs.New(errs.ErrAPIResponse, "next page "+ref+" is not on "+base.Scheme+"://"+base.Host)
+	}
+	return next.String(), nil
+}
+
+// setParams replaces the query parameters of u
+func setParams(u string, params map[string]string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
@@ -0,0 +1,136 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/tidwall/gjson"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// scrape reads the text format of the endpoint
+func (p *Prometheus) scrape() ([]sample, error) {
+	body, err := p.get(p.url, "text/plain;version=0.0.4")
+	if err != nil {
+		return nil, err
+	}
+	samples, err := parseText(bytes.NewReader(body))
+	if err != nil {
+		return nil, errs.New(errs.ErrAPIResponse, err.Error())
+	}
+	return samples, nil
+}
+
+// query runs the PromQL queries with the query API of the endpoint. Each result of a query is a sample named after
+// the query. A failed query is logged, the other queries are still returned.
+func (p *Prometheus) query() ([]sample, error) {
+	var (
+		samples []sample
+		lastErr error
+	)
+
+	base := strings.TrimSuffix(p.url, "/")
+	for _, q := range p.queries {
+		body, err := p.get(base+"/api/v1/query?query="+url.QueryEscape(q.expr), "application/json")
+		if err != nil {
+			p.Logger.Error().Err(err).Str("query", q.name).Msg("failed to run query")
+			lastErr = err
+			continue
+		}
+		results, err := parseQuery(q.name, body)
+		if err != nil {
+			p.Logger.Error().Err(err).Str("query", q.name).Msg("failed to parse query result")
+			lastErr = err
+			continue
+		}
+		samples = append(samples, results...)
+	}
+	if len(samples) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return samples, nil
+}
+
+// parseQuery returns the samples of the response of the query API, a vector or a scalar. Like the samples of the
+// text format, results that are not a number are ignored.
+//
+//	{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {"instance": "sw1"}, "value": [1685614530.1, "42"]}]}}
+func parseQuery(name string, body []byte) ([]sample, error) {
+	response := gjson.ParseBytes(body)
+	if status := response.Get("status").String(); status != "success" {
+		return nil, errs.New(errs.ErrAPIRequestRejected, response.Get("error").String())
+	}
+
+	var samples []sample
+	data := response.Get("data")
+	switch resultType := data.Get("resultType").String(); resultType {
+	case "scalar":
+		value, err := parseValue(data.Get("result.1").String())
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			break
+		}
+		samples = append(samples, sample{name: name, labels: make(map[string]string), value: value})
+	case "vector":
+		for _, r := range data.Get("result").Array() {
+			value, err := parseValue(r.Get("value.1").String())
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			s := sample{name: name, labels: make(map[string]string), value: value}
+			r.Get("metric").ForEach(func(k, v gjson.Result) bool {
+				if k.String() != "__name__" {
+					s.labels[k.String()] = v.String()
+				}
+				return true
+			})
+			samples = append(samples, s)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected resultType=[%s]", resultType)
+	}
+	return samples, nil
+}
+
+func (p *Prometheus) get(u string, accept string) ([]byte, error) {
+	request, err := requests.New(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", accept)
+	for name, value := range p.headers {
+		request.Header.Set(name, value)
+	}
+	if p.username != "" {
+		request.SetBasicAuth(p.username, p.password)
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, errs.New(errs.ErrConnection, err.Error())
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden:
+		return nil, errs.New(errs.ErrAuthFailed, response.Status)
+	case response.StatusCode != http.StatusOK:
+		return nil, errs.New(errs.ErrAPIResponse, response.Status+": "+string(body), errs.WithStatus(response.StatusCode))
+	}
+	return body, nil
+}
@@ -0,0 +1,135 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sample is a series of the text format, or a result of a PromQL query
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseText parses the Prometheus text exposition format, e.g.
+//
+//	# TYPE node_filesystem_avail_bytes gauge
+//	node_filesystem_avail_bytes{device="/dev/sda1",mountpoint="/"} 1.5e+10
+//
+// Comments, and samples that are not a number, are ignored. The timestamps of the samples are ignored too.
+func parseText(r io.Reader) ([]sample, error) {
+	var samples []sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+func parseLine(line string) (sample, error) {
+	s := sample{labels: make(map[string]string)}
+
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	s.name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		var err error
+		if rest, err = parseLabels(rest[1:], s.labels); err != nil {
+			return s, err
+		}
+	}
+
+	// the value, optionally followed by a timestamp
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	value, err := parseValue(fields[0])
+	if err != nil {
+		return s, err
+	}
+	s.value = value
+	return s, nil
+}
+
+// parseLabels parses the labels after the opening brace and returns the rest of the line after the closing brace
+func parseLabels(in string, labels map[string]string) (string, error) {
+	for {
+		in = strings.TrimLeft(in, " \t")
+		if in == "" {
+			return "", fmt.Errorf("unterminated labels")
+		}
+		if in[0] == '}' {
+			return in[1:], nil
+		}
+		eq := strings.IndexByte(in, '=')
+		if eq < 0 {
+			return "", fmt.Errorf("missing = after label")
+		}
+		name := strings.TrimSpace(in[:eq])
+		in = strings.TrimLeft(in[eq+1:], " \t")
+		if in == "" || in[0] != '"' {
+			return "", fmt.Errorf("label %s is not quoted", name)
+		}
+
+		var value strings.Builder
+		i := 1
+		for ; i < len(in) && in[i] != '"'; i++ {
+			if in[i] == '\\' && i+1 < len(in) {
+				i++
+				switch in[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(in[i])
+				}
+				continue
+			}
+			value.WriteByte(in[i])
+		}
+		if i >= len(in) {
+			return "", fmt.Errorf("unterminated value of label %s", name)
+		}
+		labels[name] = value.String()
+
+		in = strings.TrimLeft(in[i+1:], " \t")
+		if in != "" && in[0] == ',' {
+			in = in[1:]
+		}
+	}
+}
+
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
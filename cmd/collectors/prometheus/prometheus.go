@@ -0,0 +1,351 @@
+// Package prometheus collects the metrics of Prometheus endpoints, either by scraping the text format of a
+// /metrics endpoint, or with the PromQL queries of the query API of a Prometheus server, e.g. to collect the
+// metrics of switches or hosts next to the clusters through the exporters and plugins of Harvest.
+package prometheus
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Prometheus struct {
+	*collector.AbstractCollector
+	client       *http.Client
+	url          string
+	username     string
+	password     string
+	headers      map[string]string
+	queries      []query   // PromQL queries, the endpoint is scraped when empty
+	counters     []counter // metrics to collect, all metrics when empty
+	exclude      []string  // patterns of the metrics that are not collected
+	instanceKeys []string  // labels of the instance key, all labels when empty
+	seriesLabels map[string]string
+}
+
+type query struct {
+	name string
+	expr string
+}
+
+// counter is the name of a metric, or a pattern like node_network_*, and its display name
+type counter struct {
+	pattern string
+	display string
+}
+
+func init() {
+	plugin.RegisterModule(&Prometheus{})
+}
+
+func (p *Prometheus) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.prometheus",
+		New: func() plugin.Module { return new(Prometheus) },
+	}
+}
+
+func (p *Prometheus) Init(a *collector.AbstractCollector) error {
+	var err error
+	p.AbstractCollector = a
+
+	if err = collectors.LoadTemplate(p.AbstractCollector); err != nil {
+		return err
+	}
+	if err = collector.Init(p); err != nil {
+		return err
+	}
+	if err = p.InitCache(); err != nil {
+		return err
+	}
+	if err = p.initClient(); err != nil {
+		return err
+	}
+	p.InitMatrix()
+
+	p.Logger.Info().Str("url", p.url).Int("queries", len(p.queries)).Msg("initialized")
+	return nil
+}
+
+func (p *Prometheus) InitMatrix() {
+	mat := collectors.InitMatrix(p.AbstractCollector)
+	if p.Params.GetChildS("export_options") == nil {
+		exportOptions := node.NewS("export_options")
+		if len(p.instanceKeys) == 0 {
+			exportOptions.NewChildS("include_all_labels", "true")
+		} else {
+			instanceKeys := exportOptions.NewChildS("instance_keys", "")
+			for _, key := range p.instanceKeys {
+				instanceKeys.NewChildS("", p.labelName(key))
+			}
+		}
+		mat.SetExportOptions(exportOptions)
+	}
+}
+
+func (p *Prometheus) InitCache() error {
+	p.url = p.Params.GetChildContentS("url")
+	if p.url == "" {
+		p.url = "/metrics"
+	}
+
+	if queries := p.Params.GetChildS("queries"); queries != nil {
+		for _, q := range queries.GetChildren() {
+			if q.GetNameS() == "" || q.GetContentS() == "" {
+				return errs.New(errs.ErrInvalidParam, "query "+q.GetNameS())
+			}
+			p.queries = append(p.queries, query{name: q.GetNameS(), expr: q.GetContentS()})
+		}
+	}
+
+	if counters := p.Params.GetChildS("counters"); counters != nil {
+		for _, c := range counters.GetAllChildContentS() {
+			name, display, _, _ := util.ParseMetric(c)
+			if _, err := path.Match(name, ""); err != nil {
+				return errs.New(errs.ErrInvalidParam, "counter "+c)
+			}
+			p.counters = append(p.counters, counter{pattern: name, display: display})
+		}
+	}
+	if exclude := p.Params.GetChildS("exclude"); exclude != nil {
+		p.exclude = exclude.GetAllChildContentS()
+	}
+	if keys := p.Params.GetChildS("instance_keys"); keys != nil {
+		p.instanceKeys = keys.GetAllChildContentS()
+	}
+	if labels := p.Params.GetChildS("series_labels"); labels != nil {
+		p.seriesLabels = make(map[string]string)
+		for _, l := range labels.GetAllChildContentS() {
+			name, display, _, _ := util.ParseMetric(l)
+			p.seriesLabels[name] = display
+		}
+	}
+
+	p.Logger.Debug().
+		Strs("instanceKeys", p.instanceKeys).
+		Int("numCounters", len(p.counters)).
+		Int("numQueries", len(p.queries)).
+		Msg("Initialized metric cache")
+	return nil
+}
+
+func (p *Prometheus) initClient() error {
+	client, poller, err := collectors.NewHTTPClient(p.AbstractCollector, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	// a path is scraped on the address of the poller
+	if poller != nil && strings.HasPrefix(p.url, "/") {
+		addr, err := collectors.PollerURL(p.AbstractCollector, poller, "http")
+		if err != nil {
+			return err
+		}
+		p.url = addr + p.url
+	}
+	if p.Auth != nil {
+		if pollerAuth, err := p.Auth.GetPollerAuth(); err == nil && pollerAuth.Username != "" {
+			p.username = pollerAuth.Username
+			p.password = pollerAuth.Password
+		}
+	}
+	p.headers = make(map[string]string)
+	if headers := p.Params.GetChildS("headers"); headers != nil {
+		for _, h := range headers.GetChildren() {
+			p.headers[h.GetNameS()] = h.GetContentS()
+		}
+	}
+
+	p.client = client
+	return nil
+}
+
+func (p *Prometheus) PollData() (map[string]*matrix.Matrix, error) {
+	var (
+		samples      []sample
+		err          error
+		apiD, parseD time.Duration
+	)
+
+	p.Logger.Debug().Msg("starting data poll")
+	startTime := time.Now()
+	if len(p.queries) > 0 {
+		samples, err = p.query()
+	} else {
+		samples, err = p.scrape()
+	}
+	if err != nil {
+		return nil, err
+	}
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	count := p.handleSamples(samples)
+	parseD = time.Since(startTime)
+
+	numInstances := len(p.Matrix[p.Object].GetInstances())
+	if numInstances == 0 {
+		return nil, errs.New(errs.ErrNoInstance, "no series of "+p.Object+" at "+p.url)
+	}
+
+	_ = p.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = p.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = p.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = p.Metadata.LazySetValueInt64("instances", "data", int64(numInstances))
+	p.AddCollectCount(count)
+
+	return p.Matrix, nil
+}
+
+// handleSamples replaces the instances of the matrix with the series of the samples, and returns the number of
+// values that were set
+func (p *Prometheus) handleSamples(samples []sample) uint64 {
+	var (
+		count      uint64
+		duplicates int
+	)
+
+	mat := p.Matrix[p.Object]
+	mat.PurgeInstances()
+	mat.Reset()
+
+	for _, s := range samples {
+		display, ok := p.display(s.name)
+		if !ok {
+			continue
+		}
+		instanceKey, ok := p.instanceKey(s.labels)
+		if !ok {
+			p.Logger.Trace().Str("metric", s.name).Msg("skip series, missing instance key")
+			continue
+		}
+
+		instance := mat.GetInstance(instanceKey)
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				p.Logger.Error().Err(err).Str("instanceKey", instanceKey).Send()
+				continue
+			}
+		}
+		for name, value := range s.labels {
+			if label, ok := p.label(name); ok {
+				instance.SetLabel(label, value)
+			}
+		}
+
+		metric := mat.GetMetric(s.name)
+		if metric == nil {
+			var err error
+			if metric, err = mat.NewMetricFloat64(s.name, display); err != nil {
+				p.Logger.Error().Err(err).Str("metric", s.name).Msg("NewMetricFloat64")
+				continue
+			}
+		}
+		if _, ok := metric.GetValueFloat64(instance); ok {
+			duplicates++
+		}
+		if err := metric.SetValueFloat64(instance, s.value); err != nil {
+			p.Logger.Error().Err(err).Str("metric", s.name).Msg("Unable to set float key on metric")
+			continue
+		}
+		count++
+	}
+
+	if duplicates > 0 {
+		p.Logger.Debug().Int("duplicates", duplicates).Msg("series with the same instance key, the last one was kept")
+	}
+	return count
+}
+
+// display returns the display name of a metric, and false when the metric is not collected
+func (p *Prometheus) display(name string) (string, bool) {
+	for _, pattern := range p.exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return "", false
+		}
+	}
+	if len(p.counters) == 0 {
+		return name, true
+	}
+	// an exact name takes precedence over the patterns
+	for _, c := range p.counters {
+		if c.pattern == name {
+			return c.display, true
+		}
+	}
+	for _, c := range p.counters {
+		if ok, _ := path.Match(c.pattern, name); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// instanceKey joins the values of the instance keys, or all labels when the template does not define instance keys
+func (p *Prometheus) instanceKey(labels map[string]string) (string, bool) {
+	var key strings.Builder
+	if len(p.instanceKeys) == 0 {
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			if _, ok := p.label(name); ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key.WriteString(name + "=" + labels[name] + ",")
+		}
+		return key.String(), true
+	}
+	for i, name := range p.instanceKeys {
+		value, ok := labels[name]
+		if !ok {
+			return "", false
+		}
+		if i > 0 {
+			key.WriteByte('#')
+		}
+		key.WriteString(value)
+	}
+	return key.String(), true
+}
+
+// label returns the display name of a label, and false when the label is not kept. The instance keys are always kept.
+func (p *Prometheus) label(name string) (string, bool) {
+	if display, ok := p.seriesLabels[name]; ok {
+		return display, true
+	}
+	if p.seriesLabels == nil {
+		return name, true
+	}
+	for _, key := range p.instanceKeys {
+		if key == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// labelName returns the display name of a label
+func (p *Prometheus) labelName(name string) string {
+	if display, ok := p.seriesLabels[name]; ok {
+		return display
+	}
+	return name
+}
+
+// Interface guards
+var (
+	_ collector.Collector = (*Prometheus)(nil)
+)
@@ -0,0 +1,153 @@
+package prometheus
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	f, err := os.Open("testdata/metrics.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples, err := parseText(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the NaN sample is ignored
+	if len(samples) != 7 {
+		t.Fatalf("samples got=%d, want=7", len(samples))
+	}
+	if s := samples[2]; s.name != "node_filesystem_size_bytes" || s.value != 5e10 || s.labels["mountpoint"] != "/" {
+		t.Errorf("sample with a timestamp got=%+v", s)
+	}
+	if s := samples[6]; s.labels["version"] != `#1 SMP "generic"` || s.labels["nodename"] != "host1" {
+		t.Errorf("escaped label got=%+v", s)
+	}
+
+	if _, err = parseText(strings.NewReader(`node_load1{instance="host1" 1`)); err == nil {
+		t.Error("unterminated labels should fail")
+	}
+}
+
+func newPrometheus(t *testing.T, template string) *Prometheus {
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := collector.New("Prometheus", params.GetChildContentS("object"), options.New(), params, nil)
+	p := &Prometheus{}
+	if err = p.Init(ac); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// values returns the values of a metric by instance key
+func values(mat *matrix.Matrix, name string) map[string]float64 {
+	got := make(map[string]float64)
+	metric := mat.GetMetric(name)
+	if metric == nil {
+		return got
+	}
+	for key, instance := range mat.GetInstances() {
+		if v, ok := metric.GetValueFloat64(instance); ok {
+			got[key] = v
+		}
+	}
+	return got
+}
+
+func TestScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/metrics.txt")
+	}))
+	defer server.Close()
+
+	p := newPrometheus(t, `
+object: node_filesystem
+url: `+server.URL+`/metrics
+schedule:
+  - data: 1m
+instance_keys:
+  - device
+  - mountpoint
+series_labels:
+  - fstype
+  - mountpoint => path
+counters:
+  - node_filesystem_*
+  - node_filesystem_size_bytes => size
+exclude:
+  - node_filesystem_device_error
+`)
+	mats, err := p.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := mats[p.Object]
+	if len(mat.GetInstances()) != 2 || len(mat.GetMetrics()) != 2 {
+		t.Errorf("instances got=%d metrics got=%d, want 2 of each", len(mat.GetInstances()), len(mat.GetMetrics()))
+	}
+	if got := values(mat, "node_filesystem_avail_bytes"); got["/dev/sdb1#/data"] != 536870912000 {
+		t.Errorf("avail got=%v", got)
+	}
+	if display := mat.GetMetric("node_filesystem_size_bytes").GetName(); display != "size" {
+		t.Errorf("display got=%s, want=size", display)
+	}
+	instance := mat.GetInstance("/dev/sda1#/")
+	want := map[string]string{"device": "/dev/sda1", "path": "/", "fstype": "ext4"}
+	if got := instance.GetLabels(); len(got) != len(want) {
+		t.Errorf("labels got=%v, want=%v", got, want)
+	}
+	for k, v := range want {
+		if instance.GetLabel(k) != v {
+			t.Errorf("label %s got=%s, want=%s", k, instance.GetLabel(k), v)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		queries = append(queries, r.URL.Query().Get("query"))
+		http.ServeFile(w, r, "testdata/query.json")
+	}))
+	defer server.Close()
+
+	p := newPrometheus(t, `
+object: switch
+url: `+server.URL+`
+schedule:
+  - data: 1m
+queries:
+  rx_bytes: sum by (instance, interface) (rate(ifHCInOctets[5m]))
+`)
+	mats, err := p.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0] != "sum by (instance, interface) (rate(ifHCInOctets[5m]))" {
+		t.Errorf("queries got=%v", queries)
+	}
+	// without instance keys, all the labels are keys, the NaN result is ignored
+	got := values(mats[p.Object], "rx_bytes")
+	if len(got) != 2 || got["instance=sw1,interface=Ethernet1/1,"] != 1250000 {
+		t.Errorf("rx_bytes got=%v", got)
+	}
+	if options := mats[p.Object].GetExportOptions(); options.GetChildContentS("include_all_labels") != "true" {
+		t.Error("all labels should be exported without instance keys")
+	}
+}
@@ -0,0 +1,216 @@
+package rest
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"strings"
+	"time"
+)
+
+// counterProperty describes how a cumulative counter is post-processed. The properties and the math are
+// the same as the perf collectors:
+//
+//	delta   - subtract the previous value from the current
+//	rate    - delta, normalized by elapsed time
+//	average - delta, divided by the delta of the base counter
+//	percent - average * 100
+type counterProperty struct {
+	property    string
+	denominator string // display name of the base counter, required by average and percent
+}
+
+// initCounterProperties parses the counter_properties section of a template. Each entry is the display name
+// of a metric followed by its property and, for average and percent, the display name of the base counter.
+//
+//	counter_properties:
+//	  transfer_bytes: rate
+//	  transfer_ops:   delta
+//	  latency:        average transfer_ops
+func (r *Rest) initCounterProperties() error {
+	r.properties = make(map[string]*counterProperty)
+
+	props := r.Params.GetChildS("counter_properties")
+	if props == nil {
+		return nil
+	}
+	for _, p := range props.GetChildren() {
+		name := p.GetNameS()
+		fields := strings.Fields(p.GetContentS())
+		if len(fields) == 0 {
+			return errs.New(errs.ErrConfig, "counter_properties: missing property for "+name)
+		}
+		cp := &counterProperty{property: fields[0]}
+		switch cp.property {
+		case "raw", "delta", "rate":
+			if len(fields) != 1 {
+				return errs.New(errs.ErrConfig, fmt.Sprintf("counter_properties: %s does not take a base counter for %s", cp.property, name))
+			}
+		case "average", "percent":
+			if len(fields) != 2 {
+				return errs.New(errs.ErrConfig, fmt.Sprintf("counter_properties: %s requires a base counter for %s", cp.property, name))
+			}
+			cp.denominator = fields[1]
+		default:
+			return errs.New(errs.ErrConfig, fmt.Sprintf("counter_properties: unknown property %s for %s", cp.property, name))
+		}
+		r.properties[name] = cp
+	}
+
+	for name, cp := range r.properties {
+		if cp.denominator == "" {
+			continue
+		}
+		if base, ok := r.properties[cp.denominator]; !ok || base.property != "delta" {
+			return errs.New(errs.ErrConfig, fmt.Sprintf("counter_properties: base counter %s of %s must have the delta property", cp.denominator, name))
+		}
+	}
+
+	if len(r.properties) > 0 {
+		// Create an artificial metric to hold the timestamp of each instance, used to calculate rates.
+		// Instances that appear between polls have no previous timestamp, and are skipped.
+		mat := r.Matrix[r.Object]
+		if mat.GetMetric("timestamp") == nil {
+			m, err := mat.NewMetricFloat64("timestamp")
+			if err != nil {
+				return err
+			}
+			m.SetProperty("raw")
+			m.SetExportable(false)
+		}
+		_, _ = r.Metadata.NewMetricUint64("skips")
+	}
+
+	r.Logger.Debug().Int("numProperties", len(r.properties)).Msg("initialized counter properties")
+	return nil
+}
+
+// calculateProperties cooks the metrics of mat that have a counter property, using the raw values of the
+// previous poll. The raw values of this poll are cached for the next one. Counter resets and wraps, which show up
+// as negative deltas, as well as instances without a previous value, are skipped.
+func (r *Rest) calculateProperties(mat *matrix.Matrix, pollTime time.Time) {
+	if len(r.properties) == 0 {
+		return
+	}
+
+	calcStart := time.Now()
+
+	ts := mat.GetMetric("timestamp")
+	for _, instance := range mat.GetInstances() {
+		_ = ts.SetValueFloat64(instance, float64(pollTime.UnixNano())/float64(time.Second))
+	}
+
+	// cache raw data for next poll
+	cachedData := mat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+	prevMat := r.prevMat
+	r.prevMat = cachedData
+
+	if prevMat == nil {
+		r.Logger.Debug().Msg("skip postprocessing until next poll (previous cache empty)")
+		for name := range r.properties {
+			if key := metricKey(mat, name); key != "" {
+				mat.GetMetric(key).Reset(len(mat.GetInstances()))
+			}
+		}
+		return
+	}
+
+	var totalSkips int
+
+	_, _ = mat.Delta("timestamp", prevMat, r.Logger)
+
+	// order metrics, such that those requiring base counters are processed last
+	var ordered, denominators []string
+	for name, cp := range r.properties {
+		if cp.denominator == "" {
+			ordered = append(ordered, name)
+		} else {
+			denominators = append(denominators, name)
+		}
+	}
+	ordered = append(ordered, denominators...)
+
+	keys := make(map[string]string, len(ordered))
+	for _, name := range ordered {
+		cp := r.properties[name]
+		key := metricKey(mat, name)
+		if key == "" {
+			r.Logger.Trace().Str("metric", name).Msg("Metric not collected")
+			continue
+		}
+		keys[name] = key
+		metric := mat.GetMetric(key)
+		// used in aggregator plugin
+		metric.SetProperty(cp.property)
+		metric.SetComment(cp.denominator)
+
+		if cp.property == "raw" {
+			continue
+		}
+
+		if prevMat.GetMetric(key) == nil {
+			// metric is new since the previous poll, there is nothing to subtract
+			metric.Reset(len(mat.GetInstances()))
+			continue
+		}
+
+		skips, err := mat.Delta(key, prevMat, r.Logger)
+		if err != nil {
+			r.Logger.Error().Err(err).Str("key", key).Msg("Calculate delta")
+			continue
+		}
+		totalSkips += skips
+
+		if cp.property == "average" || cp.property == "percent" {
+			baseKey, ok := keys[cp.denominator]
+			if !ok {
+				r.Logger.Warn().Str("metric", name).Str("denominator", cp.denominator).Msg("Base counter missing")
+				metric.Reset(len(mat.GetInstances()))
+				continue
+			}
+			if skips, err = mat.Divide(key, baseKey, r.Logger); err != nil {
+				r.Logger.Error().Err(err).Str("key", key).Msg("Division by base")
+				continue
+			}
+			totalSkips += skips
+		}
+
+		if cp.property == "percent" {
+			if skips, err = mat.MultiplyByScalar(key, 100, r.Logger); err != nil {
+				r.Logger.Error().Err(err).Str("key", key).Msg("Multiply by scalar")
+				continue
+			}
+			totalSkips += skips
+		}
+	}
+
+	// calculate rates after averages and percents, since they may use the delta of a base counter
+	for _, name := range ordered {
+		if r.properties[name].property != "rate" {
+			continue
+		}
+		key, ok := keys[name]
+		if !ok || prevMat.GetMetric(key) == nil {
+			continue
+		}
+		skips, err := mat.Divide(key, "timestamp", r.Logger)
+		if err != nil {
+			r.Logger.Error().Err(err).Str("key", key).Msg("Calculate rate")
+			continue
+		}
+		totalSkips += skips
+	}
+
+	_ = r.Metadata.LazySetValueInt64("calc_time", "data", time.Since(calcStart).Microseconds())
+	_ = r.Metadata.LazySetValueUint64("skips", "data", uint64(totalSkips))
+}
+
+// metricKey returns the key of the metric with display name, or an empty string when the metric does not exist
+func metricKey(mat *matrix.Matrix, display string) string {
+	for key, metric := range mat.GetMetrics() {
+		if metric.GetName() == display {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"github.com/tidwall/gjson"
+	"testing"
+	"time"
+)
+
+func portRecords(ports string) []gjson.Result {
+	return gjson.Parse(ports).Array()
+}
+
+func Test_calculateProperties(t *testing.T) {
+	r := newRest("NetPortStats", "netport_stats.yaml")
+
+	poll1 := portRecords(`[
+		{"name": "e0a", "node": {"name": "n1"}, "mtu": 1500, "statistics": {"throughput_raw": {"total": 1000},
+			"device": {"receive_raw": {"packets": 100, "errors": 1, "discards": 10}}}},
+		{"name": "e0b", "node": {"name": "n1"}, "mtu": 9000, "statistics": {"throughput_raw": {"total": 5000},
+			"device": {"receive_raw": {"packets": 500, "errors": 0, "discards": 0}}}}
+	]`)
+	// e0b was reset, e0a is gone and e0c is new
+	poll2 := portRecords(`[
+		{"name": "e0b", "node": {"name": "n1"}, "mtu": 9000, "statistics": {"throughput_raw": {"total": 10},
+			"device": {"receive_raw": {"packets": 5, "errors": 0, "discards": 0}}}},
+		{"name": "e0c", "node": {"name": "n1"}, "mtu": 1500, "statistics": {"throughput_raw": {"total": 1},
+			"device": {"receive_raw": {"packets": 1, "errors": 0, "discards": 0}}}},
+		{"name": "e0a", "node": {"name": "n1"}, "mtu": 1500, "statistics": {"throughput_raw": {"total": 7000},
+			"device": {"receive_raw": {"packets": 300, "errors": 21, "discards": 110}}}}
+	]`)
+
+	now := time.Now().Truncate(time.Second)
+	mm, err := r.pollData(now, poll1, volumeEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := mm["NetPortStats"]
+
+	// first poll, nothing to subtract
+	for _, name := range []string{"statistics.throughput_raw.total", "statistics.device.receive_raw.packets"} {
+		for key, instance := range mat.GetInstances() {
+			if _, ok := mat.GetMetric(name).GetValueFloat64(instance); ok {
+				t.Errorf("%s of %s should not be exported after the first poll", name, key)
+			}
+		}
+	}
+	// metrics without a property are exported as is
+	if v, ok := mat.GetMetric("mtu").GetValueFloat64(mat.GetInstance("n1e0a")); !ok || v != 1500 {
+		t.Errorf("mtu got=%v, want=1500", v)
+	}
+
+	mm, err = r.pollData(now.Add(time.Minute), poll2, volumeEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat = mm["NetPortStats"]
+
+	tests := []struct {
+		instance string
+		metric   string
+		want     float64
+		exported bool
+	}{
+		{instance: "n1e0a", metric: "statistics.throughput_raw.total", want: 100, exported: true},
+		{instance: "n1e0a", metric: "statistics.device.receive_raw.packets", want: 200, exported: true},
+		{instance: "n1e0a", metric: "statistics.device.receive_raw.errors", want: 10, exported: true},
+		{instance: "n1e0a", metric: "statistics.device.receive_raw.discards", want: 0.5, exported: true},
+		{instance: "n1e0b", metric: "statistics.throughput_raw.total", exported: false},
+		{instance: "n1e0b", metric: "statistics.device.receive_raw.packets", exported: false},
+		{instance: "n1e0c", metric: "statistics.throughput_raw.total", exported: false},
+		{instance: "n1e0c", metric: "mtu", want: 1500, exported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instance+"_"+tt.metric, func(t *testing.T) {
+			instance := mat.GetInstance(tt.instance)
+			if instance == nil {
+				t.Fatalf("instance %s not found", tt.instance)
+			}
+			got, ok := mat.GetMetric(tt.metric).GetValueFloat64(instance)
+			if ok != tt.exported {
+				t.Fatalf("exported got=%v, want=%v", ok, tt.exported)
+			}
+			if ok && got != tt.want {
+				t.Errorf("got=%v, want=%v", got, tt.want)
+			}
+		})
+	}
+
+	if got := mat.GetMetric("statistics.throughput_raw.total").GetProperty(); got != "rate" {
+		t.Errorf("property got=%s, want=rate", got)
+	}
+}
+
+func Test_initCounterPropertiesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		props map[string]string
+	}{
+		{name: "unknown", props: map[string]string{"bytes": "median"}},
+		{name: "missing base", props: map[string]string{"bytes": "average"}},
+		{name: "base not delta", props: map[string]string{"bytes": "average packets", "packets": "rate"}},
+		{name: "unexpected base", props: map[string]string{"bytes": "rate packets"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRest("NetPortStats", "netport_stats.yaml")
+			props := r.Params.GetChildS("counter_properties")
+			props.Children = nil
+			for k, v := range tt.props {
+				props.NewChildS(k, v)
+			}
+			if err := r.initCounterProperties(); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
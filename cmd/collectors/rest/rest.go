@@ -36,9 +36,11 @@ import (
 
 type Rest struct {
 	*collector.AbstractCollector
-	Client    *rest.Client
-	Prop      *prop
-	endpoints []*endPoint
+	Client     *rest.Client
+	Prop       *prop
+	endpoints  []*endPoint
+	properties map[string]*counterProperty // display name => property, see counter_properties
+	prevMat    *matrix.Matrix              // raw data of the previous poll, used by counter_properties
 }
 
 type endPoint struct {
@@ -58,6 +60,7 @@ type prop struct {
 	Fields         []string
 	APIType        string // public, private
 	Filter         []string
+	Infer          *inferSchema // when not nil, counters are generated from the returned records
 }
 
 type Metric struct {
@@ -123,6 +126,11 @@ func (r *Rest) Init(a *collector.AbstractCollector) error {
 	if err = r.InitMatrix(); err != nil {
 		return err
 	}
+
+	if err = r.initCounterProperties(); err != nil {
+		return err
+	}
+
 	r.Logger.Debug().
 		Int("numMetrics", len(r.Prop.Metrics)).
 		Str("timeout", r.Client.Timeout.String()).
@@ -235,9 +243,14 @@ func (r *Rest) initEndPoints() error {
 					prop.Query = line1.GetContentS()
 					prop.APIType = checkQueryType(prop.Query)
 				}
-				if line1.GetNameS() == "counters" {
-					r.ParseRestCounters(line1, &prop)
-				}
+			}
+			prop.Infer = parseInferSchema(line.GetChildS("infer_counters"))
+			counters := line.GetChildS("counters")
+			if counters == nil && prop.Infer != nil {
+				counters = node.NewS("counters")
+			}
+			if counters != nil {
+				r.ParseRestCounters(counters, &prop)
 			}
 			e.prop = &prop
 			r.endpoints = append(r.endpoints, &e)
@@ -319,6 +332,7 @@ func (r *Rest) pollData(startTime time.Time, records []gjson.Result, endpointFun
 		apiD, parseD time.Duration
 	)
 
+	pollTime := startTime
 	apiD = time.Since(startTime)
 	startTime = time.Now()
 
@@ -329,6 +343,8 @@ func (r *Rest) pollData(startTime time.Time, records []gjson.Result, endpointFun
 	count += eCount
 	parseD = time.Since(startTime)
 
+	r.calculateProperties(r.Matrix[r.Object], pollTime)
+
 	numRecords := len(r.Matrix[r.Object].GetInstances())
 
 	_ = r.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
@@ -432,6 +448,10 @@ func (r *Rest) HandleResults(result []gjson.Result, prop *prop, isEndPoint bool)
 	currentInstances := set.New()
 	mat := r.Matrix[r.Object]
 
+	if prop.Infer != nil {
+		r.inferCounters(result, prop)
+	}
+
 	// copy keys of current instances. This is used to remove deleted instances from matrix later
 	for key := range mat.GetInstances() {
 		oldInstances.Add(key)
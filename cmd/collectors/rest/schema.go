@@ -0,0 +1,155 @@
+package rest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/set"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/tidwall/gjson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// inferSchema holds the state needed to generate counters from the columns returned by an endpoint.
+// This is mostly useful for private CLI passthrough objects (api/private/cli/...) where the set of
+// columns is known only after ONTAP answers.
+type inferSchema struct {
+	fields  []string // additional fields to request, when empty ONTAP returns its default columns
+	exclude *set.Set // fields that should never become counters
+	seen    *set.Set // fields already classified (or excluded), they are not inspected again
+}
+
+// parseInferSchema parses the infer_counters section of a template. The section can be a bool
+//
+//	infer_counters: true
+//
+// or a list of fields to request and fields to exclude
+//
+//	infer_counters:
+//	  fields:
+//	    - files
+//	    - files_used
+//	  exclude:
+//	    - nodes
+func parseInferSchema(n *node.Node) *inferSchema {
+	if n == nil {
+		return nil
+	}
+	if v := n.GetContentS(); v != "" {
+		if enabled, err := strconv.ParseBool(v); err != nil || !enabled {
+			return nil
+		}
+	}
+	s := &inferSchema{
+		exclude: set.New(),
+		seen:    set.New(),
+	}
+	if f := n.GetChildS("fields"); f != nil {
+		s.fields = f.GetAllChildContentS()
+	}
+	if e := n.GetChildS("exclude"); e != nil {
+		s.exclude.AddValues(e.GetAllChildContentS())
+	}
+	return s
+}
+
+// inferCounters inspects the records returned by ONTAP and adds a label or metric to prop for each field
+// that is not already defined in the template. Counters pinned in the template always win, which
+// makes it possible to rename fields, mark them as keys or force a field to be a label.
+func (r *Rest) inferCounters(records []gjson.Result, prop *prop) {
+	for _, record := range records {
+		if !record.IsObject() {
+			continue
+		}
+		fields := make(map[string]gjson.Result)
+		flattenFields(record, "", fields)
+		for name, value := range fields {
+			if prop.Infer.seen.Has(name) {
+				continue
+			}
+			kind, metricType := inferKind(value)
+			if kind == "" {
+				// empty or null value, try again with the next record
+				continue
+			}
+			prop.Infer.seen.Add(name)
+			if _, ok := prop.Counters[name]; ok || kind == "skip" || prop.Infer.exclude.Has(name) {
+				continue
+			}
+
+			display := strings.ReplaceAll(name, ".", "_")
+			display = strings.ReplaceAll(display, "-", "_")
+			prop.Counters[name] = display
+			switch kind {
+			case "label":
+				prop.InstanceLabels[name] = display
+			case "float":
+				prop.Metrics[name] = &Metric{Label: display, Name: name, MetricType: metricType, Exportable: true}
+			}
+
+			r.Logger.Debug().
+				Str("kind", kind).
+				Str("type", metricType).
+				Str("name", name).
+				Str("display", display).
+				Msg("Inferred")
+		}
+	}
+}
+
+// flattenFields collects the leaves of a JSON object in dot notation. Arrays are leaves.
+func flattenFields(value gjson.Result, parent string, fields map[string]gjson.Result) {
+	value.ForEach(func(key, val gjson.Result) bool {
+		name := key.String()
+		if parent != "" {
+			name = parent + "." + name
+		}
+		if val.IsObject() {
+			flattenFields(val, name, fields)
+		} else {
+			fields[name] = val
+		}
+		return true
+	})
+}
+
+// inferKind returns the kind of counter (label, float or skip) and the metric type for a value.
+// An empty kind means the type can not be determined from this value.
+func inferKind(value gjson.Result) (string, string) {
+	switch value.Type {
+	case gjson.Number:
+		return "float", ""
+	case gjson.True, gjson.False:
+		return "label", ""
+	case gjson.String:
+		s := value.String()
+		if s == "" {
+			return "", ""
+		}
+		if isDuration(s) {
+			return "float", "duration"
+		}
+		if _, err := time.Parse(time.RFC3339, s); err == nil {
+			return "float", "timestamp"
+		}
+		return "label", ""
+	case gjson.JSON:
+		if !value.IsArray() {
+			return "", ""
+		}
+		elements := value.Array()
+		if len(elements) == 0 {
+			return "", ""
+		}
+		// arrays of objects can not be expressed as a label or a metric
+		if elements[0].IsObject() || elements[0].IsArray() {
+			return "skip", ""
+		}
+		return "label", ""
+	default:
+		return "", ""
+	}
+}
+
+func isDuration(s string) bool {
+	return len(s) > 2 && regexTimeDuration.MatchString(s)
+}
@@ -0,0 +1,92 @@
+package rest
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"testing"
+	"time"
+)
+
+func Test_inferCounters(t *testing.T) {
+	r := newRest("SnapMirrorCli", "snapmirror_cli.yaml")
+
+	if r.Prop.Infer == nil {
+		t.Fatalf("infer_counters should be enabled")
+	}
+	if r.Prop.Fields != nil {
+		t.Errorf("fields got=%v, want none so ONTAP returns its default columns", r.Prop.Fields)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	records := collectors.JSONToGson("testdata/snapmirror-cli.json", true)
+	mm, err := r.pollData(now, records, volumeEndpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mat := mm["SnapMirrorCli"]
+
+	if len(mat.GetInstances()) != 2 {
+		t.Errorf("numInstances got=%d, want=2", len(mat.GetInstances()))
+	}
+
+	wantMetrics := map[string]string{
+		"lag_time":                    "duration",
+		"last_transfer_size":          "",
+		"last_transfer_end_timestamp": "timestamp",
+		"break_failed_count":          "",
+		"throttle.max":                "",
+	}
+	for name, metricType := range wantMetrics {
+		m, ok := r.Prop.Metrics[name]
+		if !ok {
+			t.Errorf("metric %s was not inferred", name)
+			continue
+		}
+		if m.MetricType != metricType {
+			t.Errorf("metric %s type got=%s, want=%s", name, m.MetricType, metricType)
+		}
+	}
+
+	// pinned counters keep their display name
+	if got := r.Prop.Metrics["last_transfer_size"].Label; got != "transfer_size" {
+		t.Errorf("pinned display name got=%s, want=transfer_size", got)
+	}
+
+	wantLabels := map[string]string{
+		"healthy":                 "healthy",
+		"policy":                  "policy",
+		"unhealthy_reason":        "unhealthy_reason",
+		"destination_volume_node": "destination_volume_node",
+		"status":                  "relationship_status",
+	}
+	for name, display := range wantLabels {
+		if got, ok := r.Prop.InstanceLabels[name]; !ok || got != display {
+			t.Errorf("label %s got=%s, want=%s", name, got, display)
+		}
+	}
+
+	for _, name := range []string{"relationship_id", "snapshots"} {
+		if _, ok := r.Prop.Counters[name]; ok {
+			t.Errorf("%s should not be inferred", name)
+		}
+	}
+
+	instance := mat.GetInstance("vs2:vol1_dstvs1:vol1")
+	if instance == nil {
+		t.Fatalf("instance not found")
+	}
+	if got := instance.GetLabel("policy"); got != "MirrorAllSnapshots" {
+		t.Errorf("label policy got=%s, want=MirrorAllSnapshots", got)
+	}
+	if got, _ := mat.GetMetric("lag_time").GetValueFloat64(instance); got != 30942 {
+		t.Errorf("lag_time got=%v, want=30942", got)
+	}
+	if got, _ := mat.GetMetric("last_transfer_end_timestamp").GetValueFloat64(instance); got != 1643619902 {
+		t.Errorf("last_transfer_end_timestamp got=%v, want=1643619902", got)
+	}
+
+	// unhealthy_reason is empty in the first record, but is inferred from the second one
+	other := mat.GetInstance("vs2:vol2_dstvs1:vol2")
+	if got := other.GetLabel("unhealthy_reason"); got != "Transfer failed." {
+		t.Errorf("label unhealthy_reason got=%s, want=Transfer failed.", got)
+	}
+}
@@ -42,9 +42,14 @@ func (r *Rest) InitCache() error {
 		return errs.New(errs.ErrMissingParam, "query")
 	}
 
+	r.Prop.Infer = parseInferSchema(r.Params.GetChildS("infer_counters"))
+
 	// create metric cache
 	if counters = r.Params.GetChildS("counters"); counters == nil {
-		return errs.New(errs.ErrMissingParam, "counters")
+		if r.Prop.Infer == nil {
+			return errs.New(errs.ErrMissingParam, "counters")
+		}
+		counters = node.NewS("counters")
 	}
 
 	// default value for ONTAP is 15 sec
@@ -70,11 +75,11 @@ func (r *Rest) InitCache() error {
 	return nil
 }
 
-func HandleDuration(value string) float64 {
-	// Example: duration: PT8H35M42S
-	timeDurationRegex := `^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:.\d+)?)S)?$`
+// Example: duration: PT8H35M42S
+var regexTimeDuration = regexp.MustCompile(
+	`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:.\d+)?)S)?$`)
 
-	regexTimeDuration := regexp.MustCompile(timeDurationRegex)
+func HandleDuration(value string) float64 {
 	if match := regexTimeDuration.MatchString(value); match {
 		// example: PT8H35M42S   ==>  30942
 		matches := regexTimeDuration.FindStringSubmatch(value)
@@ -217,4 +222,17 @@ func (r *Rest) ParseRestCounters(counter *node.Node, prop *prop) {
 		}
 	}
 
+	if prop.Infer != nil {
+		if len(prop.Infer.fields) == 0 {
+			// without an explicit list of fields, ONTAP returns its default columns
+			if prop.APIType == "private" {
+				prop.Fields = nil
+			}
+		} else {
+			prop.Fields = append(prop.Fields, prop.Infer.fields...)
+		}
+		if len(prop.InstanceKeys) == 0 {
+			r.Logger.Warn().Str("query", prop.Query).Msg("infer_counters without instance keys, all records share one instance")
+		}
+	}
 }
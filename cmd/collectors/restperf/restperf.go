@@ -2,6 +2,7 @@ package restperf
 
 import (
 	"fmt"
+	"github.com/netapp/harvest/v2/cmd/collectors"
 	rest2 "github.com/netapp/harvest/v2/cmd/collectors/rest"
 	"github.com/netapp/harvest/v2/cmd/collectors/restperf/plugins/disk"
 	"github.com/netapp/harvest/v2/cmd/collectors/restperf/plugins/fabricpool"
@@ -73,6 +74,9 @@ type perfProp struct {
 	counterInfo   map[string]*counter
 	latencyIoReqd int
 	qosLabels     map[string]string
+	counterReset  collectors.CounterReset
+	focus         *collectors.Focus
+	task          string // name of the task being polled, data or focus
 }
 
 type metricResponse struct {
@@ -130,6 +134,10 @@ func (r *RestPerf) Init(a *collector.AbstractCollector) error {
 		return err
 	}
 
+	if r.perfProp.focus, err = collectors.ParseFocus(r.Params, r.Schedule, r.Logger); err != nil {
+		return err
+	}
+
 	r.Logger.Debug().
 		Int("numMetrics", len(r.Prop.Metrics)).
 		Str("timeout", r.Client.Timeout.String()).
@@ -163,6 +171,7 @@ func (r *RestPerf) InitMatrix() error {
 	// init perf properties
 	r.perfProp.latencyIoReqd = r.loadParamInt("latency_io_reqd", latencyIoReqd)
 	r.perfProp.isCacheEmpty = true
+	r.perfProp.task = "data"
 	// overwrite from abstract collector
 	mat.Object = r.Prop.Object
 	// Add system (cluster) name
@@ -173,8 +182,14 @@ func (r *RestPerf) InitMatrix() error {
 		}
 	}
 
-	// Add metadata metric for skips
+	var err error
+	if r.perfProp.counterReset, err = collectors.ParseCounterReset(r.Params); err != nil {
+		return err
+	}
+
+	// Add metadata metric for skips and counter resets
 	_, _ = r.Metadata.NewMetricUint64("skips")
+	_, _ = r.Metadata.NewMetricUint64("resets")
 	return nil
 }
 
@@ -767,6 +782,10 @@ func (r *RestPerf) pollData(startTime time.Time, perfRecords []rest.PerfRecord)
 
 			instance = curMat.GetInstance(instanceKey)
 			if instance == nil {
+				if r.perfProp.task == collectors.FocusTask {
+					// the focus cache only has the selected instances
+					return true
+				}
 				if isWorkloadObject(r.Prop.Query) || isWorkloadDetailObject(r.Prop.Query) {
 					r.Logger.Debug().
 						Str("instanceKey", instanceKey).
@@ -1009,10 +1028,10 @@ func (r *RestPerf) pollData(startTime time.Time, perfRecords []rest.PerfRecord)
 	}
 
 	parseD = time.Since(startTime)
-	_ = r.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
-	_ = r.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
-	_ = r.Metadata.LazySetValueUint64("metrics", "data", count)
-	_ = r.Metadata.LazySetValueUint64("instances", "data", uint64(len(curMat.GetInstances())))
+	_ = r.Metadata.LazySetValueInt64("api_time", r.perfProp.task, apiD.Microseconds())
+	_ = r.Metadata.LazySetValueInt64("parse_time", r.perfProp.task, parseD.Microseconds())
+	_ = r.Metadata.LazySetValueUint64("metrics", r.perfProp.task, count)
+	_ = r.Metadata.LazySetValueUint64("instances", r.perfProp.task, uint64(len(curMat.GetInstances())))
 	r.AddCollectCount(count)
 
 	// skip calculating from delta if no data from previous poll
@@ -1030,6 +1049,9 @@ func (r *RestPerf) pollData(startTime time.Time, perfRecords []rest.PerfRecord)
 	// cache raw data for next poll
 	cachedData := curMat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
 
+	// detect restarted instances from raw counters, before deltas are calculated
+	resets := r.perfProp.counterReset.NewResets(curMat, prevMat)
+
 	orderedNonDenominatorMetrics := make([]*matrix.Metric, 0, len(curMat.GetMetrics()))
 	orderedNonDenominatorKeys := make([]string, 0, len(orderedNonDenominatorMetrics))
 
@@ -1089,7 +1111,7 @@ func (r *RestPerf) pollData(startTime time.Time, perfRecords []rest.PerfRecord)
 		}
 
 		// all other properties - first calculate delta
-		if skips, err = curMat.Delta(key, prevMat, r.Logger); err != nil {
+		if skips, err = curMat.DeltaWithResets(key, prevMat, resets, r.Logger); err != nil {
 			r.Logger.Error().Err(err).Str("key", key).Msg("Calculate delta")
 			continue
 		}
@@ -1187,18 +1209,54 @@ func (r *RestPerf) pollData(startTime time.Time, perfRecords []rest.PerfRecord)
 	}
 
 	calcD := time.Since(calcStart)
-	_ = r.Metadata.LazySetValueUint64("instances", "data", uint64(len(curMat.GetInstances())))
-	_ = r.Metadata.LazySetValueInt64("calc_time", "data", calcD.Microseconds())
-	_ = r.Metadata.LazySetValueUint64("skips", "data", uint64(totalSkips))
+	_ = r.Metadata.LazySetValueUint64("instances", r.perfProp.task, uint64(len(curMat.GetInstances())))
+	_ = r.Metadata.LazySetValueInt64("calc_time", r.perfProp.task, calcD.Microseconds())
+	_ = r.Metadata.LazySetValueUint64("skips", r.perfProp.task, uint64(totalSkips))
+	_ = r.Metadata.LazySetValueUint64("resets", r.perfProp.task, uint64(resets.Count()))
+	if resets.Count() > 0 {
+		r.Logger.Info().
+			Int("resets", resets.Count()).
+			Int("restarted", resets.Restarted()).
+			Str("policy", string(resets.Policy)).
+			Msg("Counter resets detected")
+	}
 
 	// store cache for next poll
 	r.Matrix[r.Object] = cachedData
 
+	if r.perfProp.task == "data" {
+		r.perfProp.focus.Rank(curMat)
+	}
+
 	newDataMap := make(map[string]*matrix.Matrix)
 	newDataMap[r.Object] = curMat
 	return newDataMap, nil
 }
 
+// PollFocus polls the instances selected by the focus parameters. ONTAP returns all rows of the counter table, rows
+// of instances that are not selected are ignored. The raw counters of the focus instances are cached separately from
+// the data cache, and the same calculations as PollData are applied.
+func (r *RestPerf) PollFocus() (map[string]*matrix.Matrix, error) {
+	if !r.perfProp.focus.Refresh(r.Matrix[r.Object], time.Now()) {
+		return nil, nil
+	}
+	return r.pollFocus(r.PollData)
+}
+
+// pollFocus swaps the data cache with the focus cache for the duration of poll
+func (r *RestPerf) pollFocus(poll func() (map[string]*matrix.Matrix, error)) (map[string]*matrix.Matrix, error) {
+	data, isCacheEmpty := r.Matrix[r.Object], r.perfProp.isCacheEmpty
+	r.Matrix[r.Object], r.perfProp.isCacheEmpty = r.perfProp.focus.Cache, r.perfProp.focus.IsEmpty
+	r.perfProp.task = collectors.FocusTask
+	defer func() {
+		r.perfProp.focus.Cache, r.perfProp.focus.IsEmpty = r.Matrix[r.Object], r.perfProp.isCacheEmpty
+		r.Matrix[r.Object], r.perfProp.isCacheEmpty = data, isCacheEmpty
+		r.perfProp.task = "data"
+	}()
+
+	return poll()
+}
+
 // Poll counter "ops" of the related/parent object, required for objects
 // workload_detail and workload_detail_volume. This counter is already
 // collected by the other collectors, so this poll is redundant
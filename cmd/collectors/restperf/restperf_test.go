@@ -338,3 +338,94 @@ func TestQosVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestRestPerf_pollFocus(t *testing.T) {
+	conf.TestLoadHarvestConfig("testdata/config.yml")
+	yml := `
+schedule:
+  - counter: 9999h
+  - instance: 9999h
+  - data: 9999h
+  - focus: 10s
+focus:
+  label: volume
+  instances:
+    - harvest
+objects:
+  Volume: volume.yaml
+`
+	root, err := tree.LoadYaml([]byte(yml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := options.New(options.WithConfPath("testdata/conf"))
+	opts.Poller = pollerName
+	opts.HomePath = "testdata"
+	opts.IsTest = true
+	r := &RestPerf{}
+	if err := r.Init(collector.New("RestPerf", "Volume", opts, root, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	counters := jsonToPerfRecords("testdata/volume-counters.json")
+	if _, err := r.pollCounter(counters[0].Records.Array()); err != nil {
+		t.Fatal(err)
+	}
+	pollInstance := jsonToPerfRecords("testdata/volume-poll-instance.json")
+	if _, err := r.pollInstance(pollInstance[0].Records.Array()); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	poll := func(path string, ts time.Time) func() (map[string]*matrix.Matrix, error) {
+		return func() (map[string]*matrix.Matrix, error) {
+			pollData := jsonToPerfRecords(path)
+			pollData[0].Timestamp = ts.UnixNano()
+			return r.pollData(ts, pollData)
+		}
+	}
+	if _, err := poll("testdata/volume-poll-1.json", now)(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.perfProp.focus.Refresh(r.Matrix[r.Object], now) {
+		t.Fatalf("focus should have selected an instance")
+	}
+	if _, err := r.pollFocus(poll("testdata/volume-poll-1.json", now)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.pollFocus(poll("testdata/volume-poll-2.json", now.Add(10*time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := got["Volume"]
+	if len(m.GetInstances()) != 1 {
+		t.Fatalf("numInstances got=%d, want=1", len(m.GetInstances()))
+	}
+	if m.GetInstance("cde3cfe9-de9a-11ed-a376-00a098d39e12") == nil {
+		t.Errorf("focus instance harvest missing")
+	}
+	if got := m.GetGlobalLabels()[collectors.FocusLabel]; got != "10s" {
+		t.Errorf("label %s got=%s, want=10s", collectors.FocusLabel, got)
+	}
+	if m.Identifier == r.Matrix[r.Object].Identifier {
+		t.Errorf("focus identifier should differ from the data identifier")
+	}
+	if _, ok := r.Matrix[r.Object].GetGlobalLabels()[collectors.FocusLabel]; ok {
+		t.Errorf("data matrix should not have the %s label", collectors.FocusLabel)
+	}
+	if len(r.Matrix[r.Object].GetInstances()) != 2 {
+		t.Errorf("data cache numInstances got=%d, want=2", len(r.Matrix[r.Object].GetInstances()))
+	}
+
+	// bytes_read is calculated from the two focus polls
+	if v, ok := m.GetMetric("bytes_read").GetValueFloat64(m.GetInstance("cde3cfe9-de9a-11ed-a376-00a098d39e12")); !ok || v == 0 {
+		t.Errorf("bytes_read got=%v recorded=%v, want rate", v, ok)
+	}
+
+	metadata := r.Metadata
+	if n, _ := metadata.GetMetric("instances").GetValueInt(metadata.GetInstance("focus")); n != 1 {
+		t.Errorf("focus metadata instances got=%d, want=1", n)
+	}
+}
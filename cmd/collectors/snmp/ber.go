@@ -0,0 +1,282 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags of the types of SNMP (RFC 3416), and of its PDUs
+const (
+	tagInteger        = 0x02
+	tagOctetString    = 0x04
+	tagNull           = 0x05
+	tagOID            = 0x06
+	tagSequence       = 0x30
+	tagIPAddress      = 0x40
+	tagCounter32      = 0x41
+	tagGauge32        = 0x42
+	tagTimeTicks      = 0x43
+	tagOpaque         = 0x44
+	tagCounter64      = 0x46
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduTrapV1   = 0xa4
+	pduGetBulk  = 0xa5
+	pduInform   = 0xa6
+	pduTrapV2   = 0xa7
+	pduReport   = 0xa8
+)
+
+var errTruncated = errors.New("truncated BER encoding")
+
+// oid is an object identifier, e.g. 1.3.6.1.2.1.1.3.0
+type oid []uint32
+
+func parseOID(s string) (oid, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), ".")
+	if s == "" {
+		return nil, fmt.Errorf("empty OID")
+	}
+	parts := strings.Split(s, ".")
+	o := make(oid, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %s", s)
+		}
+		o = append(o, uint32(n))
+	}
+	if len(o) < 2 {
+		return nil, fmt.Errorf("OID %s must have at least two arcs", s)
+	}
+	return o, nil
+}
+
+func (o oid) String() string {
+	var b strings.Builder
+	for i, n := range o {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(strconv.FormatUint(uint64(n), 10))
+	}
+	return b.String()
+}
+
+// hasPrefix returns true when o is prefix or a descendant of prefix
+func (o oid) hasPrefix(prefix oid) bool {
+	if len(o) < len(prefix) {
+		return false
+	}
+	for i, n := range prefix {
+		if o[i] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// compare returns -1, 0 or 1 when o is before, equal to, or after other in the lexicographic order of the MIB
+func (o oid) compare(other oid) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		switch {
+		case o[i] < other[i]:
+			return -1
+		case o[i] > other[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(other):
+		return -1
+	case len(o) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// tlv encodes the tag, the length and the content of a BER element
+func tlv(tag byte, content []byte) []byte {
+	b := make([]byte, 0, len(content)+6)
+	b = append(b, tag)
+	b = appendLength(b, len(content))
+	return append(b, content...)
+}
+
+func appendLength(b []byte, n int) []byte {
+	if n < 0x80 {
+		return append(b, byte(n))
+	}
+	var l []byte
+	for ; n > 0; n >>= 8 {
+		l = append([]byte{byte(n)}, l...)
+	}
+	b = append(b, 0x80|byte(len(l)))
+	return append(b, l...)
+}
+
+func sequence(tag byte, elements ...[]byte) []byte {
+	var content []byte
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return tlv(tag, content)
+}
+
+// encodeInt encodes a signed integer in the minimal two's complement form
+func encodeInt(tag byte, n int64) []byte {
+	b := []byte{byte(n)}
+	for n >= 0x80 || n < -0x80 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return tlv(tag, b)
+}
+
+// encodeUint encodes an unsigned integer, e.g. a Counter64, with a leading zero when its high bit is set
+func encodeUint(tag byte, n uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return tlv(tag, b)
+}
+
+func encodeOID(o oid) []byte {
+	if len(o) < 2 {
+		return tlv(tagOID, []byte{0})
+	}
+	b := appendBase128(nil, o[0]*40+o[1])
+	for _, n := range o[2:] {
+		b = appendBase128(b, n)
+	}
+	return tlv(tagOID, b)
+}
+
+func appendBase128(b []byte, n uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+// readTLV returns the tag and content of the element at the start of b, and the bytes after the element.
+// The content is a slice of b.
+func readTLV(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errTruncated
+	}
+	tag := b[0]
+	n := int(b[1])
+	b = b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, fmt.Errorf("invalid BER length")
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || len(b) < n {
+		return 0, nil, nil, errTruncated
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// expect reads an element with the given tag
+func expect(b []byte, tag byte) ([]byte, []byte, error) {
+	t, content, rest, err := readTLV(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if t != tag {
+		return nil, nil, fmt.Errorf("unexpected tag 0x%02x, want 0x%02x", t, tag)
+	}
+	return content, rest, nil
+}
+
+func decodeInt(content []byte) (int64, error) {
+	if len(content) == 0 || len(content) > 8 {
+		return 0, fmt.Errorf("invalid integer of %d bytes", len(content))
+	}
+	n := int64(int8(content[0]))
+	for _, c := range content[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeUint(content []byte) (uint64, error) {
+	if len(content) > 0 && content[0] == 0 {
+		content = content[1:]
+	}
+	if len(content) > 8 {
+		return 0, fmt.Errorf("invalid unsigned integer of %d bytes", len(content))
+	}
+	var n uint64
+	for _, c := range content {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// readInt reads an INTEGER element
+func readInt(b []byte) (int64, []byte, error) {
+	content, rest, err := expect(b, tagInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := decodeInt(content)
+	return n, rest, err
+}
+
+func decodeOID(content []byte) (oid, error) {
+	if len(content) == 0 {
+		return nil, fmt.Errorf("empty OID")
+	}
+	var (
+		o oid
+		n uint32
+	)
+	for i, c := range content {
+		if n > 1<<25 {
+			return nil, fmt.Errorf("OID arc overflows")
+		}
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(content)-1 {
+				return nil, errTruncated
+			}
+			continue
+		}
+		if o == nil {
+			first := min(n/40, 2)
+			o = append(o, first, n-first*40)
+		} else {
+			o = append(o, n)
+		}
+		n = 0
+	}
+	return o, nil
+}
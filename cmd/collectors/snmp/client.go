@@ -0,0 +1,302 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPort           = "161"
+	defaultTimeout        = 5 * time.Second
+	defaultRetries        = 2
+	defaultMaxRepetitions = 10
+	defaultMaxOIDs        = 20
+)
+
+// errorStatus are the error statuses of a response (RFC 3416 3)
+var errorStatus = []string{"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr", "noAccess",
+	"wrongType", "wrongLength", "wrongEncoding", "wrongValue", "noCreation", "inconsistentValue",
+	"resourceUnavailable", "commitFailed", "undoFailed", "authorizationError", "notWritable", "inconsistentName"}
+
+// client is the client of a SNMP agent, with version 2c or 3
+type client struct {
+	addr           string
+	version        int
+	community      string
+	user           *user // SNMPv3
+	contextName    string
+	timeout        time.Duration
+	retries        int
+	maxRepetitions int // of GetBulk requests, walks use GetNext when 0
+	maxOIDs        int // of a Get request
+
+	mu        sync.Mutex
+	conn      net.Conn
+	requestID int32
+	engine    *engine // the authoritative engine of the agent, discovered by the first SNMPv3 request
+}
+
+// engine is the SNMP engine of the agent, and its time when it was discovered
+type engine struct {
+	id         []byte
+	boots      int32
+	time       int32
+	discovered time.Time
+}
+
+func (e *engine) now() int32 {
+	return e.time + int32(time.Since(e.discovered).Seconds())
+}
+
+func (c *client) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	addr := c.addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultPort)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return errs.New(errs.ErrConnection, err.Error())
+	}
+	c.conn = conn
+	c.requestID = rand.Int31() //nolint:gosec
+	return nil
+}
+
+func (c *client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// get returns the varbinds of the OIDs, with a request of at most maxOIDs OIDs
+func (c *client) get(oids []oid) ([]varbind, error) {
+	var varbinds []varbind
+	for start := 0; start < len(oids); start += c.maxOIDs {
+		p := &pdu{kind: pduGet}
+		for _, o := range oids[start:min(start+c.maxOIDs, len(oids))] {
+			p.varbinds = append(p.varbinds, varbind{oid: o, tag: tagNull})
+		}
+		response, err := c.request(p)
+		if err != nil {
+			return nil, err
+		}
+		varbinds = append(varbinds, response.varbinds...)
+	}
+	return varbinds, nil
+}
+
+// walk calls handle for each varbind of the subtree of root, with GetBulk requests, or GetNext requests when
+// maxRepetitions is 0
+func (c *client) walk(root oid, handle func(varbind)) error {
+	last := root
+	for {
+		p := &pdu{kind: pduGetNext, varbinds: []varbind{{oid: last, tag: tagNull}}}
+		if c.maxRepetitions > 0 {
+			p.kind = pduGetBulk
+			p.errorIndex = c.maxRepetitions
+		}
+		response, err := c.request(p)
+		if err != nil {
+			return err
+		}
+		if len(response.varbinds) == 0 {
+			return nil
+		}
+		for _, v := range response.varbinds {
+			if v.tag == tagEndOfMibView || !v.oid.hasPrefix(root) || len(v.oid) == len(root) {
+				return nil
+			}
+			if v.oid.compare(last) <= 0 {
+				return errs.New(errs.ErrAPIResponse, "OID not increasing: "+v.oid.String())
+			}
+			handle(v)
+			last = v.oid
+		}
+	}
+}
+
+// request sends the PDU and returns the response. A request that times out is sent again, up to retries times.
+func (c *client) request(p *pdu) (*pdu, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+
+	if c.version == version3 && c.engine == nil {
+		if err := c.discover(); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := c.exchange(p)
+	var report *reportError
+	if errors.As(err, &report) && report.notInTimeWindow {
+		// the agent restarted since the engine was discovered, retry with its new boots and time
+		response, err = c.exchange(p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response.errorStatus != 0 {
+		status := fmt.Sprintf("error status %d", response.errorStatus)
+		if response.errorStatus < len(errorStatus) {
+			status = errorStatus[response.errorStatus]
+		}
+		return nil, errs.New(errs.ErrAPIRequestRejected, fmt.Sprintf("%s at varbind %d", status, response.errorIndex))
+	}
+	return response, nil
+}
+
+// reportError is a report of the agent instead of a response, e.g. because the user is unknown
+type reportError struct {
+	oid             oid
+	notInTimeWindow bool
+}
+
+func (r *reportError) Error() string {
+	return "report " + r.oid.String()
+}
+
+// discover discovers the engine of the agent with an unauthenticated request (RFC 3414 4)
+func (c *client) discover() error {
+	c.requestID++
+	m := &message{
+		version: version3,
+		msgID:   c.requestID,
+		flags:   flagReportable,
+		pdu:     &pdu{kind: pduGet, requestID: c.requestID},
+	}
+	b, _ := m.encode()
+	response, _, err := c.roundTrip(b, m.msgID)
+	if err != nil {
+		return err
+	}
+	if len(response.security.engineID) == 0 {
+		return errs.New(errs.ErrAPIResponse, "no engine ID in the discovery response")
+	}
+	c.engine = &engine{
+		id:         response.security.engineID,
+		boots:      response.security.boots,
+		time:       response.security.time,
+		discovered: time.Now(),
+	}
+	return nil
+}
+
+// exchange sends a PDU and returns the PDU of the response
+func (c *client) exchange(p *pdu) (*pdu, error) {
+	c.requestID++
+	p.requestID = c.requestID
+
+	var (
+		b   []byte
+		err error
+	)
+	m := &message{version: c.version, community: c.community, msgID: c.requestID, pdu: p}
+	if c.version == version3 {
+		m.flags = flagReportable
+		m.security = usmParams{engineID: c.engine.id, boots: c.engine.boots, time: c.engine.now()}
+		m.contextEngineID = c.engine.id
+		m.contextName = c.contextName
+		if b, err = c.user.seal(m); err != nil {
+			return nil, err
+		}
+	} else {
+		b, _ = m.encode()
+	}
+
+	response, raw, err := c.roundTrip(b, m.msgID)
+	if err != nil {
+		return nil, err
+	}
+	if c.version == version3 {
+		if response.pdu != nil && response.pdu.kind == pduReport && response.flags&flagAuth == 0 {
+			return nil, c.report(response)
+		}
+		if err = c.user.open(raw, response); err != nil {
+			return nil, errs.New(errs.ErrAuthFailed, err.Error())
+		}
+		if response.pdu.kind == pduReport {
+			return nil, c.report(response)
+		}
+		if c.user.priv != "" && response.flags&flagPriv == 0 {
+			return nil, errs.New(errs.ErrAuthFailed, "response is not encrypted")
+		}
+	}
+	if response.pdu.kind != pduResponse {
+		return nil, errs.New(errs.ErrAPIResponse, fmt.Sprintf("unexpected PDU 0x%02x", response.pdu.kind))
+	}
+	return response.pdu, nil
+}
+
+// report returns the error of a report, and updates the time of the engine when the request was out of its time window
+func (c *client) report(m *message) error {
+	r := &reportError{}
+	if len(m.pdu.varbinds) > 0 {
+		r.oid = m.pdu.varbinds[0].oid
+	}
+	if r.oid.compare(oidNotInTimeWindow) == 0 {
+		r.notInTimeWindow = true
+		c.engine.boots = m.security.boots
+		c.engine.time = m.security.time
+		c.engine.discovered = time.Now()
+		return r
+	}
+	if r.oid.hasPrefix(oidUsmStats) {
+		// unsupported security level, unknown user or engine, wrong digest, or decryption error
+		return errs.New(errs.ErrAuthFailed, r.Error())
+	}
+	return errs.New(errs.ErrAPIResponse, r.Error())
+}
+
+// roundTrip sends a message and waits for the response with the same message ID, or request ID for SNMPv2c
+func (c *client) roundTrip(b []byte, id int32) (*message, []byte, error) {
+	buf := make([]byte, maxMessageSize)
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if _, err := c.conn.Write(b); err != nil {
+			return nil, nil, errs.New(errs.ErrConnection, err.Error())
+		}
+		deadline := time.Now().Add(c.timeout)
+		for {
+			_ = c.conn.SetReadDeadline(deadline)
+			n, err := c.conn.Read(buf)
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					lastErr = errs.New(errs.ErrConnection, "timeout waiting for "+c.addr)
+					break
+				}
+				return nil, nil, errs.New(errs.ErrConnection, err.Error())
+			}
+			raw := make([]byte, n)
+			copy(raw, buf[:n])
+			m, err := decodeMessage(raw)
+			if err != nil {
+				lastErr = errs.New(errs.ErrAPIResponse, err.Error())
+				continue
+			}
+			// responses of previous requests that timed out are ignored
+			if m.version == version3 {
+				if m.msgID == id {
+					return m, raw, nil
+				}
+			} else if m.pdu.requestID == id {
+				return m, raw, nil
+			}
+		}
+	}
+	return nil, nil, lastErr
+}
@@ -0,0 +1,473 @@
+package snmp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	version1  = 0
+	version2c = 1
+	version3  = 3
+
+	// flags of a SNMPv3 message
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+
+	securityModelUSM = 3
+	maxMessageSize   = 65507
+)
+
+var (
+	oidSysUpTime   = oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	oidSnmpTrapOID = oid{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+	oidSnmpTraps   = oid{1, 3, 6, 1, 6, 3, 1, 1, 5}
+	oidUsmStats    = oid{1, 3, 6, 1, 6, 3, 15, 1, 1}
+	// usmStatsNotInTimeWindows, the engine time of the request is too old
+	oidNotInTimeWindow = oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 2, 0}
+)
+
+// varbind is a variable binding, an OID and its value
+type varbind struct {
+	oid      oid
+	tag      byte
+	integer  int64  // INTEGER
+	unsigned uint64 // Counter32, Gauge32, TimeTicks, Counter64
+	bytes    []byte // OCTET STRING, IpAddress, Opaque
+	objectID oid    // OBJECT IDENTIFIER
+}
+
+// exists returns false for the exceptions of SNMPv2: noSuchObject, noSuchInstance and endOfMibView
+func (v varbind) exists() bool {
+	return v.tag != tagNoSuchObject && v.tag != tagNoSuchInstance && v.tag != tagEndOfMibView && v.tag != tagNull
+}
+
+// float returns the value of a number, or of a string that is a number like the load averages of UCD-SNMP-MIB
+func (v varbind) float() (float64, bool) {
+	switch v.tag {
+	case tagInteger:
+		return float64(v.integer), true
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return float64(v.unsigned), true
+	case tagOctetString:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(v.bytes)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// String returns the value of a label: strings that are not printable, like MAC addresses, are hex encoded
+func (v varbind) String() string {
+	switch v.tag {
+	case tagInteger:
+		return strconv.FormatInt(v.integer, 10)
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		return strconv.FormatUint(v.unsigned, 10)
+	case tagOctetString:
+		if printable(v.bytes) {
+			return strings.TrimRight(string(v.bytes), "\x00")
+		}
+		return hexString(v.bytes)
+	case tagIPAddress:
+		if len(v.bytes) == 4 {
+			return net.IP(v.bytes).String()
+		}
+		return hexString(v.bytes)
+	case tagOID:
+		return v.objectID.String()
+	case tagOpaque:
+		return hexString(v.bytes)
+	}
+	return ""
+}
+
+func printable(b []byte) bool {
+	s := strings.TrimRight(string(b), "\x00")
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+func hexString(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = hex.EncodeToString([]byte{c})
+	}
+	return strings.Join(parts, ":")
+}
+
+func (v varbind) encode() []byte {
+	var value []byte
+	switch v.tag {
+	case tagInteger:
+		value = encodeInt(tagInteger, v.integer)
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		value = encodeUint(v.tag, v.unsigned)
+	case tagOctetString, tagIPAddress, tagOpaque:
+		value = tlv(v.tag, v.bytes)
+	case tagOID:
+		value = encodeOID(v.objectID)
+	default:
+		// NULL in requests, or an exception
+		value = tlv(v.tag, nil)
+	}
+	return sequence(tagSequence, encodeOID(v.oid), value)
+}
+
+func decodeVarbind(b []byte) (varbind, error) {
+	var v varbind
+	content, rest, err := expect(b, tagOID)
+	if err != nil {
+		return v, err
+	}
+	if v.oid, err = decodeOID(content); err != nil {
+		return v, err
+	}
+	tag, content, _, err := readTLV(rest)
+	if err != nil {
+		return v, err
+	}
+	v.tag = tag
+	switch tag {
+	case tagInteger:
+		v.integer, err = decodeInt(content)
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		v.unsigned, err = decodeUint(content)
+	case tagOctetString, tagIPAddress, tagOpaque:
+		v.bytes = content
+	case tagOID:
+		v.objectID, err = decodeOID(content)
+	case tagNull, tagNoSuchObject, tagNoSuchInstance, tagEndOfMibView:
+	default:
+		err = fmt.Errorf("unsupported type 0x%02x of %s", tag, v.oid)
+	}
+	return v, err
+}
+
+// pdu is a protocol data unit of SNMPv2 (RFC 3416), or the trap of SNMPv1 (RFC 1157)
+type pdu struct {
+	kind        byte
+	requestID   int32
+	errorStatus int // non-repeaters of a GetBulk
+	errorIndex  int // max-repetitions of a GetBulk
+	varbinds    []varbind
+
+	// SNMPv1 trap
+	enterprise   oid
+	agentAddr    net.IP
+	genericTrap  int
+	specificTrap int
+}
+
+func (p *pdu) encode() []byte {
+	var varbinds []byte
+	for _, v := range p.varbinds {
+		varbinds = append(varbinds, v.encode()...)
+	}
+	return sequence(p.kind,
+		encodeInt(tagInteger, int64(p.requestID)),
+		encodeInt(tagInteger, int64(p.errorStatus)),
+		encodeInt(tagInteger, int64(p.errorIndex)),
+		tlv(tagSequence, varbinds))
+}
+
+func decodePDU(b []byte) (*pdu, error) {
+	kind, content, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	p := &pdu{kind: kind}
+	switch kind {
+	case pduGet, pduGetNext, pduResponse, pduGetBulk, pduInform, pduTrapV2, pduReport:
+	case pduTrapV1:
+		return p, p.decodeTrapV1(content)
+	default:
+		return nil, fmt.Errorf("unsupported PDU 0x%02x", kind)
+	}
+
+	var n int64
+	if n, content, err = readInt(content); err != nil {
+		return nil, err
+	}
+	p.requestID = int32(n)
+	if n, content, err = readInt(content); err != nil {
+		return nil, err
+	}
+	p.errorStatus = int(n)
+	if n, content, err = readInt(content); err != nil {
+		return nil, err
+	}
+	p.errorIndex = int(n)
+	if p.varbinds, err = decodeVarbinds(content); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func decodeVarbinds(b []byte) ([]varbind, error) {
+	content, _, err := expect(b, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	var varbinds []varbind
+	for len(content) > 0 {
+		var vb []byte
+		if vb, content, err = expect(content, tagSequence); err != nil {
+			return nil, err
+		}
+		v, err := decodeVarbind(vb)
+		if err != nil {
+			return nil, err
+		}
+		varbinds = append(varbinds, v)
+	}
+	return varbinds, nil
+}
+
+// decodeTrapV1 decodes the trap of SNMPv1, and converts it to the varbinds of a SNMPv2 trap (RFC 3584 3.1)
+func (p *pdu) decodeTrapV1(b []byte) error {
+	content, rest, err := expect(b, tagOID)
+	if err != nil {
+		return err
+	}
+	if p.enterprise, err = decodeOID(content); err != nil {
+		return err
+	}
+	if content, rest, err = expect(rest, tagIPAddress); err != nil {
+		return err
+	}
+	p.agentAddr = net.IP(content)
+	var n int64
+	if n, rest, err = readInt(rest); err != nil {
+		return err
+	}
+	p.genericTrap = int(n)
+	if n, rest, err = readInt(rest); err != nil {
+		return err
+	}
+	p.specificTrap = int(n)
+	if content, rest, err = expect(rest, tagTimeTicks); err != nil {
+		return err
+	}
+	uptime, err := decodeUint(content)
+	if err != nil {
+		return err
+	}
+	varbinds, err := decodeVarbinds(rest)
+	if err != nil {
+		return err
+	}
+
+	trapOID := append(append(oid{}, p.enterprise...), 0, uint32(p.specificTrap))
+	if p.genericTrap != 6 {
+		trapOID = append(append(oid{}, oidSnmpTraps...), uint32(p.genericTrap+1))
+	}
+	p.kind = pduTrapV2
+	p.varbinds = append([]varbind{
+		{oid: oidSysUpTime, tag: tagTimeTicks, unsigned: uptime},
+		{oid: oidSnmpTrapOID, tag: tagOID, objectID: trapOID},
+	}, varbinds...)
+	return nil
+}
+
+// message is a SNMP message of version 1, 2c or 3
+type message struct {
+	version   int
+	community string
+
+	// SNMPv3
+	msgID           int32
+	flags           byte
+	security        usmParams
+	contextEngineID []byte
+	contextName     string
+	encrypted       []byte // scoped PDU, when the message is encrypted
+
+	pdu *pdu
+}
+
+// usmParams are the security parameters of the user-based security model (RFC 3414)
+type usmParams struct {
+	engineID   []byte
+	boots      int32
+	time       int32
+	user       string
+	authParams []byte
+	privParams []byte
+	authOffset int // of the authentication parameters in the decoded message
+}
+
+// scopedPDU encodes the scoped PDU of a SNMPv3 message
+func (m *message) scopedPDU() []byte {
+	return sequence(tagSequence, tlv(tagOctetString, m.contextEngineID), tlv(tagOctetString, []byte(m.contextName)),
+		m.pdu.encode())
+}
+
+// encode encodes the message, and returns the offset of the authentication parameters of a SNMPv3 message, so that
+// they can be replaced by the digest of the message
+func (m *message) encode() ([]byte, int) {
+	version := encodeInt(tagInteger, int64(m.version))
+	if m.version != version3 {
+		return sequence(tagSequence, version, tlv(tagOctetString, []byte(m.community)), m.pdu.encode()), 0
+	}
+
+	header := sequence(tagSequence,
+		encodeInt(tagInteger, int64(m.msgID)),
+		encodeInt(tagInteger, maxMessageSize),
+		tlv(tagOctetString, []byte{m.flags}),
+		encodeInt(tagInteger, securityModelUSM))
+
+	s := m.security
+	usmPrefix := append(append(append(append([]byte{},
+		tlv(tagOctetString, s.engineID)...),
+		encodeInt(tagInteger, int64(s.boots))...),
+		encodeInt(tagInteger, int64(s.time))...),
+		tlv(tagOctetString, []byte(s.user))...)
+	auth := tlv(tagOctetString, s.authParams)
+	usmContent := append(append(usmPrefix, auth...), tlv(tagOctetString, s.privParams)...)
+	usm := tlv(tagSequence, usmContent)
+	securityParams := tlv(tagOctetString, usm)
+
+	data := m.encrypted
+	if data == nil {
+		data = m.scopedPDU()
+	} else {
+		data = tlv(tagOctetString, data)
+	}
+	content := append(append(append(append([]byte{}, version...), header...), securityParams...), data...)
+	b := tlv(tagSequence, content)
+
+	// the headers of the elements that contain the authentication parameters, and the elements before them
+	offset := len(b) - len(content) + len(version) + len(header) +
+		len(securityParams) - len(usm) +
+		len(usm) - len(usmContent) + len(usmPrefix) +
+		len(auth) - len(s.authParams)
+	return b, offset
+}
+
+// decodeMessage decodes a message, the scoped PDU of an encrypted SNMPv3 message is decoded by the caller
+func decodeMessage(b []byte) (*message, error) {
+	content, _, err := expect(b, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	m := &message{}
+	n, content, err := readInt(content)
+	if err != nil {
+		return nil, err
+	}
+	m.version = int(n)
+
+	switch m.version {
+	case version1, version2c:
+		var community []byte
+		if community, content, err = expect(content, tagOctetString); err != nil {
+			return nil, err
+		}
+		m.community = string(community)
+		m.pdu, err = decodePDU(content)
+		return m, err
+	case version3:
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %d", m.version)
+	}
+
+	header, content, err := expect(content, tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	if n, header, err = readInt(header); err != nil {
+		return nil, err
+	}
+	m.msgID = int32(n)
+	if _, header, err = readInt(header); err != nil {
+		return nil, err
+	}
+	flags, header, err := expect(header, tagOctetString)
+	if err != nil || len(flags) != 1 {
+		return nil, fmt.Errorf("invalid msgFlags")
+	}
+	m.flags = flags[0]
+	if n, _, err = readInt(header); err != nil {
+		return nil, err
+	}
+	if n != securityModelUSM {
+		return nil, fmt.Errorf("unsupported security model %d", n)
+	}
+
+	securityParams, content, err := expect(content, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.decodeUSM(securityParams); err != nil {
+		return nil, err
+	}
+	// the offset of a subslice of b is the difference of their capacities
+	m.security.authOffset = cap(b) - cap(m.security.authParams)
+
+	if m.flags&flagPriv != 0 {
+		if m.encrypted, _, err = expect(content, tagOctetString); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return m, m.decodeScopedPDU(content)
+}
+
+func (m *message) decodeUSM(b []byte) error {
+	content, _, err := expect(b, tagSequence)
+	if err != nil {
+		return err
+	}
+	s := &m.security
+	if s.engineID, content, err = expect(content, tagOctetString); err != nil {
+		return err
+	}
+	var n int64
+	if n, content, err = readInt(content); err != nil {
+		return err
+	}
+	s.boots = int32(n)
+	if n, content, err = readInt(content); err != nil {
+		return err
+	}
+	s.time = int32(n)
+	var user []byte
+	if user, content, err = expect(content, tagOctetString); err != nil {
+		return err
+	}
+	s.user = string(user)
+	if s.authParams, content, err = expect(content, tagOctetString); err != nil {
+		return err
+	}
+	s.privParams, _, err = expect(content, tagOctetString)
+	return err
+}
+
+func (m *message) decodeScopedPDU(b []byte) error {
+	content, _, err := expect(b, tagSequence)
+	if err != nil {
+		return err
+	}
+	if m.contextEngineID, content, err = expect(content, tagOctetString); err != nil {
+		return err
+	}
+	var name []byte
+	if name, content, err = expect(content, tagOctetString); err != nil {
+		return err
+	}
+	m.contextName = string(name)
+	m.pdu, err = decodePDU(content)
+	return err
+}
@@ -0,0 +1,718 @@
+// Package snmp collects the metrics of SNMP agents, e.g. of E-Series, switches and older systems that only speak SNMP.
+// The templates map scalar OIDs, or the columns of a table, to the metrics and labels of a matrix. The collector can
+// also receive traps, and export them like the events of the Ems collector.
+package snmp
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/set"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	indexLabel     = "index"
+	trapLabel      = "trap"
+	sourceLabel    = "source"
+	eventsMetric   = "events"
+	timestampKey   = "timestamp"
+	counter32Range = 1 << 32
+)
+
+type Snmp struct {
+	*collector.AbstractCollector
+	client     *client
+	table      oid        // the counters are columns of the table, scalars when nil
+	counters   []*counter // OIDs of the metrics and labels
+	properties map[string]string
+	prevMat    *matrix.Matrix  // raw data of the previous poll, used by counter_properties
+	uptime     uint64          // sysUpTime of the agent at the previous poll, 0 when unknown
+	counter32  map[string]bool // metrics of type Counter32, that wrap at 2^32
+	listener   *trapListener   // receives the traps, when the object is a trap object
+	traps      map[string]*trapDef
+}
+
+// counter is an OID of the template, a metric or a label of the instances
+type counter struct {
+	oid     oid
+	key     string
+	display string
+	kind    string // key, label or float, see util.ParseMetric
+}
+
+// trapDef is a trap of the template, with its name and the labels of its varbinds
+type trapDef struct {
+	name   string
+	labels []*counter
+}
+
+func init() {
+	plugin.RegisterModule(&Snmp{})
+}
+
+func (s *Snmp) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.snmp",
+		New: func() plugin.Module { return new(Snmp) },
+	}
+}
+
+func (s *Snmp) Init(a *collector.AbstractCollector) error {
+	var err error
+	s.AbstractCollector = a
+
+	if err = collectors.LoadTemplate(s.AbstractCollector); err != nil {
+		return err
+	}
+	if err = collector.Init(s); err != nil {
+		return err
+	}
+	u, err := s.initUser()
+	if err != nil {
+		return err
+	}
+	if err = s.InitCache(u); err != nil {
+		return err
+	}
+	if err = s.InitMatrix(); err != nil {
+		return err
+	}
+
+	if s.listener != nil {
+		s.Logger.Info().Int("traps", len(s.traps)).Msg("initialized")
+		return s.listener.start()
+	}
+	if err = s.initClient(u); err != nil {
+		return err
+	}
+	s.Logger.Info().Str("addr", s.client.addr).Str("table", s.table.String()).Int("counters", len(s.counters)).
+		Msg("initialized")
+	return nil
+}
+
+func (s *Snmp) InitMatrix() error {
+	mat := collectors.InitMatrix(s.AbstractCollector)
+	if s.Params.GetChildS("export_options") == nil && s.listener == nil {
+		var keys, labels []string
+		for _, c := range s.counters {
+			switch c.kind {
+			case "key":
+				keys = append(keys, c.display)
+			case "label":
+				labels = append(labels, c.display)
+			}
+		}
+		if len(keys) == 0 && s.table != nil {
+			keys = append(keys, indexLabel)
+		}
+		exportOptions := node.NewS("export_options")
+		if len(keys) == 0 {
+			exportOptions.NewChildS("include_all_labels", "true")
+		} else {
+			instanceKeys := exportOptions.NewChildS("instance_keys", "")
+			for _, k := range keys {
+				instanceKeys.NewChildS("", k)
+			}
+			instanceLabels := exportOptions.NewChildS("instance_labels", "")
+			for _, l := range labels {
+				instanceLabels.NewChildS("", l)
+			}
+		}
+		mat.SetExportOptions(exportOptions)
+	}
+
+	if len(s.properties) > 0 {
+		// the timestamp of each instance, used to calculate rates
+		m, err := mat.NewMetricFloat64(timestampKey)
+		if err != nil {
+			return err
+		}
+		m.SetProperty("raw")
+		m.SetExportable(false)
+		_, _ = s.Metadata.NewMetricUint64("skips")
+	}
+	return nil
+}
+
+func (s *Snmp) InitCache(u *user) error {
+	var err error
+
+	if listener := s.Params.GetChildS("listener"); listener != nil {
+		if s.listener, err = newTrapListener(listener, s.Logger, u); err != nil {
+			return err
+		}
+		return s.initTraps()
+	}
+
+	if t := s.Params.GetChildContentS("table"); t != "" {
+		if s.table, err = parseOID(t); err != nil {
+			return errs.New(errs.ErrInvalidParam, "table: "+err.Error())
+		}
+	}
+	if s.counters, err = s.parseCounters(s.Params.GetChildS("counters")); err != nil {
+		return err
+	}
+	if len(s.counters) == 0 {
+		return errs.New(errs.ErrMissingParam, "counters")
+	}
+
+	s.properties = make(map[string]string)
+	s.counter32 = make(map[string]bool)
+	if props := s.Params.GetChildS("counter_properties"); props != nil {
+		for _, p := range props.GetChildren() {
+			switch property := p.GetContentS(); property {
+			case "raw", "delta", "rate":
+				s.properties[p.GetNameS()] = property
+			default:
+				return errs.New(errs.ErrInvalidParam, fmt.Sprintf("counter_properties: unknown property %s for %s",
+					property, p.GetNameS()))
+			}
+		}
+	}
+
+	s.Logger.Debug().
+		Int("numCounters", len(s.counters)).
+		Int("numProperties", len(s.properties)).
+		Msg("Initialized metric cache")
+	return nil
+}
+
+// parseCounters parses the counters with the syntax of the Rest collector: ^^ for the keys, ^ for the labels, the
+// other counters are metrics. A counter is an OID, or the number of a column of the table.
+func (s *Snmp) parseCounters(counters *node.Node) ([]*counter, error) {
+	var result []*counter
+	if counters == nil {
+		return nil, nil
+	}
+	for _, c := range counters.GetAllChildContentS() {
+		if c == "" {
+			continue
+		}
+		name, display, kind, _ := util.ParseMetric(c)
+		o, err := s.counterOID(name)
+		if err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, "counter "+c+": "+err.Error())
+		}
+		result = append(result, &counter{oid: o, key: o.String(), display: display, kind: kind})
+	}
+	return result, nil
+}
+
+func (s *Snmp) counterOID(name string) (oid, error) {
+	if s.table != nil && !strings.Contains(name, ".") {
+		column, err := strconv.ParseUint(name, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return append(append(oid{}, s.table...), uint32(column)), nil
+	}
+	return parseOID(name)
+}
+
+// initTraps parses the traps of the template
+//
+//	traps:
+//	  linkDown:
+//	    oid: 1.3.6.1.6.3.1.1.5.3
+//	    labels:
+//	      - 1.3.6.1.2.1.2.2.1.2 => interface
+func (s *Snmp) initTraps() error {
+	s.traps = make(map[string]*trapDef)
+	traps := s.Params.GetChildS("traps")
+	if traps == nil {
+		return nil
+	}
+	for _, t := range traps.GetChildren() {
+		o, err := parseOID(t.GetChildContentS("oid"))
+		if err != nil {
+			return errs.New(errs.ErrInvalidParam, "trap "+t.GetNameS()+": "+err.Error())
+		}
+		def := &trapDef{name: t.GetNameS()}
+		if def.labels, err = s.parseCounters(t.GetChildS("labels")); err != nil {
+			return err
+		}
+		s.traps[o.String()] = def
+	}
+	return nil
+}
+
+// initUser returns the SNMPv3 user of the poller's username and password, or nil for SNMPv2c
+func (s *Snmp) initUser() (*user, error) {
+	version := s.Params.GetChildContentS("version")
+	switch version {
+	case "", "2c":
+		return nil, nil
+	case "3":
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "version "+version)
+	}
+
+	var username, password string
+	if s.Auth != nil {
+		pollerAuth, err := s.Auth.GetPollerAuth()
+		if err != nil {
+			return nil, err
+		}
+		username, password = pollerAuth.Username, pollerAuth.Password
+	}
+	if username == "" {
+		return nil, errs.New(errs.ErrMissingParam, "username of the SNMPv3 user")
+	}
+	authProtocol := s.Params.GetChildContentS("auth_protocol")
+	if authProtocol == "" && password != "" {
+		authProtocol = "SHA"
+	}
+	privPassword := s.Params.GetChildContentS("priv_password")
+	if privPassword == "" {
+		privPassword = password
+	}
+	u, err := newUser(username, authProtocol, password, s.Params.GetChildContentS("priv_protocol"), privPassword)
+	if err != nil {
+		return nil, errs.New(errs.ErrInvalidParam, err.Error())
+	}
+	return u, nil
+}
+
+func (s *Snmp) initClient(u *user) error {
+	var err error
+	c := &client{
+		addr:           s.Params.GetChildContentS("addr"),
+		version:        version2c,
+		community:      s.Params.GetChildContentS("community"),
+		user:           u,
+		contextName:    s.Params.GetChildContentS("context_name"),
+		retries:        defaultRetries,
+		maxRepetitions: defaultMaxRepetitions,
+		maxOIDs:        defaultMaxOIDs,
+	}
+	if c.addr == "" {
+		return errs.New(errs.ErrMissingParam, "addr")
+	}
+	if u != nil {
+		c.version = version3
+	} else if c.community == "" {
+		// the community may be the password of the poller, to keep it out of the templates
+		c.community = "public"
+		if s.Auth != nil {
+			if pollerAuth, err := s.Auth.GetPollerAuth(); err == nil && pollerAuth.Password != "" {
+				c.community = pollerAuth.Password
+			}
+		}
+	}
+	if c.timeout, err = collectors.ClientTimeout(s.AbstractCollector, defaultTimeout); err != nil {
+		return err
+	}
+	for name, value := range map[string]*int{"retries": &c.retries, "max_repetitions": &c.maxRepetitions,
+		"max_oids": &c.maxOIDs} {
+		if v := s.Params.GetChildContentS(name); v != "" {
+			if *value, err = strconv.Atoi(v); err != nil || *value < 0 {
+				return errs.New(errs.ErrInvalidParam, name+": "+v)
+			}
+		}
+	}
+	if c.maxOIDs == 0 {
+		c.maxOIDs = defaultMaxOIDs
+	}
+	s.client = c
+	return nil
+}
+
+// Stop closes the socket of the trap listener
+func (s *Snmp) Stop() {
+	if s.listener != nil {
+		s.listener.stop()
+	}
+	s.AbstractCollector.Stop()
+}
+
+func (s *Snmp) PollData() (map[string]*matrix.Matrix, error) {
+	if s.listener != nil {
+		return s.pollTraps()
+	}
+
+	var (
+		rows         map[string][]varbind
+		err          error
+		apiD, parseD time.Duration
+	)
+
+	s.Logger.Debug().Msg("starting data poll")
+	mat := s.Matrix[s.Object]
+	mat.Reset()
+
+	startTime := time.Now()
+	if s.table != nil {
+		rows, err = s.walkTable()
+	} else {
+		rows, err = s.getScalars()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, errs.New(errs.ErrNoInstance, "no "+s.Object+" instances on "+s.client.addr)
+	}
+	restarted := s.restarted()
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	count := s.handleRows(rows)
+	s.calculateProperties(mat, startTime, restarted)
+	parseD = time.Since(startTime)
+
+	numInstances := len(mat.GetInstances())
+	_ = s.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = s.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = s.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = s.Metadata.LazySetValueUint64("instances", "data", uint64(numInstances))
+	s.AddCollectCount(count)
+
+	return s.Matrix, nil
+}
+
+// walkTable walks the columns of the counters, and returns the varbinds by the index of the rows
+func (s *Snmp) walkTable() (map[string][]varbind, error) {
+	rows := make(map[string][]varbind)
+	for _, c := range s.counters {
+		column := c.oid
+		err := s.client.walk(column, func(v varbind) {
+			index := v.oid[len(column):].String()
+			rows[index] = append(rows[index], v)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+// restarted returns true when the sysUpTime of the agent is lower than at the previous poll, since the counters of an
+// agent that restarted start over. It is false when the agent has no sysUpTime, or there are no counter_properties.
+func (s *Snmp) restarted() bool {
+	if len(s.properties) == 0 {
+		return false
+	}
+	varbinds, err := s.client.get([]oid{oidSysUpTime})
+	if err != nil || len(varbinds) != 1 || varbinds[0].tag != tagTimeTicks {
+		if err != nil {
+			s.Logger.Debug().Err(err).Msg("Failed to get sysUpTime")
+		}
+		s.uptime = 0
+		return false
+	}
+	uptime := varbinds[0].unsigned
+	restarted := s.uptime > 0 && uptime < s.uptime
+	s.uptime = uptime
+	return restarted
+}
+
+// getScalars returns the varbinds of the scalar counters, as a single row
+func (s *Snmp) getScalars() (map[string][]varbind, error) {
+	oids := make([]oid, 0, len(s.counters))
+	for _, c := range s.counters {
+		oids = append(oids, c.oid)
+	}
+	varbinds, err := s.client.get(oids)
+	if err != nil {
+		return nil, err
+	}
+	var row []varbind
+	for _, v := range varbinds {
+		if v.exists() {
+			row = append(row, v)
+		}
+	}
+	if len(row) == 0 {
+		return nil, nil
+	}
+	return map[string][]varbind{"": row}, nil
+}
+
+// handleRows sets the labels and metrics of the instances of the rows, and removes the instances that are gone
+func (s *Snmp) handleRows(rows map[string][]varbind) uint64 {
+	var count uint64
+
+	mat := s.Matrix[s.Object]
+	oldInstances := set.New()
+	for key := range mat.GetInstances() {
+		oldInstances.Add(key)
+	}
+
+	for index, row := range rows {
+		instance := mat.GetInstance(index)
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(index); err != nil {
+				s.Logger.Error().Err(err).Str("index", index).Msg("Failed to create new missing instance")
+				continue
+			}
+		}
+		oldInstances.Remove(index)
+		instance.ClearLabels()
+		if s.table != nil {
+			instance.SetLabel(indexLabel, index)
+		}
+
+		for _, v := range row {
+			c := s.counterOf(v.oid)
+			if c == nil {
+				continue
+			}
+			if c.kind != "float" {
+				instance.SetLabel(c.display, v.String())
+				count++
+				continue
+			}
+			value, ok := v.float()
+			if !ok {
+				s.Logger.Trace().Str("oid", v.oid.String()).Msg("value is not a number")
+				continue
+			}
+			metric := mat.GetMetric(c.key)
+			if metric == nil {
+				var err error
+				if metric, err = mat.NewMetricFloat64(c.key, c.display); err != nil {
+					s.Logger.Error().Err(err).Str("oid", c.key).Msg("NewMetricFloat64")
+					continue
+				}
+			}
+			if v.tag == tagCounter32 {
+				s.counter32[c.key] = true
+			}
+			if err := metric.SetValueFloat64(instance, value); err != nil {
+				s.Logger.Error().Err(err).Str("oid", c.key).Msg("Unable to set float key on metric")
+				continue
+			}
+			count++
+		}
+	}
+
+	for key := range oldInstances.Iter() {
+		mat.RemoveInstance(key)
+		s.Logger.Debug().Str("key", key).Msg("removed instance")
+	}
+	return count
+}
+
+// counterOf returns the counter of the OID of a varbind, the OID of a column, or the OID of a scalar
+func (s *Snmp) counterOf(o oid) *counter {
+	for _, c := range s.counters {
+		if (s.table != nil && o.hasPrefix(c.oid)) || o.compare(c.oid) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// calculateProperties cooks the metrics of the counter_properties with the raw values of the previous poll, like the
+// perf collectors. A Counter32 that is lower than its previous value by more than half of its range wrapped at 2^32,
+// the other counters that decrease were reset, and are skipped. All counters are skipped when the agent restarted.
+func (s *Snmp) calculateProperties(mat *matrix.Matrix, pollTime time.Time, restarted bool) {
+	if len(s.properties) == 0 {
+		return
+	}
+
+	ts := mat.GetMetric(timestampKey)
+	for _, instance := range mat.GetInstances() {
+		_ = ts.SetValueFloat64(instance, float64(pollTime.UnixNano())/float64(time.Second))
+	}
+
+	// cache raw data for next poll
+	prevMat := s.prevMat
+	s.prevMat = mat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+
+	keys := make(map[string]string)
+	for key, metric := range mat.GetMetrics() {
+		if property, ok := s.properties[metric.GetName()]; ok {
+			keys[key] = property
+			metric.SetProperty(property)
+		}
+	}
+
+	if prevMat == nil || restarted {
+		if restarted {
+			s.Logger.Info().Msg("agent restarted, skip postprocessing until next poll")
+		} else {
+			s.Logger.Debug().Msg("skip postprocessing until next poll (previous cache empty)")
+		}
+		for key, property := range keys {
+			if property != "raw" {
+				mat.GetMetric(key).Reset(len(mat.GetInstances()))
+			}
+		}
+		return
+	}
+
+	var totalSkips int
+	_, _ = mat.Delta(timestampKey, prevMat, s.Logger)
+	for key, property := range keys {
+		if property == "raw" {
+			continue
+		}
+		metric := mat.GetMetric(key)
+		prevMetric := prevMat.GetMetric(key)
+		if prevMetric == nil {
+			metric.Reset(len(mat.GetInstances()))
+			continue
+		}
+		if s.counter32[key] {
+			s.unwrap(mat, prevMat, key)
+		}
+		skips, err := mat.Delta(key, prevMat, s.Logger)
+		if err != nil {
+			s.Logger.Error().Err(err).Str("key", key).Msg("Calculate delta")
+			continue
+		}
+		totalSkips += skips
+		if property == "rate" {
+			if skips, err = mat.Divide(key, timestampKey, s.Logger); err != nil {
+				s.Logger.Error().Err(err).Str("key", key).Msg("Calculate rate")
+				continue
+			}
+			totalSkips += skips
+		}
+	}
+	_ = s.Metadata.LazySetValueUint64("skips", "data", uint64(totalSkips))
+}
+
+// unwrap adds 2^32 to the values of a Counter32 that are lower than their previous value by more than half of the
+// range. A smaller decrease is a reset, e.g. of an agent without sysUpTime, and is left for Delta to skip.
+func (s *Snmp) unwrap(mat *matrix.Matrix, prevMat *matrix.Matrix, key string) {
+	metric := mat.GetMetric(key)
+	prevMetric := prevMat.GetMetric(key)
+	for instanceKey, instance := range mat.GetInstances() {
+		prevInstance := prevMat.GetInstance(instanceKey)
+		if prevInstance == nil {
+			continue
+		}
+		value, ok := metric.GetValueFloat64(instance)
+		prevValue, prevOk := prevMetric.GetValueFloat64(prevInstance)
+		if ok && prevOk && prevValue-value > counter32Range/2 && prevValue < counter32Range {
+			_ = metric.SetValueFloat64(instance, value+counter32Range)
+		}
+	}
+}
+
+// pollTraps exports the traps received since the last poll: the events metric of a matrix by trap, the number of
+// traps of each source and labels
+func (s *Snmp) pollTraps() (map[string]*matrix.Matrix, error) {
+	// restart the listener if it failed
+	if err := s.listener.start(); err != nil {
+		return nil, err
+	}
+	traps, dropped := s.listener.drain()
+	if dropped > 0 {
+		s.Logger.Warn().Int("dropped", dropped).Msg("listener queue is full, traps were dropped")
+	}
+
+	// the traps of the previous poll are not exported again. The matrices of the traps that are not in the template
+	// are removed, so that the traps of unknown OIDs do not grow the memory of the poller.
+	known := make(map[string]bool, len(s.traps))
+	for _, def := range s.traps {
+		known[def.name] = true
+	}
+	for name, mat := range s.Matrix {
+		switch {
+		case name == s.Object:
+		case known[name]:
+			mat.PurgeInstances()
+		default:
+			delete(s.Matrix, name)
+		}
+	}
+
+	var count uint64
+	unknown, ignored := 0, 0
+	for _, t := range traps {
+		trapOID := t.oid.String()
+		name := trapOID
+		def := s.traps[trapOID]
+		if def != nil {
+			name = def.name
+		}
+
+		mat, ok := s.Matrix[name]
+		if !ok {
+			if def == nil {
+				if unknown >= s.listener.maxUnknown {
+					ignored++
+					continue
+				}
+				unknown++
+			}
+			mat = matrix.New(name, s.Matrix[s.Object].Object, name)
+			mat.SetGlobalLabels(s.Matrix[s.Object].GetGlobalLabels())
+			if _, err := mat.NewMetricFloat64(eventsMetric); err != nil {
+				s.Logger.Error().Err(err).Str("trap", name).Msg("NewMetricFloat64")
+				continue
+			}
+			s.Matrix[name] = mat
+		}
+
+		labels := map[string]string{trapLabel: name, sourceLabel: t.source}
+		if def != nil {
+			for _, v := range t.varbinds {
+				for _, c := range def.labels {
+					if v.oid.hasPrefix(c.oid) {
+						labels[c.display] = v.String()
+					}
+				}
+			}
+		}
+
+		instanceKey := instanceKeyOf(labels)
+		instance := mat.GetInstance(instanceKey)
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				s.Logger.Error().Err(err).Str("instanceKey", instanceKey).Msg("Failed to create new missing instance")
+				continue
+			}
+			for k, v := range labels {
+				instance.SetLabel(k, v)
+			}
+		}
+		events := mat.GetMetric(eventsMetric)
+		value, _ := events.GetValueFloat64(instance)
+		_ = events.SetValueFloat64(instance, value+1)
+		count++
+	}
+
+	if ignored > 0 {
+		s.Logger.Warn().Int("ignored", ignored).Int("max_unknown_traps", s.listener.maxUnknown).
+			Msg("too many trap OIDs that are not in traps, traps were ignored")
+	}
+
+	_ = s.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = s.Metadata.LazySetValueUint64("instances", "data", uint64(len(traps)))
+	s.AddCollectCount(count)
+	return s.Matrix, nil
+}
+
+// instanceKeyOf joins the labels of a trap, sorted by name
+func instanceKeyOf(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + labels[name] + ",")
+	}
+	return b.String()
+}
+
+// Interface guards
+var (
+	_ collector.Collector = (*Snmp)(nil)
+)
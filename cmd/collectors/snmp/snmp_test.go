@@ -0,0 +1,566 @@
+package snmp
+
+import (
+	"crypto/md5"  //nolint:gosec
+	"crypto/sha1" //nolint:gosec
+	"encoding/hex"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newSnmp(t *testing.T, template string) *Snmp {
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := collector.New("Snmp", params.GetChildContentS("object"), options.New(), params, nil)
+	s := &Snmp{}
+	if err = s.Init(ac); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// agent is a stand-in of a SNMP agent, with the varbinds of its MIB
+type agent struct {
+	t         *testing.T
+	conn      net.PacketConn
+	community string
+	user      *user
+	engineID  []byte
+
+	mu  sync.Mutex
+	mib map[string]varbind
+}
+
+func newAgent(t *testing.T, u *user, varbinds ...varbind) *agent {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &agent{t: t, conn: conn, community: "public", user: u, engineID: []byte{0x80, 0, 0x1f, 0x88, 4, 't', 'e', 's', 't'},
+		mib: make(map[string]varbind)}
+	a.set(varbinds...)
+	t.Cleanup(func() { _ = conn.Close() })
+	go a.serve()
+	return a
+}
+
+func (a *agent) addr() string {
+	return a.conn.LocalAddr().String()
+}
+
+func (a *agent) set(varbinds ...varbind) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, v := range varbinds {
+		a.mib[v.oid.String()] = v
+	}
+}
+
+func (a *agent) remove(o oid) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.mib, o.String())
+}
+
+// next returns the first varbind after the OID
+func (a *agent) next(o oid) varbind {
+	var found *varbind
+	for _, v := range a.mib {
+		if v.oid.compare(o) > 0 && (found == nil || v.oid.compare(found.oid) < 0) {
+			v := v
+			found = &v
+		}
+	}
+	if found == nil {
+		return varbind{oid: o, tag: tagEndOfMibView}
+	}
+	return *found
+}
+
+func (a *agent) respond(p *pdu) *pdu {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	response := &pdu{kind: pduResponse, requestID: p.requestID}
+	switch p.kind {
+	case pduGet:
+		for _, v := range p.varbinds {
+			found, ok := a.mib[v.oid.String()]
+			if !ok {
+				found = varbind{oid: v.oid, tag: tagNoSuchObject}
+			}
+			response.varbinds = append(response.varbinds, found)
+		}
+	case pduGetNext:
+		for _, v := range p.varbinds {
+			response.varbinds = append(response.varbinds, a.next(v.oid))
+		}
+	case pduGetBulk:
+		last := p.varbinds[0].oid
+		for i := 0; i < p.errorIndex; i++ {
+			v := a.next(last)
+			response.varbinds = append(response.varbinds, v)
+			if v.tag == tagEndOfMibView {
+				break
+			}
+			last = v.oid
+		}
+	}
+	return response
+}
+
+func (a *agent) serve() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		raw := append([]byte{}, buf[:n]...)
+		m, err := decodeMessage(raw)
+		if err != nil {
+			a.t.Error(err)
+			return
+		}
+		var b []byte
+		switch {
+		case m.version != version3:
+			if m.community != a.community {
+				continue
+			}
+			b, _ = (&message{version: m.version, community: m.community, pdu: a.respond(m.pdu)}).encode()
+		case m.flags&flagAuth == 0:
+			// discovery, report usmStatsUnknownEngineIDs with the engine ID, boots and time
+			report := &message{version: version3, msgID: m.msgID,
+				security:        usmParams{engineID: a.engineID, boots: 3, time: 1000},
+				contextEngineID: a.engineID,
+				pdu: &pdu{kind: pduReport, requestID: m.pdu.requestID, varbinds: []varbind{
+					{oid: oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 4, 0}, tag: tagCounter32, unsigned: 1}}}}
+			b, _ = report.encode()
+		default:
+			if err = a.user.open(raw, m); err != nil {
+				// dropped like the messages of an unknown user
+				continue
+			}
+			response := &message{version: version3, msgID: m.msgID,
+				security:        usmParams{engineID: a.engineID, boots: 3, time: 1000},
+				contextEngineID: a.engineID, pdu: a.respond(m.pdu)}
+			if b, err = a.user.seal(response); err != nil {
+				a.t.Error(err)
+				return
+			}
+		}
+		_, _ = a.conn.WriteTo(b, addr)
+	}
+}
+
+var (
+	ifDescr     = oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2}
+	ifInOctets  = oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 10}
+	ifHCOctets  = oid{1, 3, 6, 1, 2, 1, 31, 1, 1, 1, 6}
+	ifOutErrors = oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 20}
+)
+
+func column(column oid, index uint32) oid {
+	return append(append(oid{}, column...), index)
+}
+
+func TestPollTable(t *testing.T) {
+	for _, maxRepetitions := range []string{"10", "0"} {
+		t.Run("max_repetitions "+maxRepetitions, func(t *testing.T) {
+			a := newAgent(t, nil,
+				varbind{oid: column(ifDescr, 1), tag: tagOctetString, bytes: []byte("e0a")},
+				varbind{oid: column(ifDescr, 2), tag: tagOctetString, bytes: []byte("e0b")},
+				varbind{oid: column(ifInOctets, 1), tag: tagCounter32, unsigned: 1<<32 - 10},
+				varbind{oid: column(ifInOctets, 2), tag: tagCounter32, unsigned: 100},
+				varbind{oid: column(ifOutErrors, 1), tag: tagCounter32, unsigned: 7},
+				varbind{oid: column(ifOutErrors, 2), tag: tagCounter32, unsigned: 0},
+				// the next column of the table is not walked
+				varbind{oid: oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 21, 1}, tag: tagGauge32, unsigned: 1},
+			)
+			s := newSnmp(t, `
+object: interface
+schedule:
+  - data: 1m
+addr: `+a.addr()+`
+community: public
+client_timeout: 1s
+max_repetitions: `+maxRepetitions+`
+table: 1.3.6.1.2.1.2.2.1
+counters:
+  - ^2 => interface
+  - 10 => receive_bytes
+  - 20 => transmit_errors
+counter_properties:
+  receive_bytes: delta
+`)
+			mat := poll(t, s)
+			if n := len(mat.GetInstances()); n != 2 {
+				t.Fatalf("got %d instances, want 2", n)
+			}
+			instance := mat.GetInstance("1")
+			if got := instance.GetLabel("interface"); got != "e0a" {
+				t.Errorf("interface label = %q, want e0a", got)
+			}
+			if got := instance.GetLabel(indexLabel); got != "1" {
+				t.Errorf("index label = %q, want 1", got)
+			}
+			if v, ok := mat.GetMetric(ifOutErrors.String()).GetValueFloat64(instance); !ok || v != 7 {
+				t.Errorf("transmit_errors = %v %v, want 7", v, ok)
+			}
+			// the deltas are calculated from the second poll
+			if _, ok := mat.GetMetric(ifInOctets.String()).GetValueFloat64(instance); ok {
+				t.Errorf("receive_bytes is recorded on the first poll")
+			}
+
+			// the counter of e0a wraps
+			a.set(varbind{oid: column(ifInOctets, 1), tag: tagCounter32, unsigned: 20},
+				varbind{oid: column(ifInOctets, 2), tag: tagCounter32, unsigned: 150})
+			mat = poll(t, s)
+			receive := mat.GetMetric(ifInOctets.String())
+			for index, want := range map[string]float64{"1": 30, "2": 50} {
+				if v, ok := receive.GetValueFloat64(mat.GetInstance(index)); !ok || v != want {
+					t.Errorf("receive_bytes of %s = %v %v, want %v", index, v, ok, want)
+				}
+			}
+
+			// e0b is removed
+			for _, c := range []oid{ifDescr, ifInOctets, ifOutErrors} {
+				a.remove(column(c, 2))
+			}
+			mat = poll(t, s)
+			if mat.GetInstance("2") != nil || mat.GetInstance("1") == nil {
+				t.Errorf("got instances %v, want 1", keys(mat))
+			}
+		})
+	}
+}
+
+func TestPollRestart(t *testing.T) {
+	a := newAgent(t, nil,
+		varbind{oid: column(ifDescr, 1), tag: tagOctetString, bytes: []byte("e0a")},
+		varbind{oid: column(ifInOctets, 1), tag: tagCounter32, unsigned: 1000},
+	)
+	s := newSnmp(t, `
+object: interface
+schedule:
+  - data: 1m
+addr: `+a.addr()+`
+community: public
+client_timeout: 1s
+table: 1.3.6.1.2.1.2.2.1
+counters:
+  - ^2 => interface
+  - 10 => receive_bytes
+counter_properties:
+  receive_bytes: delta
+`)
+	steps := []struct {
+		name    string
+		uptime  uint64 // sysUpTime of the agent, none when 0
+		counter uint64
+		want    float64 // skipped when -1
+	}{
+		{name: "first poll", counter: 1000, want: -1},
+		{name: "reset without sysUpTime", counter: 500, want: -1},
+		{name: "after the reset", counter: 600, want: 100},
+		{name: "with sysUpTime", uptime: 5000, counter: 3_000_000_000, want: 3_000_000_000 - 600},
+		{name: "restart", uptime: 10, counter: 100, want: -1},
+		{name: "after the restart", uptime: 20, counter: 150, want: 50},
+	}
+	for _, step := range steps {
+		if step.uptime > 0 {
+			a.set(varbind{oid: oidSysUpTime, tag: tagTimeTicks, unsigned: step.uptime})
+		}
+		a.set(varbind{oid: column(ifInOctets, 1), tag: tagCounter32, unsigned: step.counter})
+		mat := poll(t, s)
+		v, ok := mat.GetMetric(ifInOctets.String()).GetValueFloat64(mat.GetInstance("1"))
+		if step.want < 0 && ok {
+			t.Errorf("%s: receive_bytes = %v, want skipped", step.name, v)
+		}
+		if step.want >= 0 && (!ok || v != step.want) {
+			t.Errorf("%s: receive_bytes = %v %v, want %v", step.name, v, ok, step.want)
+		}
+	}
+}
+
+func TestPollScalarsV3(t *testing.T) {
+	u, err := newUser("harvest", "SHA", "authpass123", "AES", "privpass123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentUser, _ := newUser("harvest", "SHA", "authpass123", "AES", "privpass123")
+	a := newAgent(t, agentUser,
+		varbind{oid: oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, tag: tagOctetString, bytes: []byte("switch1")},
+		varbind{oid: oid{1, 3, 6, 1, 2, 1, 1, 3, 0}, tag: tagTimeTicks, unsigned: 12345},
+		varbind{oid: ifHCOctets, tag: tagCounter64, unsigned: 1 << 40},
+	)
+
+	s := newSnmp(t, `
+object: system
+schedule:
+  - data: 1m
+addr: `+a.addr()+`
+client_timeout: 1s
+counters:
+  - ^^1.3.6.1.2.1.1.5.0 => name
+  - 1.3.6.1.2.1.1.3.0 => uptime_ticks
+  - 1.3.6.1.2.1.25.1.6.0 => processes
+`)
+	// the poller's credentials
+	s.client.version = version3
+	s.client.user = u
+
+	mat := poll(t, s)
+	instance := mat.GetInstance("")
+	if instance == nil {
+		t.Fatalf("got instances %v, want the scalars", keys(mat))
+	}
+	if got := instance.GetLabel("name"); got != "switch1" {
+		t.Errorf("name = %q, want switch1", got)
+	}
+	if v, ok := mat.GetMetric("1.3.6.1.2.1.1.3.0").GetValueFloat64(instance); !ok || v != 12345 {
+		t.Errorf("uptime_ticks = %v %v, want 12345", v, ok)
+	}
+	if mat.GetMetric("1.3.6.1.2.1.25.1.6.0") != nil {
+		t.Errorf("processes is not in the MIB of the agent")
+	}
+
+	// a user with a wrong password is rejected
+	s.client.user, _ = newUser("harvest", "SHA", "wrongpass123", "AES", "privpass123")
+	s.client.engine = nil
+	s.client.retries = 0
+	s.client.timeout = 200 * time.Millisecond
+	if _, err = s.PollData(); err == nil {
+		t.Errorf("poll with a wrong password succeeded")
+	}
+}
+
+func TestPasswordToKey(t *testing.T) {
+	// RFC 3414 A.3
+	engineID := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	tests := []struct {
+		name string
+		key  []byte
+		want string
+	}{
+		{"MD5", passwordToKey(md5.New, "maplesyrup", engineID), "526f5eed9fcce26f8964c2930787d82b"},
+		{"SHA", passwordToKey(sha1.New, "maplesyrup", engineID), "6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.key); got != tt.want {
+			t.Errorf("%s key = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTraps(t *testing.T) {
+	s := newSnmp(t, `
+object: snmp_trap
+schedule:
+  - data: 1m
+listener:
+  addr: 127.0.0.1:0
+  communities:
+    - public
+traps:
+  linkDown:
+    oid: 1.3.6.1.6.3.1.1.5.3
+    labels:
+      - 1.3.6.1.2.1.2.2.1.1 => index
+`)
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", s.listener.bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	linkDown := func(kind byte, community string, index int64) []byte {
+		m := &message{version: version2c, community: community, pdu: &pdu{kind: kind, requestID: 42, varbinds: []varbind{
+			{oid: oidSysUpTime, tag: tagTimeTicks, unsigned: 100},
+			{oid: oidSnmpTrapOID, tag: tagOID, objectID: oid{1, 3, 6, 1, 6, 3, 1, 1, 5, 3}},
+			{oid: oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 1, uint32(index)}, tag: tagInteger, integer: index},
+		}}}
+		b, _ := m.encode()
+		return b
+	}
+	coldStart := &message{version: version2c, community: "public", pdu: &pdu{kind: pduTrapV2, varbinds: []varbind{
+		{oid: oidSnmpTrapOID, tag: tagOID, objectID: oid{1, 3, 6, 1, 6, 3, 1, 1, 5, 1}},
+	}}}
+	b, _ := coldStart.encode()
+
+	for _, packet := range [][]byte{
+		linkDown(pduTrapV2, "private", 1), // unknown community
+		linkDown(pduTrapV2, "public", 2),
+		linkDown(pduTrapV2, "public", 2),
+		b,
+		linkDown(pduInform, "public", 3),
+	} {
+		if _, err = conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the inform is acknowledged
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := decodeMessage(buf[:n])
+	if err != nil || response.pdu.kind != pduResponse || response.pdu.requestID != 42 {
+		t.Fatalf("got response %v %v, want the acknowledgement of the inform", response, err)
+	}
+	waitTraps(t, s.listener, 4)
+
+	data, err := s.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]float64{}
+	for name, mat := range data {
+		if mat.GetMetric(eventsMetric) == nil {
+			continue
+		}
+		for _, instance := range mat.GetInstances() {
+			v, _ := mat.GetMetric(eventsMetric).GetValueFloat64(instance)
+			events[name+" "+instance.GetLabel("index")+" "+instance.GetLabel(sourceLabel)] = v
+		}
+	}
+	want := map[string]float64{
+		"linkDown 2 127.0.0.1":           2,
+		"linkDown 3 127.0.0.1":           1,
+		"1.3.6.1.6.3.1.1.5.1  127.0.0.1": 1,
+	}
+	if len(events) != len(want) {
+		t.Errorf("got events %v, want %v", events, want)
+	}
+	for k, v := range want {
+		if events[k] != v {
+			t.Errorf("events of %s = %v, want %v", k, events[k], v)
+		}
+	}
+
+	// the traps are exported once
+	data, err = s.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(data["linkDown"].GetInstances()); n != 0 {
+		t.Errorf("got %d linkDown instances, want 0", n)
+	}
+	// the matrices of unknown traps are removed
+	if _, ok := s.Matrix["1.3.6.1.6.3.1.1.5.1"]; ok {
+		t.Error("the matrix of the unknown trap should be removed")
+	}
+
+	// the socket is closed when the collector stops
+	s.Stop()
+	if s.listener.conn != nil {
+		t.Error("the listener should be stopped")
+	}
+}
+
+func TestTrapLimits(t *testing.T) {
+	s := newSnmp(t, `
+object: snmp_trap
+schedule:
+  - data: 1m
+listener:
+  addr: 127.0.0.1:0
+  max_unknown_traps: 2
+  communities:
+    - "*"
+traps:
+  linkDown:
+    oid: 1.3.6.1.6.3.1.1.5.3
+`)
+	defer s.Stop()
+
+	conn, err := net.Dial("udp", s.listener.bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// any community is accepted, the traps of the third unknown OID are ignored
+	for i, community := range []string{"public", "private", "other", "any"} {
+		m := &message{version: version2c, community: community, pdu: &pdu{kind: pduTrapV2, varbinds: []varbind{
+			{oid: oidSnmpTrapOID, tag: tagOID, objectID: oid{1, 3, 6, 1, 4, 1, 42, uint32(i % 3)}},
+		}}}
+		b, _ := m.encode()
+		if _, err = conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitTraps(t, s.listener, 4)
+
+	data, err := s.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range data {
+		if name != s.Object && name != "linkDown" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"1.3.6.1.4.1.42.0", "1.3.6.1.4.1.42.1"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("got matrices %v, want %v", names, want)
+	}
+
+	// the listener requires communities, or the user of SNMPv3 traps
+	params := node.NewS("listener")
+	params.NewChildS("addr", "127.0.0.1:0")
+	if _, err := newTrapListener(params, s.Logger, nil); err == nil {
+		t.Error("listener without communities should fail")
+	}
+	if _, err := newTrapListener(params, s.Logger, &user{name: "harvest"}); err != nil {
+		t.Errorf("listener of SNMPv3 traps got %v", err)
+	}
+}
+
+func waitTraps(t *testing.T, l *trapListener, n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		queued := len(l.traps)
+		l.mu.Unlock()
+		if queued >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d traps", n)
+}
+
+func poll(t *testing.T, s *Snmp) *matrix.Matrix {
+	data, err := s.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data[s.Object]
+}
+
+func keys(mat *matrix.Matrix) []string {
+	var result []string
+	for key := range mat.GetInstances() {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,223 @@
+package snmp
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxTraps        = 10_000
+	defaultMaxUnknownTraps = 100
+	anyCommunity           = "*"
+)
+
+// trap is a notification received by the listener, a SNMPv1 trap is converted to a SNMPv2 trap
+type trap struct {
+	source   string // address of the agent
+	oid      oid    // snmpTrapOID
+	varbinds []varbind
+	received time.Time
+}
+
+// trapListener receives the traps and informs of the agents, and queues them until the next poll of the collector
+type trapListener struct {
+	Logger      *logging.Logger
+	addr        string
+	bound       string          // address of the running listener, e.g. with the port chosen by the system for port 0
+	communities map[string]bool // accepted communities of SNMPv1 and SNMPv2c, any with "*"
+	user        *user           // of SNMPv3 traps, SNMPv3 traps are dropped when nil
+	maxTraps    int
+	maxUnknown  int // trap OIDs that are not in the traps of the template, exported by a poll
+
+	mu      sync.Mutex
+	conn    net.PacketConn
+	traps   []trap
+	dropped int
+}
+
+// newTrapListener creates the listener of the listener section of the template
+//
+//	listener:
+//	  addr:              :9162
+//	  max_traps:         10000
+//	  max_unknown_traps: 100
+//	  communities:
+//	    - public
+//
+// The listener accepts the traps of its communities, or of the SNMPv3 user of the poller, so that any host that can
+// reach it can't fill the poller with traps.
+func newTrapListener(params *node.Node, logger *logging.Logger, u *user) (*trapListener, error) {
+	l := &trapListener{
+		Logger:      logger,
+		addr:        params.GetChildContentS("addr"),
+		communities: make(map[string]bool),
+		user:        u,
+		maxTraps:    defaultMaxTraps,
+		maxUnknown:  defaultMaxUnknownTraps,
+	}
+	if l.addr == "" {
+		return nil, errs.New(errs.ErrMissingParam, "listener addr")
+	}
+	if s := params.GetChildContentS("max_traps"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "listener max_traps: "+s)
+		}
+		l.maxTraps = n
+	}
+	if s := params.GetChildContentS("max_unknown_traps"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "listener max_unknown_traps: "+s)
+		}
+		l.maxUnknown = n
+	}
+	if communities := params.GetChildS("communities"); communities != nil {
+		for _, c := range communities.GetAllChildContentS() {
+			l.communities[c] = true
+		}
+	}
+	if len(l.communities) == 0 && l.user == nil {
+		return nil, errs.New(errs.ErrMissingParam, "listener communities, or the SNMPv3 user of the poller")
+	}
+	return l, nil
+}
+
+// start starts the listener when it is not running
+func (l *trapListener) start() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		return errs.New(errs.ErrConnection, "listener: "+err.Error())
+	}
+	l.conn = conn
+	l.bound = conn.LocalAddr().String()
+	l.Logger.Info().Str("addr", l.bound).Msg("trap listener started")
+	go l.serve(conn)
+	return nil
+}
+
+func (l *trapListener) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		_ = l.conn.Close()
+		l.conn = nil
+	}
+}
+
+func (l *trapListener) serve(conn net.PacketConn) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			stopped := l.conn != conn
+			if !stopped {
+				// restarted by the next poll
+				l.conn = nil
+			}
+			l.mu.Unlock()
+			if !stopped && !errors.Is(err, net.ErrClosed) {
+				l.Logger.Error().Err(err).Msg("trap listener failed")
+			}
+			return
+		}
+		raw := make([]byte, n)
+		copy(raw, buf[:n])
+		if err := l.handle(conn, addr, raw); err != nil {
+			l.Logger.Warn().Err(err).Str("remote", addr.String()).Msg("invalid trap")
+		}
+	}
+}
+
+// handle queues a trap, and acknowledges an inform
+func (l *trapListener) handle(conn net.PacketConn, addr net.Addr, raw []byte) error {
+	m, err := decodeMessage(raw)
+	if err != nil {
+		return err
+	}
+	switch m.version {
+	case version1, version2c:
+		if !l.communities[anyCommunity] && !l.communities[m.community] {
+			return errors.New("unknown community")
+		}
+	case version3:
+		if l.user == nil {
+			return errors.New("SNMPv3 trap without SNMPv3 user")
+		}
+		if err = l.user.open(raw, m); err != nil {
+			return err
+		}
+	}
+
+	p := m.pdu
+	switch p.kind {
+	case pduTrapV2:
+	case pduInform:
+		if m.version == version3 {
+			return errors.New("SNMPv3 informs are not supported")
+		}
+		response := &message{version: m.version, community: m.community,
+			pdu: &pdu{kind: pduResponse, requestID: p.requestID, varbinds: p.varbinds}}
+		b, _ := response.encode()
+		if _, err = conn.WriteTo(b, addr); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unexpected PDU " + strconv.Itoa(int(p.kind)))
+	}
+
+	t := trap{source: addr.String(), received: time.Now()}
+	if host, _, err := net.SplitHostPort(t.source); err == nil {
+		t.source = host
+	}
+	if len(p.agentAddr) == 4 && !p.agentAddr.IsUnspecified() {
+		t.source = p.agentAddr.String()
+	}
+	for _, v := range p.varbinds {
+		switch {
+		case v.oid.compare(oidSnmpTrapOID) == 0:
+			t.oid = v.objectID
+		case v.oid.compare(oidSysUpTime) == 0:
+		default:
+			t.varbinds = append(t.varbinds, v)
+		}
+	}
+	if t.oid == nil {
+		return errors.New("missing snmpTrapOID")
+	}
+	l.push(t)
+	return nil
+}
+
+func (l *trapListener) push(t trap) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.traps) >= l.maxTraps {
+		// the oldest traps are dropped
+		l.traps = l.traps[1:]
+		l.dropped++
+	}
+	l.traps = append(l.traps, t)
+}
+
+// drain returns the queued traps, and the number of traps that were dropped since the last drain
+func (l *trapListener) drain() ([]trap, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	traps, dropped := l.traps, l.dropped
+	l.traps = nil
+	l.dropped = 0
+	return traps, dropped
+}
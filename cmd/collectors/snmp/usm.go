@@ -0,0 +1,297 @@
+package snmp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des" //nolint:gosec
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// user is a SNMPv3 user of the user-based security model (RFC 3414), with the authentication protocols of RFC 3414
+// and RFC 7860, and the privacy protocols of RFC 3414 (DES) and RFC 3826 (AES-128)
+type user struct {
+	name         string
+	auth         string // MD5, SHA or SHA256, noAuth when empty
+	authPassword string
+	priv         string // DES or AES, noPriv when empty
+	privPassword string
+
+	mu   sync.Mutex
+	keys map[string]*localizedKeys // by engine ID
+	salt uint64
+}
+
+type localizedKeys struct {
+	auth []byte
+	priv []byte
+}
+
+func newUser(name, auth, authPassword, priv, privPassword string) (*user, error) {
+	u := &user{
+		name:         name,
+		auth:         strings.ToUpper(auth),
+		authPassword: authPassword,
+		priv:         strings.ToUpper(priv),
+		privPassword: privPassword,
+		keys:         make(map[string]*localizedKeys),
+	}
+	switch u.auth {
+	case "", "MD5", "SHA", "SHA256":
+	default:
+		return nil, fmt.Errorf("unsupported auth_protocol %s", auth)
+	}
+	switch u.priv {
+	case "", "DES", "AES":
+	default:
+		return nil, fmt.Errorf("unsupported priv_protocol %s", priv)
+	}
+	if u.auth == "" && u.priv != "" {
+		return nil, errors.New("priv_protocol requires an auth_protocol")
+	}
+	if u.auth != "" && len(u.authPassword) < 8 {
+		return nil, errors.New("the authentication password must have at least 8 characters")
+	}
+	if u.priv != "" && len(u.privPassword) < 8 {
+		return nil, errors.New("the privacy password must have at least 8 characters")
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	u.salt = binary.BigEndian.Uint64(b[:])
+	return u, nil
+}
+
+// flags returns the security level of the messages of the user
+func (u *user) flags() byte {
+	var flags byte
+	if u.auth != "" {
+		flags |= flagAuth
+	}
+	if u.priv != "" {
+		flags |= flagPriv
+	}
+	return flags
+}
+
+func (u *user) hash() func() hash.Hash {
+	switch u.auth {
+	case "MD5":
+		return md5.New
+	case "SHA":
+		return sha1.New
+	}
+	return sha256.New
+}
+
+// digestSize is the size of the truncated HMAC of the authentication parameters
+func (u *user) digestSize() int {
+	if u.auth == "SHA256" {
+		return 24
+	}
+	return 12
+}
+
+// localize returns the keys of the user localized for the engine ID (RFC 3414 2.6), the keys are computed once
+func (u *user) localize(engineID []byte) *localizedKeys {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if k, ok := u.keys[string(engineID)]; ok {
+		return k
+	}
+	k := &localizedKeys{}
+	if u.auth != "" {
+		k.auth = passwordToKey(u.hash(), u.authPassword, engineID)
+	}
+	if u.priv != "" {
+		k.priv = passwordToKey(u.hash(), u.privPassword, engineID)
+	}
+	u.keys[string(engineID)] = k
+	return k
+}
+
+// passwordToKey derives the key of a password (RFC 3414 A.2) and localizes it for the engine ID
+func passwordToKey(h func() hash.Hash, password string, engineID []byte) []byte {
+	const size = 1024 * 1024
+	ku := h()
+	buf := make([]byte, 64)
+	for n := 0; n < size; n += len(buf) {
+		for i := range buf {
+			buf[i] = password[(n+i)%len(password)]
+		}
+		ku.Write(buf)
+	}
+	key := ku.Sum(nil)
+
+	kul := h()
+	kul.Write(key)
+	kul.Write(engineID)
+	kul.Write(key)
+	return kul.Sum(nil)
+}
+
+// digest returns the truncated HMAC of a message, computed with zeros in its authentication parameters
+func (u *user) digest(keys *localizedKeys, msg []byte) []byte {
+	mac := hmac.New(u.hash(), keys.auth)
+	mac.Write(msg)
+	return mac.Sum(nil)[:u.digestSize()]
+}
+
+// sign replaces the placeholder of the authentication parameters at offset with the digest of the message
+func (u *user) sign(keys *localizedKeys, msg []byte, offset int) {
+	copy(msg[offset:], u.digest(keys, msg))
+}
+
+// verify verifies the digest of a decoded message
+func (u *user) verify(keys *localizedKeys, msg []byte, m *message) error {
+	s := m.security
+	if len(s.authParams) != u.digestSize() || s.authOffset+len(s.authParams) > len(msg) {
+		return errors.New("invalid authentication parameters")
+	}
+	unsigned := make([]byte, len(msg))
+	copy(unsigned, msg)
+	for i := range s.authParams {
+		unsigned[s.authOffset+i] = 0
+	}
+	if subtle.ConstantTimeCompare(u.digest(keys, unsigned), s.authParams) != 1 {
+		return errors.New("wrong digest")
+	}
+	return nil
+}
+
+// encrypt encrypts the scoped PDU of a message, and returns the privacy parameters, the salt of the encryption
+func (u *user) encrypt(keys *localizedKeys, boots, engineTime int32, plaintext []byte) ([]byte, []byte, error) {
+	u.mu.Lock()
+	u.salt++
+	salt := u.salt
+	u.mu.Unlock()
+
+	privParams := make([]byte, 8)
+	switch u.priv {
+	case "DES":
+		binary.BigEndian.PutUint32(privParams, uint32(boots))
+		binary.BigEndian.PutUint32(privParams[4:], uint32(salt))
+		block, err := des.NewCipher(keys.priv[:8]) //nolint:gosec
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = keys.priv[8+i] ^ privParams[i]
+		}
+		padded := make([]byte, (len(plaintext)+7)/8*8)
+		copy(padded, plaintext)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+		return padded, privParams, nil
+	default:
+		binary.BigEndian.PutUint64(privParams, salt)
+		block, err := aes.NewCipher(keys.priv[:16])
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime, privParams)).XORKeyStream(ciphertext, plaintext)
+		return ciphertext, privParams, nil
+	}
+}
+
+// decrypt decrypts the scoped PDU of a message
+func (u *user) decrypt(keys *localizedKeys, m *message) ([]byte, error) {
+	s := m.security
+	if len(s.privParams) != 8 {
+		return nil, errors.New("invalid privacy parameters")
+	}
+	switch u.priv {
+	case "DES":
+		if len(m.encrypted)%8 != 0 {
+			return nil, errors.New("invalid length of the encrypted PDU")
+		}
+		block, err := des.NewCipher(keys.priv[:8]) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = keys.priv[8+i] ^ s.privParams[i]
+		}
+		plaintext := make([]byte, len(m.encrypted))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, m.encrypted)
+		return plaintext, nil
+	default:
+		block, err := aes.NewCipher(keys.priv[:16])
+		if err != nil {
+			return nil, err
+		}
+		plaintext := make([]byte, len(m.encrypted))
+		cipher.NewCFBDecrypter(block, aesIV(s.boots, s.time, s.privParams)).XORKeyStream(plaintext, m.encrypted)
+		return plaintext, nil
+	}
+}
+
+// aesIV is the initialization vector of AES (RFC 3826 3.1.2.1)
+func aesIV(boots, engineTime int32, salt []byte) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// seal encodes a SNMPv3 message of the user: the scoped PDU is encrypted, and the message is signed, according to
+// the security level of the user
+func (u *user) seal(m *message) ([]byte, error) {
+	m.flags |= u.flags()
+	m.security.user = u.name
+	keys := u.localize(m.security.engineID)
+	if u.priv != "" {
+		var err error
+		if m.encrypted, m.security.privParams, err = u.encrypt(keys, m.security.boots, m.security.time,
+			m.scopedPDU()); err != nil {
+			return nil, err
+		}
+	}
+	if u.auth != "" {
+		m.security.authParams = make([]byte, u.digestSize())
+	}
+	b, offset := m.encode()
+	if u.auth != "" {
+		u.sign(keys, b, offset)
+	}
+	return b, nil
+}
+
+// open verifies and decrypts a SNMPv3 message received from the engine of the message
+func (u *user) open(b []byte, m *message) error {
+	if m.security.user != u.name {
+		return fmt.Errorf("unknown user %s", m.security.user)
+	}
+	if u.auth != "" && m.flags&flagAuth == 0 {
+		return errors.New("message is not authenticated")
+	}
+	if u.priv == "" && m.flags&flagPriv != 0 {
+		return errors.New("message is encrypted without privacy protocol")
+	}
+	keys := u.localize(m.security.engineID)
+	if u.auth != "" {
+		if err := u.verify(keys, b, m); err != nil {
+			return err
+		}
+	}
+	if m.flags&flagPriv != 0 {
+		plaintext, err := u.decrypt(keys, m)
+		if err != nil {
+			return err
+		}
+		return m.decodeScopedPDU(plaintext)
+	}
+	return nil
+}
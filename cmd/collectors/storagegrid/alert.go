@@ -0,0 +1,147 @@
+package storagegrid
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/tidwall/gjson"
+	"strings"
+	"time"
+)
+
+const (
+	alertQuery      = "grid/alerts"
+	activeMetric    = "active"
+	timestampMetric = "timestamp"
+)
+
+// isAlertQuery returns true when the object polls the grid alerts. Alerts are kept between polls,
+// like the bookend events of the Ems collector, so that cleared alerts are exported once with active=0
+func (s *StorageGrid) isAlertQuery() bool {
+	return s.Props.Query == alertQuery || strings.HasPrefix(s.Props.Query, alertQuery+"?")
+}
+
+func (s *StorageGrid) initAlerts() error {
+	mat := s.Matrix[s.Object]
+	for _, name := range []string{activeMetric, timestampMetric} {
+		if _, err := mat.NewMetricFloat64(name); err != nil {
+			return err
+		}
+	}
+	if a := s.Params.GetChildS("audit"); a != nil {
+		audit, err := newAuditLog(a)
+		if err != nil {
+			return err
+		}
+		s.audit = audit
+		s.Matrix[auditObject] = audit.matrix(s.Props.Object)
+	}
+	return nil
+}
+
+func (s *StorageGrid) pollAlerts() (map[string]*matrix.Matrix, error) {
+	var (
+		count        uint64
+		apiD, parseD time.Duration
+		startTime    time.Time
+		err          error
+		records      []gjson.Result
+	)
+
+	s.Logger.Debug().Msg("starting alert poll")
+	startTime = time.Now()
+
+	if err = s.getRest(s.Props.Query, &records); err != nil {
+		return nil, err
+	}
+
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	count = s.handleAlerts(records)
+	if s.audit != nil {
+		n, err := s.audit.poll(s.Matrix[auditObject])
+		if err != nil {
+			s.Logger.Warn().Err(err).Str("path", s.audit.path).Msg("failed to read audit log")
+		}
+		count += n
+	}
+	parseD = time.Since(startTime)
+
+	numRecords := len(s.Matrix[s.Object].GetInstances())
+
+	_ = s.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = s.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = s.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = s.Metadata.LazySetValueInt64("instances", "data", int64(numRecords))
+	s.AddCollectCount(count)
+
+	return s.Matrix, nil
+}
+
+// handleAlerts updates the alert matrix with the active alerts of the grid. An alert that is no longer active
+// is exported with active=0 and removed on the next poll.
+func (s *StorageGrid) handleAlerts(records []gjson.Result) uint64 {
+	var count uint64
+
+	mat := s.Matrix[s.Object]
+	active := mat.GetMetric(activeMetric)
+	timestamp := mat.GetMetric(timestampMetric)
+
+	// alerts cleared in the previous poll have been exported, remove them
+	for key, instance := range mat.GetInstances() {
+		if v, ok := active.GetValueFloat64(instance); ok && v == 0 {
+			mat.RemoveInstance(key)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, alert := range records {
+		if !alert.IsObject() {
+			s.Logger.Warn().Str("type", alert.Type.String()).Msg("Alert is not object, skipping")
+			continue
+		}
+		if alert.Get("status").String() == "resolved" {
+			continue
+		}
+		instanceKey := s.instanceKey(alert)
+		if instanceKey == "" {
+			s.Logger.Trace().Msg("Instance key is empty, skipping")
+			continue
+		}
+
+		instance := mat.GetInstance(instanceKey)
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				s.Logger.Error().Err(err).Str("instanceKey", instanceKey).Send()
+				continue
+			}
+		}
+		seen[instanceKey] = true
+		instance.SetExportable(true)
+		count += s.setLabels(instance, instanceKey, alert)
+
+		triggeredAt := time.Now()
+		if t, err := time.Parse(time.RFC3339, alert.Get("triggeredAt").String()); err == nil {
+			triggeredAt = t
+		}
+		if err := active.SetValueFloat64(instance, 1); err != nil {
+			s.Logger.Error().Err(err).Str("key", activeMetric).Msg("Unable to set float key on metric")
+		}
+		if err := timestamp.SetValueFloat64(instance, float64(triggeredAt.UnixMicro())); err != nil {
+			s.Logger.Error().Err(err).Str("key", timestampMetric).Msg("Unable to set timestamp on metric")
+		}
+		count += 2
+	}
+
+	for key, instance := range mat.GetInstances() {
+		if seen[key] {
+			continue
+		}
+		if err := active.SetValueFloat64(instance, 0); err != nil {
+			s.Logger.Error().Err(err).Str("key", activeMetric).Msg("Unable to set float key on metric")
+		}
+		s.Logger.Debug().Str("instanceKey", key).Str("alert", instance.GetLabel("alert")).Msg("alert cleared")
+		count++
+	}
+	return count
+}
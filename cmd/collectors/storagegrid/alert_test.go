@@ -0,0 +1,130 @@
+package storagegrid
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/tidwall/gjson"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newAlert(t *testing.T, auditPath string) *StorageGrid {
+	params, err := tree.ImportYaml("../../../conf/storagegrid/11.6.0/alert.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auditPath != "" {
+		params.NewChildS("audit", "").NewChildS("path", auditPath)
+	}
+	s := &StorageGrid{AbstractCollector: collector.New("StorageGrid", "Alert", options.New(), params, nil)}
+	s.InitProp()
+	s.Matrix = map[string]*matrix.Matrix{s.Object: matrix.New("StorageGrid.Alert", "alert", "Alert")}
+	if err = s.InitCache(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func alerts(t *testing.T, keep int) []gjson.Result {
+	b, err := os.ReadFile("testdata/alerts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gjson.GetBytes(b, "data").Array()[:keep]
+}
+
+// active returns the active metric of the exported alerts by node
+func active(s *StorageGrid) map[string]float64 {
+	values := make(map[string]float64)
+	mat := s.Matrix[s.Object]
+	for _, instance := range mat.GetInstances() {
+		if v, ok := mat.GetMetric(activeMetric).GetValueFloat64(instance); ok && instance.IsExportable() {
+			values[instance.GetLabel("node")] = v
+		}
+	}
+	return values
+}
+
+func TestAlerts(t *testing.T) {
+	s := newAlert(t, "")
+	if !s.isAlertQuery() {
+		t.Fatalf("query %s should poll the alerts", s.Props.Query)
+	}
+
+	s.handleAlerts(alerts(t, 2))
+	got := active(s)
+	if len(got) != 2 || got["DC1-S1"] != 1 || got["DC1-S2"] != 1 {
+		t.Errorf("got=%v, want 2 active alerts", got)
+	}
+	instance := s.Matrix[s.Object].GetInstance("01H1XW2Q9M5PZ6T0K3N8B7C4DE")
+	if instance.GetLabel("alert") != "NodeMemoryLow" || instance.GetLabel("severity") != "critical" {
+		t.Errorf("labels got=%v", instance.GetLabels())
+	}
+	if ts, _ := s.Matrix[s.Object].GetMetric(timestampMetric).GetValueFloat64(instance); ts != 1685614530000000 {
+		t.Errorf("timestamp got=%f", ts)
+	}
+
+	// the cleared alert is exported once with active=0
+	s.handleAlerts(alerts(t, 1))
+	if got = active(s); len(got) != 2 || got["DC1-S1"] != 1 || got["DC1-S2"] != 0 {
+		t.Errorf("got=%v, want the alert of DC1-S2 cleared", got)
+	}
+	s.handleAlerts(alerts(t, 1))
+	if got = active(s); len(got) != 1 || got["DC1-S1"] != 1 {
+		t.Errorf("got=%v, want the cleared alert removed", got)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	put := `2023-06-01T10:15:30.123456 [AUDT:[RSLT(FC32):SUCS][S3BK(CSTR):"b1"][ATYP(FC32):SPUT][AMID(FC32):S3RQ]]` + "\n"
+	denied := `2023-06-01T10:15:31.123456 [AUDT:[RSLT(FC32):EACC][S3BK(CSTR):"b1"][ATYP(FC32):SGET][AMID(FC32):S3RQ]]` + "\n"
+	write := func(name string, lines ...string) {
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			_, _ = f.WriteString(line)
+		}
+		_ = f.Close()
+	}
+	counts := func(s *StorageGrid) map[string]float64 {
+		if _, err := s.audit.poll(s.Matrix[auditObject]); err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]float64)
+		mat := s.Matrix[auditObject]
+		for key, instance := range mat.GetInstances() {
+			values[key], _ = mat.GetMetric(messagesMetric).GetValueFloat64(instance)
+		}
+		return values
+	}
+
+	// the messages logged before the first poll are not counted
+	write(path, put)
+	s := newAlert(t, path)
+	defer s.audit.close()
+	if got := counts(s); len(got) != 0 {
+		t.Errorf("got=%v, want no counts", got)
+	}
+
+	// the partial line is counted once it is complete
+	write(path, put, denied, put[:20])
+	if got := counts(s); len(got) != 2 || got["SPUT-SUCS"] != 1 || got["SGET-EACC"] != 1 {
+		t.Errorf("got=%v", got)
+	}
+
+	// the messages of the rotated file are counted, then the new file is read from the start
+	write(path, put[20:])
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(path, put)
+	if got := counts(s); got["SPUT-SUCS"] != 3 {
+		t.Errorf("got=%v, want 3 SPUT", got)
+	}
+}
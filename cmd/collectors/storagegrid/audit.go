@@ -0,0 +1,210 @@
+package storagegrid
+
+import (
+	"bytes"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	auditObject    = "audit"
+	messagesMetric = "messages"
+)
+
+var (
+	auditTypeRegex   = regexp.MustCompile(`\[ATYP\(FC32\):(\w+)]`)
+	auditResultRegex = regexp.MustCompile(`\[RSLT\(FC32\):(\w+)]`)
+)
+
+type auditKey struct {
+	messageType string
+	result      string
+}
+
+// auditLog tails the audit log of an admin node, for example through the NFS audit share, and counts the audit
+// messages by type (ATYP) and result (RSLT). The counts are cumulative since the poller started.
+//
+//	audit:
+//	  path: /mnt/storagegrid/audit/export/audit.log
+//	  types:    # audit message types to count, all types when empty
+//	    - SPUT
+//	    - SDEL
+type auditLog struct {
+	path    string
+	types   map[string]bool
+	file    *os.File
+	opened  bool
+	partial []byte
+	counts  map[auditKey]float64
+}
+
+func newAuditLog(params *node.Node) (*auditLog, error) {
+	a := &auditLog{
+		path:   params.GetChildContentS("path"),
+		types:  make(map[string]bool),
+		counts: make(map[auditKey]float64),
+	}
+	if a.path == "" {
+		return nil, errs.New(errs.ErrMissingParam, "audit path")
+	}
+	if types := params.GetChildS("types"); types != nil {
+		for _, t := range types.GetAllChildContentS() {
+			a.types[strings.ToUpper(t)] = true
+		}
+	}
+	return a, nil
+}
+
+func (a *auditLog) matrix(object string) *matrix.Matrix {
+	mat := matrix.New(object+"."+auditObject, auditObject, auditObject)
+	_, _ = mat.NewMetricFloat64(messagesMetric)
+	exportOptions := node.NewS("export_options")
+	instanceKeys := exportOptions.NewChildS("instance_keys", "")
+	instanceKeys.NewChildS("", "type")
+	instanceKeys.NewChildS("", "result")
+	mat.SetExportOptions(exportOptions)
+	return mat
+}
+
+// poll reads the messages logged since the last poll and sets the counts in the matrix.
+// The counts are set even when the audit log could not be read, and the number of counts is returned.
+func (a *auditLog) poll(mat *matrix.Matrix) (uint64, error) {
+	var count uint64
+	err := a.read()
+
+	metric := mat.GetMetric(messagesMetric)
+	for key, value := range a.counts {
+		instanceKey := key.messageType + "-" + key.result
+		instance := mat.GetInstance(instanceKey)
+		if instance == nil {
+			var err2 error
+			if instance, err2 = mat.NewInstance(instanceKey); err2 != nil {
+				continue
+			}
+			instance.SetLabel("type", key.messageType)
+			instance.SetLabel("result", key.result)
+		}
+		if metric.SetValueFloat64(instance, value) == nil {
+			count++
+		}
+	}
+	return count, err
+}
+
+func (a *auditLog) read() error {
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			return err
+		}
+	}
+	if err := a.readFile(); err != nil {
+		return err
+	}
+
+	// the audit log is rotated by renaming it, continue with the new file once the renamed one has been read
+	info, err := os.Stat(a.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// the new file has not been created yet
+			return nil
+		}
+		return err
+	}
+	current, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, current) {
+		a.close()
+		if err = a.open(); err != nil {
+			return err
+		}
+		return a.readFile()
+	}
+
+	// a truncated audit log is read from the start
+	offset, err := a.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if info.Size() < offset {
+		if _, err = a.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		a.partial = nil
+		return a.readFile()
+	}
+	return nil
+}
+
+// open opens the audit log. The first time, it starts at the end of the file, the messages logged before
+// the poller started are not counted.
+func (a *auditLog) open() error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	if !a.opened {
+		if _, err = file.Seek(0, io.SeekEnd); err != nil {
+			_ = file.Close()
+			return err
+		}
+		a.opened = true
+	}
+	a.file = file
+	return nil
+}
+
+func (a *auditLog) close() {
+	if a.file != nil {
+		_ = a.file.Close()
+	}
+	a.file = nil
+	a.partial = nil
+}
+
+func (a *auditLog) readFile() error {
+	data, err := io.ReadAll(a.file)
+	if err != nil {
+		a.close()
+		return err
+	}
+	data = append(a.partial, data...)
+	end := bytes.LastIndexByte(data, '\n')
+	// the last line is kept until it is complete
+	a.partial = bytes.Clone(data[end+1:])
+	if end < 0 {
+		return nil
+	}
+	for _, line := range bytes.Split(data[:end], []byte{'\n'}) {
+		messageType, result := parseAudit(line)
+		if messageType == "" {
+			continue
+		}
+		if len(a.types) > 0 && !a.types[messageType] {
+			continue
+		}
+		a.counts[auditKey{messageType: messageType, result: result}]++
+	}
+	return nil
+}
+
+// parseAudit returns the type and result of an audit message, for example
+// 2023-06-01T10:15:30.123456 [AUDT:[RSLT(FC32):SUCS][S3BK(CSTR):"bucket"][ATYP(FC32):SPUT][AMID(FC32):S3RQ]]
+func parseAudit(line []byte) (string, string) {
+	match := auditTypeRegex.FindSubmatch(line)
+	if match == nil {
+		return "", ""
+	}
+	result := "NONE"
+	if r := auditResultRegex.FindSubmatch(line); r != nil {
+		result = string(r[1])
+	}
+	return string(match[1]), result
+}
@@ -4,13 +4,23 @@ import (
 	"github.com/netapp/harvest/v2/cmd/collectors/storagegrid/rest"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
 	"github.com/tidwall/gjson"
+	"strconv"
+	"strings"
 )
 
+// settingLabels are the labels of the bucket settings, exported with the bucket_labels metric
+var settingLabels = []string{"versioning", "object_lock", "compliance", "replication"}
+
 type Bucket struct {
 	*plugin.AbstractPlugin
-	client *rest.Client
-	data   *matrix.Matrix
+	client      *rest.Client
+	data        *matrix.Matrix
+	settings    bool
+	currentVal  int
+	tenants     map[string]*rest.Client
+	bucketCache map[string]map[string]string // labels of the bucket settings by instance key
 }
 
 func New(p *plugin.AbstractPlugin) plugin.Plugin {
@@ -39,6 +49,26 @@ func (b *Bucket) Init() error {
 	_, _ = b.data.NewMetricFloat64("objects")
 	_, _ = b.data.NewMetricFloat64("bytes")
 
+	exportOptions := node.NewS("export_options")
+	instanceKeys := exportOptions.NewChildS("instance_keys", "")
+	instanceKeys.NewChildS("", "bucket")
+	instanceKeys.NewChildS("", "tenant")
+	instanceKeys.NewChildS("", "region")
+
+	// the settings of the buckets are read with the tenant management API, see rest.Client.Tenant
+	b.settings = b.Params.GetChildContentS("settings") == "true"
+	if b.settings {
+		instanceLabels := exportOptions.NewChildS("instance_labels", "")
+		for _, label := range settingLabels {
+			instanceLabels.NewChildS("", label)
+		}
+		b.tenants = make(map[string]*rest.Client)
+		b.bucketCache = make(map[string]map[string]string)
+		// refresh the settings every plugin interval, 30m by default
+		b.currentVal = b.SetPluginInterval()
+	}
+	b.data.SetExportOptions(exportOptions)
+
 	return nil
 }
 
@@ -59,6 +89,15 @@ func (b *Bucket) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error
 	// Set all global labels from Rest.go if already not exist
 	b.data.SetGlobalLabels(data.GetGlobalLabels())
 
+	refresh := false
+	if b.settings {
+		if b.currentVal >= b.PluginInvocationRate {
+			b.currentVal = 0
+			refresh = true
+		}
+		b.currentVal++
+	}
+
 	// request the buckets for each tenant
 	for instKey, inst := range data.GetInstances() {
 		href := "grid/accounts/" + instKey + "/usage?includeBucketDetail=true"
@@ -73,6 +112,10 @@ func (b *Bucket) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error
 			continue
 		}
 
+		if refresh {
+			b.updateSettings(instKey, tenantName)
+		}
+
 		for _, record := range records {
 			if !record.IsObject() {
 				b.Logger.Warn().Str("type", record.Type.String()).Msg("Bucket is not object, skipping")
@@ -94,6 +137,9 @@ func (b *Bucket) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error
 				bucketInstance.SetLabel("bucket", bucket)
 				bucketInstance.SetLabel("tenant", tenantName)
 				bucketInstance.SetLabel("region", region)
+				for label, value := range b.bucketCache[instanceKey] {
+					bucketInstance.SetLabel(label, value)
+				}
 				for metricKey, m := range b.data.GetMetrics() {
 					jsonKey := metricToJSON[metricKey]
 					if value := bucketJSON.Get(jsonKey); value.Exists() {
@@ -117,3 +163,57 @@ func (b *Bucket) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error
 
 	return []*matrix.Matrix{b.data}, nil
 }
+
+// updateSettings reads the versioning, object lock, compliance and replication settings of the buckets of a tenant
+func (b *Bucket) updateSettings(accountID string, tenantName string) {
+	client, ok := b.tenants[accountID]
+	if !ok {
+		client = b.client.Tenant(accountID)
+		b.tenants[accountID] = client
+	}
+
+	var containers []gjson.Result
+	if err := client.Fetch("org/containers?include=compliance,region", &containers); err != nil {
+		// the poller user may not exist in every tenant
+		b.Logger.Debug().Err(err).
+			Str("id", accountID).
+			Str("tenantName", tenantName).
+			Msg("Unable to fetch bucket settings")
+		return
+	}
+
+	// forget the buckets that were deleted
+	for key := range b.bucketCache {
+		if strings.HasPrefix(key, accountID+"#") {
+			delete(b.bucketCache, key)
+		}
+	}
+	for _, container := range containers {
+		bucket := container.Get("name").String()
+		if bucket == "" {
+			continue
+		}
+		labels := map[string]string{
+			"compliance": strconv.FormatBool(container.Get("compliance").Exists()),
+		}
+
+		if content, err := client.GetGridRest("org/containers/" + bucket + "/versioning"); err == nil {
+			versioning := gjson.GetBytes(content, "data")
+			switch {
+			case versioning.Get("versioningEnabled").Bool():
+				labels["versioning"] = "enabled"
+			case versioning.Get("versioningSuspended").Bool():
+				labels["versioning"] = "suspended"
+			default:
+				labels["versioning"] = "disabled"
+			}
+		}
+		if content, err := client.GetGridRest("org/containers/" + bucket + "/object-lock"); err == nil {
+			labels["object_lock"] = strconv.FormatBool(gjson.GetBytes(content, "data.enabled").Bool())
+		}
+		if content, err := client.GetGridRest("org/containers/" + bucket + "/replication"); err == nil {
+			labels["replication"] = strconv.FormatBool(gjson.GetBytes(content, "data.xml").String() != "")
+		}
+		b.bucketCache[accountID+"#"+bucket] = labels
+	}
+}
@@ -0,0 +1,101 @@
+package bucket
+
+import (
+	"encoding/json"
+	"github.com/netapp/harvest/v2/cmd/collectors/storagegrid/rest"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTenant returns a stand-in of the tenant management API of account 42, with the buckets b1 and b2
+func newTenant() *httptest.Server {
+	responses := map[string]string{
+		"/api/v3/org/containers":                `{"data": [{"name": "b1", "compliance": {"autoDelete": false}}, {"name": "b2"}]}`,
+		"/api/v3/org/containers/b1/versioning":  `{"data": {"versioningEnabled": true, "versioningSuspended": false}}`,
+		"/api/v3/org/containers/b2/versioning":  `{"data": {"versioningEnabled": false, "versioningSuspended": true}}`,
+		"/api/v3/org/containers/b1/object-lock": `{"data": {"enabled": true}}`,
+		"/api/v3/org/containers/b2/object-lock": `{"data": {"enabled": false}}`,
+		"/api/v3/org/containers/b1/replication": `{"data": {"xml": "<ReplicationConfiguration/>"}}`,
+		"/api/v3/org/containers/b2/replication": `{"data": {"xml": ""}}`,
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/authorize" {
+			var body struct {
+				AccountID string `json:"accountId"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.AccountID != "42" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code": 401}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": "tenant-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer tenant-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code": 401}`))
+			return
+		}
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code": 404}`))
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+}
+
+func newBucket(t *testing.T, addr string) *Bucket {
+	insecure := true
+	poller := &conf.Poller{Addr: addr, Username: "harvest", Password: "secret", UseInsecureTLS: &insecure}
+	client, err := rest.New(poller, time.Minute, auth.NewCredentials(poller, logging.Get()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.APIPath = "/api/v3"
+	return &Bucket{
+		AbstractPlugin: &plugin.AbstractPlugin{Logger: logging.Get()},
+		client:         client,
+		tenants:        make(map[string]*rest.Client),
+		bucketCache:    make(map[string]map[string]string),
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	server := newTenant()
+	defer server.Close()
+	b := newBucket(t, strings.TrimPrefix(server.URL, "https://"))
+
+	// the bucket of a deleted container is forgotten
+	b.bucketCache["42#deleted"] = map[string]string{"versioning": "enabled"}
+	b.updateSettings("42", "tenant1")
+
+	want := map[string]map[string]string{
+		"42#b1": {"versioning": "enabled", "object_lock": "true", "compliance": "true", "replication": "true"},
+		"42#b2": {"versioning": "suspended", "object_lock": "false", "compliance": "false", "replication": "false"},
+	}
+	if len(b.bucketCache) != len(want) {
+		t.Errorf("buckets got=%v, want=%v", b.bucketCache, want)
+	}
+	for key, labels := range want {
+		if got := b.bucketCache[key]; !maps.Equal(got, labels) {
+			t.Errorf("%s got=%v, want=%v", key, got, labels)
+		}
+	}
+
+	// the settings of a tenant without the poller user are skipped
+	b.updateSettings("7", "tenant2")
+	if len(b.bucketCache) != len(want) {
+		t.Errorf("buckets got=%d, want=%d", len(b.bucketCache), len(want))
+	}
+}
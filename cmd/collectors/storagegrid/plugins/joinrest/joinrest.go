@@ -12,7 +12,8 @@ import (
 type JoinRest struct {
 	*plugin.AbstractPlugin
 	client       *rest.Client
-	translateMap map[string]join
+	translateMap map[string][]join
+	allMetrics   []join // joins without metrics apply to all metrics
 	timesCalled  int
 	resourcesMap map[string]resourceMap
 }
@@ -35,6 +36,11 @@ plugins:
         with_prom: policy_id
         label_rest: name
         label_prom: policy
+      - rest: grid/node-health
+        join_rest: name
+        with_prom: node
+        label_rest: siteName
+        label_prom: site
 `
 
 func (t *JoinRest) Init() error {
@@ -50,7 +56,7 @@ func (t *JoinRest) Init() error {
 	t.resourcesMap = make(map[string]resourceMap)
 
 	// Read hidden plugin
-	t.translateMap = make(map[string]join)
+	t.translateMap = make(map[string][]join)
 	decoder := yaml.NewDecoder(strings.NewReader(joinTemplate))
 	var tm translatePlugin
 	err = decoder.Decode(&tm)
@@ -60,8 +66,11 @@ func (t *JoinRest) Init() error {
 	}
 	for _, p := range tm.Plugins {
 		for _, j := range p.Translate {
+			if len(j.Metrics) == 0 {
+				t.allMetrics = append(t.allMetrics, j)
+			}
 			for _, metric := range j.Metrics {
-				t.translateMap[metric] = j
+				t.translateMap[metric] = append(t.translateMap[metric], j)
 			}
 		}
 	}
@@ -80,54 +89,80 @@ func (t *JoinRest) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, err
 		// refresh cache
 		t.timesCalled = 0
 
-		for _, model := range t.translateMap {
-			bytes, err := t.client.GetGridRest(model.Rest)
-			if err != nil {
-				t.Logger.Error().Err(err).Str("rest", model.Rest).Msg("Failed to collect records from REST")
-				continue
+		fetched := make(map[string][]byte)
+		for _, model := range t.joins() {
+			bytes, ok := fetched[model.Rest]
+			if !ok {
+				var err error
+				bytes, err = t.client.GetGridRest(model.Rest)
+				if err != nil {
+					t.Logger.Error().Err(err).Str("rest", model.Rest).Msg("Failed to collect records from REST")
+					continue
+				}
+				fetched[model.Rest] = bytes
 			}
 			t.updateCache(model, &bytes)
 		}
 	}
 
-	for metricName, model := range t.translateMap {
-		m, ok := dataMap[metricName]
-		if !ok {
+	for metricName, m := range dataMap {
+		for _, model := range t.translateMap[metricName] {
+			t.join(metricName, m, model)
+		}
+		for _, model := range t.allMetrics {
+			t.join(metricName, m, model)
+		}
+	}
+	t.timesCalled++
+	return nil, nil
+}
+
+// joins returns each join once
+func (t *JoinRest) joins() []join {
+	seen := make(map[string]bool)
+	joins := make([]join, 0, len(t.allMetrics))
+	for _, models := range t.translateMap {
+		for _, model := range models {
+			if !seen[model.key()] {
+				seen[model.key()] = true
+				joins = append(joins, model)
+			}
+		}
+	}
+	return append(joins, t.allMetrics...)
+}
+
+func (t *JoinRest) join(metricName string, m *matrix.Matrix, model join) {
+	cache, ok := t.resourcesMap[model.key()]
+	if !ok {
+		t.Logger.Warn().
+			Str("metricName", metricName).
+			Str("rest", model.Rest).
+			Msg("Cache does not have resources for REST")
+		return
+	}
+	for _, instance := range m.GetInstances() {
+		label := instance.GetLabel(model.WithProm)
+		if label == "" {
+			t.Logger.Debug().
+				Str("metricName", metricName).
+				Str("withProm", model.WithProm).
+				Str("rest", model.Rest).
+				Msg("Instance label for withProm is empty. Ignoring")
 			continue
 		}
-		cache, ok := t.resourcesMap[model.Rest]
+		newLabel, ok := cache[label]
 		if !ok {
-			t.Logger.Warn().
+			t.Logger.Debug().
 				Str("metricName", metricName).
+				Str("withProm", model.WithProm).
+				Str("label", label).
 				Str("rest", model.Rest).
-				Msg("Cache does not have resources for REST")
+				Msg("Cache does not contain label. Ignoring")
 			continue
 		}
-		for _, instance := range m.GetInstances() {
-			label := instance.GetLabel(model.WithProm)
-			if label == "" {
-				t.Logger.Debug().
-					Str("metricName", metricName).
-					Str("withProm", model.WithProm).
-					Str("rest", model.Rest).
-					Msg("Instance label for withProm is empty. Ignoring")
-				continue
-			}
-			newLabel, ok := cache[label]
-			if !ok {
-				t.Logger.Debug().
-					Str("metricName", metricName).
-					Str("withProm", model.WithProm).
-					Str("label", label).
-					Str("rest", model.Rest).
-					Msg("Cache does not contain label. Ignoring")
-				continue
-			}
-			instance.SetLabel(model.LabelProm, newLabel)
-		}
+		instance.SetLabel(model.LabelProm, newLabel)
 	}
-	t.timesCalled++
-	return nil, nil
 }
 
 func (t *JoinRest) updateCache(model join, bytes *[]byte) {
@@ -142,10 +177,10 @@ func (t *JoinRest) updateCache(model join, bytes *[]byte) {
 		return
 	}
 	for i, k := range keys {
-		m, ok := t.resourcesMap[model.Rest]
+		m, ok := t.resourcesMap[model.key()]
 		if !ok {
 			m = make(map[string]string)
-			t.resourcesMap[model.Rest] = m
+			t.resourcesMap[model.key()] = m
 		}
 		m[k.String()] = vals[i].String()
 	}
@@ -177,6 +212,11 @@ type join struct {
 	LabelProm string   `yaml:"label_prom"`
 }
 
+// key identifies the cache of a join, joins of the same REST endpoint may join different labels
+func (j join) key() string {
+	return j.Rest + "#" + j.JoinRest + "#" + j.LabelRest
+}
+
 type plugins struct {
 	Translate []join `yaml:"JoinRest"`
 }
@@ -0,0 +1,39 @@
+package joinrest
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"os"
+	"testing"
+)
+
+func TestJoinSite(t *testing.T) {
+	j := &JoinRest{
+		AbstractPlugin: &plugin.AbstractPlugin{Logger: logging.Get(), PluginInvocationRate: 10},
+		translateMap:   make(map[string][]join),
+		resourcesMap:   make(map[string]resourceMap),
+	}
+	j.allMetrics = []join{{Rest: "grid/node-health", JoinRest: "name", WithProm: "node", LabelRest: "siteName", LabelProm: "site"}}
+	b, err := os.ReadFile("testdata/node-health.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.updateCache(j.allMetrics[0], &b)
+
+	mat := matrix.New("storagegrid.cpu", "storagegrid", "storagegrid")
+	for _, node := range []string{"DC1-ADM1", "DC2-S1", "DC3-S1"} {
+		instance, _ := mat.NewInstance(node)
+		instance.SetLabel("node", node)
+	}
+	if _, err = j.Run(map[string]*matrix.Matrix{"storagegrid_node_cpu_utilization_percentage": mat}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"DC1-ADM1": "DC1", "DC2-S1": "DC2", "DC3-S1": ""}
+	for node, site := range want {
+		if got := mat.GetInstance(node).GetLabel("site"); got != site {
+			t.Errorf("site of %s got=%s, want=%s", node, got, site)
+		}
+	}
+}
@@ -37,6 +37,8 @@ type Client struct {
 	logRest bool // used to log Rest request/response
 	APIPath string
 	auth    *auth.Credentials
+	// accountID is the tenant account the client logs in to, empty for the grid
+	accountID string
 }
 
 type Cluster struct {
@@ -322,8 +324,26 @@ func (c *Client) SetVersion(v string) error {
 }
 
 type authBody struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	AccountID string `json:"accountId,omitempty"`
+}
+
+// Tenant returns a client of the tenant management API of accountID, e.g. org/containers. The client logs in to
+// the tenant with the credentials of the poller, which requires a local or federated user of the same name in the
+// tenant account.
+func (c *Client) Tenant(accountID string) *Client {
+	return &Client{
+		client:    c.client,
+		Logger:    c.Logger,
+		baseURL:   c.baseURL,
+		Cluster:   c.Cluster,
+		Timeout:   c.Timeout,
+		logRest:   c.logRest,
+		APIPath:   c.APIPath,
+		auth:      c.auth,
+		accountID: accountID,
+	}
 }
 
 func (c *Client) fetchTokenWithAuthRetry() error {
@@ -343,8 +363,9 @@ func (c *Client) fetchTokenWithAuthRetry() error {
 			return err
 		}
 		authB := authBody{
-			Username: pollerAuth.Username,
-			Password: pollerAuth.Password,
+			Username:  pollerAuth.Username,
+			Password:  pollerAuth.Password,
+			AccountID: c.accountID,
 		}
 		postBody, err := json.Marshal(authB)
 		if err != nil {
@@ -43,6 +43,7 @@ type StorageGrid struct {
 	*collector.AbstractCollector
 	client *srest.Client
 	Props  *prop
+	audit  *auditLog
 }
 
 func init() {
@@ -96,6 +97,9 @@ func (s *StorageGrid) InitMatrix() error {
 			mat.SetGlobalLabel(l.GetNameS(), l.GetContentS())
 		}
 	}
+	if audit, ok := s.Matrix[auditObject]; ok {
+		audit.SetGlobalLabels(mat.GetGlobalLabels())
+	}
 
 	return nil
 }
@@ -124,6 +128,12 @@ func (s *StorageGrid) InitCache() error {
 	}
 	s.ParseCounters(counters, s.Props)
 
+	if s.isAlertQuery() {
+		if err := s.initAlerts(); err != nil {
+			return err
+		}
+	}
+
 	s.Logger.Debug().
 		Strs("extracted Instance Keys", s.Props.InstanceKeys).
 		Int("numMetrics", len(s.Props.Metrics)).
@@ -137,6 +147,9 @@ func (s *StorageGrid) PollData() (map[string]*matrix.Matrix, error) {
 	if s.Props.Query == "prometheus" {
 		return s.pollPrometheusMetrics()
 	}
+	if s.isAlertQuery() {
+		return s.pollAlerts()
+	}
 	return s.pollRest()
 }
 
@@ -311,18 +324,7 @@ func (s *StorageGrid) handleResults(result []gjson.Result) uint64 {
 			continue
 		}
 
-		// extract instance key(s)
-		for _, k := range s.Props.InstanceKeys {
-			value := instanceData.Get(k)
-			if value.Exists() {
-				instanceKey += value.String()
-			} else {
-				s.Logger.Warn().Str("key", k).Msg("skip instance, missing key")
-				break
-			}
-		}
-
-		if instanceKey == "" {
+		if instanceKey = s.instanceKey(instanceData); instanceKey == "" {
 			s.Logger.Trace().Msg("Instance key is empty, skipping")
 			continue
 		}
@@ -336,27 +338,7 @@ func (s *StorageGrid) handleResults(result []gjson.Result) uint64 {
 			}
 		}
 
-		for label, display := range s.Props.InstanceLabels {
-			value := instanceData.Get(label)
-			if value.Exists() {
-				if value.IsArray() {
-					var labelArray []string
-					for _, r := range value.Array() {
-						labelString := r.String()
-						labelArray = append(labelArray, labelString)
-					}
-					instance.SetLabel(display, strings.Join(labelArray, ","))
-				} else {
-					instance.SetLabel(display, value.String())
-				}
-				count++
-			} else {
-				s.Logger.Trace().
-					Str("instanceKey", instanceKey).
-					Str("label", label).
-					Msg("Missing label value")
-			}
-		}
+		count += s.setLabels(instance, instanceKey, instanceData)
 
 		for _, metric := range s.Props.Metrics {
 			metr, ok := mat.GetMetrics()[metric.Name]
@@ -394,6 +376,47 @@ func (s *StorageGrid) handleResults(result []gjson.Result) uint64 {
 	return count
 }
 
+// instanceKey joins the instance keys of a record, it returns an empty string when a key is missing
+func (s *StorageGrid) instanceKey(instanceData gjson.Result) string {
+	var instanceKey string
+	for _, k := range s.Props.InstanceKeys {
+		value := instanceData.Get(k)
+		if !value.Exists() {
+			s.Logger.Warn().Str("key", k).Msg("skip instance, missing key")
+			return ""
+		}
+		instanceKey += value.String()
+	}
+	return instanceKey
+}
+
+// setLabels sets the instance labels of a record and returns the number of labels that were set
+func (s *StorageGrid) setLabels(instance *matrix.Instance, instanceKey string, instanceData gjson.Result) uint64 {
+	var count uint64
+	for label, display := range s.Props.InstanceLabels {
+		value := instanceData.Get(label)
+		if value.Exists() {
+			if value.IsArray() {
+				var labelArray []string
+				for _, r := range value.Array() {
+					labelString := r.String()
+					labelArray = append(labelArray, labelString)
+				}
+				instance.SetLabel(display, strings.Join(labelArray, ","))
+			} else {
+				instance.SetLabel(display, value.String())
+			}
+			count++
+		} else {
+			s.Logger.Trace().
+				Str("instanceKey", instanceKey).
+				Str("label", label).
+				Msg("Missing label value")
+		}
+	}
+	return count
+}
+
 func (s *StorageGrid) initClient() error {
 	var err error
 
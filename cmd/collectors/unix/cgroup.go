@@ -0,0 +1,258 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package unix
+
+import (
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultContainerCgroups - cgroups of containers, named after the 64 hex digits of their ID by Docker,
+// containerd, CRI-O and Podman, e.g. system.slice/docker-<id>.scope or kubepods/burstable/pod<uid>/<id>
+const DefaultContainerCgroups = `[0-9a-f]{64}(\.scope)?$`
+
+var containerID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// unlimited - memory limit of cgroup v1 without limit, rounded to a page
+const unlimited = math.MaxInt64 / 4096 * 4096
+
+// Cgroups - stats about resource usage of the containers of the host, parsed from the cgroup filesystem
+// both the unified hierarchy (v2) and the controller hierarchies (v1) are supported
+type Cgroups struct {
+	root       string
+	filter     *regexp.Regexp
+	containers map[string]*Stats
+	cpu        map[string]float64 // CPU time of the previous reload, to calculate cpu_percent
+	timestamp  time.Time
+}
+
+// NewCgroups - returns an instance of Cgroups, for the cgroups under root that match filter
+func NewCgroups(root string, filter *regexp.Regexp) *Cgroups {
+	return &Cgroups{root: root, filter: filter, cpu: make(map[string]float64)}
+}
+
+// Reload - refresh the stats of the containers
+func (c *Cgroups) Reload() error {
+	var err error
+
+	v2 := true
+	if _, err = os.Stat(path.Join(c.root, "cgroup.controllers")); err != nil {
+		v2 = false
+	}
+
+	// cgroups v1 are found in the hierarchy of the cpuacct controller, mounted with the cpu controller on most
+	// distributions: /sys/fs/cgroup/cpuacct -> cpu,cpuacct
+	hierarchy := c.root
+	if !v2 {
+		if hierarchy, err = filepath.EvalSymlinks(path.Join(c.root, "cpuacct")); err != nil {
+			return errs.New(ErrFileRead, "cgroup: "+err.Error())
+		}
+	}
+
+	var cgroups []string
+	err = filepath.WalkDir(hierarchy, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// cgroups of containers that stopped during the walk
+			return nil //nolint:nilerr
+		}
+		if d.IsDir() && p != hierarchy {
+			rel, _ := filepath.Rel(hierarchy, p)
+			if c.filter.MatchString(filepath.ToSlash(rel)) {
+				cgroups = append(cgroups, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errs.New(ErrFileRead, "cgroup: "+err.Error())
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(c.timestamp).Seconds()
+	prevCPU := c.cpu
+	c.cpu = make(map[string]float64)
+	c.containers = make(map[string]*Stats)
+
+	for _, cgroup := range cgroups {
+		container := newStats()
+		container.labels["cgroup"] = cgroup
+		container.labels["id"] = containerID.FindString(cgroup)
+		container.labels["container"] = containerName(cgroup)
+
+		if v2 {
+			c.loadV2(path.Join(c.root, cgroup), container.values)
+		} else {
+			c.loadV1(cgroup, container.values)
+		}
+		if len(container.values) == 0 {
+			continue
+		}
+
+		if cpu, ok := container.values["cpu_time"]; ok {
+			c.cpu[cgroup] = cpu
+			if prev, ok := prevCPU[cgroup]; ok && elapsed > 0 && cpu >= prev {
+				container.values["cpu_percent"] = (cpu - prev) / elapsed * 100
+			}
+		}
+		c.containers[cgroup] = container
+	}
+	c.timestamp = now
+	return nil
+}
+
+// containerName - short ID of the container, like docker ps, or the name of the cgroup
+func containerName(cgroup string) string {
+	if id := containerID.FindString(cgroup); id != "" {
+		return id[:12]
+	}
+	return strings.TrimSuffix(path.Base(cgroup), ".scope")
+}
+
+// loadV2 - stats of a cgroup of the unified hierarchy, controllers that are not enabled are skipped
+func (c *Cgroups) loadV2(dir string, values map[string]float64) {
+
+	// example: usage_usec 7351946
+	for key, num := range readKeyValues(path.Join(dir, "cpu.stat")) {
+		switch key {
+		case "usage_usec":
+			values["cpu_time"] = num / 1e6
+		case "user_usec":
+			values["cpu_user_time"] = num / 1e6
+		case "system_usec":
+			values["cpu_system_time"] = num / 1e6
+		case "throttled_usec":
+			values["cpu_throttled_time"] = num / 1e6
+		}
+	}
+
+	if num, ok := readValue(path.Join(dir, "memory.current")); ok {
+		values["memory_used"] = num
+	}
+	// "max" without limit
+	if num, ok := readValue(path.Join(dir, "memory.max")); ok {
+		values["memory_limit"] = num
+	}
+	if num, ok := readValue(path.Join(dir, "pids.current")); ok {
+		values["pids"] = num
+	}
+
+	// a line for each device, example:
+	// 8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+	if data, err := os.ReadFile(path.Join(dir, "io.stat")); err == nil {
+		names := map[string]string{"rbytes": "read_bytes", "wbytes": "write_bytes", "rios": "reads", "wios": "writes"}
+		for _, name := range names {
+			values[name] = 0
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			for _, field := range strings.Fields(line) {
+				key, value, _ := strings.Cut(field, "=")
+				if name, ok := names[key]; ok {
+					if num, err := strconv.ParseFloat(value, 64); err == nil {
+						values[name] += num
+					}
+				}
+			}
+		}
+	}
+}
+
+// loadV1 - stats of a cgroup in the hierarchies of the controllers, controllers that are not mounted are skipped
+func (c *Cgroups) loadV1(cgroup string, values map[string]float64) {
+
+	cpuacct := path.Join(c.root, "cpuacct", cgroup)
+	if num, ok := readValue(path.Join(cpuacct, "cpuacct.usage")); ok {
+		values["cpu_time"] = num / 1e9
+	}
+	// in USER_HZ, example: user 5433
+	for key, num := range readKeyValues(path.Join(cpuacct, "cpuacct.stat")) {
+		switch key {
+		case "user":
+			values["cpu_user_time"] = num / clkTck
+		case "system":
+			values["cpu_system_time"] = num / clkTck
+		}
+	}
+	for key, num := range readKeyValues(path.Join(c.root, "cpu", cgroup, "cpu.stat")) {
+		if key == "throttled_time" {
+			values["cpu_throttled_time"] = num / 1e9
+		}
+	}
+
+	memory := path.Join(c.root, "memory", cgroup)
+	if num, ok := readValue(path.Join(memory, "memory.usage_in_bytes")); ok {
+		values["memory_used"] = num
+	}
+	if num, ok := readValue(path.Join(memory, "memory.limit_in_bytes")); ok && num < unlimited {
+		values["memory_limit"] = num
+	}
+	if num, ok := readValue(path.Join(c.root, "pids", cgroup, "pids.current")); ok {
+		values["pids"] = num
+	}
+
+	// a line for each device and operation, example: 8:0 Read 1459200
+	blkio := path.Join(c.root, "blkio", cgroup)
+	for file, names := range map[string][2]string{
+		"blkio.throttle.io_service_bytes": {"read_bytes", "write_bytes"},
+		"blkio.throttle.io_serviced":      {"reads", "writes"},
+	} {
+		data, err := os.ReadFile(path.Join(blkio, file))
+		if err != nil {
+			continue
+		}
+		values[names[0]] = 0
+		values[names[1]] = 0
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			num, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				continue
+			}
+			switch fields[1] {
+			case "Read":
+				values[names[0]] += num
+			case "Write":
+				values[names[1]] += num
+			}
+		}
+	}
+}
+
+// readValue - reads a file of a single number, like memory.current
+func readValue(file string) (float64, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	num, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	return num, err == nil
+}
+
+// readKeyValues - reads a file of keys and numbers, like cpu.stat
+func readKeyValues(file string) map[string]float64 {
+	values := make(map[string]float64)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return values
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			if num, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				values[fields[0]] = num
+			}
+		}
+	}
+	return values
+}
@@ -11,4 +11,5 @@ var (
 	ErrFieldNotFound   = errors.New("field not found")
 	ErrFieldValue      = errors.New("field_value")
 	ErrFileRead        = errors.New("read file")
+	ErrEmptyFilesystem = errors.New("empty filesystem")
 )
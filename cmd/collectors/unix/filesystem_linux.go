@@ -0,0 +1,31 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package unix
+
+import "syscall"
+
+// statfs - disk usage of the filesystem mounted at mountPoint, in bytes and inodes
+func statfs(mountPoint string, values map[string]float64) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &st); err != nil {
+		return err
+	}
+	if st.Blocks == 0 {
+		return ErrEmptyFilesystem
+	}
+	bsize := float64(st.Bsize)
+	size := float64(st.Blocks) * bsize
+	free := float64(st.Bfree) * bsize
+	available := float64(st.Bavail) * bsize
+	values["size"] = size
+	values["free"] = free
+	values["available"] = available
+	values["used"] = size - free
+	// like df, the blocks reserved for root are not available to users
+	values["used_percent"] = (size - free) / (size - free + available) * 100
+	values["files"] = float64(st.Files)
+	values["files_free"] = float64(st.Ffree)
+	return nil
+}
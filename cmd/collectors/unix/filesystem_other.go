@@ -0,0 +1,17 @@
+//go:build !linux
+
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package unix
+
+import (
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"runtime"
+)
+
+// statfs - disk usage of filesystems is only collected on Linux
+func statfs(_ string, _ map[string]float64) error {
+	return errs.New(errs.ErrImplement, "filesystem metrics on "+runtime.GOOS)
+}
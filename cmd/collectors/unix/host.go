@@ -0,0 +1,329 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package unix
+
+import (
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// sectorSize - size of the sectors of /proc/diskstats, independent of the disk
+const sectorSize = 512
+
+// cpuModes - CPU times of the first line of /proc/stat, in their order
+var cpuModes = []string{"user", "nice", "system", "idle", "iowait", "irq", "softirq", "steal"}
+
+// pseudoFilesystems - filesystem types without disk usage, or that may hang (network filesystems)
+var pseudoFilesystems = map[string]bool{
+	"autofs": true, "binfmt_misc": true, "bpf": true, "cgroup": true, "cgroup2": true, "cifs": true,
+	"configfs": true, "debugfs": true, "devpts": true, "devtmpfs": true, "fuse.lxcfs": true, "fusectl": true,
+	"hugetlbfs": true, "mqueue": true, "nfs": true, "nfs4": true, "nfsd": true, "nsfs": true, "overlay": true,
+	"proc": true, "pstore": true, "ramfs": true, "rpc_pipefs": true, "securityfs": true, "smb3": true,
+	"squashfs": true, "sysfs": true, "tmpfs": true, "tracefs": true,
+}
+
+// Stats - labels and metrics of an instance of the host, e.g. a disk or a container
+type Stats struct {
+	labels map[string]string
+	values map[string]float64
+}
+
+func newStats() *Stats {
+	return &Stats{labels: make(map[string]string), values: make(map[string]float64)}
+}
+
+// Host - stats about resource usage of the system, parsed from the proc-filesystem
+// cpu, mem and load are system-wide, the other maps are keyed by disk, mount point and network interface
+// cpuBusy & cpuTotal are deltas since the last reload, like the cpuTotal of Process
+type Host struct {
+	procPath    string
+	sysPath     string
+	numCPUs     uint64
+	cpuBusy     float64
+	cpuTotal    float64
+	cpu         map[string]float64
+	load        map[string]float64
+	mem         map[string]uint64
+	disks       map[string]*Stats
+	filesystems map[string]*Stats
+	interfaces  map[string]*Stats
+}
+
+// NewHost - returns an instance of Host, that reads the proc-filesystem at procPath and the sysfs at sysPath
+func NewHost(procPath, sysPath string) *Host {
+	return &Host{
+		procPath: procPath,
+		sysPath:  sysPath,
+		cpu:      make(map[string]float64),
+		load:     make(map[string]float64),
+		mem:      make(map[string]uint64),
+	}
+}
+
+// Reload - refresh the stats of a group of metrics, e.g. cpu or disk
+func (h *Host) Reload(group string) error {
+	switch group {
+	case "cpu":
+		if err := h.loadStat(); err != nil {
+			return err
+		}
+		return h.loadLoadavg()
+	case "memory":
+		return h.loadMeminfo()
+	case "disk":
+		return h.loadDiskstats()
+	case "filesystem":
+		return h.loadMounts()
+	case "network":
+		return h.loadNetDev()
+	}
+	return nil
+}
+
+// read values from /proc/stat - CPU times of the host, and number of CPUs
+func (h *Host) loadStat() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "stat"))
+	if err != nil {
+		return errs.New(ErrFileRead, "stat: "+err.Error())
+	}
+
+	// store previous values to calculate deltas
+	prevTotal, prevIdle := h.cpuTimes()
+
+	// first line contains the summary of the CPU times, followed by a line for each CPU, example:
+	// cpu  8536493 101888 2291762 23315526 39855 674853 262635 0 0 0
+	// cpu0 2134123 25472 572940 5828881 9963 168713 65658 0 0 0
+	h.numCPUs = 0
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			h.numCPUs++
+			continue
+		}
+		found = true
+		for i, mode := range cpuModes {
+			if i+1 >= len(fields) {
+				break
+			}
+			if num, err := strconv.ParseUint(fields[i+1], 10, 64); err == nil {
+				h.cpu[mode] = float64(num) / clkTck
+			}
+		}
+	}
+	if !found {
+		return errs.New(ErrFieldNotFound, "/proc/stat: cpu")
+	}
+
+	total, idle := h.cpuTimes()
+	h.cpuTotal = total - prevTotal
+	h.cpuBusy = h.cpuTotal - (idle - prevIdle)
+	return nil
+}
+
+// cpuTimes - total CPU time of the host, and the time it was idle or waiting for IOs
+func (h *Host) cpuTimes() (float64, float64) {
+	var total float64
+	for _, mode := range cpuModes {
+		total += h.cpu[mode]
+	}
+	return total, h.cpu["idle"] + h.cpu["iowait"]
+}
+
+// read values from /proc/loadavg - load averages over 1, 5 and 15 minutes
+func (h *Host) loadLoadavg() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "loadavg"))
+	if err != nil {
+		return errs.New(ErrFileRead, "loadavg: "+err.Error())
+	}
+
+	// example: 0.52 0.58 0.59 2/1273 81743
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return errs.New(ErrFieldNotFound, "/proc/loadavg: ["+string(data)+"]")
+	}
+	for i, name := range []string{"1m", "5m", "15m"} {
+		num, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return errs.New(ErrFieldValue, "/proc/loadavg: ["+fields[i]+"]")
+		}
+		h.load[name] = num
+	}
+	return nil
+}
+
+// read values from /proc/meminfo - memory and swap of the host in kB
+func (h *Host) loadMeminfo() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "meminfo"))
+	if err != nil {
+		return errs.New(ErrFileRead, "meminfo: "+err.Error())
+	}
+
+	names := map[string]string{
+		"memtotal":     "total",
+		"memfree":      "free",
+		"memavailable": "available",
+		"buffers":      "buffers",
+		"cached":       "cached",
+		"swaptotal":    "swap_total",
+		"swapfree":     "swap_free",
+	}
+
+	// example: MemTotal:        7707284 kB
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			if name, ok := names[strings.ToLower(strings.TrimSuffix(fields[0], ":"))]; ok {
+				if num, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+					h.mem[name] = num
+				}
+			}
+		}
+	}
+	if h.mem["total"] == 0 {
+		return errs.New(ErrFieldNotFound, "/proc/meminfo: MemTotal")
+	}
+	return nil
+}
+
+// memoryPercent - memory used by the processes of the host, without the buffers and caches
+// kernels older than 3.14 don't provide MemAvailable
+func (h *Host) memoryPercent() float64 {
+	available, ok := h.mem["available"]
+	if !ok {
+		available = h.mem["free"] + h.mem["buffers"] + h.mem["cached"]
+	}
+	return float64(h.mem["total"]-min(available, h.mem["total"])) / float64(h.mem["total"]) * 100
+}
+
+// read values from /proc/diskstats - IOs of the disks of the host
+// partitions are skipped when the sysfs lists the disks, as well as loop and ram devices
+func (h *Host) loadDiskstats() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "diskstats"))
+	if err != nil {
+		return errs.New(ErrFileRead, "diskstats: "+err.Error())
+	}
+
+	var disks map[string]bool
+	if entries, err := os.ReadDir(path.Join(h.sysPath, "block")); err == nil {
+		disks = make(map[string]bool)
+		for _, e := range entries {
+			disks[e.Name()] = true
+		}
+	}
+
+	// fields after the device name, example:
+	//    8       0 sda 136253 48362 8398718 47637 1103484 1226468 44578282 1253716 0 779268 1345683
+	// reads, merged reads, sectors read, ms reading, writes, merged writes, sectors written, ms writing,
+	// IOs in progress, ms doing IOs
+	h.disks = make(map[string]*Stats)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 13 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || (disks != nil && !disks[name]) {
+			continue
+		}
+		values := make([]float64, 10)
+		for i := range values {
+			if values[i], err = strconv.ParseFloat(fields[i+3], 64); err != nil {
+				return errs.New(ErrFieldValue, "/proc/diskstats: "+name+" ["+fields[i+3]+"]")
+			}
+		}
+		disk := newStats()
+		disk.labels["disk"] = name
+		disk.values["reads"] = values[0]
+		disk.values["read_bytes"] = values[2] * sectorSize
+		disk.values["read_time"] = values[3] / 1000
+		disk.values["writes"] = values[4]
+		disk.values["write_bytes"] = values[6] * sectorSize
+		disk.values["write_time"] = values[7] / 1000
+		disk.values["queue_depth"] = values[8]
+		disk.values["busy_time"] = values[9] / 1000
+		h.disks[name] = disk
+	}
+	return nil
+}
+
+// read values from /proc/mounts - disk usage of the filesystems of the host
+// each device is reported once, at its first mount point
+func (h *Host) loadMounts() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "mounts"))
+	if err != nil {
+		return errs.New(ErrFileRead, "mounts: "+err.Error())
+	}
+
+	// example: /dev/sda1 /boot xfs rw,relatime,attr2,inode64 0 0
+	h.filesystems = make(map[string]*Stats)
+	devices := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || pseudoFilesystems[fields[2]] || devices[fields[0]] {
+			continue
+		}
+		// spaces in mount points are escaped as \040
+		mountPoint := strings.ReplaceAll(fields[1], `\040`, " ")
+		fs := newStats()
+		if err := statfs(mountPoint, fs.values); err != nil {
+			continue
+		}
+		devices[fields[0]] = true
+		fs.labels["device"] = fields[0]
+		fs.labels["mount_point"] = mountPoint
+		fs.labels["type"] = fields[2]
+		h.filesystems[mountPoint] = fs
+	}
+	return nil
+}
+
+// read values from /proc/net/dev - traffic of the network interfaces of the host
+func (h *Host) loadNetDev() error {
+
+	data, err := os.ReadFile(path.Join(h.procPath, "net", "dev"))
+	if err != nil {
+		return errs.New(ErrFileRead, "net/dev: "+err.Error())
+	}
+
+	// two lines of headers, then a line for each interface, example:
+	//   eth0: 1862457 13412 0 0 0 0 0 0 1232461 9844 0 0 0 0 0 0
+	// bytes, packets, errs, drop, fifo, frame, compressed, multicast received, followed by
+	// bytes, packets, errs, drop, fifo, colls, carrier, compressed sent
+	h.interfaces = make(map[string]*Stats)
+	names := map[int]string{
+		0: "receive_bytes", 1: "receive_packets", 2: "receive_errors", 3: "receive_drops",
+		8: "transmit_bytes", 9: "transmit_packets", 10: "transmit_errors", 11: "transmit_drops",
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, counters, ok := strings.Cut(line, ":")
+		fields := strings.Fields(counters)
+		if !ok || len(fields) < 16 {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		nic := newStats()
+		nic.labels["interface"] = name
+		for i, metric := range names {
+			num, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return errs.New(ErrFieldValue, "/proc/net/dev: "+name+" ["+fields[i]+"]")
+			}
+			nic.values[metric] = num
+		}
+		h.interfaces[name] = nic
+	}
+	return nil
+}
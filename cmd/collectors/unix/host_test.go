@@ -0,0 +1,168 @@
+package unix
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"math"
+	"regexp"
+	"testing"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestHost(t *testing.T) {
+	clkTck = 100
+	h := NewHost("testdata/proc", "testdata/sys")
+	for _, group := range []string{"cpu", "memory", "disk", "filesystem", "network"} {
+		if err := h.Reload(group); err != nil {
+			t.Fatalf("reload %s: %v", group, err)
+		}
+	}
+
+	if h.numCPUs != 2 {
+		t.Errorf("numCPUs = %d, want 2", h.numCPUs)
+	}
+	if h.cpu["user"] != 10 || h.cpu["steal"] != 1 {
+		t.Errorf("cpu = %v, want user 10s and steal 1s", h.cpu)
+	}
+	// busy since boot time: everything but idle and iowait
+	if got := h.cpuBusy / h.cpuTotal * 100; math.Abs(got-18) > 1e-9 {
+		t.Errorf("cpu percent = %v, want 18", got)
+	}
+	if h.load["15m"] != 0.59 {
+		t.Errorf("load = %v, want 0.59 over 15m", h.load)
+	}
+	if got := h.memoryPercent(); got != 25 {
+		t.Errorf("memory percent = %v, want 25", got)
+	}
+
+	// partitions and loop devices are skipped
+	if len(h.disks) != 2 || h.disks["sda"] == nil || h.disks["nvme0n1"] == nil {
+		t.Fatalf("disks = %v, want sda and nvme0n1", h.disks)
+	}
+	sda := h.disks["sda"].values
+	if sda["read_bytes"] != 8398718*sectorSize || sda["busy_time"] != 779.268 {
+		t.Errorf("sda = %v", sda)
+	}
+
+	if eth0 := h.interfaces["eth0"]; eth0 == nil || eth0.values["receive_errors"] != 1 ||
+		eth0.values["transmit_drops"] != 4 || eth0.values["transmit_bytes"] != 1232461 {
+		t.Errorf("eth0 = %v", eth0)
+	}
+
+	// pseudo filesystems and bind mounts of the same device are skipped
+	if len(h.filesystems) != 1 || h.filesystems["/"] == nil || h.filesystems["/"].labels["device"] != "/dev/sda1" {
+		t.Errorf("filesystems = %v, want / of /dev/sda1", h.filesystems)
+	}
+}
+
+func TestCgroups(t *testing.T) {
+	clkTck = 100
+	tests := []struct {
+		root   string
+		cgroup string
+		values map[string]float64
+	}{
+		{
+			root:   "testdata/cgroup2",
+			cgroup: "system.slice/docker-" + testContainerID + ".scope",
+			values: map[string]float64{"cpu_time": 7.351946, "cpu_user_time": 5, "memory_used": 104857600,
+				"pids": 12, "read_bytes": 1500000, "writes": 353},
+		},
+		{
+			root:   "testdata/cgroup1",
+			cgroup: "docker/" + testContainerID,
+			values: map[string]float64{"cpu_time": 2.5, "cpu_user_time": 1.5, "cpu_throttled_time": 0.5,
+				"memory_used": 52428800, "memory_limit": 268435456, "read_bytes": 4096, "write_bytes": 8192},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.root, func(t *testing.T) {
+			c := NewCgroups(tt.root, regexp.MustCompile(DefaultContainerCgroups))
+			if err := c.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.containers) != 1 || c.containers[tt.cgroup] == nil {
+				t.Fatalf("containers = %v, want %s", c.containers, tt.cgroup)
+			}
+			container := c.containers[tt.cgroup]
+			if got := container.labels["container"]; got != testContainerID[:12] {
+				t.Errorf("container = %s, want %s", got, testContainerID[:12])
+			}
+			for name, want := range tt.values {
+				if got, ok := container.values[name]; !ok || math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s = %v %v, want %v", name, got, ok, want)
+				}
+			}
+			if _, ok := container.values["cpu_percent"]; ok {
+				t.Errorf("cpu_percent is calculated on the first reload")
+			}
+
+			// the CPU time didn't change
+			if err := c.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := c.containers[tt.cgroup].values["cpu_percent"]; !ok || got != 0 {
+				t.Errorf("cpu_percent = %v %v, want 0", got, ok)
+			}
+		})
+	}
+}
+
+func TestPollHost(t *testing.T) {
+	params, err := tree.LoadYaml([]byte(`
+object: poller
+schedule:
+  - instance: 20s
+  - data: 10s
+mount_point: testdata/proc
+sys_mount_point: testdata/sys
+cgroup_mount_point: testdata/cgroup2
+counters:
+  - cpu_percent
+host_metrics:
+  - cpu
+  - memory
+  - disk
+  - network
+  - containers
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Unix{}
+	if err = u.Init(collector.New("Unix", "poller", options.New(), params, nil)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := u.PollData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := data["host"]
+	instance := host.GetInstance("host")
+	for key, want := range map[string]float64{"cpu.user": 10, "memory.total": 8000000, "cpu_count": 2,
+		"load_average.1m": 0.52, "memory_percent": 25} {
+		metric := host.GetMetric(key)
+		if metric == nil {
+			t.Errorf("missing host metric %s", key)
+			continue
+		}
+		if got, ok := metric.GetValueFloat64(instance); !ok || got != want {
+			t.Errorf("%s = %v %v, want %v", key, got, ok, want)
+		}
+	}
+	if got := host.GetMetric("cpu.idle").GetLabel("metric"); got != "idle" {
+		t.Errorf("metric label of cpu.idle = %s, want idle", got)
+	}
+
+	for object, want := range map[string]int{"host_disk": 2, "host_network": 2, "container": 1} {
+		if got := len(data[object].GetInstances()); got != want {
+			t.Errorf("%s instances = %d, want %d", object, got, want)
+		}
+	}
+	if _, ok := data["host_filesystem"]; ok {
+		t.Errorf("host_filesystem is not in host_metrics")
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/netapp/harvest/v2/pkg/util"
 	"os"
 	"os/exec"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -37,6 +38,20 @@ var supportedPlatforms = []string{
 
 var mountPoint = "/proc"
 
+var sysMountPoint = "/sys"
+
+var cgroupMountPoint = "/sys/fs/cgroup"
+
+// objects of the optional host_metrics, the metrics of cpu and memory are in the same object
+var hostObjects = map[string]string{
+	"cpu":        "host",
+	"memory":     "host",
+	"disk":       "host_disk",
+	"filesystem": "host_filesystem",
+	"network":    "host_network",
+	"containers": "container",
+}
+
 var clkTck float64
 
 // list of histograms provided by the collector, mapped
@@ -128,13 +143,17 @@ func getHistogramLabels(p *Process, name string) []string {
 	return labels
 }
 
-// Unix - collector providing basic stats about harvest pollers
+// Unix - collector providing basic stats about harvest pollers,
+// and optionally about the host and its containers
 // @TODO - extend to monitor any user-defined process
 type Unix struct {
 	*collector.AbstractCollector
 	system          *System
 	histogramLabels map[string][]string
 	processes       map[string]*Process
+	host            *Host
+	hostGroups      []string
+	cgroups         *Cgroups
 }
 
 // Init - initialize the collector
@@ -179,6 +198,11 @@ func (u *Unix) Init(a *collector.AbstractCollector) error {
 	u.Matrix[u.Object].SetGlobalLabel("hostname", u.Options.Hostname)
 	u.Matrix[u.Object].SetGlobalLabel("datacenter", u.Params.GetChildContentS("datacenter"))
 
+	if err = u.initHost(); err != nil {
+		u.Logger.Error().Stack().Err(err).Msg("init host")
+		return err
+	}
+
 	u.Logger.Debug().Msg("initialized")
 	return nil
 }
@@ -273,6 +297,64 @@ func (u *Unix) loadMetrics(counters *node.Node) error {
 	return nil
 }
 
+// initHost - create the matrices of the host_metrics, the groups of metrics of the host and its containers
+func (u *Unix) initHost() error {
+	groups := u.Params.GetChildS("host_metrics")
+	if groups == nil {
+		return nil
+	}
+
+	// the formats of /proc/stat, /proc/diskstats and the cgroup filesystem are specific to Linux
+	if runtime.GOOS != "linux" {
+		return errs.New(errs.ErrImplement, "host_metrics on "+runtime.GOOS)
+	}
+
+	if mp := u.Params.GetChildContentS("sys_mount_point"); mp != "" {
+		sysMountPoint = mp
+	}
+	u.host = NewHost(mountPoint, sysMountPoint)
+
+	for _, group := range groups.GetAllChildContentS() {
+		object, ok := hostObjects[group]
+		if !ok {
+			return errs.New(errs.ErrInvalidParam, "host_metrics: unknown group ["+group+"]")
+		}
+		u.hostGroups = append(u.hostGroups, group)
+
+		if _, ok = u.Matrix[object]; !ok {
+			mat := matrix.New(object, object, object)
+			mat.SetGlobalLabels(u.Matrix[u.Object].GetGlobalLabels())
+			mat.SetExportOptions(matrix.DefaultExportOptions())
+			u.Matrix[object] = mat
+		}
+
+		if group == "containers" {
+			if mp := u.Params.GetChildContentS("cgroup_mount_point"); mp != "" {
+				cgroupMountPoint = mp
+			}
+			pattern := u.Params.GetChildContentS("container_cgroups")
+			if pattern == "" {
+				pattern = DefaultContainerCgroups
+			}
+			filter, err := regexp.Compile(pattern)
+			if err != nil {
+				return errs.New(errs.ErrInvalidParam, "container_cgroups: "+err.Error())
+			}
+			u.cgroups = NewCgroups(cgroupMountPoint, filter)
+		}
+	}
+
+	// the host is a single instance, identified by the global labels
+	if mat, ok := u.Matrix[hostObjects["cpu"]]; ok {
+		if _, err := mat.NewInstance("host"); err != nil {
+			return err
+		}
+	}
+
+	u.Logger.Debug().Strs("groups", u.hostGroups).Msg("initialized host metrics")
+	return nil
+}
+
 // PollInstance - update instance cache with running pollers
 func (u *Unix) PollInstance() (map[string]*matrix.Matrix, error) {
 
@@ -420,11 +502,140 @@ func (u *Unix) PollData() (map[string]*matrix.Matrix, error) {
 		}
 	}
 
+	count += u.pollHost()
+
 	u.AddCollectCount(count)
 	u.Logger.Debug().Msgf("poll complete, added %d data points", count)
 	return u.Matrix, nil
 }
 
+// pollHost - update the matrices of the host_metrics, a group that fails doesn't stop the others
+func (u *Unix) pollHost() uint64 {
+
+	var count uint64
+
+	for _, group := range u.hostGroups {
+		u.Matrix[hostObjects[group]].Reset()
+	}
+
+	for _, group := range u.hostGroups {
+		var err error
+		mat := u.Matrix[hostObjects[group]]
+
+		if group == "containers" {
+			if err = u.cgroups.Reload(); err == nil {
+				count += u.setStats(mat, u.cgroups.containers)
+			}
+		} else if err = u.host.Reload(group); err == nil {
+			switch group {
+			case "cpu":
+				count += u.setHostCPU(mat)
+			case "memory":
+				count += u.setHostMemory(mat)
+			case "disk":
+				count += u.setStats(mat, u.host.disks)
+			case "filesystem":
+				count += u.setStats(mat, u.host.filesystems)
+			case "network":
+				count += u.setStats(mat, u.host.interfaces)
+			}
+		}
+
+		if err != nil {
+			u.Logger.Error().Err(err).Str("group", group).Msg("poll host metrics")
+		}
+	}
+	return count
+}
+
+// setHostValue - set a metric of the host, the metric is created on first use
+// histograms are named after their group, with the "metric" label, like the histograms of the pollers
+func (u *Unix) setHostValue(mat *matrix.Matrix, instance *matrix.Instance, name, label, dtype string, value float64) uint64 {
+	key := name
+	if label != "" {
+		key = name + "." + label
+	}
+
+	metric := mat.GetMetric(key)
+	if metric == nil {
+		var err error
+		if metric, err = mat.NewMetricType(key, dtype, name); err != nil {
+			u.Logger.Error().Stack().Err(err).Str("key", key).Msg("add host metric")
+			return 0
+		}
+		if label != "" {
+			metric.SetLabel("metric", label)
+		}
+	}
+
+	if err := metric.SetValueFloat64(instance, value); err != nil {
+		u.Logger.Error().Stack().Err(err).Str("key", key).Msg("set host metric")
+		return 0
+	}
+	return 1
+}
+
+// setStats - update the instances of the disks, filesystems, network interfaces or containers of the host,
+// instances that are gone are removed
+func (u *Unix) setStats(mat *matrix.Matrix, stats map[string]*Stats) uint64 {
+
+	var count uint64
+
+	for key := range mat.GetInstances() {
+		if _, ok := stats[key]; !ok {
+			mat.RemoveInstance(key)
+			u.Logger.Debug().Str("object", mat.Object).Str("key", key).Msg("remove instance")
+		}
+	}
+
+	for key, s := range stats {
+		instance := mat.GetInstance(key)
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(key); err != nil {
+				u.Logger.Error().Stack().Err(err).Str("key", key).Msg("add instance")
+				continue
+			}
+		}
+		for label, value := range s.labels {
+			instance.SetLabel(label, value)
+		}
+		for name, value := range s.values {
+			count += u.setHostValue(mat, instance, name, "", "float64", value)
+		}
+	}
+	return count
+}
+
+func (u *Unix) setHostCPU(mat *matrix.Matrix) uint64 {
+	var count uint64
+	instance := mat.GetInstance("host")
+
+	for _, mode := range cpuModes {
+		count += u.setHostValue(mat, instance, "cpu", mode, "float64", u.host.cpu[mode])
+	}
+	for _, name := range []string{"1m", "5m", "15m"} {
+		count += u.setHostValue(mat, instance, "load_average", name, "float64", u.host.load[name])
+	}
+	count += u.setHostValue(mat, instance, "cpu_count", "", "uint64", float64(u.host.numCPUs))
+	// CPU busy since last poll, or since boot time on the first poll
+	if u.host.cpuTotal > 0 {
+		count += u.setHostValue(mat, instance, "cpu_percent", "", "float64", u.host.cpuBusy/u.host.cpuTotal*100)
+	}
+	return count
+}
+
+func (u *Unix) setHostMemory(mat *matrix.Matrix) uint64 {
+	var count uint64
+	instance := mat.GetInstance("host")
+
+	for name, value := range u.host.mem {
+		count += u.setHostValue(mat, instance, "memory", name, "uint64", float64(value))
+	}
+	count += u.setHostValue(mat, instance, "memory_percent", "", "float64", u.host.memoryPercent())
+	return count
+}
+
 func setStartTime(m *matrix.Metric, i *matrix.Instance, p *Process, s *System) {
 	err := m.SetValueFloat64(i, p.startTime+s.bootTime)
 	if err != nil {
@@ -26,6 +26,7 @@ package zapiperf
 
 import (
 	"errors"
+	"github.com/netapp/harvest/v2/cmd/collectors"
 	"github.com/netapp/harvest/v2/cmd/collectors/zapiperf/plugins/disk"
 	"github.com/netapp/harvest/v2/cmd/collectors/zapiperf/plugins/externalserviceoperation"
 	"github.com/netapp/harvest/v2/cmd/collectors/zapiperf/plugins/fcp"
@@ -79,6 +80,9 @@ type ZapiPerf struct {
 	scalarCounters  []string
 	qosLabels       map[string]string
 	isCacheEmpty    bool
+	counterReset    collectors.CounterReset
+	focus           *collectors.Focus
+	task            string // name of the task being polled, data or focus
 	testFilePath    string // Used only from unit test
 }
 
@@ -113,6 +117,11 @@ func (z *ZapiPerf) Init(a *collector.AbstractCollector) error {
 		return err
 	}
 
+	var err error
+	if z.focus, err = collectors.ParseFocus(z.Params, z.Schedule, z.Logger); err != nil {
+		return err
+	}
+
 	z.Logger.Debug().Msg("initialized")
 	return nil
 }
@@ -150,6 +159,7 @@ func (z *ZapiPerf) InitCache() error {
 	z.batchSize = z.loadParamInt("batch_size", batchSize)
 	z.latencyIoReqd = z.loadParamInt("latency_io_reqd", latencyIoReqd)
 	z.isCacheEmpty = true
+	z.task = "data"
 	z.object = z.loadParamStr("object", "")
 	// hack to override from AbstractCollector
 	// @TODO need cleaner solution
@@ -159,8 +169,14 @@ func (z *ZapiPerf) InitCache() error {
 	z.Matrix[z.Object].Object = z.object
 	z.Logger.Debug().Msgf("object= %s --> %s", z.Object, z.object)
 
-	// Add metadata metric for skips
+	var err error
+	if z.counterReset, err = collectors.ParseCounterReset(z.Params); err != nil {
+		return err
+	}
+
+	// Add metadata metric for skips and counter resets
 	_, _ = z.Metadata.NewMetricUint64("skips")
+	_, _ = z.Metadata.NewMetricUint64("resets")
 
 	return nil
 }
@@ -621,10 +637,10 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 	}
 
 	// update metadata
-	_ = z.Metadata.LazySetValueInt64("api_time", "data", apiT.Microseconds())
-	_ = z.Metadata.LazySetValueInt64("parse_time", "data", parseT.Microseconds())
-	_ = z.Metadata.LazySetValueUint64("metrics", "data", count)
-	_ = z.Metadata.LazySetValueUint64("instances", "data", uint64(len(instanceKeys)))
+	_ = z.Metadata.LazySetValueInt64("api_time", z.task, apiT.Microseconds())
+	_ = z.Metadata.LazySetValueInt64("parse_time", z.task, parseT.Microseconds())
+	_ = z.Metadata.LazySetValueUint64("metrics", z.task, count)
+	_ = z.Metadata.LazySetValueUint64("instances", z.task, uint64(len(instanceKeys)))
 	z.AddCollectCount(count)
 
 	// skip calculating from delta if no data from previous poll
@@ -642,6 +658,9 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 	// cache raw data for next poll
 	cachedData := curMat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true}) // @TODO implement copy data
 
+	// detect restarted instances from raw counters, before deltas are calculated
+	resets := z.counterReset.NewResets(curMat, prevMat)
+
 	// order metrics, such that those requiring base counters are processed last
 	orderedMetrics := make([]*matrix.Metric, 0, len(curMat.GetMetrics()))
 	orderedKeys := make([]string, 0, len(orderedMetrics))
@@ -680,7 +699,7 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 		}
 
 		// all other properties - first calculate delta
-		if skips, err = curMat.Delta(key, prevMat, z.Logger); err != nil {
+		if skips, err = curMat.DeltaWithResets(key, prevMat, resets, z.Logger); err != nil {
 			z.Logger.Error().Err(err).Str("key", key).Msg("Calculate delta")
 			continue
 		}
@@ -766,17 +785,46 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 
 	calcD := time.Since(calcStart)
 
-	_ = z.Metadata.LazySetValueInt64("calc_time", "data", calcD.Microseconds())
-	_ = z.Metadata.LazySetValueUint64("skips", "data", uint64(totalSkips))
+	_ = z.Metadata.LazySetValueInt64("calc_time", z.task, calcD.Microseconds())
+	_ = z.Metadata.LazySetValueUint64("skips", z.task, uint64(totalSkips))
+	_ = z.Metadata.LazySetValueUint64("resets", z.task, uint64(resets.Count()))
+	if resets.Count() > 0 {
+		z.Logger.Info().
+			Int("resets", resets.Count()).
+			Int("restarted", resets.Restarted()).
+			Str("policy", string(resets.Policy)).
+			Msg("Counter resets detected")
+	}
 
 	// store cache for next poll
 	z.Matrix[z.Object] = cachedData
 
+	if z.task == "data" {
+		z.focus.Rank(curMat)
+	}
+
 	newDataMap := make(map[string]*matrix.Matrix)
 	newDataMap[z.Object] = curMat
 	return newDataMap, nil
 }
 
+// PollFocus polls the instances selected by the focus parameters. The raw counters of the focus instances are cached
+// separately from the data cache, and the same calculations as PollData are applied.
+func (z *ZapiPerf) PollFocus() (map[string]*matrix.Matrix, error) {
+	if !z.focus.Refresh(z.Matrix[z.Object], time.Now()) {
+		return nil, nil
+	}
+
+	data, isCacheEmpty := z.Matrix[z.Object], z.isCacheEmpty
+	z.Matrix[z.Object], z.isCacheEmpty, z.task = z.focus.Cache, z.focus.IsEmpty, collectors.FocusTask
+	defer func() {
+		z.focus.Cache, z.focus.IsEmpty = z.Matrix[z.Object], z.isCacheEmpty
+		z.Matrix[z.Object], z.isCacheEmpty, z.task = data, isCacheEmpty, "data"
+	}()
+
+	return z.PollData()
+}
+
 // Poll counter "ops" of the related/parent object, required for objects
 // workload_detail and workload_detail_volume. This counter is already
 // collected by the other ZapiPerf collectors, so this poll is redundant
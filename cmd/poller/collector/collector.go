@@ -21,9 +21,11 @@ import (
 	"github.com/netapp/harvest/v2/pkg/auth"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/persist"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 	"math"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -50,6 +52,7 @@ import (
 type Collector interface {
 	Init(*AbstractCollector) error
 	Start(*sync.WaitGroup)
+	Stop()
 	GetName() string
 	GetObject() string
 	GetParams() *node.Node
@@ -393,8 +396,10 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 
 					pluginStart = time.Now()
 
+					pluginTimes := make(map[string]time.Duration)
 					for _, v := range c.Plugins {
 						for _, plg := range v {
+							start := time.Now()
 							if pluginData, err := plg.Run(data); err != nil {
 								c.Logger.Error().Err(err).Str("plugin", plg.GetName()).Send()
 							} else if pluginData != nil {
@@ -403,11 +408,18 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 							} else {
 								c.Logger.Debug().Msgf("plugin [%s]: completed", plg.GetName())
 							}
+							pluginTimes[plg.GetName()] += time.Since(start)
 						}
 					}
 
 					pluginTime = time.Since(pluginStart)
 					_ = c.Metadata.LazySetValueInt64("plugin_time", task.Name, pluginTime.Microseconds())
+					c.setPluginTimes(pluginTimes)
+
+					// share the data with plugins of other objects
+					for key, value := range data {
+						plugin.Publish(c.Name, key, value)
+					}
 				}
 			}
 			if task.Name == "data" {
@@ -437,6 +449,9 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 			// continue if metadata failed, since it might be specific to metadata
 			for _, data := range results {
 				if data.IsExportable() {
+					if !data.IsExportableTo(e.GetName()) {
+						continue
+					}
 					if err := e.Export(data); err != nil {
 						c.Logger.Error().Stack().Err(err).Msgf("export data to [%s]:", e.GetName())
 						break
@@ -460,6 +475,23 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 	}
 }
 
+// setPluginTimes records the time of each plugin in a metadata instance with the labels task="plugin" and
+// plugin=<name>. The instances are created on first use.
+func (c *AbstractCollector) setPluginTimes(pluginTimes map[string]time.Duration) {
+	for name, d := range pluginTimes {
+		key := "plugin." + name
+		if c.Metadata.GetInstance(key) == nil {
+			instance, err := c.Metadata.NewInstance(key)
+			if err != nil {
+				continue
+			}
+			instance.SetLabel("task", "plugin")
+			instance.SetLabel("plugin", name)
+		}
+		_ = c.Metadata.LazySetValueInt64("plugin_time", key, d.Microseconds())
+	}
+}
+
 func (c *AbstractCollector) logMetadata() {
 	metrics := c.Metadata.GetMetrics()
 	info := c.Logger.Info()
@@ -485,6 +517,20 @@ func (c *AbstractCollector) logMetadata() {
 	info.Msg("Collected")
 }
 
+// StatePath returns the path of the file that keeps the state of the collector across restarts. The directory is the
+// parameter state_dir of the collector, by default the directory "state" of the Harvest home.
+func (c *AbstractCollector) StatePath() string {
+	dir := c.Params.GetChildContentS("state_dir")
+	poller := ""
+	if c.Options != nil {
+		poller = c.Options.Poller
+		if dir == "" {
+			dir = filepath.Join(c.Options.HomePath, "state")
+		}
+	}
+	return persist.Path(dir, poller, c.Name, c.Object)
+}
+
 // GetName returns name of the collector
 func (c *AbstractCollector) GetName() string {
 	return c.Name
@@ -610,6 +656,18 @@ func (c *AbstractCollector) LoadPlugins(params *node.Node, collector Collector,
 	return nil
 }
 
+// Stop releases the resources of the plugins of the collector. Collectors that hold resources, such as
+// listeners, override Stop and call it.
+func (c *AbstractCollector) Stop() {
+	for _, plugins := range c.Plugins {
+		for _, p := range plugins {
+			if s, ok := p.(plugin.Stopper); ok {
+				s.Stop()
+			}
+		}
+	}
+}
+
 // CollectAutoSupport allows a Collector to add autosupport information
 func (c *AbstractCollector) CollectAutoSupport(_ *Payload) {
 }
@@ -13,9 +13,17 @@ import (
 	"github.com/hashicorp/go-version"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/aggregator"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/anomaly"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/changelog"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/downsample"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/external"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/forecast"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/join"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/labelagent"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/max"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/metricagent"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/threshold"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/topn"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/errs"
 	"github.com/netapp/harvest/v2/pkg/tree"
@@ -214,5 +222,37 @@ func GetBuiltinPlugin(name string, abc *plugin.AbstractPlugin) plugin.Plugin {
 		return metricagent.New(abc)
 	}
 
+	if name == "TopN" {
+		return topn.New(abc)
+	}
+
+	if name == "External" {
+		return external.New(abc)
+	}
+
+	if name == "Join" {
+		return join.New(abc)
+	}
+
+	if name == "Threshold" {
+		return threshold.New(abc)
+	}
+
+	if name == "Forecast" {
+		return forecast.New(abc)
+	}
+
+	if name == "ChangeLog" {
+		return changelog.New(abc)
+	}
+
+	if name == "Anomaly" {
+		return anomaly.New(abc)
+	}
+
+	if name == "Downsample" {
+		return downsample.New(abc)
+	}
+
 	return nil
 }
@@ -11,7 +11,10 @@ import (
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"github.com/rs/zerolog"
 	"golang.org/x/exp/maps"
+	"math"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -33,6 +36,23 @@ type rule struct {
 	includeLabels []string
 	allLabels     bool
 	counts        map[string]map[string]float64
+	aggregations  []aggregation
+	samples       map[string][][]sample // values of the instances for each aggregation, by key of the new instance
+}
+
+// aggregation is a function applied to the values of a metric of the instances grouped by a rule, e.g. the
+// 95th percentile of read_latency. The result is a new metric named after the metric and the function.
+type aggregation struct {
+	metric     string // name or key of the metric
+	function   string // one of sum, avg, min, max, count, stddev, weighted_avg or a percentile
+	percentile float64
+	weight     string // metric that weights the average
+	name       string // name of the new metric
+}
+
+type sample struct {
+	value  float64
+	weight float64
 }
 
 func (a *Aggregator) Init() error {
@@ -64,7 +84,22 @@ func (a *Aggregator) parseRules() error {
 
 		r := rule{}
 
-		fields := strings.Fields(line)
+		// fields with "=" are aggregations of metrics, the other fields are the label of the rule
+		// and the labels to include
+		var fields []string
+		for _, field := range strings.Fields(line) {
+			if len(fields) != 0 && strings.Contains(field, "=") {
+				aggregations, err := parseAggregations(field)
+				if err != nil {
+					a.Logger.Warn().Err(err).Msgf("invalid aggregation [%s] in rule [%s]", field, line)
+					return err
+				}
+				r.aggregations = append(r.aggregations, aggregations...)
+				continue
+			}
+			fields = append(fields, field)
+		}
+
 		if len(fields) == 2 || len(fields) == 1 {
 			// parse label, possibly followed by value and object
 			a.Logger.Trace().Msgf("handling first field: [%s]", fields[0])
@@ -132,6 +167,7 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, e
 		matrices[i].SetExportOptions(matrix.DefaultExportOptions())
 		matrices[i].SetExportable(true)
 		rule.counts = make(map[string]map[string]float64)
+		rule.samples = make(map[string][][]sample)
 	}
 
 	// create instances and summarize metric values
@@ -205,6 +241,8 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, e
 				}
 			}
 
+			a.collectSamples(data, rule, objKey, instance)
+
 			for key, metric := range data.GetMetrics() {
 
 				if value, ok = metric.GetValueFloat64(instance); !ok {
@@ -294,9 +332,170 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, e
 		}
 	}
 
+	for i, rule := range a.rules {
+		if err = a.aggregate(matrices[i], rule); err != nil {
+			return nil, err
+		}
+	}
+
 	return matrices, nil
 }
 
+// parseAggregations parses the functions of a metric, e.g. "read_latency=p95,avg,weighted_avg(total_ops)"
+func parseAggregations(field string) ([]aggregation, error) {
+	metric, functions, _ := strings.Cut(field, "=")
+	if metric == "" || functions == "" {
+		return nil, errs.New(errs.ErrInvalidParam, "aggregation "+field)
+	}
+
+	var aggregations []aggregation
+	for _, function := range strings.Split(functions, ",") {
+		agg := aggregation{metric: metric, function: function}
+		suffix := function
+		switch {
+		case function == "sum", function == "avg", function == "min", function == "max", function == "count",
+			function == "stddev":
+		case strings.HasPrefix(function, "weighted_avg(") && strings.HasSuffix(function, ")"):
+			agg.function = "weighted_avg"
+			agg.weight = strings.TrimSuffix(strings.TrimPrefix(function, "weighted_avg("), ")")
+			if agg.weight == "" {
+				return nil, errs.New(errs.ErrInvalidParam, "weighted_avg of "+metric+" requires a metric")
+			}
+			suffix = agg.function
+		case strings.HasPrefix(function, "p"):
+			p, err := strconv.ParseFloat(function[1:], 64)
+			if err != nil || p < 0 || p > 100 {
+				return nil, errs.New(errs.ErrInvalidParam, "percentile "+function+" of "+metric)
+			}
+			agg.function = "percentile"
+			agg.percentile = p
+			suffix = strings.ReplaceAll(function, ".", "_")
+		default:
+			return nil, errs.New(errs.ErrInvalidParam, "unknown function "+function+" of "+metric)
+		}
+		agg.name = metric + "_" + suffix
+		aggregations = append(aggregations, agg)
+	}
+	return aggregations, nil
+}
+
+// collectSamples keeps the values of the instance for the aggregations of the rule
+func (a *Aggregator) collectSamples(data *matrix.Matrix, rule *rule, objKey string, instance *matrix.Instance) {
+	if len(rule.aggregations) == 0 {
+		return
+	}
+	samples, ok := rule.samples[objKey]
+	if !ok {
+		samples = make([][]sample, len(rule.aggregations))
+		rule.samples[objKey] = samples
+	}
+
+	for i, agg := range rule.aggregations {
+		metric := data.LookupMetric(agg.metric)
+		if metric == nil {
+			continue
+		}
+		value, ok := metric.GetValueFloat64(instance)
+		if !ok {
+			continue
+		}
+		s := sample{value: value, weight: 1}
+		if agg.weight != "" {
+			weight := data.LookupMetric(agg.weight)
+			if weight == nil {
+				continue
+			}
+			if s.weight, ok = weight.GetValueFloat64(instance); !ok {
+				continue
+			}
+		}
+		samples[i] = append(samples[i], s)
+	}
+}
+
+// aggregate creates the metrics of the aggregations of the rule
+func (a *Aggregator) aggregate(m *matrix.Matrix, rule *rule) error {
+	for i, agg := range rule.aggregations {
+		metric := m.GetMetric(agg.name)
+		if metric == nil {
+			var err error
+			if metric, err = m.NewMetricFloat64(agg.name); err != nil {
+				return err
+			}
+		}
+
+		for key, samples := range rule.samples {
+			instance := m.GetInstance(key)
+			if instance == nil || len(samples[i]) == 0 {
+				continue
+			}
+			if err := metric.SetValueFloat64(instance, compute(agg, samples[i])); err != nil {
+				a.Logger.Error().Err(err).Str("metric", agg.name).Str("key", key).Msg("set value")
+			}
+		}
+	}
+	return nil
+}
+
+func compute(agg aggregation, samples []sample) float64 {
+	var sum, weights float64
+	for _, s := range samples {
+		sum += s.value * s.weight
+		weights += s.weight
+	}
+	n := float64(len(samples))
+
+	switch agg.function {
+	case "sum":
+		return sum
+	case "count":
+		return n
+	case "avg":
+		return sum / n
+	case "weighted_avg":
+		if weights == 0 {
+			return 0
+		}
+		return sum / weights
+	case "min":
+		r := samples[0].value
+		for _, s := range samples[1:] {
+			r = math.Min(r, s.value)
+		}
+		return r
+	case "max":
+		r := samples[0].value
+		for _, s := range samples[1:] {
+			r = math.Max(r, s.value)
+		}
+		return r
+	case "stddev":
+		mean := sum / n
+		var squares float64
+		for _, s := range samples {
+			squares += (s.value - mean) * (s.value - mean)
+		}
+		return math.Sqrt(squares / n)
+	case "percentile":
+		return percentile(samples, agg.percentile)
+	}
+	return 0
+}
+
+// percentile interpolates linearly between the closest ranks
+func percentile(samples []sample, p float64) float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.value
+	}
+	slices.Sort(values)
+
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
 // NewLabels returns the new labels the receiver creates
 func (a *Aggregator) NewLabels() []string {
 	var newLabelNames []string
@@ -8,6 +8,8 @@ import (
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"strconv"
 	"testing"
 )
 
@@ -560,3 +562,90 @@ func newArtificialData() *matrix.Matrix {
 
 	return m
 }
+
+func TestRuleAggregationFunctions(t *testing.T) {
+	params := node.NewS("Aggregator")
+	params.NewChildS("", "svm read_latency=p50,p95,min,max,avg,stddev,count read_latency=weighted_avg(read_ops) read_ops=sum")
+
+	abc := plugin.New("Test", nil, params, nil, "volume", nil)
+	p := &Aggregator{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := matrix.New("", "volume", "volume")
+	latency, _ := m.NewMetricFloat64("read_latency")
+	ops, _ := m.NewMetricFloat64("read_ops")
+	// latencies of svm1 are 1 to 5, the ops of each volume are 10 times its latency
+	for i := 1; i <= 5; i++ {
+		instance, _ := m.NewInstance("vol" + strconv.Itoa(i))
+		instance.SetLabel("svm", "svm1")
+		_ = latency.SetValueFloat64(instance, float64(i))
+		_ = ops.SetValueFloat64(instance, float64(i*10))
+	}
+
+	results, err := p.Run(map[string]*matrix.Matrix{"volume": m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Plugin output has %d matrices, 1 was expected", len(results))
+	}
+	n := results[0]
+	instance := n.GetInstance("svm1")
+	if instance == nil {
+		t.Fatal("Instance [svm1] missing")
+	}
+
+	want := map[string]float64{
+		"read_latency_p50":          3,
+		"read_latency_p95":          4.8,
+		"read_latency_min":          1,
+		"read_latency_max":          5,
+		"read_latency_avg":          3,
+		"read_latency_stddev":       math.Sqrt(2),
+		"read_latency_count":        5,
+		"read_latency_weighted_avg": 550.0 / 150,
+		"read_ops_sum":              150,
+	}
+	for name, expected := range want {
+		metric := n.GetMetric(name)
+		if metric == nil {
+			t.Errorf("Metric [%s] missing", name)
+			continue
+		}
+		if value, ok := metric.GetValueFloat64(instance); !ok {
+			t.Errorf("Value [%s] missing", name)
+		} else if math.Abs(value-expected) > 1e-9 {
+			t.Errorf("Value [%s] got=%v, want=%v", name, value, expected)
+		}
+	}
+}
+
+func TestParseAggregations(t *testing.T) {
+	tests := []struct {
+		field string
+		names []string
+		ok    bool
+	}{
+		{field: "read_latency=p99.9", names: []string{"read_latency_p99_9"}, ok: true},
+		{field: "read_latency=weighted_avg(read_ops),sum", names: []string{"read_latency_weighted_avg", "read_latency_sum"}, ok: true},
+		{field: "read_latency=p101"},
+		{field: "read_latency=median"},
+		{field: "read_latency=weighted_avg()"},
+		{field: "=sum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			aggregations, err := parseAggregations(tt.field)
+			if (err == nil) != tt.ok {
+				t.Fatalf("err got=%v, want ok=%t", err, tt.ok)
+			}
+			for i, agg := range aggregations {
+				if agg.name != tt.names[i] {
+					t.Errorf("name got=%s, want=%s", agg.name, tt.names[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package anomaly implements a plugin that learns a baseline of perf metrics per instance and flags values that
+// deviate from it.
+package anomaly
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/persist"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	stateVersion        = 1
+	defaultAlpha        = 0.05
+	defaultThreshold    = 3.0
+	defaultWarmup       = 30
+	defaultMaxInstances = 10000
+	defaultCheckpoint   = 5 * time.Minute
+
+	scoreSuffix   = "_anomaly_score"
+	anomalySuffix = "_anomaly"
+)
+
+// Anomaly keeps an exponentially weighted moving average and variance of each metric of each instance, and exports
+// the z-score of the current value against that baseline.
+//
+//	plugins:
+//	  - Anomaly:
+//	      metrics:
+//	        - read_latency
+//	        - total_ops
+//	      alpha: 0.05
+//	      threshold: 3
+//	      seasonality: daily
+//
+// With seasonality, each hour of the day (daily) or of the week (weekly) has its own baseline. A value is an anomaly
+// when the absolute score reaches threshold, after the baseline has seen warmup values.
+type Anomaly struct {
+	*plugin.AbstractPlugin
+	metrics      []string
+	alpha        float64
+	threshold    float64
+	warmup       int
+	buckets      int // number of seasonal baselines, one hour each
+	maxInstances int
+	checkpoint   time.Duration
+	path         string
+	mu           sync.Mutex // guards state, the poller saves it from another goroutine when it stops
+	state        state
+	lastSave     time.Time // time of the last checkpoint, or of the first poll
+	now          func() time.Time
+}
+
+type state struct {
+	Version   int                  `json:"version"`
+	Buckets   int                  `json:"buckets"`
+	Instances map[string]*baseline `json:"instances"`
+}
+
+// baseline are the statistics of an instance, for each metric and seasonal bucket
+type baseline struct {
+	Seen    int64               `json:"seen"` // unix timestamp of the last poll with the instance
+	Metrics map[string][]*stats `json:"metrics"`
+}
+
+type stats struct {
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	N        int     `json:"n"`
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &Anomaly{AbstractPlugin: p}
+}
+
+func (a *Anomaly) Init() error {
+
+	var err error
+
+	if err = a.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	if m := a.Params.GetChildS("metrics"); m != nil {
+		a.metrics = m.GetAllChildContentS()
+	}
+	if len(a.metrics) == 0 {
+		return errs.New(errs.ErrMissingParam, "metrics")
+	}
+
+	if a.alpha, err = a.float("alpha", defaultAlpha); err != nil || a.alpha <= 0 || a.alpha >= 1 {
+		return errs.New(errs.ErrInvalidParam, "alpha must be between 0 and 1")
+	}
+	if a.threshold, err = a.float("threshold", defaultThreshold); err != nil || a.threshold <= 0 {
+		return errs.New(errs.ErrInvalidParam, "threshold must be positive")
+	}
+	if a.warmup, err = a.int("warmup", defaultWarmup); err != nil || a.warmup < 2 {
+		return errs.New(errs.ErrInvalidParam, "warmup must be at least 2")
+	}
+	if a.maxInstances, err = a.int("max_instances", defaultMaxInstances); err != nil || a.maxInstances < 1 {
+		return errs.New(errs.ErrInvalidParam, "max_instances must be positive")
+	}
+
+	switch s := a.Params.GetChildContentS("seasonality"); s {
+	case "", "none":
+		a.buckets = 1
+	case "daily":
+		a.buckets = 24
+	case "weekly":
+		a.buckets = 24 * 7
+	default:
+		return errs.New(errs.ErrInvalidParam, "seasonality: "+s)
+	}
+
+	a.checkpoint = defaultCheckpoint
+	if c := a.Params.GetChildContentS("checkpoint"); c != "" {
+		if a.checkpoint, err = time.ParseDuration(c); err != nil {
+			return errs.New(errs.ErrInvalidParam, "checkpoint: "+err.Error())
+		}
+	}
+
+	a.now = time.Now
+	a.path = a.StatePath()
+	a.load()
+
+	a.Logger.Debug().
+		Strs("metrics", a.metrics).
+		Float64("alpha", a.alpha).
+		Int("buckets", a.buckets).
+		Str("path", a.path).
+		Int("instances", len(a.state.Instances)).
+		Msg("initialized")
+	return nil
+}
+
+func (a *Anomaly) float(name string, defaultValue float64) (float64, error) {
+	if v := a.Params.GetChildContentS(name); v != "" {
+		return strconv.ParseFloat(v, 64)
+	}
+	return defaultValue, nil
+}
+
+func (a *Anomaly) int(name string, defaultValue int) (int, error) {
+	if v := a.Params.GetChildContentS(name); v != "" {
+		return strconv.Atoi(v)
+	}
+	return defaultValue, nil
+}
+
+func (a *Anomaly) load() {
+	a.state = state{Version: stateVersion, Buckets: a.buckets, Instances: make(map[string]*baseline)}
+	var s state
+	if err := persist.Load(a.path, &s); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			a.Logger.Warn().Err(err).Str("path", a.path).Msg("Unable to load baselines, starting over")
+		}
+		return
+	}
+	// baselines of another seasonality can't be reused
+	if s.Version != stateVersion || s.Buckets != a.buckets || s.Instances == nil {
+		a.Logger.Warn().Str("path", a.path).Msg("Baselines don't match the configuration, starting over")
+		return
+	}
+	a.state = s
+}
+
+func (a *Anomaly) save() {
+	if err := persist.Save(a.path, a.state); err != nil {
+		a.Logger.Error().Err(err).Str("path", a.path).Msg("Unable to save baselines")
+	}
+}
+
+func (a *Anomaly) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	data := dataMap[a.Object]
+	now := a.now()
+	bucket := a.bucket(now)
+
+	for _, name := range a.metrics {
+		metric := data.LookupMetric(name)
+		if metric == nil {
+			a.Logger.Debug().Str("metric", name).Msg("metric not found, skip")
+			continue
+		}
+		score, err := a.newMetric(data, name+scoreSuffix)
+		if err != nil {
+			return nil, err
+		}
+		flag, err := a.newMetric(data, name+anomalySuffix)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, instance := range data.GetInstances() {
+			v, ok := metric.GetValueFloat64(instance)
+			if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			b := a.state.Instances[key]
+			if b == nil {
+				b = &baseline{Metrics: make(map[string][]*stats)}
+				a.state.Instances[key] = b
+			}
+			b.Seen = now.Unix()
+			if b.Metrics[name] == nil {
+				b.Metrics[name] = make([]*stats, a.buckets)
+			}
+			s := b.Metrics[name][bucket]
+			if s == nil {
+				s = &stats{}
+				b.Metrics[name][bucket] = s
+			}
+
+			// the value is scored against the baseline before it is added to it
+			if s.N >= a.warmup {
+				z := s.score(v)
+				_ = score.SetValueFloat64(instance, z)
+				anomaly := 0.0
+				if math.Abs(z) >= a.threshold {
+					anomaly = 1
+				}
+				_ = flag.SetValueFloat64(instance, anomaly)
+			}
+			s.update(v, a.alpha)
+		}
+	}
+
+	a.evict()
+
+	if a.lastSave.IsZero() {
+		a.lastSave = now
+	}
+	if now.Sub(a.lastSave) >= a.checkpoint {
+		a.save()
+		a.lastSave = now
+	}
+	return nil, nil
+}
+
+// Stop saves the baselines learned since the last checkpoint when the poller stops
+func (a *Anomaly) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// the baselines were not updated when the plugin did not run
+	if !a.lastSave.IsZero() {
+		a.save()
+	}
+}
+
+// bucket returns the seasonal baseline of the time, the hour of the day or of the week
+func (a *Anomaly) bucket(t time.Time) int {
+	if a.buckets == 1 {
+		return 0
+	}
+	t = t.UTC()
+	return (int(t.Weekday())*24 + t.Hour()) % a.buckets
+}
+
+// score returns the z-score of the value. It is 0 while the baseline has no variance.
+func (s *stats) score(v float64) float64 {
+	std := math.Sqrt(s.Variance)
+	if std == 0 {
+		return 0
+	}
+	return (v - s.Mean) / std
+}
+
+// update adds a value to the exponentially weighted mean and variance
+func (s *stats) update(v, alpha float64) {
+	s.N++
+	if s.N == 1 {
+		s.Mean = v
+		return
+	}
+	diff := v - s.Mean
+	incr := alpha * diff
+	s.Mean += incr
+	s.Variance = (1 - alpha) * (s.Variance + diff*incr)
+}
+
+// evict removes the instances that were not seen for the longest time when there are more than max_instances
+func (a *Anomaly) evict() {
+	excess := len(a.state.Instances) - a.maxInstances
+	if excess <= 0 {
+		return
+	}
+	keys := make([]string, 0, len(a.state.Instances))
+	for key := range a.state.Instances {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := a.state.Instances[keys[i]].Seen, a.state.Instances[keys[j]].Seen
+		if si != sj {
+			return si < sj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys[:excess] {
+		delete(a.state.Instances, key)
+	}
+	a.Logger.Debug().Int("evicted", excess).Msg("max_instances reached")
+}
+
+func (a *Anomaly) newMetric(data *matrix.Matrix, name string) (*matrix.Metric, error) {
+	if metric := data.GetMetric(name); metric != nil {
+		return metric, nil
+	}
+	metric, err := data.NewMetricFloat64(name)
+	if err != nil {
+		return nil, err
+	}
+	metric.SetProperty("raw")
+	return metric, nil
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package anomaly
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+
+func newAnomaly(t *testing.T, dir string, params map[string]string) *Anomaly {
+	n := node.NewS("Anomaly")
+	n.NewChildS("state_dir", dir)
+	n.NewChildS("metrics", "").NewChildS("", "read_latency")
+	for k, v := range params {
+		n.NewChildS(k, v)
+	}
+	abc := plugin.New("Test", nil, n, nil, "Volume", nil)
+	p := &Anomaly{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return start }
+	return p
+}
+
+func newVolumes(latency map[string]float64) *matrix.Matrix {
+	m := matrix.New("ZapiPerf", "volume", "volume")
+	metric, _ := m.NewMetricFloat64("read_latency")
+	for name, value := range latency {
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("volume", name)
+		_ = metric.SetValueFloat64(instance, value)
+	}
+	return m
+}
+
+func run(t *testing.T, p *Anomaly, latency map[string]float64) *matrix.Matrix {
+	t.Helper()
+	data := newVolumes(latency)
+	if _, err := p.Run(map[string]*matrix.Matrix{"Volume": data}); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func value(m *matrix.Matrix, metric string, instance string) (float64, bool) {
+	mt := m.GetMetric(metric)
+	if mt == nil {
+		return 0, false
+	}
+	return mt.GetValueFloat64(m.GetInstance(instance))
+}
+
+func TestAnomaly(t *testing.T) {
+	dir := t.TempDir()
+	params := map[string]string{"warmup": "10", "alpha": "0.2", "checkpoint": "0s"}
+	p := newAnomaly(t, dir, params)
+
+	for i := 0; i < 20; i++ {
+		data := run(t, p, map[string]float64{"vol1": 100 + float64(i%2)*10})
+		_, ok := value(data, "read_latency_anomaly_score", "vol1")
+		if i < 10 && ok {
+			t.Errorf("poll %d: score during warm-up", i)
+		}
+		if i >= 10 {
+			if flag, _ := value(data, "read_latency_anomaly", "vol1"); flag != 0 {
+				t.Errorf("poll %d: anomaly got=%f, want=0", i, flag)
+			}
+		}
+	}
+
+	// baselines are reloaded after a restart
+	p = newAnomaly(t, dir, params)
+	data := run(t, p, map[string]float64{"vol1": 1000})
+	score, _ := value(data, "read_latency_anomaly_score", "vol1")
+	flag, _ := value(data, "read_latency_anomaly", "vol1")
+	if score < 3 || flag != 1 {
+		t.Errorf("spike got score=%f anomaly=%f, want score>=3 anomaly=1", score, flag)
+	}
+}
+
+func TestAnomalyStop(t *testing.T) {
+	dir := t.TempDir()
+	p := newAnomaly(t, dir, map[string]string{})
+	run(t, p, map[string]float64{"vol1": 100})
+
+	// the baselines are saved when the poller stops, before the first checkpoint
+	if reloaded := newAnomaly(t, dir, map[string]string{}); len(reloaded.state.Instances) != 0 {
+		t.Errorf("reloaded instances got=%d, want=0 before the checkpoint", len(reloaded.state.Instances))
+	}
+	p.Stop()
+	if reloaded := newAnomaly(t, dir, map[string]string{}); len(reloaded.state.Instances) != 1 {
+		t.Errorf("reloaded instances got=%d, want=1 after stop", len(reloaded.state.Instances))
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := &stats{}
+	for _, v := range []float64{10, 10, 10} {
+		s.update(v, 0.1)
+	}
+	if s.Mean != 10 || s.Variance != 0 || s.score(20) != 0 {
+		t.Errorf("constant got mean=%f variance=%f, want mean=10 variance=0", s.Mean, s.Variance)
+	}
+	s.update(20, 0.5)
+	if math.Abs(s.Mean-15) > 1e-9 || math.Abs(s.Variance-25) > 1e-9 {
+		t.Errorf("got mean=%f variance=%f, want mean=15 variance=25", s.Mean, s.Variance)
+	}
+	if math.Abs(s.score(25)-2) > 1e-9 {
+		t.Errorf("score got=%f, want=2", s.score(25))
+	}
+}
+
+func TestSeasonalityAndEviction(t *testing.T) {
+	p := newAnomaly(t, t.TempDir(), map[string]string{"seasonality": "daily", "max_instances": "2"})
+
+	if p.bucket(start.Add(5*time.Hour)) != 5 {
+		t.Errorf("bucket got=%d, want=5", p.bucket(start.Add(5*time.Hour)))
+	}
+
+	run(t, p, map[string]float64{"vol1": 1, "vol2": 1})
+	p.now = func() time.Time { return start.Add(time.Hour) }
+	run(t, p, map[string]float64{"vol2": 1, "vol3": 1})
+
+	if len(p.state.Instances) != 2 || p.state.Instances["vol1"] != nil {
+		t.Errorf("instances got=%d, want vol2 and vol3", len(p.state.Instances))
+	}
+	if b := p.state.Instances["vol2"].Metrics["read_latency"]; b[0] == nil || b[1] == nil || b[2] != nil {
+		t.Error("each hour should have its own baseline")
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package changelog implements a plugin that tracks changes of the labels of configuration objects, e.g. when the
+// QoS policy of a volume changed.
+package changelog
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/persist"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stateVersion     = 1
+	defaultRetention = time.Hour
+
+	objectName = "change_log"
+	timeMetric = "time"
+
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// ChangeLog compares the labels of each instance with the previous poll, and exports a change_log matrix with an
+// instance for each change. Changes are exported for the duration of retention.
+//
+//	plugins:
+//	  - ChangeLog:
+//	      track:
+//	        - qos_policy_group
+//	        - export_policy
+//	      labels:
+//	        - svm
+//	        - volume
+//	      retention: 1h
+//	      log: true
+//
+// The previous labels are persisted, so changes made while the poller was down are reported after a restart.
+type ChangeLog struct {
+	*plugin.AbstractPlugin
+	track     []string // labels compared between polls, all labels when empty
+	labels    []string // labels of the instance copied to the changes, all labels when empty
+	retention time.Duration
+	log       bool
+	path      string
+	state     state
+	now       func() time.Time
+}
+
+type state struct {
+	Version int `json:"version"`
+	// Instances are the labels of each instance at the last poll, nil before the first poll
+	Instances map[string]map[string]string `json:"instances"`
+	Changes   []change                     `json:"changes"`
+}
+
+// change is a created or deleted instance, or a changed label of an instance
+type change struct {
+	Time   int64             `json:"time"`
+	Key    string            `json:"key"`
+	Op     string            `json:"op"`
+	Track  string            `json:"track,omitempty"`
+	Old    string            `json:"old,omitempty"`
+	New    string            `json:"new,omitempty"`
+	Labels map[string]string `json:"labels"`
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &ChangeLog{AbstractPlugin: p}
+}
+
+func (c *ChangeLog) Init() error {
+
+	var err error
+
+	if err = c.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	if t := c.Params.GetChildS("track"); t != nil {
+		c.track = t.GetAllChildContentS()
+	}
+	if l := c.Params.GetChildS("labels"); l != nil {
+		c.labels = l.GetAllChildContentS()
+	}
+
+	c.retention = defaultRetention
+	if r := c.Params.GetChildContentS("retention"); r != "" {
+		if c.retention, err = time.ParseDuration(r); err != nil {
+			return errs.New(errs.ErrInvalidParam, "retention: "+err.Error())
+		}
+	}
+	if l := c.Params.GetChildContentS("log"); l != "" {
+		if c.log, err = strconv.ParseBool(l); err != nil {
+			return errs.New(errs.ErrInvalidParam, "log: "+l)
+		}
+	}
+
+	c.now = time.Now
+	c.path = c.StatePath()
+	c.load()
+
+	c.Logger.Debug().
+		Strs("track", c.track).
+		Str("retention", c.retention.String()).
+		Str("path", c.path).
+		Int("instances", len(c.state.Instances)).
+		Msg("initialized")
+	return nil
+}
+
+func (c *ChangeLog) load() {
+	c.state = state{Version: stateVersion}
+	var s state
+	if err := persist.Load(c.path, &s); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.Logger.Warn().Err(err).Str("path", c.path).Msg("Unable to load state, starting over")
+		}
+		return
+	}
+	if s.Version != stateVersion {
+		c.Logger.Warn().Int("version", s.Version).Str("path", c.path).Msg("Unknown state version, starting over")
+		return
+	}
+	c.state = s
+}
+
+func (c *ChangeLog) save() {
+	if err := persist.Save(c.path, c.state); err != nil {
+		c.Logger.Error().Err(err).Str("path", c.path).Msg("Unable to save state")
+	}
+}
+
+func (c *ChangeLog) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	data := dataMap[c.Object]
+	ts := c.now().Unix()
+
+	current := make(map[string]map[string]string, len(data.GetInstances()))
+	for key, instance := range data.GetInstances() {
+		current[key] = maps.Clone(instance.GetLabels())
+	}
+
+	var changes []change
+	// the first poll without state is the baseline, all instances would be reported as created otherwise
+	if c.state.Instances != nil {
+		changes = c.compare(c.state.Instances, current, ts)
+	}
+
+	changed := len(changes) > 0 || !equal(c.state.Instances, current)
+	c.state.Instances = current
+	c.state.Changes = append(c.state.Changes, changes...)
+
+	oldest := ts - int64(c.retention.Seconds())
+	i := 0
+	for i < len(c.state.Changes) && c.state.Changes[i].Time < oldest {
+		i++
+	}
+	if i > 0 {
+		c.state.Changes = c.state.Changes[i:]
+		changed = true
+	}
+
+	if c.log {
+		for _, ch := range changes {
+			c.Logger.Info().
+				Str("key", ch.Key).
+				Str("op", ch.Op).
+				Str("track", ch.Track).
+				Str("old", ch.Old).
+				Str("new", ch.New).
+				Interface("labels", ch.Labels).
+				Msg("change")
+		}
+	}
+
+	if changed {
+		c.save()
+	}
+
+	return []*matrix.Matrix{c.export(data)}, nil
+}
+
+// compare returns the changes between the labels of the previous and current poll, ordered by key
+func (c *ChangeLog) compare(previous, current map[string]map[string]string, ts int64) []change {
+	var changes []change
+
+	for _, key := range sortedKeys(current) {
+		labels := current[key]
+		old, ok := previous[key]
+		if !ok {
+			changes = append(changes, change{Time: ts, Key: key, Op: opCreate, Labels: c.copyLabels(labels)})
+			continue
+		}
+		tracked := c.track
+		if len(tracked) == 0 {
+			all := maps.Clone(old)
+			maps.Copy(all, labels)
+			tracked = sortedKeys(all)
+		}
+		for _, label := range tracked {
+			if old[label] != labels[label] {
+				changes = append(changes, change{
+					Time:   ts,
+					Key:    key,
+					Op:     opUpdate,
+					Track:  label,
+					Old:    old[label],
+					New:    labels[label],
+					Labels: c.copyLabels(labels),
+				})
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(previous) {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, change{Time: ts, Key: key, Op: opDelete, Labels: c.copyLabels(previous[key])})
+		}
+	}
+	return changes
+}
+
+func (c *ChangeLog) copyLabels(labels map[string]string) map[string]string {
+	if len(c.labels) == 0 {
+		return maps.Clone(labels)
+	}
+	copied := make(map[string]string, len(c.labels))
+	for _, label := range c.labels {
+		copied[label] = labels[label]
+	}
+	return copied
+}
+
+// export creates a matrix with an instance for each retained change. The metric time is the unix timestamp of the
+// poll that detected the change.
+func (c *ChangeLog) export(data *matrix.Matrix) *matrix.Matrix {
+	mat := matrix.New(c.Parent+".ChangeLog", objectName, objectName)
+	mat.SetExportOptions(matrix.DefaultExportOptions())
+	mat.SetGlobalLabels(data.GetGlobalLabels())
+	metric, _ := mat.NewMetricFloat64(timeMetric)
+
+	for i, ch := range c.state.Changes {
+		key := strings.Join([]string{ch.Key, ch.Op, ch.Track, strconv.FormatInt(ch.Time, 10), strconv.Itoa(i)}, "/")
+		instance, err := mat.NewInstance(key)
+		if err != nil {
+			c.Logger.Warn().Err(err).Str("key", key).Msg("error while creating instance")
+			continue
+		}
+		instance.SetLabels(maps.Clone(ch.Labels))
+		instance.SetLabel("object", data.Object)
+		instance.SetLabel("op", ch.Op)
+		instance.SetLabel("track", ch.Track)
+		instance.SetLabel("old_value", ch.Old)
+		instance.SetLabel("new_value", ch.New)
+		_ = metric.SetValueFloat64(instance, float64(ch.Time))
+	}
+	return mat
+}
+
+func equal(a, b map[string]map[string]string) bool {
+	if a == nil || len(a) != len(b) {
+		return false
+	}
+	for key, labels := range a {
+		if !maps.Equal(labels, b[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
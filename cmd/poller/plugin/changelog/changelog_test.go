@@ -0,0 +1,123 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package changelog
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newChangeLog(t *testing.T, dir string) *ChangeLog {
+	params := node.NewS("ChangeLog")
+	params.NewChildS("state_dir", dir)
+	params.NewChildS("retention", "1h")
+	track := params.NewChildS("track", "")
+	track.NewChildS("", "qos_policy_group")
+	track.NewChildS("", "export_policy")
+	labels := params.NewChildS("labels", "")
+	labels.NewChildS("", "volume")
+
+	abc := plugin.New("Test", nil, params, nil, "Volume", nil)
+	p := &ChangeLog{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return start }
+	return p
+}
+
+// newVolumes creates a volume for each name with the labels qos_policy_group, export_policy and state
+func newVolumes(volumes map[string][]string) *matrix.Matrix {
+	m := matrix.New("Zapi", "volume", "volume")
+	for name, labels := range volumes {
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("volume", name)
+		instance.SetLabel("qos_policy_group", labels[0])
+		instance.SetLabel("export_policy", labels[1])
+		instance.SetLabel("state", labels[2])
+	}
+	return m
+}
+
+func changes(t *testing.T, p *ChangeLog, data *matrix.Matrix) []string {
+	t.Helper()
+	results, err := p.Run(map[string]*matrix.Matrix{"Volume": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("results got=%d, want=1", len(results))
+	}
+	var got []string
+	for _, instance := range results[0].GetInstances() {
+		got = append(got, strings.Join([]string{
+			instance.GetLabel("volume"),
+			instance.GetLabel("op"),
+			instance.GetLabel("track"),
+			instance.GetLabel("old_value"),
+			instance.GetLabel("new_value"),
+		}, " "))
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestChangeLog(t *testing.T) {
+	dir := t.TempDir()
+	p := newChangeLog(t, dir)
+
+	// the first poll is the baseline
+	got := changes(t, p, newVolumes(map[string][]string{
+		"vol1": {"gold", "default", "online"},
+		"vol2": {"silver", "default", "online"},
+	}))
+	if len(got) != 0 {
+		t.Errorf("baseline got=%v, want none", got)
+	}
+
+	// untracked labels are ignored
+	got = changes(t, p, newVolumes(map[string][]string{
+		"vol1": {"bronze", "default", "offline"},
+		"vol3": {"gold", "default", "online"},
+	}))
+	want := []string{
+		"vol1 update qos_policy_group gold bronze",
+		"vol2 delete   ",
+		"vol3 create   ",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("changes got=%v, want=%v", got, want)
+	}
+
+	// changes are remembered after a restart
+	p = newChangeLog(t, dir)
+	p.now = func() time.Time { return start.Add(30 * time.Minute) }
+	got = changes(t, p, newVolumes(map[string][]string{
+		"vol1": {"bronze", "restricted", "offline"},
+		"vol3": {"gold", "default", "online"},
+	}))
+	want = append(want, "vol1 update export_policy default restricted")
+	sort.Strings(want)
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("changes after restart got=%v, want=%v", got, want)
+	}
+
+	// changes older than retention are dropped
+	p.now = func() time.Time { return start.Add(2 * time.Hour) }
+	got = changes(t, p, newVolumes(map[string][]string{
+		"vol1": {"bronze", "restricted", "offline"},
+		"vol3": {"gold", "default", "online"},
+	}))
+	if len(got) != 0 {
+		t.Errorf("retained changes got=%v, want none", got)
+	}
+}
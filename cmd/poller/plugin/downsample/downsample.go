@@ -0,0 +1,245 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package downsample implements a plugin that summarizes the metrics of several polls, to export them at a lower
+// resolution.
+package downsample
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var functions = []string{"min", "max", "avg", "last"}
+
+// Downsample accumulates the metrics of each instance over a window of polls, and exports a summary of the window
+// when it ends.
+//
+//	plugins:
+//	  - Downsample:
+//	      window: 15
+//	      functions:
+//	        - avg
+//	        - max
+//	      exporters:
+//	        - archive
+//
+// The window is a number of polls or a duration. With exporters, the summary is only exported to these exporters,
+// and the data of each poll is exported to all other exporters.
+type Downsample struct {
+	*plugin.AbstractPlugin
+	polls     int           // number of polls of the window, or
+	duration  time.Duration // duration of the window
+	functions []string
+	metrics   []string // metrics to summarize, all when empty
+	exporters []string
+	count     int       // polls in the current window
+	start     time.Time // start of the current window
+	instances map[string]*summary
+	sources   map[string]*matrix.Metric // metrics of the current window by key, without values
+	now       func() time.Time
+}
+
+// summary are the accumulated values of an instance
+type summary struct {
+	labels  map[string]string
+	metrics map[string]*stats
+}
+
+type stats struct {
+	min, max, sum, last float64
+	n                   int
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &Downsample{AbstractPlugin: p}
+}
+
+func (d *Downsample) Init() error {
+
+	var err error
+
+	if err = d.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	window := d.Params.GetChildContentS("window")
+	if window == "" {
+		return errs.New(errs.ErrMissingParam, "window")
+	}
+	if d.polls, err = strconv.Atoi(window); err != nil {
+		if d.duration, err = time.ParseDuration(window); err != nil || d.duration <= 0 {
+			return errs.New(errs.ErrInvalidParam, "window must be a number of polls or a duration: "+window)
+		}
+	} else if d.polls < 1 {
+		return errs.New(errs.ErrInvalidParam, "window: "+window)
+	}
+
+	d.functions = functions
+	if f := d.Params.GetChildS("functions"); f != nil {
+		d.functions = f.GetAllChildContentS()
+		for _, name := range d.functions {
+			if !isFunction(name) {
+				return errs.New(errs.ErrInvalidParam, "function: "+name)
+			}
+		}
+	}
+	if m := d.Params.GetChildS("metrics"); m != nil {
+		d.metrics = m.GetAllChildContentS()
+	}
+	if e := d.Params.GetChildS("exporters"); e != nil {
+		d.exporters = e.GetAllChildContentS()
+	}
+
+	d.instances = make(map[string]*summary)
+	d.sources = make(map[string]*matrix.Metric)
+	d.now = time.Now
+
+	d.Logger.Debug().
+		Int("polls", d.polls).
+		Str("duration", d.duration.String()).
+		Strs("functions", d.functions).
+		Strs("exporters", d.exporters).
+		Msg("initialized")
+	return nil
+}
+
+func isFunction(name string) bool {
+	for _, f := range functions {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Downsample) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	data := dataMap[d.Object]
+	now := d.now()
+
+	// the data of each poll is not exported to the exporters of the summary
+	if len(d.exporters) > 0 {
+		data.SetSkipExporters(d.exporters)
+	}
+
+	if d.count == 0 {
+		d.start = now
+	}
+	d.accumulate(data)
+	d.count++
+
+	if d.polls > 0 && d.count < d.polls || d.duration > 0 && now.Sub(d.start) < d.duration {
+		return nil, nil
+	}
+
+	result := d.summarize(data)
+	d.count = 0
+	d.instances = make(map[string]*summary)
+	d.sources = make(map[string]*matrix.Metric)
+	return []*matrix.Matrix{result}, nil
+}
+
+func (d *Downsample) accumulate(data *matrix.Matrix) {
+	// metrics are keyed by their key, the elements of an array counter share the name of the counter
+	metrics := make(map[string]*matrix.Metric)
+	for key, metric := range data.GetMetrics() {
+		// the buckets of a histogram are not summarized, their min or max is not a histogram
+		if metric.IsHistogram() || metric.Buckets() != nil {
+			continue
+		}
+		if len(d.metrics) == 0 && metric.IsExportable() || slices.Contains(d.metrics, key) || slices.Contains(d.metrics, metric.GetName()) {
+			metrics[key] = metric
+			if d.sources[key] == nil {
+				d.sources[key] = metric.Clone(false)
+			}
+		}
+	}
+
+	for key, instance := range data.GetInstances() {
+		if !instance.IsExportable() {
+			continue
+		}
+		s := d.instances[key]
+		if s == nil {
+			s = &summary{metrics: make(map[string]*stats)}
+			d.instances[key] = s
+		}
+		s.labels = maps.Clone(instance.GetLabels())
+
+		for key, metric := range metrics {
+			v, ok := metric.GetValueFloat64(instance)
+			if !ok || math.IsNaN(v) {
+				continue
+			}
+			st := s.metrics[key]
+			if st == nil {
+				st = &stats{min: v, max: v}
+				s.metrics[key] = st
+			}
+			st.min = min(st.min, v)
+			st.max = max(st.max, v)
+			st.sum += v
+			st.last = v
+			st.n++
+		}
+	}
+}
+
+// summarize creates a matrix with a metric per function and metric, e.g. read_ops_avg, with the labels of the metric
+// and the labels of the last poll of each instance
+func (d *Downsample) summarize(data *matrix.Matrix) *matrix.Matrix {
+	mat := data.Clone(matrix.With{Data: false, Metrics: false, Instances: false})
+	mat.UUID = d.Parent + ".Downsample"
+	mat.SetExportable(true)
+	mat.SetSkipExporters(nil)
+	if len(d.exporters) > 0 {
+		mat.SetExportTo(d.exporters)
+	}
+
+	for key, s := range d.instances {
+		instance, err := mat.NewInstance(key)
+		if err != nil {
+			d.Logger.Warn().Err(err).Str("key", key).Msg("error while creating instance")
+			continue
+		}
+		instance.SetLabels(s.labels)
+
+		for key, st := range s.metrics {
+			source := d.sources[key]
+			for _, f := range d.functions {
+				metricKey := key + "_" + f
+				metric := mat.GetMetric(metricKey)
+				if metric == nil {
+					if metric, err = mat.NewMetricFloat64(metricKey, source.GetName()+"_"+f); err != nil {
+						d.Logger.Error().Err(err).Str("metric", metricKey).Msg("error while creating metric")
+						continue
+					}
+					metric.SetLabels(maps.Clone(source.GetLabels()))
+				}
+				_ = metric.SetValueFloat64(instance, st.value(f))
+			}
+		}
+	}
+	return mat
+}
+
+func (st *stats) value(function string) float64 {
+	switch function {
+	case "min":
+		return st.min
+	case "max":
+		return st.max
+	case "avg":
+		return st.sum / float64(st.n)
+	default:
+		return st.last
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package downsample
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"testing"
+	"time"
+)
+
+func newDownsample(t *testing.T, window string, exporters ...string) *Downsample {
+	params := node.NewS("Downsample")
+	params.NewChildS("window", window)
+	if len(exporters) > 0 {
+		e := params.NewChildS("exporters", "")
+		for _, name := range exporters {
+			e.NewChildS("", name)
+		}
+	}
+	p := &Downsample{AbstractPlugin: plugin.New("Test", nil, params, nil, "Volume", nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func newVolumes(ops float64) *matrix.Matrix {
+	m := matrix.New("ZapiPerf", "volume", "volume")
+	metric, _ := m.NewMetricFloat64("total_ops")
+	instance, _ := m.NewInstance("vol1")
+	instance.SetLabel("volume", "vol1")
+	_ = metric.SetValueFloat64(instance, ops)
+	return m
+}
+
+func TestDownsamplePolls(t *testing.T) {
+	p := newDownsample(t, "3", "archive")
+
+	var results []*matrix.Matrix
+	for i, ops := range []float64{10, 40, 20} {
+		data := newVolumes(ops)
+		var err error
+		if results, err = p.Run(map[string]*matrix.Matrix{"Volume": data}); err != nil {
+			t.Fatal(err)
+		}
+		if data.IsExportableTo("archive") || !data.IsExportableTo("prometheus") {
+			t.Errorf("poll %d: data should only be exported to prometheus", i)
+		}
+		if i < 2 && results != nil {
+			t.Errorf("poll %d: summary before the end of the window", i)
+		}
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("results got=%d, want=1", len(results))
+	}
+	summary := results[0]
+	if !summary.IsExportableTo("archive") || summary.IsExportableTo("prometheus") {
+		t.Error("summary should only be exported to archive")
+	}
+	if summary.GetInstance("vol1").GetLabel("volume") != "vol1" {
+		t.Error("summary should have the labels of the instance")
+	}
+
+	want := map[string]float64{"total_ops_min": 10, "total_ops_max": 40, "total_ops_avg": 70.0 / 3, "total_ops_last": 20}
+	for name, value := range want {
+		metric := summary.GetMetric(name)
+		if metric == nil {
+			t.Errorf("metric %s not found", name)
+			continue
+		}
+		if got, _ := metric.GetValueFloat64(summary.GetInstance("vol1")); got != value {
+			t.Errorf("%s got=%f, want=%f", name, got, value)
+		}
+	}
+
+	// the next window starts over
+	results, _ = p.Run(map[string]*matrix.Matrix{"Volume": newVolumes(5)})
+	if results != nil || p.count != 1 {
+		t.Errorf("next window got results=%v count=%d, want none and 1", results, p.count)
+	}
+}
+
+func TestDownsampleDuration(t *testing.T) {
+	p := newDownsample(t, "5m")
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, minutes := range []int{0, 2, 4, 5} {
+		now := start.Add(time.Duration(minutes) * time.Minute)
+		p.now = func() time.Time { return now }
+		data := newVolumes(float64(i))
+		results, _ := p.Run(map[string]*matrix.Matrix{"Volume": data})
+		if !data.IsExportableTo("prometheus") {
+			t.Error("data should be exported to all exporters")
+		}
+		if (results != nil) != (minutes == 5) {
+			t.Errorf("minute %d: got results=%v", minutes, results != nil)
+		}
+	}
+}
+
+func TestDownsampleArrayCounter(t *testing.T) {
+	params := node.NewS("Downsample")
+	params.NewChildS("window", "2")
+	params.NewChildS("functions", "").NewChildS("", "max")
+	p := &Downsample{AbstractPlugin: plugin.New("Test", nil, params, nil, "Volume", nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []*matrix.Matrix
+	for _, latency := range []float64{10, 30} {
+		data := matrix.New("ZapiPerf", "volume", "volume")
+		instance, _ := data.NewInstance("vol1")
+		for _, op := range []string{"read", "write"} {
+			metric, _ := data.NewMetricFloat64("latency."+op, "latency")
+			metric.SetLabel("metric", op)
+			metric.SetArray(true)
+			_ = metric.SetValueFloat64(instance, latency)
+			latency++
+		}
+		histogram, _ := data.NewMetricFloat64("size_histogram.<4KB", "size_histogram")
+		histogram.SetHistogram(true)
+		_ = histogram.SetValueFloat64(instance, 1)
+		var err error
+		if results, err = p.Run(map[string]*matrix.Matrix{"Volume": data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("results got=%d, want=1", len(results))
+	}
+	summary := results[0]
+	want := map[string]float64{"read": 30, "write": 31}
+	if len(summary.GetMetrics()) != len(want) {
+		t.Errorf("metrics got=%d, want=%d without the histogram", len(summary.GetMetrics()), len(want))
+	}
+	for op, value := range want {
+		metric := summary.GetMetric("latency." + op + "_max")
+		if metric == nil {
+			t.Errorf("latency of %s is missing", op)
+			continue
+		}
+		if metric.GetName() != "latency_max" || metric.GetLabel("metric") != op {
+			t.Errorf("%s got name=%s label=%s, want name=latency_max label=%s", op, metric.GetName(), metric.GetLabel("metric"), op)
+		}
+		if got, _ := metric.GetValueFloat64(summary.GetInstance("vol1")); got != value {
+			t.Errorf("latency of %s got=%f, want=%f", op, got, value)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package plugin
+
+import (
+	"github.com/netapp/harvest/v2/pkg/expr"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+)
+
+// SubscribeRefs subscribes to the objects referenced by an expression, except the object of the plugin
+func SubscribeRefs(e *expr.Expr, object string) {
+	for _, ref := range e.Refs() {
+		if ref.Object != object {
+			Subscribe(ref.Object)
+		}
+	}
+}
+
+// ExprEnv resolves the operands of expressions for the instances of a matrix, one instance at a time. Metrics of
+// other objects are looked up in the data of the collector first, then in the data published by other collectors.
+type ExprEnv struct {
+	logger   *logging.Logger
+	data     *matrix.Matrix
+	dataMap  map[string]*matrix.Matrix
+	key      string
+	instance *matrix.Instance
+	metrics  map[string]*matrix.Metric
+	joins    map[*expr.Ref]*join
+}
+
+// join finds the instances of another object
+type join struct {
+	data    *matrix.Matrix
+	metric  *matrix.Metric
+	byLabel map[string]*matrix.Instance
+}
+
+func NewExprEnv(data *matrix.Matrix, dataMap map[string]*matrix.Matrix, logger *logging.Logger) *ExprEnv {
+	return &ExprEnv{
+		logger:  logger,
+		data:    data,
+		dataMap: dataMap,
+		metrics: make(map[string]*matrix.Metric),
+		joins:   make(map[*expr.Ref]*join),
+	}
+}
+
+// SetInstance sets the instance the expressions are evaluated for
+func (e *ExprEnv) SetInstance(key string, instance *matrix.Instance) {
+	e.key = key
+	e.instance = instance
+}
+
+func (e *ExprEnv) Metric(name string) (float64, bool) {
+	metric, ok := e.metrics[name]
+	if !ok {
+		if metric = e.data.LookupMetric(name); metric == nil {
+			e.logger.Debug().Str("metric", name).Msg("expression: metric not found")
+		}
+		e.metrics[name] = metric
+	}
+	if metric == nil {
+		return 0, false
+	}
+	return metric.GetValueFloat64(e.instance)
+}
+
+func (e *ExprEnv) Label(name string) string {
+	return e.instance.GetLabel(name)
+}
+
+func (e *ExprEnv) Ref(ref *expr.Ref) (float64, bool) {
+	j, ok := e.joins[ref]
+	if !ok {
+		j = e.newJoin(ref)
+		e.joins[ref] = j
+	}
+	if j.metric == nil {
+		return 0, false
+	}
+
+	var other *matrix.Instance
+	switch {
+	case ref.Label != "":
+		other = j.byLabel[e.instance.GetLabel(ref.Label)]
+	case len(j.data.GetInstances()) == 1:
+		for _, instance := range j.data.GetInstances() {
+			other = instance
+		}
+	default:
+		other = j.data.GetInstance(e.key)
+	}
+	if other == nil {
+		return 0, false
+	}
+	return j.metric.GetValueFloat64(other)
+}
+
+func (e *ExprEnv) newJoin(ref *expr.Ref) *join {
+	j := &join{data: e.dataMap[ref.Object]}
+	if j.data == nil {
+		j.data = Latest(ref.Object)
+	}
+	if j.data == nil {
+		e.logger.Debug().Str("ref", ref.String()).Msg("expression: object not published yet")
+		return j
+	}
+	if j.metric = j.data.LookupMetric(ref.Metric); j.metric == nil {
+		e.logger.Debug().Str("ref", ref.String()).Msg("expression: metric not found")
+		return j
+	}
+	if ref.Label != "" {
+		j.byLabel = make(map[string]*matrix.Instance)
+		for _, instance := range j.data.GetInstances() {
+			j.byLabel[instance.GetLabel(ref.OtherLabel)] = instance
+		}
+	}
+	return j
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package external implements a plugin that runs outside of the poller process. The plugin either executes a
+// program for each poll, or talks to a long-lived sidecar over a Unix socket. Both receive the matrices of the
+// collector as JSON and answer with the matrices they modified or created. See docs/plugins.md for the protocol.
+package external
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProtocolVersion = 1
+	defaultTimeout  = 30 * time.Second
+	minBackoff      = time.Second
+	maxBackoff      = 5 * time.Minute
+	// maxStderr is the number of bytes of stderr that are logged when a program fails
+	maxStderr = 4096
+)
+
+// Request is sent to the external plugin on each run
+type Request struct {
+	Version   int                       `json:"version"`
+	Poller    string                    `json:"poller"`
+	Collector string                    `json:"collector"`
+	Object    string                    `json:"object"`
+	Plugin    string                    `json:"plugin"`
+	Params    map[string]any            `json:"params,omitempty"`
+	Matrices  map[string]*matrix.Matrix `json:"matrices"`
+}
+
+// Response is the answer of the external plugin. Matrices are merged into the matrices of the request with the same
+// key, New are additional matrices that are exported.
+type Response struct {
+	Matrices map[string]*matrix.Matrix `json:"matrices,omitempty"`
+	New      []*matrix.Matrix          `json:"new,omitempty"`
+	Error    string                    `json:"error,omitempty"`
+}
+
+type External struct {
+	*plugin.AbstractPlugin
+	command  []string // program executed for each run
+	socket   string   // Unix socket of a long-lived sidecar
+	sidecar  []string // optional program that serves socket, started and restarted by the plugin
+	timeout  time.Duration
+	params   map[string]any
+	mu       sync.Mutex // guards proc and exited, the poller stops the sidecar from another goroutine
+	proc     *exec.Cmd
+	exited   chan struct{}
+	failures int
+	retryAt  time.Time
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &External{AbstractPlugin: p}
+}
+
+func (e *External) Init() error {
+
+	var err error
+
+	if err = e.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	if name := e.Params.GetChildContentS("name"); name != "" {
+		e.Name = name
+		e.Logger = e.Logger.SubLogger("name", name)
+	}
+
+	e.command = parseCommand(e.Params.GetChildS("exec"))
+	e.socket = e.Params.GetChildContentS("socket")
+	e.sidecar = parseCommand(e.Params.GetChildS("sidecar"))
+
+	switch {
+	case len(e.command) == 0 && e.socket == "":
+		return errs.New(errs.ErrMissingParam, "exec or socket")
+	case len(e.command) != 0 && e.socket != "":
+		return errs.New(errs.ErrInvalidParam, "exec and socket are exclusive")
+	case len(e.sidecar) != 0 && e.socket == "":
+		return errs.New(errs.ErrMissingParam, "socket of sidecar")
+	}
+
+	e.timeout = defaultTimeout
+	if t := e.Params.GetChildContentS("timeout"); t != "" {
+		if e.timeout, err = time.ParseDuration(t); err != nil {
+			return errs.New(errs.ErrInvalidParam, "timeout: "+err.Error())
+		}
+	}
+
+	e.params = make(map[string]any)
+	if p := e.Params.GetChildS("params"); p != nil {
+		for _, c := range p.GetChildren() {
+			if c.GetContentS() != "" {
+				e.params[c.GetNameS()] = c.GetContentS()
+			} else {
+				e.params[c.GetNameS()] = c.GetAllChildContentS()
+			}
+		}
+	}
+
+	e.Logger.Debug().
+		Strs("exec", e.command).
+		Str("socket", e.socket).
+		Strs("sidecar", e.sidecar).
+		Str("timeout", e.timeout.String()).
+		Msg("initialized")
+	return nil
+}
+
+// parseCommand accepts a command line as a string, or a list of arguments
+func parseCommand(n *node.Node) []string {
+	if n == nil {
+		return nil
+	}
+	if n.GetContentS() != "" {
+		return strings.Fields(n.GetContentS())
+	}
+	return n.GetAllChildContentS()
+}
+
+func (e *External) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	if time.Now().Before(e.retryAt) {
+		e.Logger.Debug().Str("retryAt", e.retryAt.Format(time.RFC3339)).Msg("skip, waiting to retry")
+		return nil, nil
+	}
+
+	var poller string
+	if e.Options != nil {
+		poller = e.Options.Poller
+	}
+
+	body, err := json.Marshal(Request{
+		Version:   ProtocolVersion,
+		Poller:    poller,
+		Collector: e.Parent,
+		Object:    e.Object,
+		Plugin:    e.Name,
+		Params:    e.params,
+		Matrices:  dataMap,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	if e.socket != "" {
+		out, err = e.runSocket(body)
+	} else {
+		out, err = e.runExec(body)
+	}
+	if err != nil {
+		return nil, e.fail(err)
+	}
+
+	var response Response
+	if err = json.Unmarshal(out, &response); err != nil {
+		return nil, e.fail(errs.New(errs.ErrAPIResponse, "invalid response: "+err.Error()))
+	}
+	if response.Error != "" {
+		return nil, e.fail(errs.New(errs.ErrAPIResponse, response.Error))
+	}
+
+	// the response is complete, apply it
+	for key, m := range response.Matrices {
+		data, ok := dataMap[key]
+		if !ok || m == nil {
+			e.Logger.Warn().Str("key", key).Msg("response has an unknown matrix, ignored")
+			continue
+		}
+		if err = merge(data, m); err != nil {
+			return nil, e.fail(errs.New(errs.ErrAPIResponse, "invalid matrix "+key+": "+err.Error()))
+		}
+	}
+	newMatrices := make([]*matrix.Matrix, 0, len(response.New))
+	for _, m := range response.New {
+		if m != nil {
+			newMatrices = append(newMatrices, m)
+		}
+	}
+
+	if e.failures > 0 {
+		e.Logger.Info().Int("failures", e.failures).Msg("recovered")
+	}
+	e.failures = 0
+	return newMatrices, nil
+}
+
+// merge applies the matrix of a response to the matrix of the collector. Instances and metrics that are not in the
+// response are removed, the others are updated in place, so that the collector and the plugins that run after
+// External keep their references. Global labels of the response are added, export options are kept.
+func merge(data *matrix.Matrix, from *matrix.Matrix) error {
+	var err error
+
+	for label, value := range from.GetGlobalLabels() {
+		data.SetGlobalLabel(label, value)
+	}
+
+	for key := range data.GetMetrics() {
+		if from.GetMetric(key) == nil {
+			data.RemoveMetric(key)
+		}
+	}
+	for key := range data.GetInstances() {
+		if from.GetInstance(key) == nil {
+			data.RemoveInstance(key)
+		}
+	}
+
+	for key, fromMetric := range from.GetMetrics() {
+		metric := data.GetMetric(key)
+		if metric == nil {
+			if metric, err = data.NewMetricType(key, fromMetric.GetType(), fromMetric.GetName()); err != nil {
+				return err
+			}
+		}
+		metric.SetProperty(fromMetric.GetProperty())
+		metric.SetComment(fromMetric.GetComment())
+		metric.SetArray(fromMetric.IsArray())
+		metric.SetHistogram(fromMetric.IsHistogram())
+		metric.SetBuckets(fromMetric.Buckets())
+		metric.SetExportable(fromMetric.IsExportable())
+		metric.SetLabels(fromMetric.GetLabels())
+	}
+
+	for key, fromInstance := range from.GetInstances() {
+		instance := data.GetInstance(key)
+		if instance == nil {
+			if instance, err = data.NewInstance(key); err != nil {
+				return err
+			}
+		}
+		instance.SetExportable(fromInstance.IsExportable())
+		instance.ClearLabels()
+		for label, value := range fromInstance.GetLabels() {
+			instance.SetLabel(label, value)
+		}
+		for metricKey, fromMetric := range from.GetMetrics() {
+			metric := data.GetMetric(metricKey)
+			if value, ok := fromMetric.GetValueFloat64(fromInstance); ok {
+				_ = metric.SetValueFloat64(instance, value)
+			} else {
+				metric.SetValueNAN(instance)
+			}
+		}
+	}
+	return nil
+}
+
+// runExec starts the program, writes the request to its stdin and reads the response from its stdout
+func (e *External) runExec(body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// don't wait for children of the program that keep stdout open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		e.Logger.Debug().Str("stderr", truncate(stderr.String())).Msg("plugin stderr")
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, errs.New(errs.ErrConnection, "timeout after "+e.timeout.String())
+	}
+	if err != nil {
+		return nil, errs.New(errs.ErrConnection, err.Error()+": "+truncate(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// runSocket sends the request as a line of JSON to the sidecar and reads one line of JSON as response.
+// The sidecar is (re)started when it is not running.
+func (e *External) runSocket(body []byte) ([]byte, error) {
+	deadline := time.Now().Add(e.timeout)
+
+	if len(e.sidecar) != 0 && !e.isSidecarRunning() {
+		if err := e.startSidecar(); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := e.dial(deadline)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+
+	if _, err = conn.Write(append(body, '\n')); err != nil {
+		return nil, errs.New(errs.ErrConnection, err.Error())
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, errs.New(errs.ErrConnection, err.Error())
+	}
+	return line, nil
+}
+
+// dial connects to the socket. A sidecar that was just started may not listen yet, so dial retries until deadline.
+func (e *External) dial(deadline time.Time) (net.Conn, error) {
+	for {
+		conn, err := net.DialTimeout("unix", e.socket, time.Until(deadline))
+		if err == nil {
+			return conn, nil
+		}
+		if len(e.sidecar) == 0 || time.Now().Add(100*time.Millisecond).After(deadline) {
+			return nil, errs.New(errs.ErrConnection, err.Error())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (e *External) startSidecar() error {
+	cmd := exec.Command(e.sidecar[0], e.sidecar[1:]...)
+	cmd.Stderr = &logWriter{e}
+	if err := cmd.Start(); err != nil {
+		return errs.New(errs.ErrConnection, "start sidecar: "+err.Error())
+	}
+	exited := make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		e.Logger.Warn().Err(err).Int("pid", cmd.Process.Pid).Msg("sidecar exited")
+		close(exited)
+	}()
+	e.mu.Lock()
+	e.proc = cmd
+	e.exited = exited
+	e.mu.Unlock()
+	e.Logger.Info().Int("pid", cmd.Process.Pid).Msg("started sidecar")
+	return nil
+}
+
+func (e *External) isSidecarRunning() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.exited == nil {
+		return false
+	}
+	select {
+	case <-e.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// fail delays the next run with an exponential backoff. A sidecar is stopped, since it may hang, and is restarted
+// by the next run.
+func (e *External) fail(err error) error {
+	e.failures++
+	backoff := maxBackoff
+	if e.failures < 20 {
+		backoff = min(minBackoff<<(e.failures-1), maxBackoff)
+	}
+	e.retryAt = time.Now().Add(backoff)
+
+	e.killSidecar()
+
+	e.Logger.Debug().
+		Int("failures", e.failures).
+		Str("backoff", backoff.String()).
+		Msg("retry after backoff")
+	return err
+}
+
+// Stop kills the sidecar when the poller stops
+func (e *External) Stop() {
+	e.killSidecar()
+}
+
+func (e *External) killSidecar() {
+	if e.isSidecarRunning() {
+		e.mu.Lock()
+		_ = e.proc.Process.Kill()
+		e.mu.Unlock()
+	}
+}
+
+func truncate(s string) string {
+	if len(s) > maxStderr {
+		return s[len(s)-maxStderr:]
+	}
+	return s
+}
+
+// logWriter logs the stderr of a sidecar
+type logWriter struct {
+	e *External
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.e.Logger.Debug().Str("stderr", truncate(strings.TrimSpace(string(p)))).Msg("sidecar stderr")
+	return len(p), nil
+}
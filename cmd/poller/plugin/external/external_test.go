@@ -0,0 +1,258 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newExternal(t *testing.T, params map[string]string, command ...string) *External {
+	t.Helper()
+	p := node.NewS("External")
+	for k, v := range params {
+		p.NewChildS(k, v)
+	}
+	if len(command) > 0 {
+		c := p.NewChildS("exec", "")
+		for _, arg := range command {
+			c.NewChildS("", arg)
+		}
+	}
+	e := &External{AbstractPlugin: plugin.New("Zapi", nil, p, nil, "volume", nil)}
+	if err := e.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func newData() map[string]*matrix.Matrix {
+	m := matrix.New("Zapi", "volume", "volume")
+	m.SetExportOptions(matrix.DefaultExportOptions())
+	m.SetGlobalLabel("datacenter", "dc1")
+	size, _ := m.NewMetricFloat64("size")
+	instance, _ := m.NewInstance("vol1")
+	instance.SetLabel("volume", "vol1")
+	_ = size.SetValueFloat64(instance, 100)
+	return map[string]*matrix.Matrix{"volume": m}
+}
+
+func checkResponse(t *testing.T, dataMap map[string]*matrix.Matrix, data *matrix.Matrix, got []*matrix.Matrix) {
+	t.Helper()
+	if dataMap["volume"] != data {
+		t.Errorf("data matrix should be updated in place")
+	}
+	if data.GetExportOptions() == nil {
+		t.Errorf("export options should be kept")
+	}
+	if got := data.GetGlobalLabels()["datacenter"]; got != "dc1" {
+		t.Errorf("global label datacenter got=%s, want=dc1", got)
+	}
+	instance := data.GetInstance("vol1")
+	if instance.GetLabel("owner") != "db-team" {
+		t.Errorf("label owner got=%s, want=db-team", instance.GetLabel("owner"))
+	}
+	if v, _ := data.GetMetric("size").GetValueFloat64(instance); v != 200 {
+		t.Errorf("size got=%v, want=200", v)
+	}
+	if len(got) != 1 || got[0].Object != "volume_owner" {
+		t.Fatalf("new matrices got=%v, want volume_owner", got)
+	}
+}
+
+func TestExternal_Exec(t *testing.T) {
+	dir := t.TempDir()
+	requestFile := filepath.Join(dir, "request.json")
+	e := newExternal(t, map[string]string{"name": "owner"}, "sh", "-c", "cat > "+requestFile+"; cat testdata/response.json")
+	if e.GetName() != "owner" {
+		t.Errorf("name got=%s, want=owner", e.GetName())
+	}
+
+	dataMap := newData()
+	data := dataMap["volume"]
+	got, err := e.Run(dataMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, dataMap, data, got)
+
+	b, err := os.ReadFile(requestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request Request
+	if err := json.Unmarshal(b, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Version != ProtocolVersion || request.Object != "volume" || request.Collector != "Zapi" || request.Plugin != "owner" {
+		t.Errorf("request got=%+v", request)
+	}
+	if m := request.Matrices["volume"]; m == nil || m.GetInstance("vol1") == nil {
+		t.Errorf("request matrices got=%v", request.Matrices)
+	}
+}
+
+func TestExternal_Failures(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		command []string
+	}{
+		{name: "exit code", command: []string{"sh", "-c", "echo broken >&2; exit 3"}},
+		{name: "timeout", params: map[string]string{"timeout": "100ms"}, command: []string{"sleep", "5"}},
+		{name: "invalid response", command: []string{"echo", "{"}},
+		{name: "error response", command: []string{"echo", `{"error": "no owner database"}`}},
+		{name: "missing program", command: []string{"/does/not/exist"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newExternal(t, tt.params, tt.command...)
+			dataMap := newData()
+
+			start := time.Now()
+			if _, err := e.Run(dataMap); err == nil {
+				t.Fatalf("Run() should fail")
+			}
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("Run() took %s", time.Since(start))
+			}
+			if v, _ := dataMap["volume"].GetMetric("size").GetValueFloat64(dataMap["volume"].GetInstance("vol1")); v != 100 {
+				t.Errorf("data should not change on failure, size got=%v", v)
+			}
+
+			// next run is skipped during backoff
+			if e.failures != 1 || !e.retryAt.After(time.Now()) {
+				t.Errorf("failures=%d retryAt=%s, want backoff", e.failures, e.retryAt)
+			}
+			if got, err := e.Run(dataMap); got != nil || err != nil {
+				t.Errorf("Run() during backoff got=%v err=%v", got, err)
+			}
+		})
+	}
+}
+
+func TestExternal_Socket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	response, err := os.ReadFile("testdata/response.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compact Response
+	if err := json.Unmarshal(response, &compact); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(compact)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = bufio.NewReader(conn).ReadBytes('\n')
+			_, _ = conn.Write(append(line, '\n'))
+			_ = conn.Close()
+		}
+	}()
+
+	e := newExternal(t, map[string]string{"socket": socket, "timeout": "2s"})
+	dataMap := newData()
+	data := dataMap["volume"]
+	got, err := e.Run(dataMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponse(t, dataMap, data, got)
+}
+
+func TestExternal_Merge(t *testing.T) {
+	dataMap := newData()
+	data := dataMap["volume"]
+	size := data.GetMetric("size")
+	_, _ = data.NewMetricFloat64("files")
+	_, _ = data.NewInstance("vol2")
+
+	from := matrix.New("Zapi", "volume", "volume")
+	fromSize, _ := from.NewMetricFloat64("size")
+	fromSize.SetProperty("raw")
+	for _, key := range []string{"vol1", "vol3"} {
+		instance, _ := from.NewInstance(key)
+		instance.SetLabel("volume", key)
+		_ = fromSize.SetValueFloat64(instance, 300)
+	}
+
+	if err := merge(data, from); err != nil {
+		t.Fatal(err)
+	}
+	if data.GetMetric("size") != size || size.GetProperty() != "raw" {
+		t.Errorf("metric size should be updated in place")
+	}
+	if data.GetMetric("files") != nil {
+		t.Errorf("metric files should be removed")
+	}
+	if data.GetInstance("vol2") != nil || data.GetInstance("vol3") == nil {
+		t.Errorf("instances got=%v, want=[vol1 vol3]", data.GetInstanceKeys())
+	}
+	for _, key := range []string{"vol1", "vol3"} {
+		if v, ok := size.GetValueFloat64(data.GetInstance(key)); !ok || v != 300 {
+			t.Errorf("size of %s got=%v, want=300", key, v)
+		}
+	}
+	if got := data.GetGlobalLabels()["datacenter"]; got != "dc1" {
+		t.Errorf("global label datacenter got=%s, want=dc1", got)
+	}
+}
+
+func TestExternal_Stop(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	e := newExternal(t, map[string]string{"socket": socket, "sidecar": "sleep 60"})
+	if err := e.startSidecar(); err != nil {
+		t.Fatal(err)
+	}
+	e.Stop()
+	select {
+	case <-e.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sidecar should be killed")
+	}
+}
+
+func TestExternal_Init(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+	}{
+		{name: "no exec or socket", params: map[string]string{}},
+		{name: "exec and socket", params: map[string]string{"exec": "true", "socket": "/tmp/x.sock"}},
+		{name: "sidecar without socket", params: map[string]string{"exec": "true", "sidecar": "server"}},
+		{name: "invalid timeout", params: map[string]string{"exec": "true", "timeout": "1x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := node.NewS("External")
+			for k, v := range tt.params {
+				p.NewChildS(k, v)
+			}
+			e := &External{AbstractPlugin: plugin.New("Zapi", nil, p, nil, "volume", nil)}
+			if err := e.Init(); err == nil {
+				t.Errorf("Init() should fail")
+			}
+		})
+	}
+}
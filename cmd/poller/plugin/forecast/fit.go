@@ -0,0 +1,150 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package forecast
+
+import (
+	"math"
+)
+
+// sample is a value of the used metric at a unix timestamp in seconds
+type sample struct {
+	T int64   `json:"t"`
+	V float64 `json:"v"`
+}
+
+// trend is a least-squares line fitted to the samples of an instance, optionally with a seasonal profile. x is the
+// time in days since the first sample.
+type trend struct {
+	start    int64   // timestamp of x=0
+	a, b     float64 // intercept and slope per day
+	s        float64 // standard error of the residuals
+	n        float64
+	xm, sxx  float64
+	season   float64   // length of the season in days, 0 without seasonal profile
+	profile  []float64 // mean residual of each bucket of the season
+	seasonal bool
+}
+
+const secondsPerDay = 86400
+
+func days(t, start int64) float64 {
+	return float64(t-start) / secondsPerDay
+}
+
+// fitLinear fits a line to the samples. It needs at least three samples at different times.
+func fitLinear(samples []sample) (*trend, bool) {
+	n := float64(len(samples))
+	if n < 3 {
+		return nil, false
+	}
+	f := &trend{start: samples[0].T, n: n}
+
+	var sx, sy float64
+	for _, s := range samples {
+		sx += days(s.T, f.start)
+		sy += s.V
+	}
+	f.xm = sx / n
+	ym := sy / n
+
+	var sxy float64
+	for _, s := range samples {
+		dx := days(s.T, f.start) - f.xm
+		f.sxx += dx * dx
+		sxy += dx * (s.V - ym)
+	}
+	if f.sxx == 0 {
+		return nil, false
+	}
+	f.b = sxy / f.sxx
+	f.a = ym - f.b*f.xm
+	f.s = f.residualError(samples)
+	return f, true
+}
+
+// fitSeasonal fits a line and the mean residual of each bucket of the season, e.g. the hours of a week. It falls
+// back to a line when the samples don't cover two seasons.
+func fitSeasonal(samples []sample, season float64, buckets int) (*trend, bool) {
+	f, ok := fitLinear(samples)
+	if !ok {
+		return nil, false
+	}
+	if days(samples[len(samples)-1].T, f.start) < 2*season || buckets < 2 {
+		return f, true
+	}
+
+	sums := make([]float64, buckets)
+	counts := make([]float64, buckets)
+	for _, s := range samples {
+		x := days(s.T, f.start)
+		i := bucket(x, season, buckets)
+		sums[i] += s.V - (f.a + f.b*x)
+		counts[i]++
+	}
+	f.profile = make([]float64, buckets)
+	for i := range sums {
+		if counts[i] > 0 {
+			f.profile[i] = sums[i] / counts[i]
+		}
+	}
+	f.season = season
+	f.seasonal = true
+	f.s = f.residualError(samples)
+	return f, true
+}
+
+func bucket(x, season float64, buckets int) int {
+	phase := math.Mod(x, season) / season
+	if phase < 0 {
+		phase++
+	}
+	return min(int(phase*float64(buckets)), buckets-1)
+}
+
+func (f *trend) residualError(samples []sample) float64 {
+	if f.n <= 2 {
+		return 0
+	}
+	var sse float64
+	for _, s := range samples {
+		r := s.V - f.predict(days(s.T, f.start))
+		sse += r * r
+	}
+	return math.Sqrt(sse / (f.n - 2))
+}
+
+func (f *trend) predict(x float64) float64 {
+	y := f.a + f.b*x
+	if f.seasonal {
+		y += f.profile[bucket(x, f.season, len(f.profile))]
+	}
+	return y
+}
+
+// at returns the projected value at the timestamp and the half-width of its prediction interval for the z-score
+func (f *trend) at(t int64, z float64) (float64, float64) {
+	x := days(t, f.start)
+	se := f.s * math.Sqrt(1+1/f.n+(x-f.xm)*(x-f.xm)/f.sxx)
+	return f.predict(x), z * se
+}
+
+// daysUntil returns the days from the timestamp until the trend line reaches the limit. Seasonal variations are
+// ignored. It returns false when the trend does not grow.
+func (f *trend) daysUntil(t int64, limit float64) (float64, bool) {
+	x := days(t, f.start)
+	current := f.a + f.b*x
+	if current >= limit {
+		return 0, true
+	}
+	if f.b <= 0 {
+		return 0, false
+	}
+	return (limit - current) / f.b, true
+}
+
+// zScore returns the z-score of a two-sided confidence level, e.g. 1.96 for 0.95
+func zScore(confidence float64) float64 {
+	return math.Sqrt2 * math.Erfinv(confidence)
+}
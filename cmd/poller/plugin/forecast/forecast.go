@@ -0,0 +1,342 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package forecast implements a plugin that projects the used capacity of volumes and aggregates.
+package forecast
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/persist"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stateVersion      = 1
+	defaultHistory    = 30 * 24 * time.Hour
+	defaultInterval   = 6 * time.Hour
+	defaultSeason     = 7 * 24 * time.Hour
+	defaultConfidence = 0.95
+	defaultMinSamples = 8
+	defaultCheckpoint = time.Hour
+
+	daysUntilFull = "forecast_days_until_full"
+	growthPerDay  = "forecast_growth_per_day"
+)
+
+// Forecast keeps a rolling history of the used size of each instance and projects it with a linear trend, optionally
+// with a seasonal profile. The history is persisted, so forecasts continue after a restart of the poller.
+//
+//	plugins:
+//	  - Forecast:
+//	      history: 30d
+//	      interval: 6h
+//	      model: seasonal
+//	      days:
+//	        - 30
+//	        - 90
+type Forecast struct {
+	*plugin.AbstractPlugin
+	used       string
+	total      string
+	history    time.Duration
+	interval   time.Duration // minimum time between two samples of the history
+	seasonal   bool
+	season     time.Duration
+	horizons   []int // days of the projections
+	confidence float64
+	minSamples int
+	checkpoint time.Duration
+	path       string
+	mu         sync.Mutex // guards samples, the poller saves them from another goroutine when it stops
+	samples    map[string][]sample
+	changed    bool      // the history changed since the last checkpoint
+	lastSave   time.Time // time of the last checkpoint, or of the first poll
+	now        func() time.Time
+}
+
+type state struct {
+	Version   int                 `json:"version"`
+	Instances map[string][]sample `json:"instances"`
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &Forecast{AbstractPlugin: p}
+}
+
+func (f *Forecast) Init() error {
+
+	var err error
+
+	if err = f.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	// aggregate templates name their size metrics space_*
+	used, total := "size_used", "size_total"
+	if strings.HasPrefix(strings.ToLower(f.Object), "aggr") {
+		used, total = "space_used", "space_total"
+	}
+	if f.used = f.Params.GetChildContentS("used"); f.used == "" {
+		f.used = used
+	}
+	if f.total = f.Params.GetChildContentS("total"); f.total == "" {
+		f.total = total
+	}
+
+	if f.history, err = parseDays(f.Params.GetChildContentS("history"), defaultHistory); err != nil {
+		return errs.New(errs.ErrInvalidParam, "history: "+err.Error())
+	}
+	if f.interval, err = parseDays(f.Params.GetChildContentS("interval"), defaultInterval); err != nil {
+		return errs.New(errs.ErrInvalidParam, "interval: "+err.Error())
+	}
+	if f.season, err = parseDays(f.Params.GetChildContentS("season"), defaultSeason); err != nil {
+		return errs.New(errs.ErrInvalidParam, "season: "+err.Error())
+	}
+
+	switch model := f.Params.GetChildContentS("model"); model {
+	case "", "linear":
+	case "seasonal":
+		f.seasonal = true
+		if f.season < 2*f.interval {
+			return errs.New(errs.ErrInvalidParam, "season must be at least twice the interval")
+		}
+	default:
+		return errs.New(errs.ErrInvalidParam, "model: "+model)
+	}
+
+	f.horizons = []int{30}
+	if h := f.Params.GetChildS("days"); h != nil {
+		values := h.GetAllChildContentS()
+		if h.GetContentS() != "" {
+			values = strings.Split(h.GetContentS(), ",")
+		}
+		f.horizons = f.horizons[:0]
+		for _, v := range values {
+			d, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || d < 1 {
+				return errs.New(errs.ErrInvalidParam, "days: "+v)
+			}
+			f.horizons = append(f.horizons, d)
+		}
+	}
+
+	f.confidence = defaultConfidence
+	if c := f.Params.GetChildContentS("confidence"); c != "" {
+		if f.confidence, err = strconv.ParseFloat(c, 64); err != nil || f.confidence <= 0 || f.confidence >= 1 {
+			return errs.New(errs.ErrInvalidParam, "confidence: "+c)
+		}
+	}
+
+	f.minSamples = defaultMinSamples
+	if m := f.Params.GetChildContentS("min_samples"); m != "" {
+		if f.minSamples, err = strconv.Atoi(m); err != nil || f.minSamples < 3 {
+			return errs.New(errs.ErrInvalidParam, "min_samples: "+m)
+		}
+	}
+
+	f.checkpoint = defaultCheckpoint
+	if c := f.Params.GetChildContentS("checkpoint"); c != "" {
+		if f.checkpoint, err = time.ParseDuration(c); err != nil {
+			return errs.New(errs.ErrInvalidParam, "checkpoint: "+err.Error())
+		}
+	}
+
+	f.now = time.Now
+	f.path = f.StatePath()
+	f.samples = make(map[string][]sample)
+	f.load()
+
+	f.Logger.Debug().
+		Str("used", f.used).
+		Str("history", f.history.String()).
+		Str("interval", f.interval.String()).
+		Bool("seasonal", f.seasonal).
+		Ints("days", f.horizons).
+		Str("path", f.path).
+		Int("instances", len(f.samples)).
+		Msg("initialized")
+	return nil
+}
+
+// parseDays parses a Go duration, or a number of days like "30d"
+func parseDays(s string, defaultValue time.Duration) (time.Duration, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	if d, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 {
+			return 0, errors.New("invalid number of days " + s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (f *Forecast) load() {
+	var s state
+	if err := persist.Load(f.path, &s); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			f.Logger.Warn().Err(err).Str("path", f.path).Msg("Unable to load history, starting over")
+		}
+		return
+	}
+	if s.Version != stateVersion || s.Instances == nil {
+		f.Logger.Warn().Int("version", s.Version).Str("path", f.path).Msg("Unknown history version, starting over")
+		return
+	}
+	f.samples = s.Instances
+}
+
+func (f *Forecast) save() {
+	if err := persist.Save(f.path, state{Version: stateVersion, Instances: f.samples}); err != nil {
+		f.Logger.Error().Err(err).Str("path", f.path).Msg("Unable to save history")
+	}
+}
+
+func (f *Forecast) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data := dataMap[f.Object]
+	now := f.now()
+	ts := now.Unix()
+
+	used := data.LookupMetric(f.used)
+	if used == nil {
+		f.Logger.Warn().Str("metric", f.used).Msg("used metric not found, skip")
+		return nil, nil
+	}
+	total := data.LookupMetric(f.total)
+
+	metrics, err := f.createMetrics(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.prune(ts) {
+		f.changed = true
+	}
+	z := zScore(f.confidence)
+
+	for key, instance := range data.GetInstances() {
+		if v, ok := used.GetValueFloat64(instance); ok {
+			history := f.samples[key]
+			if len(history) == 0 || ts-history[len(history)-1].T >= int64(f.interval.Seconds()) {
+				f.samples[key] = append(history, sample{T: ts, V: v})
+				f.changed = true
+			}
+		}
+
+		// collectors that keep their instances keep the values of the previous poll, which are stale when there
+		// is no forecast
+		for _, metric := range metrics {
+			metric.SetValueNAN(instance)
+		}
+
+		history := f.samples[key]
+		if len(history) < f.minSamples {
+			continue
+		}
+		var t *trend
+		var ok bool
+		if f.seasonal {
+			t, ok = fitSeasonal(history, f.season.Hours()/24, int(f.season/f.interval))
+		} else {
+			t, ok = fitLinear(history)
+		}
+		if !ok {
+			continue
+		}
+
+		_ = metrics[growthPerDay].SetValueFloat64(instance, t.b)
+		if total != nil {
+			if limit, ok := total.GetValueFloat64(instance); ok && limit > 0 {
+				if d, ok := t.daysUntil(ts, limit); ok {
+					_ = metrics[daysUntilFull].SetValueFloat64(instance, d)
+				}
+			}
+		}
+		for _, h := range f.horizons {
+			projected, bound := t.at(ts+int64(h)*secondsPerDay, z)
+			name := projectedName(h)
+			_ = metrics[name].SetValueFloat64(instance, projected)
+			_ = metrics[name+"_lower"].SetValueFloat64(instance, max(projected-bound, 0))
+			_ = metrics[name+"_upper"].SetValueFloat64(instance, projected+bound)
+		}
+	}
+
+	if f.lastSave.IsZero() {
+		f.lastSave = now
+	}
+	if f.changed && now.Sub(f.lastSave) >= f.checkpoint {
+		f.save()
+		f.changed = false
+		f.lastSave = now
+	}
+	return nil, nil
+}
+
+// Stop saves the history that changed since the last checkpoint when the poller stops
+func (f *Forecast) Stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.changed {
+		f.save()
+		f.changed = false
+	}
+}
+
+// prune removes samples older than the history, and instances without samples
+func (f *Forecast) prune(ts int64) bool {
+	oldest := ts - int64(f.history.Seconds())
+	pruned := false
+	for key, history := range f.samples {
+		i := 0
+		for i < len(history) && history[i].T < oldest {
+			i++
+		}
+		switch {
+		case i == len(history):
+			delete(f.samples, key)
+			pruned = true
+		case i > 0:
+			f.samples[key] = history[i:]
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+func projectedName(days int) string {
+	return "forecast_used_" + strconv.Itoa(days) + "d"
+}
+
+func (f *Forecast) createMetrics(data *matrix.Matrix) (map[string]*matrix.Metric, error) {
+	names := []string{daysUntilFull, growthPerDay}
+	for _, h := range f.horizons {
+		name := projectedName(h)
+		names = append(names, name, name+"_lower", name+"_upper")
+	}
+	metrics := make(map[string]*matrix.Metric, len(names))
+	for _, name := range names {
+		metric := data.GetMetric(name)
+		if metric == nil {
+			var err error
+			if metric, err = data.NewMetricFloat64(name); err != nil {
+				return nil, err
+			}
+			metric.SetProperty("forecast")
+		}
+		metrics[name] = metric
+	}
+	return metrics, nil
+}
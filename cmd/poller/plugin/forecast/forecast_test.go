@@ -0,0 +1,247 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package forecast
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newForecast(t *testing.T, dir string, params map[string]string) (*Forecast, *time.Time) {
+	n := node.NewS("Forecast")
+	n.NewChildS("state_dir", dir)
+	for k, v := range params {
+		n.NewChildS(k, v)
+	}
+	abc := plugin.New("Test", nil, n, nil, "Volume", nil)
+	p := &Forecast{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	now := start
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func newVolumes(used, total float64) *matrix.Matrix {
+	m := matrix.New("Zapi", "volume", "volume")
+	u, _ := m.NewMetricFloat64("size_used")
+	s, _ := m.NewMetricFloat64("size_total")
+	instance, _ := m.NewInstance("vol1")
+	instance.SetLabel("volume", "vol1")
+	_ = u.SetValueFloat64(instance, used)
+	_ = s.SetValueFloat64(instance, total)
+	return m
+}
+
+func value(t *testing.T, m *matrix.Matrix, metric string) (float64, bool) {
+	t.Helper()
+	mt := m.GetMetric(metric)
+	if mt == nil {
+		t.Fatalf("metric %s not found", metric)
+	}
+	return mt.GetValueFloat64(m.GetInstance("vol1"))
+}
+
+func TestFitLinear(t *testing.T) {
+	var samples []sample
+	for i := 0; i < 10; i++ {
+		samples = append(samples, sample{T: start.Unix() + int64(i)*secondsPerDay, V: 100 + 10*float64(i)})
+	}
+	f, ok := fitLinear(samples)
+	if !ok {
+		t.Fatal("fit failed")
+	}
+	if math.Abs(f.b-10) > 1e-9 || math.Abs(f.a-100) > 1e-9 {
+		t.Errorf("fit got a=%f b=%f, want a=100 b=10", f.a, f.b)
+	}
+	v, bound := f.at(samples[9].T+10*secondsPerDay, 1.96)
+	if math.Abs(v-290) > 1e-9 || bound > 1e-9 {
+		t.Errorf("at got=%f±%f, want=290±0", v, bound)
+	}
+	d, ok := f.daysUntil(samples[9].T, 290)
+	if !ok || math.Abs(d-10) > 1e-9 {
+		t.Errorf("daysUntil got=%f, want=10", d)
+	}
+
+	if _, ok := fitLinear(samples[:2]); ok {
+		t.Error("fit of two samples should fail")
+	}
+}
+
+func TestFitSeasonal(t *testing.T) {
+	// daily peak of +20 on a flat line, sampled every 6 hours for four days
+	var samples []sample
+	for i := 0; i < 16; i++ {
+		v := 100.0
+		if i%4 == 2 {
+			v += 20
+		}
+		samples = append(samples, sample{T: start.Unix() + int64(i)*6*3600, V: v})
+	}
+	f, ok := fitSeasonal(samples, 1, 4)
+	if !ok || !f.seasonal {
+		t.Fatal("seasonal fit failed")
+	}
+	peak, _ := f.at(start.Unix()+10*secondsPerDay+12*3600, 1.96)
+	low, _ := f.at(start.Unix()+10*secondsPerDay, 1.96)
+	if math.Abs(peak-low-20) > 1e-6 {
+		t.Errorf("seasonal amplitude got=%f, want=20", peak-low)
+	}
+
+	if f, _ := fitSeasonal(samples[:6], 1, 4); f.seasonal {
+		t.Error("less than two seasons should fall back to linear")
+	}
+}
+
+func TestForecast(t *testing.T) {
+	dir := t.TempDir()
+	params := map[string]string{"interval": "1d", "min_samples": "5", "days": "10"}
+	p, now := newForecast(t, dir, params)
+
+	for i := 0; i < 5; i++ {
+		data := newVolumes(100+10*float64(i), 200)
+		if _, err := p.Run(map[string]*matrix.Matrix{"Volume": data}); err != nil {
+			t.Fatal(err)
+		}
+		if i < 4 {
+			if _, ok := value(t, data, daysUntilFull); ok {
+				t.Errorf("run %d: forecast before min_samples", i)
+			}
+		}
+		*now = now.Add(24 * time.Hour)
+	}
+
+	// samples more frequent than interval are ignored
+	*now = now.Add(-time.Hour)
+	data := newVolumes(500, 200)
+	_, _ = p.Run(map[string]*matrix.Matrix{"Volume": data})
+	if len(p.samples["vol1"]) != 5 {
+		t.Errorf("samples got=%d, want=5", len(p.samples["vol1"]))
+	}
+
+	// history is reloaded after a restart
+	p, _ = newForecast(t, dir, params)
+	if len(p.samples["vol1"]) != 5 {
+		t.Fatalf("reloaded samples got=%d, want=5", len(p.samples["vol1"]))
+	}
+	p.now = func() time.Time { return start.Add(4 * 24 * time.Hour) }
+	data = newVolumes(140, 200)
+	_, _ = p.Run(map[string]*matrix.Matrix{"Volume": data})
+
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{daysUntilFull, 6},
+		{growthPerDay, 10},
+		{"forecast_used_10d", 240},
+		{"forecast_used_10d_lower", 240},
+		{"forecast_used_10d_upper", 240},
+	}
+	for _, tt := range tests {
+		got, ok := value(t, data, tt.metric)
+		if !ok || math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s got=%f, want=%f", tt.metric, got, tt.want)
+		}
+	}
+
+	// old samples are pruned
+	p.now = func() time.Time { return start.Add(60 * 24 * time.Hour) }
+	data = newVolumes(0, 0)
+	data.RemoveInstance("vol1")
+	_, _ = p.Run(map[string]*matrix.Matrix{"Volume": data})
+	if len(p.samples) != 0 {
+		t.Errorf("instances got=%d, want=0", len(p.samples))
+	}
+}
+
+func run(t *testing.T, p *Forecast, data *matrix.Matrix) {
+	t.Helper()
+	if _, err := p.Run(map[string]*matrix.Matrix{"Volume": data}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForecastCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	params := map[string]string{"interval": "1d", "checkpoint": "48h"}
+	p, now := newForecast(t, dir, params)
+
+	// the second sample is kept in memory until the checkpoint
+	run(t, p, newVolumes(100, 200))
+	*now = now.Add(24 * time.Hour)
+	run(t, p, newVolumes(110, 200))
+	if reloaded, _ := newForecast(t, dir, params); len(reloaded.samples) != 0 {
+		t.Errorf("reloaded instances got=%d, want=0 before the checkpoint", len(reloaded.samples))
+	}
+
+	*now = now.Add(24 * time.Hour)
+	run(t, p, newVolumes(120, 200))
+	if reloaded, _ := newForecast(t, dir, params); len(reloaded.samples["vol1"]) != 3 {
+		t.Errorf("reloaded samples got=%d, want=3 after the checkpoint", len(reloaded.samples["vol1"]))
+	}
+
+	// the samples since the checkpoint are saved when the poller stops
+	*now = now.Add(24 * time.Hour)
+	run(t, p, newVolumes(130, 200))
+	p.Stop()
+	if reloaded, _ := newForecast(t, dir, params); len(reloaded.samples["vol1"]) != 4 {
+		t.Errorf("reloaded samples got=%d, want=4 after stop", len(reloaded.samples["vol1"]))
+	}
+}
+
+func TestForecastReset(t *testing.T) {
+	p, now := newForecast(t, t.TempDir(), map[string]string{"interval": "1d", "min_samples": "3", "history": "5d"})
+
+	// the collector keeps its instances and values between polls
+	data := newVolumes(100, 200)
+	instance := data.GetInstance("vol1")
+	for i := 0; i < 3; i++ {
+		_ = data.GetMetric("size_used").SetValueFloat64(instance, 100+10*float64(i))
+		run(t, p, data)
+		*now = now.Add(24 * time.Hour)
+	}
+	if _, ok := value(t, data, growthPerDay); !ok {
+		t.Fatal("growth should be projected")
+	}
+
+	// the history is pruned to one sample, the previous projections are removed
+	*now = now.Add(10 * 24 * time.Hour)
+	run(t, p, data)
+	for _, metric := range []string{growthPerDay, daysUntilFull, "forecast_used_30d"} {
+		if mt := data.GetMetric(metric); mt != nil {
+			if v, ok := mt.GetValueFloat64(instance); ok {
+				t.Errorf("%s got=%f, want no value", metric, v)
+			}
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", time.Hour, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"6h", 6 * time.Hour, false},
+		{"xd", 0, true},
+		{"0d", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDays(tt.in, time.Hour)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDays(%q) got=%v %v, want=%v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package join
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"strings"
+)
+
+// Join copies labels and metrics of another object to the instances of the object of the collector. The instances
+// are joined by a mapping of labels, e.g. the label aggr of volumes to the label aggr of aggregates. The other
+// object is the data of the same collector, or the last poll of another collector of the poller, named
+// Collector.Object.
+//
+//	plugins:
+//	  - Join:
+//	      object: Rest.Aggregate
+//	      keys:
+//	        - aggr => aggr
+//	      labels:
+//	        - type => aggr_type
+//	      metrics:
+//	        - size_total => aggr_size_total
+//
+// The index of the other object is cached until the other object is polled again. Misses are counted in the
+// metadata_join matrix.
+type Join struct {
+	*plugin.AbstractPlugin
+	object  string
+	keys    []mapping
+	labels  []mapping
+	metrics []mapping
+	other   *matrix.Matrix              // data of the other object that the index was built from
+	index   map[string]*matrix.Instance // instances of the other object by join key
+	status  *matrix.Matrix
+}
+
+// mapping maps a label or metric of one object to the other, written as "source => target"
+type mapping struct {
+	source string
+	target string
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &Join{AbstractPlugin: p}
+}
+
+func (j *Join) Init() error {
+
+	var err error
+
+	if err = j.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	if j.object = j.Params.GetChildContentS("object"); j.object == "" {
+		return errs.New(errs.ErrMissingParam, "object")
+	}
+	j.keys = parseMappings(j.Params.GetChildS("keys"))
+	j.labels = parseMappings(j.Params.GetChildS("labels"))
+	j.metrics = parseMappings(j.Params.GetChildS("metrics"))
+	if len(j.labels) == 0 && len(j.metrics) == 0 {
+		return errs.New(errs.ErrMissingParam, "labels or metrics")
+	}
+
+	if j.object != j.Object {
+		if !strings.Contains(j.object, ".") {
+			j.Logger.Warn().Str("object", j.object).Msg("objects of other collectors are named Collector.Object")
+		}
+		plugin.Subscribe(j.object)
+	}
+
+	j.status = matrix.New(j.Parent+".Join", "metadata_join", "metadata_join_"+j.Object+"_"+j.object)
+	j.status.SetExportOptions(matrix.DefaultExportOptions())
+	for _, name := range []string{"matches", "misses"} {
+		if _, err = j.status.NewMetricUint64(name); err != nil {
+			return err
+		}
+	}
+	instance, err := j.status.NewInstance(j.object)
+	if err != nil {
+		return err
+	}
+	instance.SetLabel("object", j.Object)
+	instance.SetLabel("join", j.object)
+
+	j.Logger.Debug().
+		Str("object", j.object).
+		Int("keys", len(j.keys)).
+		Int("labels", len(j.labels)).
+		Int("metrics", len(j.metrics)).
+		Msg("initialized")
+	return nil
+}
+
+// parseMappings parses a list of "source => target", or "name" when source and target are the same
+func parseMappings(n *node.Node) []mapping {
+	if n == nil {
+		return nil
+	}
+	var mappings []mapping
+	for _, line := range n.GetAllChildContentS() {
+		source, target, found := strings.Cut(line, "=>")
+		source = strings.TrimSpace(source)
+		target = strings.TrimSpace(target)
+		if !found || target == "" {
+			target = source
+		}
+		if source != "" {
+			mappings = append(mappings, mapping{source: source, target: target})
+		}
+	}
+	return mappings
+}
+
+func (j *Join) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	data := dataMap[j.Object]
+
+	// the data of this collector changes in place, published data is replaced by the next poll
+	other, ok := dataMap[j.object]
+	if ok {
+		j.buildIndex(other)
+	} else if other = plugin.Latest(j.object); other == nil {
+		j.Logger.Debug().Str("object", j.object).Msg("object not polled yet, skip")
+		return nil, nil
+	} else if other != j.other {
+		j.buildIndex(other)
+	}
+
+	// resolve the metrics of the other object and create the metrics of the data
+	type metricPair struct {
+		source *matrix.Metric
+		target *matrix.Metric
+	}
+	metrics := make([]metricPair, 0, len(j.metrics))
+	for _, m := range j.metrics {
+		source := other.LookupMetric(m.source)
+		if source == nil {
+			j.Logger.Debug().Str("metric", m.source).Msg("metric not found")
+			continue
+		}
+		target := data.GetMetric(m.target)
+		if target == nil {
+			var err error
+			if target, err = data.NewMetricFloat64(m.target); err != nil {
+				return nil, err
+			}
+			target.SetProperty("join")
+		}
+		metrics = append(metrics, metricPair{source: source, target: target})
+	}
+
+	var matches, misses uint64
+	for key, instance := range data.GetInstances() {
+		match := j.index[j.joinKey(key, instance)]
+		if match == nil {
+			misses++
+			continue
+		}
+		matches++
+		for _, l := range j.labels {
+			if value := match.GetLabel(l.source); value != "" {
+				instance.SetLabel(l.target, value)
+			}
+		}
+		for _, m := range metrics {
+			if value, ok := m.source.GetValueFloat64(match); ok {
+				_ = m.target.SetValueFloat64(instance, value)
+			}
+		}
+	}
+
+	j.status.Reset()
+	_ = j.status.LazySetValueUint64("matches", j.object, matches)
+	_ = j.status.LazySetValueUint64("misses", j.object, misses)
+	if misses > 0 {
+		j.Logger.Debug().Uint64("matches", matches).Uint64("misses", misses).Msg("joined")
+	}
+
+	return []*matrix.Matrix{j.status}, nil
+}
+
+// buildIndex indexes the instances of the other object by their join key. Without keys, the instance key is used.
+func (j *Join) buildIndex(other *matrix.Matrix) {
+	j.other = other
+	j.index = make(map[string]*matrix.Instance, len(other.GetInstances()))
+	for key, instance := range other.GetInstances() {
+		if len(j.keys) != 0 {
+			values := make([]string, 0, len(j.keys))
+			for _, k := range j.keys {
+				values = append(values, instance.GetLabel(k.target))
+			}
+			key = strings.Join(values, ".")
+		}
+		j.index[key] = instance
+	}
+}
+
+func (j *Join) joinKey(key string, instance *matrix.Instance) string {
+	if len(j.keys) == 0 {
+		return key
+	}
+	values := make([]string, 0, len(j.keys))
+	for _, k := range j.keys {
+		values = append(values, instance.GetLabel(k.source))
+	}
+	return strings.Join(values, ".")
+}
+
+// NewLabels returns the new labels the receiver creates
+func (j *Join) NewLabels() []string {
+	var newLabelNames []string
+	for _, l := range j.labels {
+		newLabelNames = append(newLabelNames, l.target)
+	}
+	return newLabelNames
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package join
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"testing"
+)
+
+func newJoin(object string) *Join {
+	params := node.NewS("Join")
+	params.NewChildS("object", object)
+	params.NewChildS("keys", "").NewChildS("", "aggr => name")
+	labels := params.NewChildS("labels", "")
+	labels.NewChildS("", "type => aggr_type")
+	labels.NewChildS("", "node")
+	params.NewChildS("metrics", "").NewChildS("", "size_total => aggr_size_total")
+
+	abc := plugin.New("Test", nil, params, nil, "Volume", nil)
+	p := &Join{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func newAggregates() *matrix.Matrix {
+	m := matrix.New("Zapi", "aggr", "aggr")
+	size, _ := m.NewMetricFloat64("size_total")
+	for i, name := range []string{"aggr1", "aggr2"} {
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("name", name)
+		instance.SetLabel("type", "ssd")
+		instance.SetLabel("node", "node"+name[4:])
+		_ = size.SetValueFloat64(instance, float64(i+1)*100)
+	}
+	return m
+}
+
+func newVolumes() *matrix.Matrix {
+	m := matrix.New("Zapi", "volume", "volume")
+	for _, v := range [][2]string{{"vol1", "aggr1"}, {"vol2", "aggr2"}, {"vol3", "aggr3"}} {
+		instance, _ := m.NewInstance(v[0])
+		instance.SetLabel("volume", v[0])
+		instance.SetLabel("aggr", v[1])
+	}
+	return m
+}
+
+func TestJoin(t *testing.T) {
+	p := newJoin("Zapi.JoinTestAggregate")
+	plugin.Publish("Zapi", "JoinTestAggregate", newAggregates())
+
+	volumes := newVolumes()
+	results, err := p.Run(map[string]*matrix.Matrix{"Volume": volumes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol2 := volumes.GetInstance("vol2")
+	if got := vol2.GetLabel("aggr_type"); got != "ssd" {
+		t.Errorf("label aggr_type got=%s, want=ssd", got)
+	}
+	if got := vol2.GetLabel("node"); got != "node2" {
+		t.Errorf("label node got=%s, want=node2", got)
+	}
+	if got, ok := volumes.GetMetric("aggr_size_total").GetValueFloat64(vol2); !ok || got != 200 {
+		t.Errorf("metric aggr_size_total got=%v, want=200", got)
+	}
+	if _, ok := volumes.GetMetric("aggr_size_total").GetValueFloat64(volumes.GetInstance("vol3")); ok {
+		t.Error("vol3 has no aggregate and should have no aggr_size_total")
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("results got=%d, want=1", len(results))
+	}
+	status := results[0]
+	instance := status.GetInstance("Zapi.JoinTestAggregate")
+	if got, _ := status.GetMetric("matches").GetValueUint64(instance); got != 2 {
+		t.Errorf("matches got=%d, want=2", got)
+	}
+	if got, _ := status.GetMetric("misses").GetValueUint64(instance); got != 1 {
+		t.Errorf("misses got=%d, want=1", got)
+	}
+}
+
+func TestJoinNotPolled(t *testing.T) {
+	p := newJoin("JoinTestMissing")
+	volumes := newVolumes()
+	results, err := p.Run(map[string]*matrix.Matrix{"Volume": volumes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results != nil {
+		t.Errorf("results got=%d, want none", len(results))
+	}
+	if volumes.GetMetric("aggr_size_total") != nil {
+		t.Error("metric aggr_size_total should not be created")
+	}
+}
+
+func TestJoinOtherCollector(t *testing.T) {
+	p := newJoin("Rest.JoinTestOtherAggregate")
+	// the same object published by another collector is not joined
+	plugin.Publish("Zapi", "JoinTestOtherAggregate", newAggregates())
+	if plugin.Latest("JoinTestOtherAggregate") != nil {
+		t.Errorf("data should only be published with the collector")
+	}
+
+	volumes := newVolumes()
+	if _, err := p.Run(map[string]*matrix.Matrix{"Volume": volumes}); err != nil {
+		t.Fatal(err)
+	}
+	if got := volumes.GetInstance("vol2").GetLabel("aggr_type"); got != "" {
+		t.Errorf("label aggr_type got=%s, want empty", got)
+	}
+}
@@ -31,6 +31,8 @@ type LabelAgent struct {
 	includeRegexRules    []includeRegexRule
 	valueToNumRules      []valueToNumRule
 	valueToNumRegexRules []valueToNumRegexRule
+	lookupRules          []*lookupRule
+	splitRegexNamedRules []splitRegexNamedRule
 }
 
 func New(p *plugin.AbstractPlugin) *LabelAgent {
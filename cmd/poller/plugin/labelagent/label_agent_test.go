@@ -9,7 +9,11 @@ import (
 	"github.com/netapp/harvest/v2/pkg/dict"
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 	// "github.com/netapp/harvest/v2/share/logger"
 )
 
@@ -580,3 +584,133 @@ func TestIncludeContainExcludeContainRuleOrder(t *testing.T) {
 		t.Error("instanceSix should have been excluded")
 	}
 }
+
+func TestLookupRule(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "owners.csv")
+	if err := os.WriteFile(file, []byte("svm,owner,cost_center\n# comment\nsvm1,alice,cc1\nsvm2,bob,\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	params := node.NewS("LabelAgent")
+	lookup := params.NewChildS("lookup", "")
+	lookup.NewChildS("", "svm `"+file+"` `unknown`")
+	inline := lookup.NewChildS("", "")
+	inline.NewChildS("source", "node")
+	inline.NewChildS("target", "site")
+	inline.NewChildS("default", "other")
+	m := inline.NewChildS("map", "")
+	m.NewChildS("node1", "paris")
+	m.NewChildS("node2", "berlin")
+
+	p := &LabelAgent{AbstractPlugin: plugin.New("Test", nil, params, nil, "", nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := matrix.New("TestLabelAgent", "test", "test")
+	for key, labels := range map[string][2]string{"0": {"svm1", "node1"}, "1": {"svm2", "node3"}, "2": {"svm3", "node2"}} {
+		instance, _ := data.NewInstance(key)
+		instance.SetLabel("svm", labels[0])
+		instance.SetLabel("node", labels[1])
+	}
+	_ = p.lookup(data)
+
+	tests := []struct {
+		key        string
+		owner      string
+		costCenter string
+		site       string
+	}{
+		{"0", "alice", "cc1", "paris"},
+		{"1", "bob", "", "other"},
+		{"2", "unknown", "unknown", "berlin"},
+	}
+	for _, tt := range tests {
+		instance := data.GetInstance(tt.key)
+		if instance.GetLabel("owner") != tt.owner || instance.GetLabel("cost_center") != tt.costCenter || instance.GetLabel("site") != tt.site {
+			t.Errorf("instance %s got=[%s], want owner=%s cost_center=%s site=%s",
+				tt.key, dict.String(instance.GetLabels()), tt.owner, tt.costCenter, tt.site)
+		}
+	}
+
+	// the file is reloaded when it changes
+	if err := os.WriteFile(file, []byte("svm,owner\nsvm3,carol\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(file, future, future)
+	_ = p.lookup(data)
+	if got := data.GetInstance("2").GetLabel("owner"); got != "carol" {
+		t.Errorf("owner after reload got=%s, want=carol", got)
+	}
+}
+
+func TestLookupLateFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "owners.csv")
+	params := node.NewS("LabelAgent")
+	params.NewChildS("lookup", "").NewChildS("", "svm `"+file+"` `unknown`")
+	p := &LabelAgent{AbstractPlugin: plugin.New("Test", nil, params, nil, "", nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.NewLabels()) != 0 {
+		t.Errorf("new labels without file got=%v, want none", p.NewLabels())
+	}
+
+	data := matrix.New("TestLabelAgent", "test", "test")
+	instance, _ := data.NewInstance("0")
+	instance.SetLabel("svm", "svm2")
+
+	// the file is created after the start, then gains a column
+	for _, content := range []string{"svm,owner\nsvm1,alice\n", "svm,owner,team\nsvm1,alice,storage\n"} {
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_ = p.lookup(data)
+	}
+
+	if got := strings.Join(p.NewLabels(), ","); got != "owner,team" {
+		t.Errorf("new labels got=%s, want=owner,team", got)
+	}
+	if instance.GetLabel("owner") != "unknown" || instance.GetLabel("team") != "unknown" {
+		t.Errorf("got=[%s], want owner=unknown team=unknown", dict.String(instance.GetLabels()))
+	}
+}
+
+func TestLookupYAMLFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "owners.yaml")
+	if err := os.WriteFile(file, []byte("svm1:\n  owner: alice\n  team: storage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	table, targets, err := readLookupFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table["svm1"]["team"] != "storage" || strings.Join(targets, ",") != "owner,team" {
+		t.Errorf("got table=%v targets=%v", table, targets)
+	}
+}
+
+func TestSplitRegexNamedRule(t *testing.T) {
+	params := node.NewS("LabelAgent")
+	params.NewChildS("split_regex_named", "").NewChildS("", "volume `^(?P<app>[a-z]+)_(?P<env>prod|dev)_\\d+$` `unknown`")
+	p := &LabelAgent{AbstractPlugin: plugin.New("Test", nil, params, nil, "", nil)}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := matrix.New("TestLabelAgent", "test", "test")
+	matched, _ := data.NewInstance("0")
+	matched.SetLabel("volume", "erp_prod_01")
+	unmatched, _ := data.NewInstance("1")
+	unmatched.SetLabel("volume", "tmp")
+	_ = p.splitRegexNamed(data)
+
+	if matched.GetLabel("app") != "erp" || matched.GetLabel("env") != "prod" {
+		t.Errorf("matched got=[%s], want app=erp env=prod", dict.String(matched.GetLabels()))
+	}
+	if unmatched.GetLabel("app") != "unknown" || unmatched.GetLabel("env") != "unknown" {
+		t.Errorf("unmatched got=[%s], want app=unknown env=unknown", dict.String(unmatched.GetLabels()))
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package labelagent
+
+import (
+	"encoding/csv"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+type lookupRule struct {
+	source     string
+	targets    []string
+	table      map[string]map[string]string // labels set for each value of source
+	def        string
+	hasDefault bool
+	file       string
+	modTime    time.Time
+	size       int64
+}
+
+// example rules:
+// svm `conf/owners.csv` `unknown`
+// sets the labels of the columns of the row of owners.csv whose first column is the value of label "svm",
+// or sets these labels to "unknown" when there is no such row
+//
+// source: svm
+// target: owner
+// default: unknown
+// map:
+//   svm1: alice
+//   svm2: bob
+// sets label "owner" to "alice" if svm="svm1"
+
+func (a *LabelAgent) parseLookupRule(rc *node.Node, rule string) {
+	r := &lookupRule{}
+
+	if rule != "" {
+		fields := strings.SplitN(rule, " `", 3)
+		if len(fields) < 2 {
+			a.Logger.Warn().Msgf("(lookup) rule has invalid format [%s]", rule)
+			return
+		}
+		r.source = strings.TrimSpace(fields[0])
+		r.file = strings.TrimSuffix(fields[1], "`")
+		if len(fields) == 3 {
+			r.def = strings.TrimSuffix(fields[2], "`")
+			r.hasDefault = true
+		}
+	} else {
+		r.source = rc.GetChildContentS("source")
+		r.file = rc.GetChildContentS("file")
+		if d := rc.GetChildS("default"); d != nil {
+			r.def = d.GetContentS()
+			r.hasDefault = true
+		}
+		if m := rc.GetChildS("map"); m != nil {
+			target := rc.GetChildContentS("target")
+			if target == "" {
+				a.Logger.Warn().Str("source", r.source).Msg("(lookup) map without target")
+				return
+			}
+			r.targets = []string{target}
+			r.table = make(map[string]map[string]string)
+			for _, entry := range m.GetChildren() {
+				r.table[entry.GetNameS()] = map[string]string{target: entry.GetContentS()}
+			}
+		}
+	}
+
+	if r.source == "" || (r.file == "") == (r.table == nil) {
+		a.Logger.Warn().Str("source", r.source).Msg("(lookup) rule needs a source and either a file or a map")
+		return
+	}
+
+	if r.file != "" {
+		if !filepath.IsAbs(r.file) {
+			r.file = conf.Path(r.file)
+		}
+		// a missing or invalid file is logged, the rule loads it when it becomes valid
+		r.table = make(map[string]map[string]string)
+		a.reloadLookup(r)
+	}
+
+	a.lookupRules = append(a.lookupRules, r)
+	a.addNewLabels(r.targets)
+	a.Logger.Debug().Str("source", r.source).Str("file", r.file).Strs("targets", r.targets).Msg("(lookup) parsed rule")
+}
+
+// reloadLookup loads the file of the rule when it changed since it was loaded. The previous table is kept when the
+// file can't be loaded.
+func (a *LabelAgent) reloadLookup(r *lookupRule) {
+	info, err := os.Stat(r.file)
+	if err != nil {
+		a.Logger.Warn().Err(err).Str("file", r.file).Msg("(lookup) stat file")
+		return
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return
+	}
+
+	table, targets, err := readLookupFile(r.file)
+	if err != nil {
+		a.Logger.Error().Err(err).Str("file", r.file).Msg("(lookup) load file")
+		return
+	}
+	r.table = table
+	r.targets = targets
+	// the file may be missing at startup or gain columns later
+	a.addNewLabels(targets)
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	a.Logger.Debug().Str("file", r.file).Int("rows", len(table)).Msg("(lookup) loaded file")
+}
+
+// readLookupFile reads a CSV file with a header, or a YAML file with a map of labels for each value. The first
+// column of the CSV file is the value of the source label, the other columns are the labels to set.
+func readLookupFile(path string) (map[string]map[string]string, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	table := make(map[string]map[string]string)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		reader := csv.NewReader(f)
+		reader.Comment = '#'
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) == 0 || len(records[0]) < 2 {
+			return nil, nil, errors.New("header needs at least two columns")
+		}
+		targets := records[0][1:]
+		for _, record := range records[1:] {
+			labels := make(map[string]string, len(targets))
+			for i, target := range targets {
+				labels[target] = record[i+1]
+			}
+			table[record[0]] = labels
+		}
+		return table, targets, nil
+	case ".yaml", ".yml":
+		if err := yaml.NewDecoder(f).Decode(&table); err != nil {
+			return nil, nil, err
+		}
+		seen := make(map[string]bool)
+		var targets []string
+		for _, labels := range table {
+			for label := range labels {
+				if !seen[label] {
+					seen[label] = true
+					targets = append(targets, label)
+				}
+			}
+		}
+		sort.Strings(targets)
+		return table, targets, nil
+	default:
+		return nil, nil, errors.New("unknown file type, use .csv, .yaml or .yml")
+	}
+}
+
+// sets labels from the lookup table of the value of the source label
+func (a *LabelAgent) lookup(matrix *matrix.Matrix) error {
+	for _, r := range a.lookupRules {
+		if r.file != "" {
+			a.reloadLookup(r)
+		}
+	}
+	for _, instance := range matrix.GetInstances() {
+		for _, r := range a.lookupRules {
+			value := instance.GetLabel(r.source)
+			if labels, ok := r.table[value]; ok {
+				for label, v := range labels {
+					if v != "" {
+						instance.SetLabel(label, v)
+					}
+				}
+				a.Logger.Trace().Msgf("lookup: (%s) [%s] => %v", r.source, value, labels)
+			} else if r.hasDefault {
+				for _, target := range r.targets {
+					instance.SetLabel(target, r.def)
+				}
+				a.Logger.Trace().Msgf("lookup: (%s) [%s] => default [%s]", r.source, value, r.def)
+			}
+		}
+	}
+	return nil
+}
+
+type splitRegexNamedRule struct {
+	reg        *regexp.Regexp
+	source     string
+	targets    []string
+	def        string
+	hasDefault bool
+}
+
+// example rule:
+// volume `^(?P<app>[a-z]+)_(?P<env>prod|dev)_\d+$` `unknown`
+// if volume="erp_prod_01", then:
+// app="erp", env="prod"
+// if volume="tmp", then:
+// app="unknown", env="unknown"
+
+func (a *LabelAgent) parseSplitRegexNamedRule(rule string) {
+	if fields := strings.SplitN(rule, " `", 3); len(fields) >= 2 {
+		r := splitRegexNamedRule{source: strings.TrimSpace(fields[0])}
+		var err error
+		if r.reg, err = regexp.Compile(strings.TrimSuffix(fields[1], "`")); err != nil {
+			a.Logger.Error().Stack().Err(err).Msg("(split_regex_named) invalid regex")
+			return
+		}
+		for _, name := range r.reg.SubexpNames() {
+			if name != "" {
+				r.targets = append(r.targets, name)
+			}
+		}
+		if len(r.targets) == 0 {
+			a.Logger.Warn().Msgf("(split_regex_named) regex has no named groups [%s]", rule)
+			return
+		}
+		if len(fields) == 3 {
+			r.def = strings.TrimSuffix(fields[2], "`")
+			r.hasDefault = true
+		}
+		a.splitRegexNamedRules = append(a.splitRegexNamedRules, r)
+		a.addNewLabels(r.targets)
+		a.Logger.Debug().Msgf("(split_regex_named) parsed rule [%v]", r)
+		return
+	}
+	a.Logger.Warn().Msgf("(split_regex_named) rule has invalid format [%s]", rule)
+}
+
+// splits one label value into the labels of the named groups of a regex
+func (a *LabelAgent) splitRegexNamed(matrix *matrix.Matrix) error {
+	for _, instance := range matrix.GetInstances() {
+		for _, r := range a.splitRegexNamedRules {
+			value := instance.GetLabel(r.source)
+			if m := r.reg.FindStringSubmatch(value); m != nil {
+				for i, name := range r.reg.SubexpNames() {
+					if name != "" && m[i] != "" {
+						instance.SetLabel(name, m[i])
+						a.Logger.Trace().Msgf("splitRegexNamed: (%s) [%s] => (%s) [%s]", r.source, value, name, m[i])
+					}
+				}
+			} else if r.hasDefault {
+				for _, target := range r.targets {
+					instance.SetLabel(target, r.def)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package labelagent
 import (
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -28,6 +29,8 @@ func (a *LabelAgent) parseRules() int {
 	a.splitPairsRules = make([]splitPairsRule, 0)
 	a.valueToNumRules = make([]valueToNumRule, 0)
 	a.valueToNumRegexRules = make([]valueToNumRegexRule, 0)
+	a.lookupRules = make([]*lookupRule, 0)
+	a.splitRegexNamedRules = make([]splitRegexNamedRule, 0)
 
 	for _, c := range a.Params.GetChildren() {
 		name := c.GetNameS()
@@ -66,6 +69,10 @@ func (a *LabelAgent) parseRules() int {
 				a.parseValueToNumRule(rule)
 			case "value_to_num_regex":
 				a.parseValueToNumRegexRule(rule)
+			case "lookup":
+				a.parseLookupRule(rc, rule)
+			case "split_regex_named":
+				a.parseSplitRegexNamedRule(rule)
 			default:
 				a.Logger.Warn().
 					Str("object", a.ParentParams.GetChildContentS("object")).
@@ -150,6 +157,16 @@ func (a *LabelAgent) parseRules() int {
 				a.actions = append(a.actions, a.mapValueToNumRegex)
 				count += len(a.valueToNumRegexRules)
 			}
+		case "lookup":
+			if len(a.lookupRules) != 0 {
+				a.actions = append(a.actions, a.lookup)
+				count += len(a.lookupRules)
+			}
+		case "split_regex_named":
+			if len(a.splitRegexNamedRules) != 0 {
+				a.actions = append(a.actions, a.splitRegexNamed)
+				count += len(a.splitRegexNamedRules)
+			}
 		default:
 			a.Logger.Warn().
 				Str("object", a.ParentParams.GetChildContentS("object")).
@@ -191,7 +208,7 @@ func (a *LabelAgent) parseSplitSimpleRule(rule string) {
 
 func (a *LabelAgent) addNewLabels(targets []string) {
 	for _, target := range targets {
-		if len(target) > 0 {
+		if len(target) > 0 && !slices.Contains(a.newLabelNames, target) {
 			a.newLabelNames = append(a.newLabelNames, target)
 		}
 	}
@@ -0,0 +1,22 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package metricagent
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+)
+
+// computeExpression sets the metric to the value of the expression for each instance. Instances are skipped when
+// the expression has no value, e.g. because a metric it uses has no value.
+func (a *MetricAgent) computeExpression(m *matrix.Matrix, r computeMetricRule, metric *matrix.Metric) {
+	env := plugin.NewExprEnv(m, a.dataMap, a.Logger)
+	for key, instance := range m.GetInstances() {
+		env.SetInstance(key, instance)
+		if v, ok := r.expression.Eval(env); ok {
+			_ = metric.SetValueFloat64(instance, v)
+		}
+	}
+}
@@ -15,6 +15,7 @@ type MetricAgent struct {
 	*plugin.AbstractPlugin
 	actions            []func(*matrix.Matrix) error
 	computeMetricRules []computeMetricRule
+	dataMap            map[string]*matrix.Matrix // data of the current run, used by references to other objects
 }
 
 func New(p *plugin.AbstractPlugin) plugin.Plugin {
@@ -32,7 +33,10 @@ func (a *MetricAgent) Init() error {
 		return err
 	}
 
-	if count = a.parseRules(); count == 0 {
+	if count, err = a.parseRules(); err != nil {
+		return err
+	}
+	if count == 0 {
 		err = errs.New(errs.ErrMissingParam, "valid rules")
 	} else {
 		a.Logger.Debug().Msgf("parsed %d rules for %d actions", count, len(a.actions))
@@ -45,6 +49,7 @@ func (a *MetricAgent) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix,
 
 	var err error
 	data := dataMap[a.Object]
+	a.dataMap = dataMap
 
 	for _, foo := range a.actions {
 		_ = foo(data)
@@ -64,7 +69,7 @@ func (a *MetricAgent) computeMetrics(m *matrix.Matrix) error {
 
 	// map values for compute_metric mapping rules
 	for _, r := range a.computeMetricRules {
-		if metric = a.getMetric(m, r.metric); metric == nil {
+		if metric = m.LookupMetric(r.metric); metric == nil {
 			if metric, err = m.NewMetricFloat64(r.metric); err != nil {
 				a.Logger.Error().Err(err).Str("metric", r.metric).Msg("Failed to create metric")
 				return err
@@ -72,11 +77,16 @@ func (a *MetricAgent) computeMetrics(m *matrix.Matrix) error {
 			metric.SetProperty("compute_metric mapping")
 		}
 
+		if r.expression != nil {
+			a.computeExpression(m, r, metric)
+			continue
+		}
+
 		for _, instance := range m.GetInstances() {
 			var result float64
 
 			// Parse first operand and store in result for further processing
-			if firstMetricVal = a.getMetric(m, r.metricNames[0]); firstMetricVal != nil {
+			if firstMetricVal = m.LookupMetric(r.metricNames[0]); firstMetricVal != nil {
 				if val, ok := firstMetricVal.GetValueFloat64(instance); ok {
 					result = val
 				} else {
@@ -92,7 +102,7 @@ func (a *MetricAgent) computeMetrics(m *matrix.Matrix) error {
 				if value, err := strconv.Atoi(r.metricNames[i]); err == nil {
 					v = float64(value)
 				} else {
-					metricVal = a.getMetric(m, r.metricNames[i])
+					metricVal = m.LookupMetric(r.metricNames[i])
 					if metricVal != nil {
 						v, _ = metricVal.GetValueFloat64(instance)
 					} else {
@@ -136,11 +146,3 @@ func (a *MetricAgent) computeMetrics(m *matrix.Matrix) error {
 	}
 	return nil
 }
-
-func (a *MetricAgent) getMetric(m *matrix.Matrix, name string) *matrix.Metric {
-	metric := m.DisplayMetric(name)
-	if metric != nil {
-		return metric
-	}
-	return m.GetMetric(name)
-}
@@ -8,6 +8,7 @@ import (
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"strings"
 	"testing"
 	//"github.com/netapp/harvest/v2/share/logger"
 )
@@ -200,3 +201,90 @@ func TestComputeMetricsRule(t *testing.T) {
 	}
 
 }
+
+func TestComputeExpressionRule(t *testing.T) {
+	params := node.NewS("MetricAgent")
+	rules := params.NewChildS("compute_metric", "")
+	rules.NewChildS("", "size_used_percent = clamp(percent(size_used, size_total), 0, 100)")
+	rules.NewChildS("", `size_online_gib = if(label("state") == "online", convert(size_total, "B", "GiB"), 0)`)
+	rules.NewChildS("", "aggr_share = percent(size_total, Zapi.Aggregate:size[aggr])")
+	rules.NewChildS("", "size_missing = size_total + missing")
+
+	abc := plugin.New("Test", nil, params, nil, "Volume", nil)
+	p := &MetricAgent{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates := matrix.New("Zapi", "aggr", "aggr")
+	aggrSize, _ := aggregates.NewMetricFloat64("size")
+	for name, size := range map[string]float64{"aggr1": 8 << 30, "aggr2": 16 << 30} {
+		instance, _ := aggregates.NewInstance(name)
+		instance.SetLabel("aggr", name)
+		_ = aggrSize.SetValueFloat64(instance, size)
+	}
+	plugin.Publish("Zapi", "Aggregate", aggregates)
+
+	m := matrix.New("Zapi", "volume", "volume")
+	total, _ := m.NewMetricFloat64("size_total")
+	used, _ := m.NewMetricFloat64("size_used")
+	volumes := []struct {
+		name  string
+		state string
+		aggr  string
+		total float64
+		used  float64
+	}{
+		{"vol1", "online", "aggr1", 2 << 30, 1 << 30},
+		{"vol2", "offline", "aggr2", 4 << 30, 5 << 30},
+	}
+	for _, v := range volumes {
+		instance, _ := m.NewInstance(v.name)
+		instance.SetLabel("state", v.state)
+		instance.SetLabel("aggr", v.aggr)
+		_ = total.SetValueFloat64(instance, v.total)
+		_ = used.SetValueFloat64(instance, v.used)
+	}
+
+	if _, err := p.Run(map[string]*matrix.Matrix{"Volume": m}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]map[string]float64{
+		"size_used_percent": {"vol1": 50, "vol2": 100},
+		"size_online_gib":   {"vol1": 2, "vol2": 0},
+		"aggr_share":        {"vol1": 25, "vol2": 25},
+	}
+	for name, values := range want {
+		metric := m.GetMetric(name)
+		if metric == nil {
+			t.Fatalf("metric [%s] missing", name)
+		}
+		for key, expected := range values {
+			if got, ok := metric.GetValueFloat64(m.GetInstance(key)); !ok || got != expected {
+				t.Errorf("metric [%s] of %s got=%v (%t), want=%v", name, key, got, ok, expected)
+			}
+		}
+	}
+
+	// the expression has no value when an operand is missing
+	if _, ok := m.GetMetric("size_missing").GetValueFloat64(m.GetInstance("vol1")); ok {
+		t.Error("metric [size_missing] should not have a value")
+	}
+}
+
+func TestComputeExpressionError(t *testing.T) {
+	params := node.NewS("MetricAgent")
+	rule := "size_used_percent = percent(size_used, size_total"
+	params.NewChildS("compute_metric", "").NewChildS("", rule)
+
+	abc := plugin.New("Test", nil, params, nil, "Volume", nil)
+	p := &MetricAgent{AbstractPlugin: abc}
+	err := p.Init()
+	if err == nil {
+		t.Fatal("expected an error for an invalid expression")
+	}
+	if !strings.Contains(err.Error(), rule) || !strings.Contains(err.Error(), "column 50") {
+		t.Errorf("error should point at the rule and column, got=%v", err)
+	}
+}
@@ -5,12 +5,17 @@
 package metricagent
 
 import (
+	"errors"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/expr"
 	"github.com/netapp/harvest/v2/pkg/matrix"
+	"regexp"
 	"strings"
 )
 
 // parse rules from plugin parameters and return number of rules parsed
-func (a *MetricAgent) parseRules() int {
+func (a *MetricAgent) parseRules() (int, error) {
 
 	a.computeMetricRules = make([]computeMetricRule, 0)
 
@@ -24,7 +29,9 @@ func (a *MetricAgent) parseRules() int {
 
 			switch name {
 			case "compute_metric":
-				a.parseComputeMetricRule(rule)
+				if err := a.parseComputeMetricRule(rule); err != nil {
+					return 0, err
+				}
 			default:
 				a.Logger.Warn().
 					Str("object", a.ParentParams.GetChildContentS("object")).
@@ -51,16 +58,39 @@ func (a *MetricAgent) parseRules() int {
 		}
 	}
 
-	return count
+	return count, nil
 }
 
 type computeMetricRule struct {
 	metric      string
 	operation   string
 	metricNames []string
+	expression  *expr.Expr
 }
 
-func (a *MetricAgent) parseComputeMetricRule(rule string) {
+// expressionRule matches compute_metric rules of the form "METRIC = EXPRESSION"
+var expressionRule = regexp.MustCompile(`^([^\s=]+)\s*=([^=~].*)$`)
+
+func (a *MetricAgent) parseComputeMetricRule(rule string) error {
+	if match := expressionRule.FindStringSubmatch(rule); match != nil {
+		text := strings.TrimSpace(match[2])
+		e, err := expr.Compile(text)
+		if err != nil {
+			// point at the column of the rule
+			var exprErr *expr.Error
+			if errors.As(err, &exprErr) {
+				exprErr.Pos += strings.LastIndex(rule, text)
+				exprErr.Expr = rule
+			}
+			return errs.New(errs.ErrInvalidParam, "compute_metric: "+err.Error())
+		}
+		plugin.SubscribeRefs(e, a.Object)
+		r := computeMetricRule{metric: match[1], operation: "EXPRESSION", expression: e}
+		a.computeMetricRules = append(a.computeMetricRules, r)
+		a.Logger.Debug().Str("metric", r.metric).Str("expression", e.String()).Msg("(compute_metric) parsed expression")
+		return nil
+	}
+
 	if fields := strings.Fields(rule); len(fields) >= 4 {
 		r := computeMetricRule{metric: fields[0], operation: fields[1], metricNames: make([]string, 0)}
 
@@ -70,7 +100,8 @@ func (a *MetricAgent) parseComputeMetricRule(rule string) {
 
 		a.computeMetricRules = append(a.computeMetricRules, r)
 		a.Logger.Debug().Msgf("(compute_metric) parsed rule [%v]", r)
-		return
+		return nil
 	}
 	a.Logger.Warn().Msgf("(compute_metric) rule has invalid format [%s]", rule)
+	return nil
 }
@@ -32,7 +32,9 @@ import (
 	"github.com/netapp/harvest/v2/pkg/errs"
 	"github.com/netapp/harvest/v2/pkg/logging"
 	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/persist"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -47,6 +49,12 @@ type Plugin interface {
 	Run(map[string]*matrix.Matrix) ([]*matrix.Matrix, error)
 }
 
+// Stopper is implemented by plugins that hold resources, such as child processes, that must be released when
+// the poller stops
+type Stopper interface {
+	Stop()
+}
+
 var (
 	modules   = make(map[string]ModuleInfo)
 	modulesMu sync.RWMutex
@@ -153,6 +161,20 @@ func (p *AbstractPlugin) Run(map[string]*matrix.Matrix) ([]*matrix.Matrix, error
 	panic(p.Name + " has not implemented Run()")
 }
 
+// StatePath returns the path of the file that keeps the state of the plugin across restarts. The directory is the
+// parameter state_dir of the plugin, by default the directory "state" of the Harvest home.
+func (p *AbstractPlugin) StatePath() string {
+	dir := p.Params.GetChildContentS("state_dir")
+	poller := ""
+	if p.Options != nil {
+		poller = p.Options.Poller
+		if dir == "" {
+			dir = filepath.Join(p.Options.HomePath, "state")
+		}
+	}
+	return persist.Path(dir, poller, p.Parent, p.Object, p.Name)
+}
+
 func (p *AbstractPlugin) SetPluginInterval() int {
 	pollInterval := GetInterval(p.ParentParams, DefaultPollInterval)
 	pluginInterval := GetInterval(p.Params, DefaultPluginInterval)
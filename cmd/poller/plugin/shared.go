@@ -0,0 +1,59 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package plugin
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"sync"
+)
+
+// Plugins may use the data of other objects of the poller, e.g. a MetricAgent expression of volumes that references
+// the size of aggregates. A plugin subscribes to the objects it needs during Init, and each collector publishes a copy
+// of its data after the plugins of a data poll ran. Objects are named by collector and object, e.g. "Zapi.Aggregate",
+// since an object may be polled by several collectors. Published matrices are shared by all plugins and must not be
+// modified.
+var (
+	shared     = make(map[string]*matrix.Matrix)
+	subscribed = make(map[string]bool)
+	sharedMu   sync.RWMutex
+)
+
+// Subscribe asks collectors to publish the data of the object, named by collector and object
+func Subscribe(object string) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	subscribed[object] = true
+}
+
+// Publish stores a copy of the data of a collector object when a plugin subscribed to it
+func Publish(collector, object string, data *matrix.Matrix) {
+	qualified := QualifiedObject(collector, object)
+
+	sharedMu.RLock()
+	wanted := subscribed[qualified]
+	sharedMu.RUnlock()
+	if !wanted {
+		return
+	}
+
+	clone := data.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+	clone.DetachGlobalLabels()
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	shared[qualified] = clone
+}
+
+// QualifiedObject returns the name of the object of a collector in published data, e.g. "Zapi.Aggregate"
+func QualifiedObject(collector, object string) string {
+	return collector + "." + object
+}
+
+// Latest returns the last published data of the object, or nil when the object was not published yet
+func Latest(object string) *matrix.Matrix {
+	sharedMu.RLock()
+	defer sharedMu.RUnlock()
+	return shared[object]
+}
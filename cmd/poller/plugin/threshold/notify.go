@@ -0,0 +1,390 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package threshold
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"maps"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+	defaultTimeout = 10 * time.Second
+	// queueSize is the number of notifications that wait for the notifiers before new notifications are dropped
+	queueSize = 100
+	// stopTimeout is how long the poller waits for the queued notifications when it stops
+	stopTimeout = 10 * time.Second
+)
+
+// event is a change of the state of an alert, sent to notifiers
+type event struct {
+	Name     string            `json:"name"`
+	Status   string            `json:"status"`
+	Severity string            `json:"severity"`
+	Summary  string            `json:"summary,omitempty"`
+	Labels   map[string]string `json:"labels"`
+	StartsAt time.Time         `json:"starts_at"`
+	EndsAt   *time.Time        `json:"ends_at,omitempty"`
+}
+
+func (s *state) event(resolved bool, now time.Time) event {
+	e := event{
+		Name:     s.alert.name,
+		Status:   statusFiring,
+		Severity: s.alert.severity,
+		Summary:  s.alert.summary,
+		Labels:   maps.Clone(s.labels),
+		StartsAt: s.since,
+	}
+	if resolved {
+		e.Status = statusResolved
+		e.EndsAt = &now
+	}
+	return e
+}
+
+// notifier sends alerts. events are the alerts that fired or resolved during the last run, firing are all alerts
+// that are firing.
+type notifier interface {
+	name() string
+	send(ctx context.Context, events []event, firing []event) error
+}
+
+func newNotifiers(n *node.Node) ([]notifier, time.Duration, error) {
+	if n == nil {
+		return nil, 0, nil
+	}
+
+	timeout := defaultTimeout
+	if t := n.GetChildContentS("timeout"); t != "" {
+		var err error
+		if timeout, err = time.ParseDuration(t); err != nil {
+			return nil, 0, errs.New(errs.ErrInvalidParam, "notify timeout: "+err.Error())
+		}
+	}
+	client := &http.Client{Timeout: timeout}
+
+	var notifiers []notifier
+	if url := n.GetChildContentS("webhook"); url != "" {
+		notifiers = append(notifiers, &webhook{url: url, client: client})
+	}
+	if url := n.GetChildContentS("alertmanager"); url != "" {
+		notifiers = append(notifiers, &alertmanager{url: strings.TrimSuffix(url, "/") + "/api/v2/alerts", client: client})
+	}
+	if s := n.GetChildS("smtp"); s != nil {
+		m := &mailer{
+			addr:     s.GetChildContentS("host"),
+			from:     s.GetChildContentS("from"),
+			username: s.GetChildContentS("username"),
+			password: s.GetChildContentS("password"),
+		}
+		if to := s.GetChildS("to"); to != nil {
+			if to.GetContentS() != "" {
+				m.to = []string{to.GetContentS()}
+			} else {
+				m.to = to.GetAllChildContentS()
+			}
+		}
+		if m.addr == "" || m.from == "" || len(m.to) == 0 {
+			return nil, 0, errs.New(errs.ErrMissingParam, "smtp host, from and to")
+		}
+		notifiers = append(notifiers, m)
+	}
+	if len(notifiers) == 0 {
+		return nil, 0, errs.New(errs.ErrMissingParam, "webhook, alertmanager or smtp of notify")
+	}
+	return notifiers, timeout, nil
+}
+
+// notification is a batch of events sent by the worker
+type notification struct {
+	events []event
+	firing []event
+}
+
+// notify queues the alerts for the worker, so that slow notifiers don't block the poll. When the queue is full, the
+// notification is dropped.
+func (t *Threshold) notify(events []event, globalLabels map[string]string, now time.Time) {
+	firing := make([]event, 0)
+	for _, s := range t.states {
+		if s.firing {
+			firing = append(firing, s.event(false, now))
+		}
+	}
+	sort.Slice(firing, func(i, j int) bool {
+		if firing[i].Name != firing[j].Name {
+			return firing[i].Name < firing[j].Name
+		}
+		return firing[i].StartsAt.Before(firing[j].StartsAt)
+	})
+	for _, list := range [][]event{events, firing} {
+		for _, e := range list {
+			for k, v := range globalLabels {
+				if _, ok := e.Labels[k]; !ok {
+					e.Labels[k] = v
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.queue == nil {
+		return
+	}
+	select {
+	case t.queue <- notification{events: events, firing: firing}:
+	default:
+		t.Logger.Warn().Int("events", len(events)).Msg("notification queue is full, notification dropped")
+	}
+}
+
+// startWorker starts the goroutine that sends the queued notifications to all notifiers. Failed notifications are
+// logged and not retried, Alertmanager receives all firing alerts with each run anyway.
+func (t *Threshold) startWorker() {
+	t.queue = make(chan notification, queueSize)
+	t.worker.Add(1)
+	go func(queue chan notification) {
+		defer t.worker.Done()
+		for n := range queue {
+			t.send(n)
+		}
+	}(t.queue)
+}
+
+func (t *Threshold) send(n notification) {
+	for _, notifier := range t.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+		if err := notifier.send(ctx, n.events, n.firing); err != nil {
+			t.Logger.Error().Err(err).Str("notifier", notifier.name()).Int("events", len(n.events)).Msg("notify")
+		}
+		cancel()
+	}
+}
+
+// Stop stops the worker when the poller stops, and waits until the queued notifications are sent, or the stop timeout.
+// The notifications that are still queued after the timeout are dropped.
+func (t *Threshold) Stop() {
+	t.mu.Lock()
+	if t.queue == nil {
+		t.mu.Unlock()
+		return
+	}
+	close(t.queue)
+	t.queue = nil
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.worker.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(t.stopTimeout):
+		t.Logger.Warn().Str("timeout", t.stopTimeout.String()).Msg("queued notifications were not sent before stop")
+	}
+}
+
+func post(ctx context.Context, client *http.Client, url string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return errs.New(errs.ErrConnection, err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return errs.New(errs.ErrAPIRequestRejected, url+": "+response.Status, errs.WithStatus(response.StatusCode))
+	}
+	return nil
+}
+
+// webhook posts the alerts that fired or resolved as JSON
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhook) name() string { return "webhook" }
+
+func (w *webhook) send(ctx context.Context, events []event, _ []event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return post(ctx, w.client, w.url, map[string]any{"alerts": events})
+}
+
+// alertmanager posts all firing alerts, and the alerts that resolved, to the API of Alertmanager
+type alertmanager struct {
+	url    string
+	client *http.Client
+}
+
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+func (a *alertmanager) name() string { return "alertmanager" }
+
+func (a *alertmanager) send(ctx context.Context, events []event, firing []event) error {
+	alerts := make([]amAlert, 0, len(firing)+len(events))
+	add := func(e event) {
+		labels := maps.Clone(e.Labels)
+		labels["alertname"] = e.Name
+		labels[severityLabel] = e.Severity
+		alert := amAlert{Labels: labels, StartsAt: e.StartsAt, EndsAt: e.EndsAt}
+		if e.Summary != "" {
+			alert.Annotations = map[string]string{"summary": e.Summary}
+		}
+		alerts = append(alerts, alert)
+	}
+	for _, e := range firing {
+		add(e)
+	}
+	for _, e := range events {
+		if e.Status == statusResolved {
+			add(e)
+		}
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	return post(ctx, a.client, a.url, alerts)
+}
+
+// mailer sends an email with the alerts that fired or resolved
+type mailer struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func (m *mailer) name() string { return "smtp" }
+
+func (m *mailer) send(ctx context.Context, events []event, _ []event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	host, _, _ := net.SplitHostPort(m.addr)
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	// the deadline of the connection bounds the whole SMTP conversation
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return errs.New(errs.ErrConnection, "smtp: "+err.Error())
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err = m.sendMail(conn, host, auth, m.message(events)); err != nil {
+		return errs.New(errs.ErrConnection, "smtp: "+err.Error())
+	}
+	return nil
+}
+
+// sendMail is smtp.SendMail over an open connection
+func (m *mailer) sendMail(conn net.Conn, host string, auth smtp.Auth, msg []byte) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err = c.Mail(m.from); err != nil {
+		return err
+	}
+	for _, to := range m.to {
+		if err = c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *mailer) message(events []event) []byte {
+	var fired, resolved int
+	for _, e := range events {
+		if e.Status == statusFiring {
+			fired++
+		} else {
+			resolved++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.to, ", "))
+	fmt.Fprintf(&b, "Subject: [Harvest] %d alerts firing, %d resolved\r\n", fired, resolved)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, e := range events {
+		fmt.Fprintf(&b, "[%s] %s (%s)", strings.ToUpper(e.Status), e.Name, e.Severity)
+		if e.Summary != "" {
+			fmt.Fprintf(&b, ": %s", e.Summary)
+		}
+		b.WriteString("\r\n")
+		keys := make([]string, 0, len(e.Labels))
+		for k := range e.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "  %s: %s\r\n", k, e.Labels[k])
+		}
+		fmt.Fprintf(&b, "  since: %s\r\n\r\n", e.StartsAt.Format(time.RFC3339))
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package threshold implements a plugin that raises alerts when the metrics of an instance cross a threshold.
+// Alerts are exported like the health_* matrices of the Health plugin, and optionally sent to a webhook, by email
+// or to Alertmanager.
+package threshold
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/expr"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"maps"
+	"sync"
+	"time"
+)
+
+const (
+	alertsMetric    = "alerts"
+	severityLabel   = "severity"
+	defaultSeverity = "warning"
+)
+
+// Threshold evaluates the conditions of its alerts for each instance.
+//
+//	plugins:
+//	  - Threshold:
+//	      alerts:
+//	        - name: volume_space_full
+//	          condition: size_used_percent > 90
+//	          clear: size_used_percent < 85
+//	          for: 10m
+//	          severity: critical
+//	      notify:
+//	        webhook: https://hooks.example.com/harvest
+//
+// An alert is pending while its condition is true, and fires when the condition was true for the duration of for.
+// A firing alert resolves when its clear condition is true, or when its condition is false when it has no clear
+// condition. Conditions without value, e.g. because a metric was not collected, don't change the state of the alert.
+type Threshold struct {
+	*plugin.AbstractPlugin
+	alerts      []*alert
+	states      map[string]*state // state of each alert and instance
+	notifiers   []notifier
+	timeout     time.Duration // timeout of notifications
+	stopTimeout time.Duration // how long Stop waits for the queued notifications
+	mu          sync.Mutex    // guards queue, the poller stops the worker from another goroutine
+	queue       chan notification
+	worker      sync.WaitGroup
+	now         func() time.Time
+}
+
+type alert struct {
+	name      string
+	condition *expr.Expr
+	clear     *expr.Expr
+	duration  time.Duration
+	severity  string
+	labels    []string // labels of the instance copied to the alert, default all
+	summary   string
+}
+
+type state struct {
+	alert  *alert
+	key    string
+	since  time.Time // the condition is true since
+	firing bool
+	labels map[string]string
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &Threshold{AbstractPlugin: p}
+}
+
+func (t *Threshold) Init() error {
+
+	var err error
+
+	if err = t.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	alerts := t.Params.GetChildS("alerts")
+	if alerts == nil || len(alerts.GetChildren()) == 0 {
+		return errs.New(errs.ErrMissingParam, "alerts")
+	}
+
+	names := make(map[string]bool)
+	for _, n := range alerts.GetChildren() {
+		a, err := t.parseAlert(n)
+		if err != nil {
+			return err
+		}
+		if names[a.name] {
+			return errs.New(errs.ErrInvalidParam, "duplicate alert "+a.name)
+		}
+		names[a.name] = true
+		t.alerts = append(t.alerts, a)
+	}
+
+	if t.notifiers, t.timeout, err = newNotifiers(t.Params.GetChildS("notify")); err != nil {
+		return err
+	}
+
+	t.stopTimeout = stopTimeout
+	if len(t.notifiers) > 0 {
+		t.startWorker()
+	}
+
+	t.states = make(map[string]*state)
+	t.now = time.Now
+
+	t.Logger.Debug().Int("alerts", len(t.alerts)).Int("notifiers", len(t.notifiers)).Msg("initialized")
+	return nil
+}
+
+func (t *Threshold) parseAlert(n *node.Node) (*alert, error) {
+	var err error
+
+	a := &alert{
+		name:     n.GetChildContentS("name"),
+		severity: n.GetChildContentS("severity"),
+		summary:  n.GetChildContentS("summary"),
+	}
+	if a.name == "" {
+		return nil, errs.New(errs.ErrMissingParam, "alert name")
+	}
+	if a.severity == "" {
+		a.severity = defaultSeverity
+	}
+
+	condition := n.GetChildContentS("condition")
+	if condition == "" {
+		return nil, errs.New(errs.ErrMissingParam, "condition of alert "+a.name)
+	}
+	if a.condition, err = expr.Compile(condition); err != nil {
+		return nil, errs.New(errs.ErrInvalidParam, "condition of alert "+a.name+": "+err.Error())
+	}
+	plugin.SubscribeRefs(a.condition, t.Object)
+
+	if clear := n.GetChildContentS("clear"); clear != "" {
+		if a.clear, err = expr.Compile(clear); err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, "clear of alert "+a.name+": "+err.Error())
+		}
+		plugin.SubscribeRefs(a.clear, t.Object)
+	}
+
+	if d := n.GetChildContentS("for"); d != "" {
+		if a.duration, err = time.ParseDuration(d); err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, "for of alert "+a.name+": "+err.Error())
+		}
+	}
+
+	if labels := n.GetChildS("labels"); labels != nil {
+		a.labels = labels.GetAllChildContentS()
+	}
+	return a, nil
+}
+
+func (t *Threshold) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	data := dataMap[t.Object]
+	now := t.now()
+	env := plugin.NewExprEnv(data, dataMap, t.Logger)
+
+	var events []event
+	seen := make(map[string]bool)
+
+	for _, a := range t.alerts {
+		for key, instance := range data.GetInstances() {
+			if !instance.IsExportable() {
+				continue
+			}
+			env.SetInstance(key, instance)
+			id := a.name + "/" + key
+			seen[id] = true
+			s := t.states[id]
+
+			active, ok := a.condition.Eval(env)
+
+			if s != nil && s.firing {
+				resolved := ok && active == 0
+				if a.clear != nil {
+					clear, ok := a.clear.Eval(env)
+					resolved = ok && clear != 0
+				}
+				if resolved {
+					events = append(events, s.event(resolved, now))
+					delete(t.states, id)
+				} else {
+					s.labels = t.alertLabels(a, instance)
+				}
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+			if active == 0 {
+				delete(t.states, id)
+				continue
+			}
+			if s == nil {
+				s = &state{alert: a, key: key, since: now}
+				t.states[id] = s
+			}
+			s.labels = t.alertLabels(a, instance)
+			if now.Sub(s.since) >= a.duration {
+				s.firing = true
+				events = append(events, s.event(false, now))
+			}
+		}
+	}
+
+	// alerts of instances that disappeared are resolved
+	for id, s := range t.states {
+		if seen[id] {
+			continue
+		}
+		if s.firing {
+			events = append(events, s.event(true, now))
+		}
+		delete(t.states, id)
+	}
+
+	if len(t.notifiers) > 0 {
+		t.notify(events, data.GetGlobalLabels(), now)
+	}
+
+	return t.export(data), nil
+}
+
+// alertLabels returns the labels of the instance copied to the alert
+func (t *Threshold) alertLabels(a *alert, instance *matrix.Instance) map[string]string {
+	if a.labels == nil {
+		return maps.Clone(instance.GetLabels())
+	}
+	labels := make(map[string]string, len(a.labels))
+	for _, label := range a.labels {
+		labels[label] = instance.GetLabel(label)
+	}
+	return labels
+}
+
+// export creates a matrix for each alert with the firing alerts, like the health_* matrices of the Health plugin
+func (t *Threshold) export(data *matrix.Matrix) []*matrix.Matrix {
+	matrices := make(map[string]*matrix.Matrix, len(t.alerts))
+	result := make([]*matrix.Matrix, 0, len(t.alerts))
+	for _, a := range t.alerts {
+		object := "health_" + a.name
+		mat := matrix.New(t.Parent+".Threshold", object, object)
+		mat.SetExportOptions(matrix.DefaultExportOptions())
+		mat.SetGlobalLabels(data.GetGlobalLabels())
+		_, _ = mat.NewMetricFloat64(alertsMetric)
+		matrices[a.name] = mat
+		result = append(result, mat)
+	}
+
+	for _, s := range t.states {
+		if !s.firing {
+			continue
+		}
+		mat := matrices[s.alert.name]
+		instance, err := mat.NewInstance(s.key)
+		if err != nil {
+			t.Logger.Warn().Err(err).Str("key", s.key).Msg("error while creating instance")
+			continue
+		}
+		instance.SetLabels(maps.Clone(s.labels))
+		instance.SetLabel(severityLabel, s.alert.severity)
+		_ = mat.GetMetric(alertsMetric).SetValueFloat64(instance, 1)
+	}
+	return result
+}
@@ -0,0 +1,264 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package threshold
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newThreshold(t *testing.T, notify func(n *node.Node)) (*Threshold, *time.Time) {
+	params := node.NewS("Threshold")
+	a := params.NewChildS("alerts", "").NewChildS("", "")
+	a.NewChildS("name", "volume_full")
+	a.NewChildS("condition", "size_used_percent > 90")
+	a.NewChildS("clear", "size_used_percent < 85")
+	a.NewChildS("for", "10m")
+	a.NewChildS("severity", "critical")
+	a.NewChildS("summary", "volume is almost full")
+	a.NewChildS("labels", "").NewChildS("", "volume")
+	if notify != nil {
+		notify(params.NewChildS("notify", ""))
+	}
+
+	abc := plugin.New("Test", nil, params, nil, "Volume", nil)
+	p := &Threshold{AbstractPlugin: abc}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func newVolumes(used map[string]float64) *matrix.Matrix {
+	m := matrix.New("Zapi", "volume", "volume")
+	m.SetGlobalLabel("cluster", "cluster1")
+	metric, _ := m.NewMetricFloat64("size_used_percent")
+	for name, value := range used {
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("volume", name)
+		instance.SetLabel("svm", "svm1")
+		_ = metric.SetValueFloat64(instance, value)
+	}
+	return m
+}
+
+func firing(t *testing.T, results []*matrix.Matrix) []string {
+	t.Helper()
+	if len(results) != 1 {
+		t.Fatalf("results got=%d, want=1", len(results))
+	}
+	m := results[0]
+	if m.Object != "health_volume_full" {
+		t.Errorf("object got=%s, want=health_volume_full", m.Object)
+	}
+	var keys []string
+	for key, instance := range m.GetInstances() {
+		if instance.GetLabel("severity") != "critical" {
+			t.Errorf("severity of %s got=%s, want=critical", key, instance.GetLabel("severity"))
+		}
+		if v, _ := m.GetMetric("alerts").GetValueFloat64(instance); v != 1 {
+			t.Errorf("alerts of %s got=%v, want=1", key, v)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestThresholdStates(t *testing.T) {
+	p, now := newThreshold(t, nil)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		used    float64
+		firing  int
+	}{
+		{name: "pending", used: 95, firing: 0},
+		{name: "still pending", advance: 5 * time.Minute, used: 95, firing: 0},
+		{name: "fires after for", advance: 5 * time.Minute, used: 92, firing: 1},
+		{name: "hysteresis keeps firing", advance: time.Minute, used: 87, firing: 1},
+		{name: "clear resolves", advance: time.Minute, used: 80, firing: 0},
+		{name: "pending again", advance: time.Minute, used: 95, firing: 0},
+		{name: "pending resets", advance: 9 * time.Minute, used: 50, firing: 0},
+		{name: "pending from start", advance: 2 * time.Minute, used: 95, firing: 0},
+	}
+
+	for _, step := range steps {
+		*now = now.Add(step.advance)
+		results, err := p.Run(map[string]*matrix.Matrix{"Volume": newVolumes(map[string]float64{"vol1": step.used})})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := firing(t, results); len(got) != step.firing {
+			t.Errorf("%s: firing got=%v, want=%d", step.name, got, step.firing)
+		}
+	}
+}
+
+func TestThresholdNotify(t *testing.T) {
+	var webhookBodies []map[string][]event
+	var amBodies [][]amAlert
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/hook":
+			var body map[string][]event
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			webhookBodies = append(webhookBodies, body)
+		case "/api/v2/alerts":
+			var body []amAlert
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			amBodies = append(amBodies, body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p, now := newThreshold(t, func(n *node.Node) {
+		n.NewChildS("webhook", server.URL+"/hook")
+		n.NewChildS("alertmanager", server.URL)
+	})
+
+	run := func(used map[string]float64) {
+		if _, err := p.Run(map[string]*matrix.Matrix{"Volume": newVolumes(used)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(map[string]float64{"vol1": 95, "vol2": 99})
+	*now = now.Add(10 * time.Minute)
+	run(map[string]float64{"vol1": 95, "vol2": 99})
+	*now = now.Add(time.Minute)
+	run(map[string]float64{"vol1": 95, "vol2": 99})
+	*now = now.Add(time.Minute)
+	// vol2 disappears
+	run(map[string]float64{"vol1": 95})
+
+	// the worker sends the queued notifications before it stops
+	p.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the webhook receives changes only: two alerts fired, then vol2 resolved
+	if len(webhookBodies) != 2 {
+		t.Fatalf("webhook calls got=%d, want=2", len(webhookBodies))
+	}
+	if got := len(webhookBodies[0]["alerts"]); got != 2 {
+		t.Errorf("fired alerts got=%d, want=2", got)
+	}
+	resolved := webhookBodies[1]["alerts"]
+	if len(resolved) != 1 || resolved[0].Status != statusResolved || resolved[0].Labels["volume"] != "vol2" {
+		t.Errorf("resolved alerts got=%+v", resolved)
+	}
+	if resolved[0].Labels["cluster"] != "cluster1" {
+		t.Errorf("global label cluster got=%s, want=cluster1", resolved[0].Labels["cluster"])
+	}
+
+	// alertmanager receives all firing alerts with each run
+	if len(amBodies) != 3 {
+		t.Fatalf("alertmanager calls got=%d, want=3", len(amBodies))
+	}
+	last := amBodies[2]
+	if len(last) != 2 {
+		t.Fatalf("alertmanager alerts got=%d, want=2", len(last))
+	}
+	for _, a := range last {
+		if a.Labels["alertname"] != "volume_full" || a.Labels["severity"] != "critical" {
+			t.Errorf("alertmanager labels got=%v", a.Labels)
+		}
+		if a.Labels["volume"] == "vol2" && a.EndsAt == nil {
+			t.Error("alert of vol2 should be resolved")
+		}
+	}
+}
+
+func TestMailMessage(t *testing.T) {
+	m := &mailer{from: "harvest@example.com", to: []string{"ops@example.com"}}
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := string(m.message([]event{
+		{Name: "volume_full", Status: statusFiring, Severity: "critical", Labels: map[string]string{"volume": "vol1"}, StartsAt: since},
+	}))
+	for _, want := range []string{"Subject: [Harvest] 1 alerts firing, 0 resolved", "[FIRING] volume_full (critical)", "volume: vol1"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message should contain %q, got=%s", want, msg)
+		}
+	}
+}
+
+func TestThresholdNotifyDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p, now := newThreshold(t, func(n *node.Node) {
+		n.NewChildS("alertmanager", server.URL)
+		n.NewChildS("timeout", "1m")
+	})
+	p.stopTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	for i := 0; i < queueSize+10; i++ {
+		if _, err := p.Run(map[string]*matrix.Matrix{"Volume": newVolumes(map[string]float64{"vol1": 95})}); err != nil {
+			t.Fatal(err)
+		}
+		*now = now.Add(10 * time.Minute)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runs should not wait for the notifier, took %s", elapsed)
+	}
+
+	// stop waits for the queued notifications until the stop timeout
+	start = time.Now()
+	p.Stop()
+	if elapsed := time.Since(start); elapsed < p.stopTimeout || elapsed > 5*time.Second {
+		t.Errorf("stop should wait for the stop timeout, took %s", elapsed)
+	}
+}
+
+func TestMailTimeout(t *testing.T) {
+	// the server accepts the connection and never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			<-time.After(10 * time.Second)
+			_ = conn.Close()
+		}
+	}()
+
+	m := &mailer{addr: listener.Addr().String(), from: "harvest@example.com", to: []string{"ops@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.send(ctx, []event{{Name: "volume_full", Status: statusFiring}}, nil); err == nil {
+		t.Errorf("send should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("send should stop at the timeout, took %s", elapsed)
+	}
+}
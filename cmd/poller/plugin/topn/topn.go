@@ -0,0 +1,240 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package topn
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const otherInstance = "other"
+
+// TopN only exports the N instances with the highest value of a metric, per group of instances. The remaining
+// instances are not exported, and optionally summed into an "other" instance per group. TopN is meant for
+// ZapiPerf and RestPerf objects: the collector still calculates the deltas of all instances, so an instance that
+// moves into the top N is exported with correct values.
+type TopN struct {
+	*plugin.AbstractPlugin
+	n       int
+	metric  string
+	groupBy []string
+	other   bool
+	others  []string // keys of the other instances created during the last run
+	hidden  []string // keys of the instances hidden during the last run
+}
+
+func New(p *plugin.AbstractPlugin) plugin.Plugin {
+	return &TopN{AbstractPlugin: p}
+}
+
+func (t *TopN) Init() error {
+
+	var err error
+
+	if err = t.AbstractPlugin.Init(); err != nil {
+		return err
+	}
+
+	n := t.Params.GetChildContentS("n")
+	if n == "" {
+		return errs.New(errs.ErrMissingParam, "n")
+	}
+	if t.n, err = strconv.Atoi(n); err != nil || t.n < 1 {
+		return errs.New(errs.ErrInvalidParam, "n: "+n)
+	}
+
+	if t.metric = t.Params.GetChildContentS("metric"); t.metric == "" {
+		return errs.New(errs.ErrMissingParam, "metric")
+	}
+
+	if g := t.Params.GetChildS("group_by"); g != nil {
+		if g.GetContentS() != "" {
+			t.groupBy = strings.Split(g.GetContentS(), ",")
+		} else {
+			t.groupBy = g.GetAllChildContentS()
+		}
+		for i := range t.groupBy {
+			t.groupBy[i] = strings.TrimSpace(t.groupBy[i])
+		}
+	}
+
+	t.other = t.Params.GetChildContentS("other") == "true"
+
+	t.Logger.Debug().
+		Int("n", t.n).
+		Str("metric", t.metric).
+		Strs("groupBy", t.groupBy).
+		Bool("other", t.other).
+		Msg("initialized")
+	return nil
+}
+
+type ranked struct {
+	key      string
+	instance *matrix.Instance
+	value    float64
+	ok       bool
+}
+
+func (t *TopN) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+
+	data := dataMap[t.Object]
+
+	// undo the previous run for collectors that keep their instances between polls. Perf collectors create
+	// new instances each poll
+	for _, key := range t.others {
+		data.RemoveInstance(key)
+	}
+	for _, key := range t.hidden {
+		if instance := data.GetInstance(key); instance != nil {
+			instance.SetExportable(true)
+		}
+	}
+	t.others = t.others[:0]
+	t.hidden = t.hidden[:0]
+
+	metric := data.LookupMetric(t.metric)
+	if metric == nil {
+		t.Logger.Warn().Str("metric", t.metric).Msg("metric not found, all instances are exported")
+		return nil, nil
+	}
+
+	groups := make(map[string][]ranked)
+	for key, instance := range data.GetInstances() {
+		if !instance.IsExportable() {
+			continue
+		}
+		value, ok := metric.GetValueFloat64(instance)
+		group := t.groupKey(instance)
+		groups[group] = append(groups[group], ranked{key: key, instance: instance, value: value, ok: ok})
+	}
+
+	for group, instances := range groups {
+		if len(instances) <= t.n {
+			continue
+		}
+		// instances without a value, e.g. skipped because of a counter reset, are ranked last
+		sort.Slice(instances, func(i, j int) bool {
+			a, b := instances[i], instances[j]
+			if a.ok != b.ok {
+				return a.ok
+			}
+			if a.value != b.value {
+				return a.value > b.value
+			}
+			return a.key < b.key
+		})
+
+		rest := instances[t.n:]
+		for _, r := range rest {
+			r.instance.SetExportable(false)
+			t.hidden = append(t.hidden, r.key)
+		}
+
+		if t.other {
+			if err := t.addOther(data, group, rest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	t.Logger.Debug().Int("groups", len(groups)).Int("hidden", len(t.hidden)).Msg("ranked instances")
+	return nil, nil
+}
+
+func (t *TopN) groupKey(instance *matrix.Instance) string {
+	values := make([]string, 0, len(t.groupBy))
+	for _, label := range t.groupBy {
+		values = append(values, instance.GetLabel(label))
+	}
+	return strings.Join(values, ".")
+}
+
+// addOther sums the instances of a group into a new instance. Labels that have the same value in all instances,
+// such as the labels of the group, keep their value. The other labels are set to "other".
+// Deltas and rates are summed, averages and percents are weighted by their base counter, like the Aggregator plugin
+// does. Other metrics, such as raw counters, are not additive and are not set on the new instance.
+func (t *TopN) addOther(data *matrix.Matrix, group string, instances []ranked) error {
+	key := otherInstance
+	if group != "" {
+		key += "." + group
+	}
+	other, err := data.NewInstance(key)
+	if err != nil {
+		return err
+	}
+	t.others = append(t.others, key)
+
+	labels := make(map[string]string)
+	for label, value := range instances[0].instance.GetLabels() {
+		labels[label] = value
+	}
+	for _, r := range instances[1:] {
+		for label, value := range labels {
+			if r.instance.GetLabel(label) != value {
+				labels[label] = otherInstance
+			}
+		}
+	}
+	other.SetLabels(labels)
+
+	for _, metric := range data.GetMetrics() {
+		var sum, weights float64
+		var recorded bool
+
+		var isAverage bool
+		switch metric.GetProperty() {
+		case "delta", "rate":
+		case "average", "percent":
+			isAverage = true
+		default:
+			// raw counters, such as sizes or maximums, are not additive
+			continue
+		}
+		var base *matrix.Metric
+		if isAverage {
+			if base = data.GetMetric(metric.GetComment()); base == nil {
+				continue
+			}
+		}
+
+		for _, r := range instances {
+			value, ok := metric.GetValueFloat64(r.instance)
+			if !ok {
+				continue
+			}
+			if isAverage {
+				weight, ok := base.GetValueFloat64(r.instance)
+				if !ok {
+					continue
+				}
+				sum += value * weight
+				weights += weight
+			} else {
+				sum += value
+			}
+			recorded = true
+		}
+
+		if !recorded {
+			continue
+		}
+		if isAverage {
+			if weights == 0 {
+				sum = 0
+			} else {
+				sum /= weights
+			}
+		}
+		if err := metric.SetValueFloat64(other, sum); err != nil {
+			t.Logger.Error().Err(err).Str("metric", metric.GetName()).Str("key", key).Msg("set value")
+		}
+	}
+	return nil
+}
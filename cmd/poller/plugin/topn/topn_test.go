@@ -0,0 +1,145 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package topn
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"slices"
+	"testing"
+)
+
+func newTopN(n string, groupBy string, other bool) *TopN {
+	params := node.NewS("TopN")
+	params.NewChildS("n", n)
+	params.NewChildS("metric", "ops")
+	if groupBy != "" {
+		params.NewChildS("group_by", groupBy)
+	}
+	if other {
+		params.NewChildS("other", "true")
+	}
+
+	abc := plugin.New("Test", nil, params, nil, "volume", nil)
+	p := &TopN{AbstractPlugin: abc}
+
+	if err := p.Init(); err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// newVolumes creates six volumes on two nodes. The ops of each volume are its number times 10,
+// and its latency is its number.
+func newVolumes() *matrix.Matrix {
+	m := matrix.New("", "volume", "volume")
+
+	ops, _ := m.NewMetricFloat64("total_ops", "ops")
+	ops.SetProperty("rate")
+	latency, _ := m.NewMetricFloat64("read_latency")
+	latency.SetProperty("average")
+	latency.SetComment("total_ops")
+	size, _ := m.NewMetricFloat64("size")
+	size.SetProperty("raw")
+
+	volumes := []struct {
+		name string
+		node string
+	}{
+		{"vol1", "nodeA"}, {"vol2", "nodeA"}, {"vol3", "nodeA"},
+		{"vol4", "nodeB"}, {"vol5", "nodeB"}, {"vol6", "nodeB"},
+	}
+	for i, v := range volumes {
+		instance, _ := m.NewInstance(v.name)
+		instance.SetLabel("volume", v.name)
+		instance.SetLabel("node", v.node)
+		instance.SetLabel("svm", "svm1")
+		_ = ops.SetValueFloat64(instance, float64(i+1)*10)
+		_ = latency.SetValueFloat64(instance, float64(i+1))
+		_ = size.SetValueFloat64(instance, 100)
+	}
+	return m
+}
+
+func exported(m *matrix.Matrix) []string {
+	var keys []string
+	for key, instance := range m.GetInstances() {
+		if instance.IsExportable() {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestTopN(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       string
+		groupBy string
+		other   bool
+		want    []string
+	}{
+		{name: "cluster", n: "2", want: []string{"vol5", "vol6"}},
+		{name: "per node", n: "1", groupBy: "node", want: []string{"vol3", "vol6"}},
+		{name: "other", n: "2", other: true, want: []string{"other", "vol5", "vol6"}},
+		{name: "other per node", n: "2", groupBy: "node", other: true, want: []string{"other.nodeA", "other.nodeB", "vol2", "vol3", "vol5", "vol6"}},
+		{name: "fewer instances than n", n: "10", other: true, want: []string{"vol1", "vol2", "vol3", "vol4", "vol5", "vol6"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTopN(tt.n, tt.groupBy, tt.other)
+			m := newVolumes()
+			if _, err := p.Run(map[string]*matrix.Matrix{"volume": m}); err != nil {
+				t.Fatal(err)
+			}
+			if got := exported(m); !slices.Equal(got, tt.want) {
+				t.Errorf("exported got=%v, want=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopNOther(t *testing.T) {
+	p := newTopN("1", "node", true)
+	m := newVolumes()
+	if _, err := p.Run(map[string]*matrix.Matrix{"volume": m}); err != nil {
+		t.Fatal(err)
+	}
+
+	other := m.GetInstance("other.nodeA")
+	if other == nil {
+		t.Fatalf("instance other.nodeA missing")
+	}
+	wantLabels := map[string]string{"node": "nodeA", "svm": "svm1", "volume": "other"}
+	for label, want := range wantLabels {
+		if got := other.GetLabel(label); got != want {
+			t.Errorf("label %s got=%s, want=%s", label, got, want)
+		}
+	}
+
+	// vol1 and vol2 are summed
+	if got, _ := m.GetMetric("total_ops").GetValueFloat64(other); got != 30 {
+		t.Errorf("total_ops got=%v, want=30", got)
+	}
+	// latency is weighted by ops: (1*10 + 2*20) / 30
+	if got, _ := m.GetMetric("read_latency").GetValueFloat64(other); got != 50.0/30 {
+		t.Errorf("read_latency got=%v, want=%v", got, 50.0/30)
+	}
+	// raw counters are not summed
+	if _, ok := m.GetMetric("size").GetValueFloat64(other); ok {
+		t.Errorf("size should not be set on the other instance")
+	}
+
+	// the other instances are replaced in the next run
+	if _, err := p.Run(map[string]*matrix.Matrix{"volume": m}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(m.GetInstances()); got != 8 {
+		t.Errorf("numInstances got=%d, want=8", got)
+	}
+}
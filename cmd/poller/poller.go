@@ -31,8 +31,11 @@ import (
 	"errors"
 	"fmt"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/ems"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/genericrest"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/prometheus"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/restperf"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/simple"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/snmp"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/storagegrid"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/unix"
 	_ "github.com/netapp/harvest/v2/cmd/collectors/zapi/collector"
@@ -123,6 +126,7 @@ type Poller struct {
 	client          *http.Client
 	auth            *auth.Credentials
 	hasPromExporter bool
+	stopOnce        sync.Once
 }
 
 // Init starts Poller, reads parameters, opens zeroLog handler, initializes metadata,
@@ -526,9 +530,14 @@ func (p *Poller) Run() {
 	}
 }
 
-// Stop gracefully exits the program by closing zeroLog
+// Stop gracefully exits the program by closing zeroLog and stopping the collectors
 func (p *Poller) Stop() {
 	logger.Info().Msgf("cleaning up and stopping [pid=%d]", os.Getpid())
+	p.stopOnce.Do(func() {
+		for _, col := range p.collectors {
+			col.Stop()
+		}
+	})
 }
 
 // set up signal disposition
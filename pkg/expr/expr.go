@@ -0,0 +1,827 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package expr implements the arithmetic expressions of templates, e.g. the compute_metric rules of MetricAgent.
+// An expression is compiled once and evaluated for each instance of a matrix.
+//
+//	100 * clamp(size_used / size_total, 0, 1)
+//	if(label("state") == "online", convert(size, "B", "GiB"), 0)
+//	Aggregate:size_total[aggr] - size
+//
+// Operands are numbers, "strings", metrics of the instance, labels of the instance with label("name"), and metrics of
+// other objects with Object:metric. A reference to another object uses the instance with the same key, or the
+// only instance of the object. With Object:metric[label] the instance of the other object is found by the value of
+// label, with Object:metric[label=other] the value of label is matched against the label other of the other object.
+// Metric names that are not identifiers, like zapi keys, are quoted in backticks.
+//
+// Operators by increasing precedence: ||, &&, comparisons (== != < <= > >= and =~ to match a regex), + -, * / %,
+// and the unary - and !. Comparisons and logical operators return 1 or 0, zero is false.
+//
+// An expression has no value when a metric it uses has no value. Division by zero returns 0, like the operations of
+// compute_metric.
+package expr
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Env resolves the operands of an expression for one instance
+type Env interface {
+	Metric(name string) (float64, bool)
+	Label(name string) string
+	Ref(ref *Ref) (float64, bool)
+}
+
+// Ref is a metric of another object
+type Ref struct {
+	Object     string
+	Metric     string
+	Label      string // label of the instance that joins the instances of both objects
+	OtherLabel string // label of the other object, defaults to Label
+}
+
+func (r *Ref) String() string {
+	s := r.Object + ":" + r.Metric
+	switch {
+	case r.Label != "" && r.OtherLabel != r.Label:
+		s += "[" + r.Label + "=" + r.OtherLabel + "]"
+	case r.Label != "":
+		s += "[" + r.Label + "]"
+	}
+	return s
+}
+
+// Error is a compile error. Pos is the column of the error, starting at 1.
+type Error struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at column %d: %s", e.Msg, e.Pos, e.Expr)
+}
+
+// Expr is a compiled expression
+type Expr struct {
+	text    string
+	root    node
+	metrics []string
+	refs    []*Ref
+}
+
+// Compile parses an expression and checks the types of its operands
+func Compile(text string) (*Expr, error) {
+	p := &parser{text: text, known: make(map[string]bool)}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	if root.kind() != numType {
+		return nil, p.errorf(token{pos: 0}, "expression is a string, not a number")
+	}
+	return &Expr{text: text, root: root, metrics: p.metrics, refs: p.refs}, nil
+}
+
+func (e *Expr) String() string {
+	return e.text
+}
+
+// Metrics returns the names of the metrics of the instance used by the expression
+func (e *Expr) Metrics() []string {
+	return e.metrics
+}
+
+// Refs returns the metrics of other objects used by the expression
+func (e *Expr) Refs() []*Ref {
+	return e.refs
+}
+
+// Eval returns the value of the expression, or false when an operand has no value
+func (e *Expr) Eval(env Env) (float64, bool) {
+	v, ok := e.root.eval(env)
+	if !ok || math.IsNaN(v.num) {
+		return 0, false
+	}
+	return v.num, true
+}
+
+type valueType int
+
+const (
+	numType valueType = iota
+	strType
+)
+
+func (t valueType) String() string {
+	if t == strType {
+		return "string"
+	}
+	return "number"
+}
+
+type value struct {
+	num float64
+	str string
+}
+
+type node interface {
+	eval(env Env) (value, bool)
+	kind() valueType
+}
+
+type numberNode float64
+
+func (n numberNode) eval(Env) (value, bool) { return value{num: float64(n)}, true }
+func (n numberNode) kind() valueType        { return numType }
+
+type stringNode string
+
+func (n stringNode) eval(Env) (value, bool) { return value{str: string(n)}, true }
+func (n stringNode) kind() valueType        { return strType }
+
+type metricNode string
+
+func (n metricNode) eval(env Env) (value, bool) {
+	v, ok := env.Metric(string(n))
+	return value{num: v}, ok
+}
+func (n metricNode) kind() valueType { return numType }
+
+type refNode struct {
+	ref *Ref
+}
+
+func (n refNode) eval(env Env) (value, bool) {
+	v, ok := env.Ref(n.ref)
+	return value{num: v}, ok
+}
+func (n refNode) kind() valueType { return numType }
+
+type labelNode string
+
+func (n labelNode) eval(env Env) (value, bool) { return value{str: env.Label(string(n))}, true }
+func (n labelNode) kind() valueType            { return strType }
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n unaryNode) eval(env Env) (value, bool) {
+	x, ok := n.x.eval(env)
+	if !ok {
+		return x, false
+	}
+	if n.op == "!" {
+		return value{num: boolean(x.num == 0)}, true
+	}
+	return value{num: -x.num}, true
+}
+func (n unaryNode) kind() valueType { return numType }
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+func (n binaryNode) eval(env Env) (value, bool) {
+	x, ok := n.x.eval(env)
+	if !ok {
+		return x, false
+	}
+	// the right side of logical operators is only evaluated when needed
+	switch n.op {
+	case "&&":
+		if x.num == 0 {
+			return value{num: 0}, true
+		}
+	case "||":
+		if x.num != 0 {
+			return value{num: 1}, true
+		}
+	}
+	y, ok := n.y.eval(env)
+	if !ok {
+		return y, false
+	}
+	if n.x.kind() == strType {
+		switch n.op {
+		case "==":
+			return value{num: boolean(x.str == y.str)}, true
+		case "!=":
+			return value{num: boolean(x.str != y.str)}, true
+		case "<":
+			return value{num: boolean(x.str < y.str)}, true
+		case "<=":
+			return value{num: boolean(x.str <= y.str)}, true
+		case ">":
+			return value{num: boolean(x.str > y.str)}, true
+		case ">=":
+			return value{num: boolean(x.str >= y.str)}, true
+		}
+	}
+	var r float64
+	switch n.op {
+	case "+":
+		r = x.num + y.num
+	case "-":
+		r = x.num - y.num
+	case "*":
+		r = x.num * y.num
+	case "/":
+		if y.num != 0 {
+			r = x.num / y.num
+		}
+	case "%":
+		if y.num != 0 {
+			r = math.Mod(x.num, y.num)
+		}
+	case "==":
+		r = boolean(x.num == y.num)
+	case "!=":
+		r = boolean(x.num != y.num)
+	case "<":
+		r = boolean(x.num < y.num)
+	case "<=":
+		r = boolean(x.num <= y.num)
+	case ">":
+		r = boolean(x.num > y.num)
+	case ">=":
+		r = boolean(x.num >= y.num)
+	case "&&", "||":
+		r = boolean(y.num != 0)
+	}
+	return value{num: r}, true
+}
+func (n binaryNode) kind() valueType { return numType }
+
+type matchNode struct {
+	x  node
+	re *regexp.Regexp
+}
+
+func (n matchNode) eval(env Env) (value, bool) {
+	x, ok := n.x.eval(env)
+	if !ok {
+		return x, false
+	}
+	return value{num: boolean(n.re.MatchString(x.str))}, true
+}
+func (n matchNode) kind() valueType { return numType }
+
+type ifNode struct {
+	cond, then, otherwise node
+}
+
+func (n ifNode) eval(env Env) (value, bool) {
+	c, ok := n.cond.eval(env)
+	if !ok {
+		return c, false
+	}
+	if c.num != 0 {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+func (n ifNode) kind() valueType { return n.then.kind() }
+
+type callNode struct {
+	fn   func(args []float64) float64
+	args []node
+}
+
+func (n callNode) eval(env Env) (value, bool) {
+	args := make([]float64, len(n.args))
+	for i, a := range n.args {
+		v, ok := a.eval(env)
+		if !ok {
+			return v, false
+		}
+		args[i] = v.num
+	}
+	return value{num: n.fn(args)}, true
+}
+func (n callNode) kind() valueType { return numType }
+
+func boolean(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// function is a builtin function with numeric arguments. maxArgs < 0 means any number of arguments
+type function struct {
+	minArgs, maxArgs int
+	fn               func(args []float64) float64
+}
+
+var functions = map[string]function{
+	"abs":   {1, 1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"ceil":  {1, 1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"floor": {1, 1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"round": {1, 1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"min": {1, -1, func(a []float64) float64 {
+		r := a[0]
+		for _, v := range a[1:] {
+			r = math.Min(r, v)
+		}
+		return r
+	}},
+	"max": {1, -1, func(a []float64) float64 {
+		r := a[0]
+		for _, v := range a[1:] {
+			r = math.Max(r, v)
+		}
+		return r
+	}},
+	"clamp": {3, 3, func(a []float64) float64 { return math.Max(a[1], math.Min(a[0], a[2])) }},
+	"percent": {2, 2, func(a []float64) float64 {
+		if a[1] == 0 {
+			return 0
+		}
+		return a[0] / a[1] * 100
+	}},
+}
+
+// units maps a unit to its factor of the base unit of its dimension
+var units = map[string]struct {
+	dimension string
+	factor    float64
+}{
+	"b":   {"bytes", 1.0 / 8},
+	"B":   {"bytes", 1},
+	"KB":  {"bytes", 1e3},
+	"MB":  {"bytes", 1e6},
+	"GB":  {"bytes", 1e9},
+	"TB":  {"bytes", 1e12},
+	"PB":  {"bytes", 1e15},
+	"KiB": {"bytes", 1 << 10},
+	"MiB": {"bytes", 1 << 20},
+	"GiB": {"bytes", 1 << 30},
+	"TiB": {"bytes", 1 << 40},
+	"PiB": {"bytes", 1 << 50},
+	"ns":  {"time", 1e-9},
+	"us":  {"time", 1e-6},
+	"ms":  {"time", 1e-3},
+	"s":   {"time", 1},
+	"min": {"time", 60},
+	"h":   {"time", 3600},
+	"d":   {"time", 86400},
+}
+
+type tokenKind int
+
+const (
+	tEOF tokenKind = iota
+	tNumber
+	tString
+	tIdent
+	tOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // byte offset in the expression
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tEOF:
+		return "end of expression"
+	case tString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+type parser struct {
+	text    string
+	tokens  []token
+	next    int
+	metrics []string
+	refs    []*Ref
+	known   map[string]bool
+}
+
+func (p *parser) errorf(t token, format string, a ...any) error {
+	return &Error{Expr: p.text, Pos: t.pos + 1, Msg: fmt.Sprintf(format, a...)}
+}
+
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ",", ":", "[", "]", "="}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *parser) lex() error {
+	s := p.text
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+				j++
+				if j < len(s) && (s[j] == '+' || s[j] == '-') {
+					j++
+				}
+				for j < len(s) && unicode.IsDigit(rune(s[j])) {
+					j++
+				}
+			}
+			if _, err := strconv.ParseFloat(s[i:j], 64); err != nil {
+				return p.errorf(token{pos: i}, "invalid number %s", s[i:j])
+			}
+			p.tokens = append(p.tokens, token{kind: tNumber, text: s[i:j], pos: i})
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && isIdent(rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tIdent, text: s[i:j], pos: i})
+			i = j
+		case c == '`':
+			j := strings.IndexByte(s[i+1:], '`')
+			if j < 0 {
+				return p.errorf(token{pos: i}, "unterminated name")
+			}
+			p.tokens = append(p.tokens, token{kind: tIdent, text: s[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != s[i] {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return p.errorf(token{pos: i}, "unterminated string")
+			}
+			text := s[i+1 : j]
+			if c == '"' {
+				var err error
+				if text, err = strconv.Unquote(s[i : j+1]); err != nil {
+					return p.errorf(token{pos: i}, "invalid string")
+				}
+			}
+			p.tokens = append(p.tokens, token{kind: tString, text: text, pos: i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return p.errorf(token{pos: i}, "unexpected character %q", c)
+			}
+			p.tokens = append(p.tokens, token{kind: tOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tEOF, pos: len(s)})
+	return nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tEOF {
+		p.next++
+	}
+	return t
+}
+
+// accept consumes the next token when it is one of the operators
+func (p *parser) accept(ops ...string) (token, bool) {
+	t := p.peek()
+	if t.kind != tOp {
+		return t, false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.next++
+			return t, true
+		}
+	}
+	return t, false
+}
+
+func (p *parser) expect(op string) error {
+	if t, ok := p.accept(op); !ok {
+		return p.errorf(t, "expected '%s', found %s", op, t)
+	}
+	return nil
+}
+
+func (p *parser) number(t token, n node) error {
+	if n.kind() != numType {
+		return p.errorf(t, "%s expects a number, found a string", t)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *parser) parseAdd() (node, error) {
+	return p.parseBinary(p.parseMul, "+", "-")
+}
+
+func (p *parser) parseMul() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+// parseBinary parses left associative numeric operators
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.accept(ops...)
+		if !ok {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.number(t, x); err != nil {
+			return nil, err
+		}
+		if err = p.number(t, y); err != nil {
+			return nil, err
+		}
+		x = binaryNode{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~")
+	if !ok {
+		return x, nil
+	}
+	if t.text == "=~" {
+		r := p.advance()
+		if r.kind != tString {
+			return nil, p.errorf(r, "'=~' expects a regex string, found %s", r)
+		}
+		if x.kind() != strType {
+			return nil, p.errorf(t, "'=~' expects a string, found a number")
+		}
+		re, err := regexp.Compile("^(?:" + r.text + ")$")
+		if err != nil {
+			return nil, p.errorf(r, "invalid regex: %v", err)
+		}
+		return matchNode{x: x, re: re}, nil
+	}
+	y, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if x.kind() != y.kind() {
+		return nil, p.errorf(t, "%s compares a %s with a %s", t, x.kind(), y.kind())
+	}
+	return binaryNode{op: t.text, x: x, y: y}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t, ok := p.accept("-", "!", "+")
+	if !ok {
+		return p.parsePrimary()
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.number(t, x); err != nil {
+		return nil, err
+	}
+	if t.text == "+" {
+		return x, nil
+	}
+	return unaryNode{op: t.text, x: x}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tNumber:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return numberNode(v), nil
+	case tString:
+		return stringNode(t.text), nil
+	case tIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		if _, ok := p.accept(":"); ok {
+			return p.parseRef(t)
+		}
+		if !p.known[t.text] {
+			p.known[t.text] = true
+			p.metrics = append(p.metrics, t.text)
+		}
+		return metricNode(t.text), nil
+	case tOp:
+		if t.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t)
+}
+
+func (p *parser) parseArgs() ([]node, []token, error) {
+	var args []node
+	var tokens []token
+	if _, ok := p.accept(")"); ok {
+		return args, tokens, nil
+	}
+	for {
+		tokens = append(tokens, p.peek())
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, arg)
+		if _, ok := p.accept(","); !ok {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, nil, err
+	}
+	return args, tokens, nil
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	args, tokens, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name.text {
+	case "label":
+		if len(args) != 1 {
+			return nil, p.errorf(name, "label expects 1 argument, found %d", len(args))
+		}
+		s, ok := args[0].(stringNode)
+		if !ok {
+			return nil, p.errorf(tokens[0], "label expects the name of a label as string")
+		}
+		return labelNode(s), nil
+	case "if":
+		if len(args) != 3 {
+			return nil, p.errorf(name, "if expects 3 arguments, found %d", len(args))
+		}
+		if err = p.number(tokens[0], args[0]); err != nil {
+			return nil, err
+		}
+		if args[1].kind() != args[2].kind() {
+			return nil, p.errorf(name, "if returns a %s or a %s", args[1].kind(), args[2].kind())
+		}
+		return ifNode{cond: args[0], then: args[1], otherwise: args[2]}, nil
+	case "convert":
+		return p.parseConvert(name, args, tokens)
+	}
+
+	f, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function %s", name.text)
+	}
+	if len(args) < f.minArgs || f.maxArgs >= 0 && len(args) > f.maxArgs {
+		return nil, p.errorf(name, "%s expects %s, found %d", name.text, arguments(f.minArgs, f.maxArgs), len(args))
+	}
+	for i, a := range args {
+		if err = p.number(tokens[i], a); err != nil {
+			return nil, err
+		}
+	}
+	return callNode{fn: f.fn, args: args}, nil
+}
+
+func arguments(minArgs, maxArgs int) string {
+	switch {
+	case maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", minArgs)
+	case minArgs == 1 && maxArgs == 1:
+		return "1 argument"
+	case minArgs == maxArgs:
+		return fmt.Sprintf("%d arguments", minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", minArgs, maxArgs)
+}
+
+// parseConvert parses convert(value, "from", "to"), which converts a value between units of the same dimension
+func (p *parser) parseConvert(name token, args []node, tokens []token) (node, error) {
+	if len(args) != 3 {
+		return nil, p.errorf(name, "convert expects 3 arguments, found %d", len(args))
+	}
+	if err := p.number(tokens[0], args[0]); err != nil {
+		return nil, err
+	}
+	from, ok := args[1].(stringNode)
+	if !ok {
+		return nil, p.errorf(tokens[1], "convert expects a unit as string")
+	}
+	to, ok := args[2].(stringNode)
+	if !ok {
+		return nil, p.errorf(tokens[2], "convert expects a unit as string")
+	}
+	f, ok := units[string(from)]
+	if !ok {
+		return nil, p.errorf(tokens[1], "unknown unit %s", from)
+	}
+	u, ok := units[string(to)]
+	if !ok {
+		return nil, p.errorf(tokens[2], "unknown unit %s", to)
+	}
+	if f.dimension != u.dimension {
+		return nil, p.errorf(tokens[2], "can't convert %s to %s", from, to)
+	}
+	factor := f.factor / u.factor
+	return callNode{fn: func(a []float64) float64 { return a[0] * factor }, args: args[:1]}, nil
+}
+
+// parseRef parses the metric and the optional join of Object:metric[label=other]
+func (p *parser) parseRef(object token) (node, error) {
+	t := p.advance()
+	if t.kind != tIdent {
+		return nil, p.errorf(t, "expected a metric of %s, found %s", object.text, t)
+	}
+	ref := &Ref{Object: object.text, Metric: t.text}
+	if _, ok := p.accept("["); ok {
+		l := p.advance()
+		if l.kind != tIdent {
+			return nil, p.errorf(l, "expected a label, found %s", l)
+		}
+		ref.Label = l.text
+		ref.OtherLabel = l.text
+		if _, ok := p.accept("="); ok {
+			o := p.advance()
+			if o.kind != tIdent {
+				return nil, p.errorf(o, "expected a label, found %s", o)
+			}
+			ref.OtherLabel = o.text
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range p.refs {
+		if *r == *ref {
+			return refNode{ref: r}, nil
+		}
+	}
+	p.refs = append(p.refs, ref)
+	return refNode{ref: ref}, nil
+}
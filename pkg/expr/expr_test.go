@@ -0,0 +1,142 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package expr
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+type testEnv struct {
+	metrics map[string]float64
+	labels  map[string]string
+	refs    map[string]float64
+}
+
+func (e testEnv) Metric(name string) (float64, bool) {
+	v, ok := e.metrics[name]
+	return v, ok
+}
+
+func (e testEnv) Label(name string) string {
+	return e.labels[name]
+}
+
+func (e testEnv) Ref(ref *Ref) (float64, bool) {
+	v, ok := e.refs[ref.String()]
+	return v, ok
+}
+
+func TestEval(t *testing.T) {
+	env := testEnv{
+		metrics: map[string]float64{"size": 1 << 30, "used": 256 << 20, "zero": 0, "a.b": 3, "read-ops": 7},
+		labels:  map[string]string{"state": "online", "node": "umeng-aff300-01"},
+		refs:    map[string]float64{"Aggregate:size_total[aggr]": 4 << 30, "Cluster:nodes": 2},
+	}
+
+	tests := []struct {
+		expr string
+		want float64
+		ok   bool
+	}{
+		{expr: "1 + 2 * 3", want: 7, ok: true},
+		{expr: "(1 + 2) * 3", want: 9, ok: true},
+		{expr: "10 - 4 - 3", want: 3, ok: true},
+		{expr: "-2 * -3", want: 6, ok: true},
+		{expr: "7 % 3", want: 1, ok: true},
+		{expr: "1.5e3 / 3", want: 500, ok: true},
+		{expr: "used / zero", want: 0, ok: true},
+		{expr: "percent(used, size)", want: 25, ok: true},
+		{expr: "percent(used, zero)", want: 0, ok: true},
+		{expr: "a.b * `read-ops`", want: 21, ok: true},
+		{expr: "min(3, 1, 2) + max(3, 1, 2)", want: 4, ok: true},
+		{expr: "abs(-4)", want: 4, ok: true},
+		{expr: "clamp(used / size * 10, 0, 1)", want: 1, ok: true},
+		{expr: "round(2.5) + floor(1.7) + ceil(1.2)", want: 6, ok: true},
+		{expr: `if(label("state") == "online", 1, 0)`, want: 1, ok: true},
+		{expr: `if(label("state") != "online", missing, 2)`, want: 2, ok: true},
+		{expr: `label("node") =~ ".*-01" && size > used`, want: 1, ok: true},
+		{expr: `!(1 < 2) || 0`, want: 0, ok: true},
+		{expr: `convert(size, "B", "GiB")`, want: 1, ok: true},
+		{expr: `convert(90, "s", "min")`, want: 1.5, ok: true},
+		{expr: `convert(1, "GB", "MB")`, want: 1000, ok: true},
+		{expr: "Aggregate:size_total[aggr] - size", want: 3 << 30, ok: true},
+		{expr: "Cluster:nodes", want: 2, ok: true},
+		{expr: "Node:cpu", ok: false},
+		{expr: "missing + 1", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := e.Eval(env)
+			if ok != tt.ok {
+				t.Fatalf("ok got=%t, want=%t", ok, tt.ok)
+			}
+			if ok && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got=%v, want=%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{expr: "1 + * 2", pos: 5},
+		{expr: "(1 + 2", pos: 7},
+		{expr: "foo(1)", pos: 1},
+		{expr: "clamp(1, 2)", pos: 1},
+		{expr: `convert(1, "B", "s")`, pos: 17},
+		{expr: `convert(1, "B", "XB")`, pos: 17},
+		{expr: `label("state") + 1`, pos: 16},
+		{expr: `label("state") == 1`, pos: 16},
+		{expr: `label("state")`, pos: 1},
+		{expr: `size =~ "a"`, pos: 6},
+		{expr: `label("a") =~ "("`, pos: 15},
+		{expr: "1 2", pos: 3},
+		{expr: `"abc`, pos: 1},
+		{expr: "size # 2", pos: 6},
+		{expr: "Aggregate:", pos: 11},
+		{expr: "Aggregate:size[", pos: 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Compile(tt.expr)
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected a compile error, got=%v", err)
+			}
+			if e.Pos != tt.pos {
+				t.Errorf("pos got=%d, want=%d: %v", e.Pos, tt.pos, err)
+			}
+		})
+	}
+}
+
+func TestOperands(t *testing.T) {
+	e, err := Compile("a + b * a + Aggregate:size[aggr=aggregate] + Aggregate:size[aggr=aggregate]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := e.Metrics(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("metrics got=%v, want=[a b]", got)
+	}
+	refs := e.Refs()
+	if len(refs) != 1 {
+		t.Fatalf("refs got=%d, want=1", len(refs))
+	}
+	want := Ref{Object: "Aggregate", Metric: "size", Label: "aggr", OtherLabel: "aggregate"}
+	if *refs[0] != want {
+		t.Errorf("ref got=%+v, want=%+v", *refs[0], want)
+	}
+}
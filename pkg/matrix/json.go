@@ -0,0 +1,178 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package matrix
+
+import (
+	"encoding/json"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"math"
+	"sort"
+)
+
+// The JSON representation of a Matrix is used to exchange matrices with processes outside of Harvest, e.g. external
+// plugins. Values are stored with their instance, keyed by metric key. Values that are not recorded, as well as NaN
+// and infinite values, are omitted. The type of a metric defaults to float64, and the matrix, metrics and instances
+// are exportable unless "exportable" is false. Export options are not serialized.
+//
+//	{
+//	  "uuid": "Zapi", "object": "volume", "identifier": "volume", "exportable": true,
+//	  "global_labels": {"cluster": "c1"},
+//	  "metrics": [{"key": "size", "name": "size", "type": "float64", "exportable": true}],
+//	  "instances": [{"key": "vol1", "exportable": true, "labels": {"volume": "vol1"}, "values": {"size": 100}}]
+//	}
+type jsonMatrix struct {
+	UUID         string            `json:"uuid"`
+	Object       string            `json:"object"`
+	Identifier   string            `json:"identifier"`
+	Exportable   *bool             `json:"exportable,omitempty"`
+	GlobalLabels map[string]string `json:"global_labels,omitempty"`
+	Metrics      []jsonMetric      `json:"metrics"`
+	Instances    []jsonInstance    `json:"instances"`
+}
+
+type jsonMetric struct {
+	Key        string            `json:"key"`
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Property   string            `json:"property,omitempty"`
+	Comment    string            `json:"comment,omitempty"`
+	Array      bool              `json:"array,omitempty"`
+	Histogram  bool              `json:"histogram,omitempty"`
+	Exportable *bool             `json:"exportable,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Buckets    []string          `json:"buckets,omitempty"`
+}
+
+type jsonInstance struct {
+	Key        string             `json:"key"`
+	Exportable *bool              `json:"exportable,omitempty"`
+	Labels     map[string]string  `json:"labels,omitempty"`
+	Values     map[string]float64 `json:"values,omitempty"`
+}
+
+func (m *Matrix) MarshalJSON() ([]byte, error) {
+	jm := jsonMatrix{
+		UUID:         m.UUID,
+		Object:       m.Object,
+		Identifier:   m.Identifier,
+		Exportable:   &m.exportable,
+		GlobalLabels: m.globalLabels,
+		Metrics:      make([]jsonMetric, 0, len(m.metrics)),
+		Instances:    make([]jsonInstance, 0, len(m.instances)),
+	}
+
+	metricKeys := make([]string, 0, len(m.metrics))
+	for key := range m.metrics {
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
+
+	for _, key := range metricKeys {
+		metric := m.metrics[key]
+		jMetric := jsonMetric{
+			Key:        key,
+			Name:       metric.name,
+			Type:       metric.dataType,
+			Property:   metric.property,
+			Comment:    metric.comment,
+			Array:      metric.array,
+			Histogram:  metric.histogram,
+			Exportable: &metric.exportable,
+			Labels:     metric.labels,
+		}
+		if metric.buckets != nil {
+			jMetric.Buckets = *metric.buckets
+		}
+		jm.Metrics = append(jm.Metrics, jMetric)
+	}
+
+	// keep the order of the instances
+	instanceKeys := m.GetInstanceKeys()
+	sort.Slice(instanceKeys, func(i, j int) bool {
+		return m.instances[instanceKeys[i]].index < m.instances[instanceKeys[j]].index
+	})
+
+	for _, key := range instanceKeys {
+		instance := m.instances[key]
+		jInstance := jsonInstance{
+			Key:        key,
+			Exportable: &instance.exportable,
+			Labels:     instance.labels,
+			Values:     make(map[string]float64),
+		}
+		for _, metricKey := range metricKeys {
+			if v, ok := m.metrics[metricKey].GetValueFloat64(instance); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				jInstance.Values[metricKey] = v
+			}
+		}
+		jm.Instances = append(jm.Instances, jInstance)
+	}
+
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON replaces the content of the matrix. Export options of the matrix are kept.
+func (m *Matrix) UnmarshalJSON(data []byte) error {
+	var jm jsonMatrix
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+
+	mat := New(jm.UUID, jm.Object, jm.Identifier)
+	mat.exportable = isExportable(jm.Exportable)
+	mat.exportOptions = m.exportOptions
+	for label, value := range jm.GlobalLabels {
+		mat.globalLabels[label] = value
+	}
+
+	for _, jMetric := range jm.Metrics {
+		dataType := jMetric.Type
+		if dataType == "" {
+			dataType = "float64"
+		}
+		metric, err := mat.NewMetricType(jMetric.Key, dataType, jMetric.Name)
+		if err != nil {
+			return err
+		}
+		metric.property = jMetric.Property
+		metric.comment = jMetric.Comment
+		metric.array = jMetric.Array
+		metric.histogram = jMetric.Histogram
+		metric.exportable = isExportable(jMetric.Exportable)
+		if jMetric.Labels != nil {
+			metric.labels = jMetric.Labels
+		}
+		if jMetric.Buckets != nil {
+			buckets := jMetric.Buckets
+			metric.buckets = &buckets
+		}
+	}
+
+	for _, jInstance := range jm.Instances {
+		instance, err := mat.NewInstance(jInstance.Key)
+		if err != nil {
+			return err
+		}
+		instance.exportable = isExportable(jInstance.Exportable)
+		if jInstance.Labels != nil {
+			instance.labels = jInstance.Labels
+		}
+		for metricKey, value := range jInstance.Values {
+			metric := mat.GetMetric(metricKey)
+			if metric == nil {
+				return errs.New(ErrInvalidMetricKey, metricKey)
+			}
+			_ = metric.SetValueFloat64(instance, value)
+		}
+	}
+
+	*m = *mat
+	return nil
+}
+
+// isExportable returns true when exportable is missing from the JSON, like new matrices, metrics and instances
+func isExportable(exportable *bool) bool {
+	return exportable == nil || *exportable
+}
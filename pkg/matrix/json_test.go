@@ -0,0 +1,127 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package matrix
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestMatrix_JSON(t *testing.T) {
+	m := New("Zapi", "volume", "volume")
+	m.SetGlobalLabel("cluster", "c1")
+	m.SetExportOptions(DefaultExportOptions())
+	size, _ := m.NewMetricUint64("size", "size_total")
+	size.SetProperty("raw")
+	latency, _ := m.NewMetricFloat64("read_latency")
+	latency.SetComment("read_ops")
+	latency.SetExportable(false)
+	buckets := []string{"<1ms", "<10ms"}
+	hist, _ := m.NewMetricFloat64("hist.bucket")
+	hist.SetHistogram(true)
+	hist.SetBuckets(&buckets)
+
+	vol1, _ := m.NewInstance("vol1")
+	vol1.SetLabel("volume", "vol1")
+	vol2, _ := m.NewInstance("vol2")
+	vol2.SetExportable(false)
+	_ = size.SetValueUint64(vol1, 100)
+	_ = latency.SetValueFloat64(vol1, 1.5)
+	_ = latency.SetValueFloat64(vol2, math.NaN())
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := New("", "", "")
+	got.SetExportOptions(DefaultExportOptions())
+	if err := json.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.UUID != "Zapi" || got.Object != "volume" || got.Identifier != "volume" {
+		t.Errorf("got uuid=%s object=%s identifier=%s", got.UUID, got.Object, got.Identifier)
+	}
+	if got.GetGlobalLabels()["cluster"] != "c1" {
+		t.Errorf("global label cluster got=%s, want=c1", got.GetGlobalLabels()["cluster"])
+	}
+	if got.GetExportOptions() == nil {
+		t.Errorf("export options should be kept")
+	}
+
+	gotSize := got.DisplayMetric("size_total")
+	if gotSize == nil || gotSize.GetType() != "uint64" || gotSize.GetProperty() != "raw" {
+		t.Fatalf("metric size got=%+v", gotSize)
+	}
+	gotLatency := got.GetMetric("read_latency")
+	if gotLatency.IsExportable() || gotLatency.GetComment() != "read_ops" {
+		t.Errorf("metric read_latency exportable=%v comment=%s", gotLatency.IsExportable(), gotLatency.GetComment())
+	}
+	if b := got.GetMetric("hist.bucket").Buckets(); b == nil || len(*b) != 2 {
+		t.Errorf("histogram buckets got=%v", b)
+	}
+
+	gotVol1 := got.GetInstance("vol1")
+	if gotVol1.GetLabel("volume") != "vol1" || !gotVol1.IsExportable() {
+		t.Errorf("instance vol1 labels=%v exportable=%v", gotVol1.GetLabels(), gotVol1.IsExportable())
+	}
+	if v, ok := gotSize.GetValueUint64(gotVol1); !ok || v != 100 {
+		t.Errorf("size got=%v recorded=%v, want=100", v, ok)
+	}
+	gotVol2 := got.GetInstance("vol2")
+	if gotVol2.IsExportable() {
+		t.Errorf("instance vol2 should not be exportable")
+	}
+	if _, ok := gotLatency.GetValueFloat64(gotVol2); ok {
+		t.Errorf("NaN value should not be recorded")
+	}
+	if _, ok := gotSize.GetValueFloat64(gotVol2); ok {
+		t.Errorf("missing value should not be recorded")
+	}
+}
+
+func TestMatrix_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{name: "default type", json: `{"object":"x","metrics":[{"key":"a"}],"instances":[{"key":"i","values":{"a":1}}]}`},
+		{name: "unknown metric", json: `{"object":"x","metrics":[],"instances":[{"key":"i","values":{"a":1}}]}`, wantErr: true},
+		{name: "duplicate instance", json: `{"object":"x","instances":[{"key":"i"},{"key":"i"}]}`, wantErr: true},
+		{name: "invalid type", json: `{"object":"x","metrics":[{"key":"a","type":"string"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New("", "", "")
+			err := json.Unmarshal([]byte(tt.json), m)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalJSON() error=%v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatrix_UnmarshalJSONExportable(t *testing.T) {
+	m := New("", "", "")
+	data := `{"object":"x","metrics":[{"key":"a"},{"key":"b","exportable":false}],
+		"instances":[{"key":"i","values":{"a":1}},{"key":"j","exportable":false}]}`
+	if err := json.Unmarshal([]byte(data), m); err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsExportable() {
+		t.Errorf("matrix should be exportable when exportable is missing")
+	}
+	if !m.GetMetric("a").IsExportable() || m.GetMetric("b").IsExportable() {
+		t.Errorf("metric exportable a=%v b=%v, want a=true b=false",
+			m.GetMetric("a").IsExportable(), m.GetMetric("b").IsExportable())
+	}
+	if !m.GetInstance("i").IsExportable() || m.GetInstance("j").IsExportable() {
+		t.Errorf("instance exportable i=%v j=%v, want i=true j=false",
+			m.GetInstance("i").IsExportable(), m.GetInstance("j").IsExportable())
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/netapp/harvest/v2/pkg/errs"
 	"github.com/netapp/harvest/v2/pkg/logging"
 	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"maps"
 	"strings"
 )
 
@@ -28,6 +29,8 @@ type Matrix struct {
 	displayMetrics map[string]string  // display name of metric to => metric name (in templates, this is right side)
 	exportOptions  *node.Node
 	exportable     bool
+	exportTo       map[string]bool // names of the exporters the matrix is exported to, nil for all
+	skipExporters  map[string]bool // names of the exporters the matrix is not exported to
 }
 
 type With struct {
@@ -72,11 +75,42 @@ func (m *Matrix) SetExportable(b bool) {
 	m.exportable = b
 }
 
+// IsExportableTo indicates whether this matrix is exported to the exporter with the given name
+func (m *Matrix) IsExportableTo(exporter string) bool {
+	if m.exportTo != nil && !m.exportTo[exporter] {
+		return false
+	}
+	return !m.skipExporters[exporter]
+}
+
+// SetExportTo exports this matrix only to the exporters with the given names, nil exports to all exporters
+func (m *Matrix) SetExportTo(exporters []string) {
+	m.exportTo = toSet(exporters)
+}
+
+// SetSkipExporters does not export this matrix to the exporters with the given names
+func (m *Matrix) SetSkipExporters(exporters []string) {
+	m.skipExporters = toSet(exporters)
+}
+
+func toSet(names []string) map[string]bool {
+	if names == nil {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
 func (m *Matrix) Clone(with With) *Matrix {
 	clone := &Matrix{UUID: m.UUID, Object: m.Object, Identifier: m.Identifier}
 	clone.globalLabels = m.globalLabels
 	clone.exportOptions = m.exportOptions
 	clone.exportable = m.exportable
+	clone.exportTo = m.exportTo
+	clone.skipExporters = m.skipExporters
 	clone.displayMetrics = make(map[string]string)
 
 	if with.Instances {
@@ -121,6 +155,14 @@ func (m *Matrix) DisplayMetric(name string) *Metric {
 	return nil
 }
 
+// LookupMetric returns the metric with the display name, or else the metric with the key
+func (m *Matrix) LookupMetric(name string) *Metric {
+	if metric := m.DisplayMetric(name); metric != nil {
+		return metric
+	}
+	return m.GetMetric(name)
+}
+
 func (m *Matrix) GetMetric(key string) *Metric {
 	if metric, has := m.metrics[key]; has {
 		return metric
@@ -301,6 +343,12 @@ func (m *Matrix) SetGlobalLabels(allLabels map[string]string) {
 	}
 }
 
+// DetachGlobalLabels gives the matrix its own copy of the global labels. Clones share the global labels of the
+// original matrix, call this before changing the global labels of a clone.
+func (m *Matrix) DetachGlobalLabels() {
+	m.globalLabels = maps.Clone(m.globalLabels)
+}
+
 func (m *Matrix) GetGlobalLabels() map[string]string {
 	return m.globalLabels
 }
@@ -335,6 +383,12 @@ func CreateMetric(key string, data *Matrix) error {
 
 // Delta vector arithmetics
 func (m *Matrix) Delta(metricKey string, prevMat *Matrix, logger *logging.Logger) (int, error) {
+	return m.DeltaWithResets(metricKey, prevMat, nil, logger)
+}
+
+// DeltaWithResets is Delta, with counter resets handled according to resets. Resets may be nil, in which
+// case samples of reset counters are skipped.
+func (m *Matrix) DeltaWithResets(metricKey string, prevMat *Matrix, resets *Resets, logger *logging.Logger) (int, error) {
 	var skips int
 	prevMetric := prevMat.GetMetric(metricKey)
 	curMetric := m.GetMetric(metricKey)
@@ -351,9 +405,25 @@ func (m *Matrix) Delta(metricKey string, prevMat *Matrix, logger *logging.Logger
 				curMetric.values[currIndex] -= prevRaw[prevIndex]
 				// Sometimes ONTAP sends spurious zeroes or values less than the previous poll.
 				// Detect and don't publish negative deltas or the subsequent poll will show a large spike.
-				isInvalidZero := (curRaw == 0 || prevRaw[prevIndex] == 0) && curMetric.values[prevIndex] != 0
+				// Instances known to have restarted, and negative deltas, are counter resets.
+				isInvalidZero := (curRaw == 0 || prevRaw[prevIndex] == 0) && curMetric.values[currIndex] != 0
 				isNegative := curMetric.values[currIndex] < 0
-				if isInvalidZero || isNegative {
+				isReset := resets.isRestarted(key) || (isNegative && !isInvalidZero)
+				if isReset && resets != nil {
+					if value, ok := resets.apply(key, curRaw); ok {
+						curMetric.values[currIndex] = value
+						logger.Trace().
+							Str("metric", curMetric.GetName()).
+							Float64("currentRaw", curRaw).
+							Float64("previousRaw", prevRaw[prevIndex]).
+							Float64("cooked", value).
+							Str("policy", string(resets.Policy)).
+							Str("instKey", key).
+							Msg("Counter reset")
+						continue
+					}
+				}
+				if isInvalidZero || isNegative || isReset {
 					curMetric.record[currIndex] = false
 					skips++
 					logger.Trace().
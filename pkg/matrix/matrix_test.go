@@ -47,3 +47,44 @@ func TestMatrix_RemoveInstance(t *testing.T) {
 		})
 	}
 }
+
+func TestMatrix_IsExportableTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		exportTo []string
+		skip     []string
+		want     map[string]bool
+	}{
+		{"all", nil, nil, map[string]bool{"prom": true, "archive": true}},
+		{"only", []string{"archive"}, nil, map[string]bool{"prom": false, "archive": true}},
+		{"skip", nil, []string{"archive"}, map[string]bool{"prom": true, "archive": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setUpMatrix()
+			m.SetExportTo(tt.exportTo)
+			m.SetSkipExporters(tt.skip)
+			clone := m.Clone(With{})
+			for exporter, want := range tt.want {
+				if got := clone.IsExportableTo(exporter); got != want {
+					t.Errorf("IsExportableTo(%s) got=%v, want=%v", exporter, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMatrix_LookupMetric(t *testing.T) {
+	m := New("Test", "test", "test")
+	read, _ := m.NewMetricFloat64("read_ops", "ops")
+
+	if got := m.LookupMetric("ops"); got != read {
+		t.Errorf("ops should find the metric with the display name ops")
+	}
+	if got := m.LookupMetric("read_ops"); got != read {
+		t.Errorf("read_ops should find the metric by key")
+	}
+	if got := m.LookupMetric("missing"); got != nil {
+		t.Errorf("missing should not be found")
+	}
+}
@@ -281,3 +281,35 @@ func TestMetricReset(t *testing.T) {
 		t.Errorf("expected metric to be skipped but passed")
 	}
 }
+
+// TestMetricFloat64_DeltaReorderedInstances checks that spurious zeroes are detected
+// on the current instance when the instance order differs between polls.
+func TestMetricFloat64_DeltaReorderedInstances(t *testing.T) {
+	previous := New("Test", "test", "test")
+	prevSpeed, _ := previous.NewMetricFloat64("speed")
+	for _, key := range []string{"B", "A"} {
+		instance, _ := previous.NewInstance(key)
+		prevSpeed.SetValueFloat64(instance, 0)
+	}
+
+	current := New("Test", "test", "test")
+	curSpeed, _ := current.NewMetricFloat64("speed")
+	for i, key := range []string{"A", "B"} {
+		instance, _ := current.NewInstance(key)
+		curSpeed.SetValueFloat64(instance, []float64{50, 0}[i])
+	}
+
+	skips, err := current.Delta("speed", previous, logging.Get())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if skips != 1 {
+		t.Errorf("skips expected = 1, got %d", skips)
+	}
+	if _, ok := current.LazyGetValueFloat64("speed", "A"); ok {
+		t.Errorf("instance A should be skipped after a spurious zero")
+	}
+	if v, ok := current.LazyGetValueFloat64("speed", "B"); !ok || v != 0 {
+		t.Errorf("instance B expected = 0, got %f recorded=%t", v, ok)
+	}
+}
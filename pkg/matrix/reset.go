@@ -0,0 +1,127 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package matrix
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+)
+
+// ResetPolicy defines how DeltaWithResets handles a counter that restarted since the previous poll.
+// This happens when a node reboots or a counter wraps, and usually shows up as a counter that is smaller
+// than its previous value.
+type ResetPolicy string
+
+const (
+	// ResetSkip does not publish the sample. This is the default.
+	ResetSkip ResetPolicy = "skip"
+	// ResetZeroBased assumes the counter restarted from zero, the delta is the current value.
+	ResetZeroBased ResetPolicy = "zero_based"
+	// ResetInterpolate is like ResetZeroBased, but when the time of the restart is known, the delta is
+	// scaled to the whole poll interval.
+	ResetInterpolate ResetPolicy = "interpolate"
+)
+
+func ParseResetPolicy(s string) (ResetPolicy, error) {
+	switch p := ResetPolicy(s); p {
+	case "":
+		return ResetSkip, nil
+	case ResetSkip, ResetZeroBased, ResetInterpolate:
+		return p, nil
+	default:
+		return "", errs.New(errs.ErrInvalidParam, fmt.Sprintf("counter reset policy %s", s))
+	}
+}
+
+// Resets tracks the instances whose counters restarted between two polls
+type Resets struct {
+	Policy ResetPolicy
+	// restarted holds the instances that are known to have restarted, e.g. because their uptime decreased.
+	// The value is the fraction of the poll interval since the restart or zero when unknown.
+	restarted map[string]float64
+	// instances holds the keys of the instances with at least one reset counter
+	instances map[string]struct{}
+}
+
+func NewResets(policy ResetPolicy) *Resets {
+	return &Resets{
+		Policy:    policy,
+		restarted: make(map[string]float64),
+		instances: make(map[string]struct{}),
+	}
+}
+
+// DetectRestarts compares the raw uptime counter of each instance with the previous poll. An instance whose uptime
+// decreased has restarted. Must be called before calculating the delta of the timestamp metric.
+func (r *Resets) DetectRestarts(uptimeKey string, curMat *Matrix, prevMat *Matrix) {
+	curUptime := curMat.GetMetric(uptimeKey)
+	prevUptime := prevMat.GetMetric(uptimeKey)
+	if curUptime == nil || prevUptime == nil {
+		return
+	}
+	curTimestamp := curMat.GetMetric("timestamp")
+	prevTimestamp := prevMat.GetMetric("timestamp")
+	for key, curInstance := range curMat.GetInstances() {
+		prevInstance := prevMat.GetInstance(key)
+		if prevInstance == nil {
+			continue
+		}
+		cur, ok1 := curUptime.GetValueFloat64(curInstance)
+		prev, ok2 := prevUptime.GetValueFloat64(prevInstance)
+		if !ok1 || !ok2 || cur >= prev {
+			continue
+		}
+		fraction := 0.0
+		if curTimestamp != nil && prevTimestamp != nil {
+			curTs, ok1 := curTimestamp.GetValueFloat64(curInstance)
+			prevTs, ok2 := prevTimestamp.GetValueFloat64(prevInstance)
+			if elapsed := curTs - prevTs; ok1 && ok2 && elapsed > 0 && cur > 0 && cur < elapsed {
+				fraction = cur / elapsed
+			}
+		}
+		r.restarted[key] = fraction
+	}
+}
+
+// Count returns the number of instances with at least one reset counter
+func (r *Resets) Count() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.instances)
+}
+
+// Restarted returns the number of instances known to have restarted
+func (r *Resets) Restarted() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.restarted)
+}
+
+// apply handles the reset of an instance's counter according to the policy and returns the new delta,
+// and false when the sample should be skipped
+func (r *Resets) apply(key string, curRaw float64) (float64, bool) {
+	r.instances[key] = struct{}{}
+	switch r.Policy {
+	case ResetZeroBased:
+		return curRaw, true
+	case ResetInterpolate:
+		if fraction := r.restarted[key]; fraction > 0 {
+			return curRaw / fraction, true
+		}
+		return curRaw, true
+	default:
+		return 0, false
+	}
+}
+
+func (r *Resets) isRestarted(key string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.restarted[key]
+	return ok
+}
@@ -0,0 +1,151 @@
+package matrix
+
+import (
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"testing"
+)
+
+// setUpResetMatrix returns a matrix with a timestamp, uptime and ops metric for each instance
+func setUpResetMatrix(values map[string][3]float64) *Matrix {
+	m := New("TestDeltaWithResets", "test", "test")
+	ts, _ := m.NewMetricFloat64("timestamp")
+	uptime, _ := m.NewMetricFloat64("uptime")
+	ops, _ := m.NewMetricFloat64("ops")
+	for key, v := range values {
+		instance, _ := m.NewInstance(key)
+		_ = ts.SetValueFloat64(instance, v[0])
+		_ = uptime.SetValueFloat64(instance, v[1])
+		_ = ops.SetValueFloat64(instance, v[2])
+	}
+	return m
+}
+
+func TestMatrix_DeltaWithResets(t *testing.T) {
+	logger := logging.Get()
+
+	// A is steady, B counter went backwards, C rebooted 15s before the poll and its counter is larger than before
+	prev := map[string][3]float64{
+		"A": {1000, 5000, 100},
+		"B": {1000, 5000, 100},
+		"C": {1000, 5000, 100},
+	}
+	cur := map[string][3]float64{
+		"A": {1060, 5060, 160},
+		"B": {1060, 5060, 40},
+		"C": {1060, 15, 120},
+	}
+
+	type want struct {
+		value    float64
+		exported bool
+	}
+
+	tests := []struct {
+		name      string
+		policy    ResetPolicy
+		uptime    bool
+		want      map[string]want
+		numResets int
+		numSkips  int
+	}{
+		{
+			name:   "nil",
+			policy: "",
+			want: map[string]want{
+				"A": {60, true},
+				"B": {0, false},
+				"C": {20, true},
+			},
+			numSkips: 1,
+		},
+		{
+			name:   "skip",
+			policy: ResetSkip,
+			uptime: true,
+			want: map[string]want{
+				"A": {60, true},
+				"B": {0, false},
+				"C": {0, false},
+			},
+			numResets: 2,
+			numSkips:  2,
+		},
+		{
+			name:   "zero_based",
+			policy: ResetZeroBased,
+			uptime: true,
+			want: map[string]want{
+				"A": {60, true},
+				"B": {40, true},
+				"C": {120, true},
+			},
+			numResets: 2,
+		},
+		{
+			name:   "interpolate",
+			policy: ResetInterpolate,
+			uptime: true,
+			want: map[string]want{
+				"A": {60, true},
+				"B": {40, true},
+				"C": {480, true},
+			},
+			numResets: 2,
+		},
+		{
+			name:   "interpolate without uptime",
+			policy: ResetInterpolate,
+			want: map[string]want{
+				"A": {60, true},
+				"B": {40, true},
+				"C": {20, true},
+			},
+			numResets: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prevMat := setUpResetMatrix(prev)
+			curMat := setUpResetMatrix(cur)
+
+			var resets *Resets
+			if tt.policy != "" {
+				resets = NewResets(tt.policy)
+				if tt.uptime {
+					resets.DetectRestarts("uptime", curMat, prevMat)
+				}
+			}
+
+			skips, err := curMat.DeltaWithResets("ops", prevMat, resets, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if skips != tt.numSkips {
+				t.Errorf("skips got=%d, want=%d", skips, tt.numSkips)
+			}
+			if resets.Count() != tt.numResets {
+				t.Errorf("resets got=%d, want=%d", resets.Count(), tt.numResets)
+			}
+			for key, w := range tt.want {
+				got, ok := curMat.GetMetric("ops").GetValueFloat64(curMat.GetInstance(key))
+				if ok != w.exported {
+					t.Errorf("%s exported got=%v, want=%v", key, ok, w.exported)
+					continue
+				}
+				if ok && got != w.value {
+					t.Errorf("%s got=%v, want=%v", key, got, w.value)
+				}
+			}
+		})
+	}
+}
+
+func TestParseResetPolicy(t *testing.T) {
+	if p, err := ParseResetPolicy(""); err != nil || p != ResetSkip {
+		t.Errorf("empty policy got=%s err=%v, want=%s", p, err, ResetSkip)
+	}
+	if _, err := ParseResetPolicy("wrap"); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+// Package persist keeps the state of collectors and plugins across restarts of a poller. The state is stored as
+// JSON and written atomically, so a crash during a write leaves the previous state intact.
+package persist
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path returns the path of a state file in dir. The name of the file joins the parts with underscores, e.g. the
+// poller, collector and object the state belongs to.
+func Path(dir string, parts ...string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, strings.Join(parts, "_"))
+	return filepath.Join(dir, name+".json")
+}
+
+// Save writes v as JSON to path. The file is written to a temporary file in the same directory first and renamed,
+// the directory is created when it does not exist.
+func Save(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err = f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads the JSON of path into v. Use errors.Is(err, os.ErrNotExist) to tell a missing state from an error.
+func Load(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
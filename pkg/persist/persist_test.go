@@ -0,0 +1,47 @@
+/*
+ * Copyright NetApp Inc, 2023 All rights reserved
+ */
+
+package persist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	type state struct {
+		Keys  []string           `json:"keys"`
+		Times map[string]float64 `json:"times"`
+	}
+	path := filepath.Join(t.TempDir(), "poller", "state.json")
+
+	var got state
+	if err := Load(path, &got); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Load of missing state got=%v, want=ErrNotExist", err)
+	}
+
+	want := state{Keys: []string{"a", "b"}, Times: map[string]float64{"a": 1.5}}
+	if err := Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	// overwrite
+	want.Keys = append(want.Keys, "c")
+	if err := Save(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Keys) != 3 || got.Times["a"] != 1.5 {
+		t.Errorf("got=%+v, want=%+v", got, want)
+	}
+
+	// no temporary files are left
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("files got=%d, want=1", len(entries))
+	}
+}
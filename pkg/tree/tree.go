@@ -60,7 +60,7 @@ func consume(r *node.Node, key string, y *y3.Node, makeNewChild bool) {
 		for _, child := range y.Content {
 			makeNewChild := false
 			if child.Tag == "!!map" {
-				makeNewChild = key == "endpoints" || key == "events" || key == "matches"
+				makeNewChild = key == "endpoints" || key == "events" || key == "matches" || key == "maintenance" || key == "suppress"
 			}
 			consume(s, "", child, makeNewChild)
 		}
@@ -27,6 +27,9 @@ var IsCollector = map[string]struct{}{
 	"Rest":        {},
 	"RestPerf":    {},
 	"Ems":         {},
+	"GenericRest": {},
+	"Prometheus":  {},
+	"Snmp":        {},
 	"StorageGrid": {},
 	"Unix":        {},
 	"Simple":      {},